@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/peekeah/book-store/config"
 	"github.com/peekeah/book-store/handler"
 	"github.com/peekeah/book-store/logger"
 	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/notify"
+	"github.com/peekeah/book-store/payment"
+	"github.com/peekeah/book-store/storage"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -24,7 +29,13 @@ func NewSever() *Server {
 }
 
 func (s *Server) MigragateDB() {
-	dbConfig := config.GetConfig().DB
+	cfg := config.GetConfig()
+	dbConfig := cfg.DB
+
+	// old prices are migrated in the default currency, set it first
+	if err := model.SetDefaultCurrency(cfg.DefaultCurrency); err != nil {
+		log.Fatal("Invalid DEFAULT_CURRENCY: ", err)
+	}
 
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
 		dbConfig.Host, dbConfig.User, dbConfig.Password, dbConfig.DBName, dbConfig.Port,
@@ -36,12 +47,24 @@ func (s *Server) MigragateDB() {
 	}
 
 	model.DBMigrate(db)
+
+	if err := model.MigrateMoney(db); err != nil {
+		log.Fatal("Price migration failed: ", err)
+	}
+
+	if err := model.MigrateBookAuthors(db); err != nil {
+		log.Fatal("Author migration failed: ", err)
+	}
+
+	if err := model.MigrateBookEditions(db); err != nil {
+		log.Fatal("Edition migration failed: ", err)
+	}
 	s.DB = db
 }
 
-func (s *Server) Run() {
+// Router builds the HTTP routes served by the book store API.
+func (s *Server) Router() *mux.Router {
 	router := mux.NewRouter()
-	l := logger.Get()
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
@@ -52,13 +75,24 @@ func (s *Server) Run() {
 	// Logger
 	router.Use(logger.ReqMiddleware)
 
+	// Retried POSTs with an Idempotency-Key get the first response back.
+	// It runs after authenticate, keys are kept per user.
+	idempotent := s.MiddlewareHandler(handler.Idempotent)
+
 	// Health
 	router.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write([]byte("."))
 	})
 
+	// Error catalog
+	router.HandleFunc("/errors", handler.GetErrorCatalog).Methods("GET")
+
+	// Payment provider webhooks, authenticated by their signature
+	router.HandleFunc("/payments/webhook", s.RequestHandler(handler.PaymentWebhook)).Methods("POST")
+
 	// Auth Routes
 	authRoutes := router.PathPrefix("/auth").Subrouter()
+	authRoutes.Use(idempotent)
 	authRoutes.HandleFunc("/login", s.RequestHandler(handler.UserLogin)).Methods("POST")
 	authRoutes.HandleFunc("/signup", s.RequestHandler(handler.CreateUser)).Methods("POST")
 
@@ -67,7 +101,8 @@ func (s *Server) Run() {
 	userRoutes := router.PathPrefix("/users").Subrouter()
 	userRoutes.Use(s.MiddlewareHandler(authenticate))
 	userRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetUserById)).Methods("GET")
-	userRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateUser)).Methods("PUT")
+	userRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplaceUser)).Methods("PUT")
+	userRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateUser)).Methods("PATCH")
 	userRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeleteUser)).Methods("DELETE")
 
 	// Admin user routes
@@ -78,17 +113,230 @@ func (s *Server) Run() {
 	// Book Routes
 	bookRoutes := router.PathPrefix("/books").Subrouter()
 	bookRoutes.Use(s.MiddlewareHandler(authenticate))
+	bookRoutes.Use(idempotent)
 	bookRoutes.HandleFunc("/purchase", s.RequestHandler(handler.PurchaseBook)).Methods("POST")
+	bookRoutes.HandleFunc("/purchase/preview", s.RequestHandler(handler.PreviewPurchase)).Methods("POST")
 
 	bookRoutes.HandleFunc("/", s.RequestHandler(handler.GetBooks)).Methods("GET")
+	bookRoutes.HandleFunc("/isbn/{isbn}", s.RequestHandler(handler.GetBookByISBN)).Methods("GET")
 	bookRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetBookById)).Methods("GET")
+	bookRoutes.HandleFunc("/{id}/authors", s.RequestHandler(handler.GetBookAuthors)).Methods("GET")
+	bookRoutes.HandleFunc("/{id}/categories", s.RequestHandler(handler.GetBookCategories)).Methods("GET")
+	bookRoutes.HandleFunc("/{id}/editions", s.RequestHandler(handler.GetBookEditions)).Methods("GET")
 
 	// Admin book routes
 	bookAdminRoutes := bookRoutes.PathPrefix("/").Subrouter()
 	bookAdminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
-	bookAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateBook)).Methods("POST")
+	bookAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplaceBook)).Methods("PUT")
+	bookAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateBook)).Methods("PATCH", "POST")
 	bookAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeleteBook)).Methods("DELETE")
 	bookAdminRoutes.HandleFunc("/", s.RequestHandler(handler.CreateBook)).Methods("POST")
+	bookAdminRoutes.HandleFunc("/{id}/authors", s.RequestHandler(handler.SetBookAuthors)).Methods("PUT")
+	bookAdminRoutes.HandleFunc("/{id}/categories", s.RequestHandler(handler.SetBookCategories)).Methods("PUT")
+	bookAdminRoutes.HandleFunc("/{id}/editions", s.RequestHandler(handler.CreateEdition)).Methods("POST")
+	bookAdminRoutes.HandleFunc("/{id}/cover", s.RequestHandler(handler.UploadBookCover)).Methods("POST", "PUT")
+	bookAdminRoutes.HandleFunc("/{id}/cover", s.RequestHandler(handler.DeleteBookCover)).Methods("DELETE")
+
+	// Edition routes
+	editionRoutes := router.PathPrefix("/editions").Subrouter()
+	editionRoutes.Use(s.MiddlewareHandler(authenticate))
+	editionRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetEditionById)).Methods("GET")
+
+	// Edition admin routes
+	editionAdminRoutes := editionRoutes.PathPrefix("/").Subrouter()
+	editionAdminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	editionAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplaceEdition)).Methods("PUT")
+	editionAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateEdition)).Methods("PATCH")
+	editionAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeleteEdition)).Methods("DELETE")
+
+	// Publisher routes
+	publisherRoutes := router.PathPrefix("/publishers").Subrouter()
+	publisherRoutes.Use(s.MiddlewareHandler(authenticate))
+	publisherRoutes.Use(idempotent)
+	publisherRoutes.HandleFunc("/", s.RequestHandler(handler.GetPublishers)).Methods("GET")
+	publisherRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetPublisherById)).Methods("GET")
+
+	// Publisher admin routes
+	publisherAdminRoutes := publisherRoutes.PathPrefix("/").Subrouter()
+	publisherAdminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	publisherAdminRoutes.HandleFunc("/", s.RequestHandler(handler.CreatePublisher)).Methods("POST")
+	publisherAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplacePublisher)).Methods("PUT")
+	publisherAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdatePublisher)).Methods("PATCH")
+	publisherAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeletePublisher)).Methods("DELETE")
+
+	// Author Routes
+	authorRoutes := router.PathPrefix("/authors").Subrouter()
+	authorRoutes.Use(s.MiddlewareHandler(authenticate))
+	authorRoutes.Use(idempotent)
+	authorRoutes.HandleFunc("/", s.RequestHandler(handler.GetAuthors)).Methods("GET")
+	authorRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetAuthorById)).Methods("GET")
+	authorRoutes.HandleFunc("/{id}/books", s.RequestHandler(handler.GetAuthorBooks)).Methods("GET")
+
+	// Admin author routes
+	authorAdminRoutes := authorRoutes.PathPrefix("/").Subrouter()
+	authorAdminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	authorAdminRoutes.HandleFunc("/", s.RequestHandler(handler.CreateAuthor)).Methods("POST")
+	authorAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplaceAuthor)).Methods("PUT")
+	authorAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateAuthor)).Methods("PATCH")
+	authorAdminRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeleteAuthor)).Methods("DELETE")
+
+	// Category Routes
+	categoryRoutes := router.PathPrefix("/categories").Subrouter()
+	categoryRoutes.Use(s.MiddlewareHandler(authenticate))
+	categoryRoutes.Use(idempotent)
+	categoryRoutes.HandleFunc("/", s.RequestHandler(handler.GetCategories)).Methods("GET")
+	categoryRoutes.HandleFunc("/{slug}", s.RequestHandler(handler.GetCategory)).Methods("GET")
+	categoryRoutes.HandleFunc("/{slug}/books", s.RequestHandler(handler.GetCategoryBooks)).Methods("GET")
+
+	// Admin category routes
+	categoryAdminRoutes := categoryRoutes.PathPrefix("/").Subrouter()
+	categoryAdminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	categoryAdminRoutes.HandleFunc("/", s.RequestHandler(handler.CreateCategory)).Methods("POST")
+	categoryAdminRoutes.HandleFunc("/order", s.RequestHandler(handler.ReorderCategories)).Methods("PUT")
+	categoryAdminRoutes.HandleFunc("/{id:[0-9]+}", s.RequestHandler(handler.UpdateCategory)).Methods("PATCH")
+	categoryAdminRoutes.HandleFunc("/{id:[0-9]+}", s.RequestHandler(handler.DeleteCategory)).Methods("DELETE")
+
+	// Coupon routes, admin only
+	couponRoutes := router.PathPrefix("/coupons").Subrouter()
+	couponRoutes.Use(s.MiddlewareHandler(authenticate))
+	couponRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	couponRoutes.Use(idempotent)
+	couponRoutes.HandleFunc("/", s.RequestHandler(handler.GetCoupons)).Methods("GET")
+	couponRoutes.HandleFunc("/", s.RequestHandler(handler.CreateCoupon)).Methods("POST")
+	couponRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetCouponById)).Methods("GET")
+	couponRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplaceCoupon)).Methods("PUT")
+	couponRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdateCoupon)).Methods("PATCH")
+	couponRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeleteCoupon)).Methods("DELETE")
+
+	// Promotion routes, admin only
+	promotionRoutes := router.PathPrefix("/promotions").Subrouter()
+	promotionRoutes.Use(s.MiddlewareHandler(authenticate))
+	promotionRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	promotionRoutes.Use(idempotent)
+	promotionRoutes.HandleFunc("/", s.RequestHandler(handler.GetPromotions)).Methods("GET")
+	promotionRoutes.HandleFunc("/", s.RequestHandler(handler.CreatePromotion)).Methods("POST")
+	promotionRoutes.HandleFunc("/{id}", s.RequestHandler(handler.GetPromotionById)).Methods("GET")
+	promotionRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReplacePromotion)).Methods("PUT")
+	promotionRoutes.HandleFunc("/{id}", s.RequestHandler(handler.UpdatePromotion)).Methods("PATCH")
+	promotionRoutes.HandleFunc("/{id}", s.RequestHandler(handler.DeletePromotion)).Methods("DELETE")
+
+	// Reservation routes
+	reservationRoutes := router.PathPrefix("/reservations").Subrouter()
+	reservationRoutes.Use(s.MiddlewareHandler(authenticate))
+	reservationRoutes.Use(idempotent)
+	reservationRoutes.HandleFunc("/", s.RequestHandler(handler.GetMyReservations)).Methods("GET")
+	reservationRoutes.HandleFunc("/", s.RequestHandler(handler.CreateReservation)).Methods("POST")
+	reservationRoutes.HandleFunc("/{id}", s.RequestHandler(handler.ReleaseReservation)).Methods("DELETE")
+
+	// Purchase routes
+	purchaseRoutes := router.PathPrefix("/purchases").Subrouter()
+	purchaseRoutes.Use(s.MiddlewareHandler(authenticate))
+	purchaseRoutes.Use(idempotent)
+	purchaseRoutes.HandleFunc("/{id}/returns", s.RequestHandler(handler.RequestReturn)).Methods("POST")
+
+	// Return routes
+	returnRoutes := router.PathPrefix("/returns").Subrouter()
+	returnRoutes.Use(s.MiddlewareHandler(authenticate))
+	returnRoutes.HandleFunc("/", s.RequestHandler(handler.GetMyReturns)).Methods("GET")
+
+	// Admin routes
+	adminRoutes := router.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(s.MiddlewareHandler(authenticate))
+	adminRoutes.Use(s.MiddlewareHandler(authorizeAdmin))
+	adminRoutes.Use(idempotent)
+	adminRoutes.HandleFunc("/books/import", s.RequestHandler(handler.ImportBooks)).Methods("POST")
+	adminRoutes.HandleFunc("/books/{id}/stock-history", s.RequestHandler(handler.GetStockHistory)).Methods("GET")
+	adminRoutes.HandleFunc("/stock/reconcile", s.RequestHandler(handler.ReconcileStock)).Methods("POST")
+	adminRoutes.HandleFunc("/inventory/low-stock", s.RequestHandler(handler.GetLowStock)).Methods("GET")
+	adminRoutes.HandleFunc("/warehouses", s.RequestHandler(handler.GetWarehouses)).Methods("GET")
+	adminRoutes.HandleFunc("/warehouses", s.RequestHandler(handler.CreateWarehouse)).Methods("POST")
+	adminRoutes.HandleFunc("/warehouses/transfers", s.RequestHandler(handler.GetTransfers)).Methods("GET")
+	adminRoutes.HandleFunc("/warehouses/transfers", s.RequestHandler(handler.TransferStock)).Methods("POST")
+	adminRoutes.HandleFunc("/warehouses/{id:[0-9]+}", s.RequestHandler(handler.ReplaceWarehouse)).Methods("PUT")
+	adminRoutes.HandleFunc("/warehouses/{id:[0-9]+}/stock", s.RequestHandler(handler.GetWarehouseStock)).Methods("GET")
+	adminRoutes.HandleFunc("/warehouses/{id:[0-9]+}/stock", s.RequestHandler(handler.SetWarehouseStock)).Methods("PUT")
+	adminRoutes.HandleFunc("/suppliers", s.RequestHandler(handler.GetSuppliers)).Methods("GET")
+	adminRoutes.HandleFunc("/suppliers", s.RequestHandler(handler.CreateSupplier)).Methods("POST")
+	adminRoutes.HandleFunc("/suppliers/{id:[0-9]+}", s.RequestHandler(handler.ReplaceSupplier)).Methods("PUT")
+	adminRoutes.HandleFunc("/supplier-orders", s.RequestHandler(handler.GetSupplierOrders)).Methods("GET")
+	adminRoutes.HandleFunc("/supplier-orders", s.RequestHandler(handler.CreateSupplierOrder)).Methods("POST")
+	adminRoutes.HandleFunc("/supplier-orders/{id:[0-9]+}", s.RequestHandler(handler.GetSupplierOrderById)).Methods("GET")
+	adminRoutes.HandleFunc("/supplier-orders/{id:[0-9]+}/receive", s.RequestHandler(handler.ReceiveSupplierOrder)).Methods("POST")
+	adminRoutes.HandleFunc("/supplier-orders/{id:[0-9]+}/cancel", s.RequestHandler(handler.CancelSupplierOrder)).Methods("POST")
+	adminRoutes.HandleFunc("/stocktakes", s.RequestHandler(handler.GetStocktakes)).Methods("GET")
+	adminRoutes.HandleFunc("/stocktakes", s.RequestHandler(handler.CreateStocktake)).Methods("POST")
+	adminRoutes.HandleFunc("/stocktakes/{id:[0-9]+}", s.RequestHandler(handler.GetStocktakeById)).Methods("GET")
+	adminRoutes.HandleFunc("/stocktakes/{id:[0-9]+}/counts", s.RequestHandler(handler.SubmitStocktakeCounts)).Methods("PUT")
+	adminRoutes.HandleFunc("/stocktakes/{id:[0-9]+}/variances", s.RequestHandler(handler.GetStocktakeVariances)).Methods("GET")
+	adminRoutes.HandleFunc("/stocktakes/{id:[0-9]+}/apply", s.RequestHandler(handler.ApplyStocktake)).Methods("POST")
+	adminRoutes.HandleFunc("/stocktakes/{id:[0-9]+}/cancel", s.RequestHandler(handler.CancelStocktake)).Methods("POST")
+	adminRoutes.HandleFunc("/export/books", s.RequestHandler(handler.ExportBooks)).Methods("GET")
+	adminRoutes.HandleFunc("/export/purchases", s.RequestHandler(handler.ExportPurchases)).Methods("GET")
+	adminRoutes.HandleFunc("/export/refunds", s.RequestHandler(handler.ExportRefunds)).Methods("GET")
+	adminRoutes.HandleFunc("/returns", s.RequestHandler(handler.GetReturns)).Methods("GET")
+	adminRoutes.HandleFunc("/returns/{id}/approve", s.RequestHandler(handler.ApproveReturn)).Methods("POST")
+	adminRoutes.HandleFunc("/returns/{id}/reject", s.RequestHandler(handler.RejectReturn)).Methods("POST")
+	adminRoutes.HandleFunc("/purchases/{id}/refunds", s.RequestHandler(handler.GetPurchaseRefunds)).Methods("GET")
+	adminRoutes.HandleFunc("/purchases/{id}/refunds", s.RequestHandler(handler.RefundPurchase)).Methods("POST")
+
+	return router
+}
+
+func (s *Server) Run() {
+	router := s.Router()
+	l := logger.Get()
+
+	if path := config.GetConfig().BookMetadataFile; path != "" {
+		provider, err := handler.NewFileMetadataProvider(path)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Loading book metadata failed")
+		}
+		handler.SetMetadataProvider(provider)
+	}
+
+	if path := config.GetConfig().TaxRulesFile; path != "" {
+		calculator, err := handler.NewFileTaxCalculator(path)
+		if err != nil {
+			l.Fatal().Err(err).Msg("Loading tax rules failed")
+		}
+		handler.SetTaxCalculator(calculator)
+	}
+
+	handler.SetIdempotencyWindow(config.GetConfig().Server.IdempotencyWindow)
+	go s.purgeIdempotencyKeys(time.Hour)
+
+	if err := handler.SetAllocationRule(config.GetConfig().Server.AllocationRule); err != nil {
+		l.Fatal().Err(err).Msg("Setting up stock allocation failed")
+	}
+
+	handler.SetReservationTTL(config.GetConfig().Server.ReservationTTL)
+	go s.expireReservations(time.Minute)
+
+	provider, err := newPaymentProvider(config.GetConfig().Payment)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Setting up payments failed")
+	}
+	handler.SetPaymentProvider(provider)
+
+	notifier, err := newNotifier(config.GetConfig().Notify)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Setting up notifications failed")
+	}
+	handler.SetNotifier(notifier)
+
+	store, err := newBlobStore(config.GetConfig().Blob)
+	if err != nil {
+		l.Fatal().Err(err).Msg("Setting up blob storage failed")
+	}
+	handler.SetBlobStore(store)
+
+	// the local store serves its files itself
+	if local, ok := store.(*storage.LocalStore); ok {
+		if base := config.GetConfig().Blob.BaseURL; strings.HasPrefix(base, "/") {
+			base = strings.TrimSuffix(base, "/")
+			router.PathPrefix(base+"/").Methods("GET", "HEAD").Handler(http.StripPrefix(base, local))
+		}
+	}
 
 	// Run Server
 	l.Info().
@@ -100,6 +348,89 @@ func (s *Server) Run() {
 		Msg("Go Book Store App Closed")
 }
 
+func newBlobStore(cfg config.Blob) (storage.BlobStore, error) {
+	switch cfg.Store {
+	case "local":
+		return storage.NewLocalStore(cfg.LocalDir, cfg.BaseURL)
+	case "s3":
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PublicURL:       cfg.S3.PublicURL,
+		})
+	}
+	return nil, fmt.Errorf("unknown blob store %q", cfg.Store)
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval
+func (s *Server) purgeIdempotencyKeys(interval time.Duration) {
+	l := logger.Get()
+
+	for range time.Tick(interval) {
+		if _, err := handler.PurgeIdempotencyKeys(s.DB); err != nil {
+			l.Error().Err(err).Msg("Purging idempotency keys failed")
+		}
+	}
+}
+
+// expireReservations puts the copies of expired reservations back in stock
+// every interval
+func (s *Server) expireReservations(interval time.Duration) {
+	l := logger.Get()
+
+	for range time.Tick(interval) {
+		if _, err := handler.ExpireReservations(s.DB); err != nil {
+			l.Error().Err(err).Msg("Expiring reservations failed")
+		}
+	}
+}
+
+func newPaymentProvider(cfg config.Payment) (payment.Provider, error) {
+	switch cfg.Provider {
+	case "none":
+		return nil, nil
+	case "fake":
+		return payment.NewFakeProvider(cfg.WebhookSecret), nil
+	case "stripe":
+		return payment.NewStripeProvider(payment.StripeConfig{
+			BaseURL:       cfg.StripeURL,
+			SecretKey:     cfg.StripeSecretKey,
+			WebhookSecret: cfg.WebhookSecret,
+		})
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+}
+
+func newNotifier(cfg config.Notify) (notify.Notifier, error) {
+	switch cfg.Notifier {
+	case "none":
+		return nil, nil
+	case "log":
+		return notify.NewLogNotifier(logger.Get()), nil
+	case "webhook":
+		return notify.NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookSecret)
+	case "email":
+		to := []string{}
+		for _, address := range strings.Split(cfg.EmailTo, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				to = append(to, address)
+			}
+		}
+
+		return notify.NewEmailNotifier(notify.EmailConfig{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+			From:     cfg.EmailFrom,
+			To:       to,
+		})
+	}
+	return nil, fmt.Errorf("unknown notifier %q", cfg.Notifier)
+}
+
 type RequestHandler func(db *gorm.DB, w http.ResponseWriter, r *http.Request)
 
 func (s *Server) RequestHandler(handler RequestHandler) http.HandlerFunc {
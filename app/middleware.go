@@ -15,7 +15,7 @@ func authenticate(db *gorm.DB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := r.Header.Get("Authorization")
 		if tokenStr == "" {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, "unauthorized"}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized}
 			res.Dispatch()
 			return
 		}
@@ -24,7 +24,7 @@ func authenticate(db *gorm.DB, next http.Handler) http.Handler {
 
 		id, err := utils.VerifyJWTToken(tokenStr)
 		if err != nil {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, "unauthorized"}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized.Wrap(err)}
 			res.Dispatch()
 			return
 		}
@@ -33,13 +33,13 @@ func authenticate(db *gorm.DB, next http.Handler) http.Handler {
 		user := model.User{}
 
 		if err := db.First(&user, id).Error; err != nil {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, err.Error()}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized.Wrap(err)}
 			res.Dispatch()
 			return
 		}
 
 		if user.ID == 0 {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, err.Error()}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized}
 			res.Dispatch()
 			return
 		}
@@ -58,25 +58,23 @@ func authorizeAdmin(db *gorm.DB, next http.Handler) http.Handler {
 		user := model.User{}
 
 		if err := db.First(&user, userId).Error; err != nil {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, err.Error()}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized.Wrap(err)}
 			res.Dispatch()
 			return
 		}
 
 		if user.ID == 0 {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, "unauthorized"}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrUnauthorized}
 			res.Dispatch()
 			return
 		}
 
 		if user.Role != "admin" {
-			res := handler.ErrorResponse{w, http.StatusUnauthorized, "only admin are allowed"}
+			res := handler.ErrorResponse{RW: w, Req: r, Err: handler.ErrForbidden.WithDetail("only admin are allowed")}
 			res.Dispatch()
 			return
 		}
 
-		next.ServeHTTP(w, r)
-
 		ctx := context.WithValue(r.Context(), "role", "admin")
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestAuthorizeAdmin_ServesOnce(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(1, "admin"))
+
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Context().Value("role") != "admin" {
+			t.Errorf("expected admin role in context, got %v", r.Context().Value("role"))
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/orders", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	authorizeAdmin(db, next).ServeHTTP(w, req)
+
+	if calls != 1 {
+		t.Fatalf("expected handler to be served once, got %d", calls)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 type DB struct {
 	Host     string
@@ -12,12 +15,78 @@ type DB struct {
 
 type Server struct {
 	Port string
+	// RequireIfMatch rejects updates and deletes sent without an If-Match header
+	RequireIfMatch bool
+	// IdempotencyWindow is how long responses to requests sent with an
+	// Idempotency-Key are kept for retries
+	IdempotencyWindow time.Duration
+	// ReservationTTL is how long a reservation holds its copies
+	ReservationTTL time.Duration
+	// AllocationRule picks the warehouses purchases are shipped from,
+	// "nearest" (default) to the customer's city or by "priority"
+	AllocationRule string
+}
+
+// Blob selects where uploaded files such as book covers are kept
+type Blob struct {
+	// Store is "local" (default) or "s3"
+	Store string
+	// LocalDir and BaseURL configure the local store, files are served
+	// below BaseURL by the API itself
+	LocalDir string
+	BaseURL  string
+	S3       S3
+}
+
+type S3 struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string
+}
+
+// Payment selects the provider purchases are charged through
+type Payment struct {
+	// Provider is "fake" (default), "stripe" or "none", which marks
+	// purchases paid without charging them
+	Provider string
+	// WebhookSecret signs the provider's webhook deliveries
+	WebhookSecret   string
+	StripeURL       string
+	StripeSecretKey string
+}
+
+// Notify selects where staff notifications, such as low stock alerts, are
+// sent
+type Notify struct {
+	// Notifier is "log" (default), "webhook", "email" or "none"
+	Notifier      string
+	WebhookURL    string
+	WebhookSecret string
+	SMTPAddr      string
+	SMTPUser      string
+	SMTPPassword  string
+	EmailFrom     string
+	// EmailTo is a comma separated list of recipients
+	EmailTo string
 }
 
 type Config struct {
 	DB           DB
 	Server       Server
 	JWTSecretKey string
+	// BookMetadataFile is a JSON file of ISBN metadata used to prefill new books
+	BookMetadataFile string
+	Blob             Blob
+	// DefaultCurrency is the ISO 4217 code of prices sent without one
+	DefaultCurrency string
+	// TaxRulesFile is a JSON file of tax rules per region, purchases are
+	// not taxed without one
+	TaxRulesFile string
+	Payment      Payment
+	Notify       Notify
 }
 
 func GetConfig() Config {
@@ -30,8 +99,58 @@ func GetConfig() Config {
 			DBName:   os.Getenv("DB_NAME"),
 		},
 		Server: Server{
-			Port: os.Getenv("SERVER_PORT"),
+			Port:              os.Getenv("SERVER_PORT"),
+			RequireIfMatch:    os.Getenv("REQUIRE_IF_MATCH") == "true",
+			IdempotencyWindow: getDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+			ReservationTTL:    getDuration("RESERVATION_TTL", 15*time.Minute),
+			AllocationRule:    getEnv("ALLOCATION_RULE", "nearest"),
+		},
+		JWTSecretKey:     os.Getenv("JWT_SECRET_KEY"),
+		BookMetadataFile: os.Getenv("BOOK_METADATA_FILE"),
+		Blob: Blob{
+			Store:    getEnv("BLOB_STORE", "local"),
+			LocalDir: getEnv("BLOB_LOCAL_DIR", "data/blobs"),
+			BaseURL:  getEnv("BLOB_BASE_URL", "/media"),
+			S3: S3{
+				Endpoint:        os.Getenv("S3_ENDPOINT"),
+				Region:          os.Getenv("S3_REGION"),
+				Bucket:          os.Getenv("S3_BUCKET"),
+				AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+				PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+			},
 		},
-		JWTSecretKey: os.Getenv("JWT_SECRET_KEY"),
+		DefaultCurrency: getEnv("DEFAULT_CURRENCY", "USD"),
+		TaxRulesFile:    os.Getenv("TAX_RULES_FILE"),
+		Payment: Payment{
+			Provider:        getEnv("PAYMENT_PROVIDER", "fake"),
+			WebhookSecret:   os.Getenv("PAYMENT_WEBHOOK_SECRET"),
+			StripeURL:       os.Getenv("STRIPE_API_URL"),
+			StripeSecretKey: os.Getenv("STRIPE_SECRET_KEY"),
+		},
+		Notify: Notify{
+			Notifier:      getEnv("NOTIFIER", "log"),
+			WebhookURL:    os.Getenv("NOTIFY_WEBHOOK_URL"),
+			WebhookSecret: os.Getenv("NOTIFY_WEBHOOK_SECRET"),
+			SMTPAddr:      os.Getenv("SMTP_ADDR"),
+			SMTPUser:      os.Getenv("SMTP_USER"),
+			SMTPPassword:  os.Getenv("SMTP_PASSWORD"),
+			EmailFrom:     os.Getenv("NOTIFY_EMAIL_FROM"),
+			EmailTo:       os.Getenv("NOTIFY_EMAIL_TO"),
+		},
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
 	}
+	return fallback
 }
@@ -0,0 +1,487 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+// AuthorCredit is a book an author is credited on, with the role they had
+type AuthorCredit struct {
+	Role string     `json:"role"`
+	Book model.Book `json:"book"`
+}
+
+// BookCredit assigns an author to a book in a role
+type BookCredit struct {
+	AuthorID uint   `json:"author_id" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=author translator illustrator"`
+}
+
+// BookCreditsPayload replaces every credit of a book, in display order
+type BookCreditsPayload struct {
+	Credits []BookCredit `json:"credits" validate:"dive"`
+}
+
+func GetAuthors(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authors := []model.Author{}
+
+	query := db.Order("name")
+	if name := strings.TrimSpace(r.URL.Query().Get("name")); name != "" {
+		query = query.Where("name ILIKE ?", "%"+escapeLike(name)+"%")
+	}
+
+	if err := query.Find(&authors).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, authors, ""}
+	res.Dispatch()
+}
+
+func GetAuthorById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authorId, err := authorIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	author := model.Author{}
+
+	if err := db.First(&author, authorId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrAuthorNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(author.ID, author.Version)) {
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, author, ""}
+	res.Dispatch()
+}
+
+// GetAuthorBooks lists the books an author is credited on in any role
+func GetAuthorBooks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authorId, err := authorIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	author := model.Author{}
+
+	if err := db.First(&author, authorId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrAuthorNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	credits := []model.BookAuthor{}
+
+	if err := db.Where("author_id = ?", author.ID).Order("book_id").Find(&credits).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	result := []AuthorCredit{}
+
+	if len(credits) > 0 {
+		bookIds := make([]uint, len(credits))
+		for i, credit := range credits {
+			bookIds[i] = credit.BookID
+		}
+
+		books := []model.Book{}
+		if err := db.Find(&books, bookIds).Error; err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+
+		byId := make(map[uint]model.Book, len(books))
+		for _, book := range books {
+			byId[book.ID] = book
+		}
+
+		// credits of deleted books are skipped
+		for _, credit := range credits {
+			if book, ok := byId[credit.BookID]; ok {
+				result = append(result, AuthorCredit{Role: credit.Role, Book: book})
+			}
+		}
+	}
+
+	res := SuccessResponse{w, http.StatusOK, result, ""}
+	res.Dispatch()
+}
+
+func CreateAuthor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	fields := model.AuthorFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	author := model.Author{}
+	author.SetFields(fields)
+
+	if err := db.Create(&author).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, author, ""}
+	res.Dispatch()
+}
+
+// UpdateAuthor applies a JSON merge patch (RFC 7396) to an author
+func UpdateAuthor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authorId, err := authorIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbAuthor := model.Author{}
+
+	if err := db.First(&dbAuthor, authorId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrAuthorNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbAuthor.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	saveAuthorFields(db, w, r, &dbAuthor, fields)
+}
+
+// ReplaceAuthor overwrites every editable field of an author
+func ReplaceAuthor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authorId, err := authorIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.AuthorFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbAuthor := model.Author{}
+
+	if err := db.First(&dbAuthor, authorId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrAuthorNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	saveAuthorFields(db, w, r, &dbAuthor, fields)
+}
+
+// saveAuthorFields writes fields only if the author still has the version
+// that was read
+func saveAuthorFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbAuthor *model.Author, fields model.AuthorFields) {
+	if _, err := checkIfMatch(r, versionETag(dbAuthor.ID, dbAuthor.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	version, name := dbAuthor.Version, dbAuthor.Name
+	dbAuthor.SetFields(fields)
+	dbAuthor.Version++
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(dbAuthor).Where("version = ?", version).Select(model.AuthorFieldColumns).Updates(dbAuthor)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("author was modified concurrently")
+		}
+
+		// bylines show the author's name
+		if dbAuthor.Name == name {
+			return nil
+		}
+		return model.RefreshBylines(tx, dbAuthor.ID)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbAuthor.ID, dbAuthor.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbAuthor, ""}
+	res.Dispatch()
+}
+
+// DeleteAuthor refuses to delete an author still credited on a book, the
+// credits have to be reassigned first
+func DeleteAuthor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	authorId, err := authorIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	author := model.Author{}
+
+	if err := db.First(&author, authorId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrAuthorNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(author.ID, author.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	count := int64(0)
+	if err := db.Model(&model.BookAuthor{}).Where("author_id = ?", author.ID).Count(&count).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if count > 0 {
+		res := ErrorResponse{w, r, ErrAuthorInUse.WithDetail("author is credited on %d books", count)}
+		res.Dispatch()
+		return
+	}
+
+	query := db
+	if conditional {
+		query = db.Where("version = ?", author.Version)
+	}
+
+	result := query.Delete(&author)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("author was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, author, ""}
+	res.Dispatch()
+}
+
+// GetBookAuthors lists the credits of a book in display order
+func GetBookAuthors(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.Preload("Credits", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Credits.Author").First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, book.Credits, ""}
+	res.Dispatch()
+}
+
+// SetBookAuthors replaces the credits of a book. The names credited as
+// author become the book's author byline, so at least one is required.
+func SetBookAuthors(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := BookCreditsPayload{}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if _, err := checkIfMatch(r, versionETag(book.ID, book.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	authorIds := []uint{}
+	credits := make([]model.BookAuthor, len(payload.Credits))
+	for i, credit := range payload.Credits {
+		for _, previous := range payload.Credits[:i] {
+			if previous == credit {
+				res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("author %d is credited twice as %s", credit.AuthorID, credit.Role)}
+				res.Dispatch()
+				return
+			}
+		}
+
+		authorIds = append(authorIds, credit.AuthorID)
+		credits[i] = model.BookAuthor{BookID: book.ID, AuthorID: credit.AuthorID, Role: credit.Role, Position: i}
+	}
+
+	authors := []model.Author{}
+	if len(authorIds) > 0 {
+		if err := db.Find(&authors, authorIds).Error; err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	byId := make(map[uint]*model.Author, len(authors))
+	for i := range authors {
+		byId[authors[i].ID] = &authors[i]
+	}
+
+	for i := range credits {
+		author, ok := byId[credits[i].AuthorID]
+		if !ok {
+			res := ErrorResponse{w, r, ErrAuthorNotFound.WithDetail("author %d does not exist", credits[i].AuthorID)}
+			res.Dispatch()
+			return
+		}
+		credits[i].Author = author
+	}
+
+	byline := model.Byline(credits)
+	if byline == "" {
+		res := ErrorResponse{w, r, ErrValidationFailed.WithDetail("credits must name at least one author")}
+		res.Dispatch()
+		return
+	}
+
+	version := book.Version
+	book.Author = byline
+	book.Version++
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&book).Where("version = ?", version).Select("author", "version").Updates(&book)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("book was modified concurrently")
+		}
+
+		if err := tx.Where("book_id = ?", book.ID).Delete(&model.BookAuthor{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Omit("Author").Create(&credits).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(book.ID, book.Version))
+
+	res := SuccessResponse{w, http.StatusOK, credits, ""}
+	res.Dispatch()
+}
+
+func authorIdParam(r *http.Request) (int, error) {
+	authorIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	authorId, err := strconv.Atoi(authorIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid author id")
+	}
+
+	return authorId, nil
+}
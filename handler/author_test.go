@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestSplitAuthorNames(t *testing.T) {
+	cases := map[string][]string{
+		"Terry Pratchett & Neil Gaiman":      {"Terry Pratchett", "Neil Gaiman"},
+		"A. Author, B. Author and C. Author": {"A. Author", "B. Author", "C. Author"},
+		"  J.R.R. Tolkien ":                  {"J.R.R. Tolkien"},
+		"":                                   {},
+	}
+
+	for byline, want := range cases {
+		if got := model.SplitAuthorNames(byline); !reflect.DeepEqual(got, want) {
+			t.Errorf("split %q: expected %v, got %v", byline, want, got)
+		}
+	}
+
+	author := model.Author{Name: "J.R.R. Tolkien", Aliases: []string{"John Ronald Reuel Tolkien"}}
+	for _, name := range []string{"JRR Tolkien", "j r r tolkien", "John Ronald Reuel Tolkien"} {
+		if !author.Matches(name) {
+			t.Errorf("expected %q to match %s", name, author.Name)
+		}
+	}
+
+	if author.Matches("Christopher Tolkien") {
+		t.Errorf("expected a different author not to match")
+	}
+}
+
+// expectBylineCredits expects the author credits of a book to be replaced
+// by a credit of the existing author named in its byline
+func expectBylineCredits(mock sqlmock.Sqlmock, bookId, authorId int, name string) {
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(authorId, name))
+	mock.ExpectQuery(`^SELECT (.+) FROM "book_authors" WHERE book_id = \$1 AND role <> \$2 ORDER BY position`).
+		WithArgs(bookId, model.RoleAuthor).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "author_id", "role", "position"}))
+	mock.ExpectExec(`^DELETE FROM "book_authors" WHERE book_id = \$1`).
+		WithArgs(bookId).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^INSERT INTO "book_authors"`).
+		WithArgs(bookId, authorId, model.RoleAuthor, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestMigrateBookAuthors(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "data_migrations" (.+) ON CONFLICT DO NOTHING`).
+		WithArgs("book_authors", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE \(author <> '' AND NOT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "author"}).
+			AddRow(1, "J.R.R. Tolkien").
+			AddRow(2, "JRR Tolkien & Christopher Tolkien"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}))
+	mock.ExpectQuery(`^INSERT INTO "authors"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "J.R.R. Tolkien", "", nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^INSERT INTO "book_authors"`).
+		WithArgs(1, 1, model.RoleAuthor, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "authors"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Christopher Tolkien", "", nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec(`^INSERT INTO "book_authors"`).
+		WithArgs(2, 1, model.RoleAuthor, 0, 2, 2, model.RoleAuthor, 1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := model.MigrateBookAuthors(db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// it ran already, credits removed since are not brought back
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "data_migrations"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := model.MigrateBookAuthors(db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetAuthorBooks(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(3, "Author1", 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "book_authors" WHERE author_id = \$1 ORDER BY book_id`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "author_id", "role"}).
+			AddRow(1, 3, model.RoleAuthor).
+			AddRow(2, 3, model.RoleTranslator))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE "books"."id" IN \(\$1,\$2\)`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(1, "Book1", 1).AddRow(2, "Book2", 1))
+
+	req, _ := http.NewRequest(http.MethodGet, "/authors/3/books", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "3"})
+	w := httptest.NewRecorder()
+
+	GetAuthorBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	res := struct {
+		Data []AuthorCredit `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if len(res.Data) != 2 || res.Data[1].Role != model.RoleTranslator || res.Data[1].Book.Name != "Book2" {
+		t.Fatalf("unexpected credits %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSetBookAuthors(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "version"}).AddRow(1, "Book1", "Pratchett", 2))
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors" WHERE "authors"."id" IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).
+			AddRow(4, "Terry Pratchett").
+			AddRow(5, "Neil Gaiman").
+			AddRow(6, "Josh Kirby"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "books" SET`).
+		WithArgs(sqlmock.AnyArg(), "Terry Pratchett, Neil Gaiman", 3, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^DELETE FROM "book_authors" WHERE book_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^INSERT INTO "book_authors"`).
+		WithArgs(1, 4, model.RoleAuthor, 0, 1, 5, model.RoleAuthor, 1, 1, 6, model.RoleIllustrator, 2).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	body := []byte(`{"credits":[{"author_id":4,"role":"author"},{"author_id":5,"role":"author"},{"author_id":6,"role":"illustrator"}]}`)
+	req, _ := http.NewRequest(http.MethodPut, "/books/1/authors", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	SetBookAuthors(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if etag := w.Header().Get("ETag"); etag != `"1-3"` {
+		t.Fatalf("unexpected etag %s", etag)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSetBookAuthors_InvalidRole(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	body := []byte(`{"credits":[{"author_id":4,"role":"editor"}]}`)
+	req, _ := http.NewRequest(http.MethodPut, "/books/1/authors", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	SetBookAuthors(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestSetBookAuthors_NoAuthor(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "version"}).AddRow(1, "Book1", "Pratchett", 2))
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors" WHERE "authors"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(6, "Josh Kirby"))
+
+	// the byline would be left naming authors that are no longer credited
+	body := []byte(`{"credits":[{"author_id":6,"role":"illustrator"}]}`)
+	req, _ := http.NewRequest(http.MethodPut, "/books/1/authors", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	SetBookAuthors(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateAuthor_Rename(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(4, "Terry Pratchet", 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "authors" SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT "book_id" FROM "book_authors" WHERE author_id = \$1 AND role = \$2 ORDER BY book_id`).
+		WithArgs(4, model.RoleAuthor).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow(1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "book_authors" WHERE book_id = \$1 ORDER BY position`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "author_id", "role", "position"}).
+			AddRow(1, 4, model.RoleAuthor, 0).
+			AddRow(1, 5, model.RoleAuthor, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors" WHERE "authors"."id" IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).
+			AddRow(4, "Terry Pratchett").
+			AddRow(5, "Neil Gaiman"))
+	mock.ExpectExec(`^UPDATE "books" SET "author"=\$1,"version"=version \+ 1`).
+		WithArgs("Terry Pratchett, Neil Gaiman", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"name":"Terry Pratchett"}`)
+	req, _ := http.NewRequest(http.MethodPatch, "/authors/4", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	w := httptest.NewRecorder()
+
+	UpdateAuthor(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDeleteAuthor_InUse(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(4, "Terry Pratchett", 1))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "book_authors" WHERE author_id = \$1`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	req, _ := http.NewRequest(http.MethodDelete, "/authors/4", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	w := httptest.NewRecorder()
+
+	DeleteAuthor(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
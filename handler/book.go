@@ -2,20 +2,44 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
 	"gorm.io/gorm"
 )
 
 func GetBooks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, err := bookFilters(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
 	books := []model.Book{}
 
-	if err := db.Find(&books).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if err := db.Scopes(filters).Find(&books).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	ids := make([]uint, len(books))
+	versions := make([]uint, len(books))
+	refs := make([]*model.Book, len(books))
+	for i, book := range books {
+		ids[i], versions[i], refs[i] = book.ID, book.Version, &books[i]
+	}
+
+	if notModified(w, r, listETag(ids, versions)) {
+		return
+	}
+
+	if err := bookAvailability(db, refs...); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -29,14 +53,14 @@ func GetBookById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	bookId, ok := vars["id"]
 
 	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid book id"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid book id")}
 		res.Dispatch()
 		return
 	}
 
 	bookIdInt, err := strconv.Atoi(bookId)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid book id"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid book id")}
 		res.Dispatch()
 		return
 	}
@@ -44,7 +68,17 @@ func GetBookById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	book := model.Book{}
 
 	if err := db.First(&book, bookIdInt).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, "book not found"}
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(book.ID, book.Version)) {
+		return
+	}
+
+	if err := bookAvailability(db, &book); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -54,25 +88,54 @@ func GetBookById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	book := model.Book{}
+	fields := model.BookFields{}
 
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	defer r.Body.Close()
 
+	prefillBookFields(r.Context(), &fields)
+
 	// validate payload
-	if err := validate.Struct(&book); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := db.Save(&book).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	book := model.Book{}
+	book.SetFields(fields)
+
+	if err := checkISBNAvailable(db, &book); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&book).Error; err != nil {
+			return err
+		}
+
+		if err := model.CreditBylineAuthors(tx, &book); err != nil {
+			return err
+		}
+
+		return recordStock(tx, &model.StockMovement{
+			BookID:    book.ID,
+			Kind:      model.StockAdjustment,
+			Quantity:  book.AvailableCopies,
+			ActorID:   stockActor(r.Context()),
+			Reason:    "book created",
+			Reference: model.StockRef("books", book.ID),
+		})
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -81,34 +144,72 @@ func CreateBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	res.Dispatch()
 }
 
-func UpdateBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	bookIdStr, ok := vars["id"]
+// GetBookByISBN looks a book up by its ISBN-10 or ISBN-13, or by the ISBN
+// of one of its editions
+func GetBookByISBN(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	isbn13 := utils.NormalizeISBN(mux.Vars(r)["isbn"])
+	if isbn13 == "" {
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid isbn")}
+		res.Dispatch()
+		return
+	}
 
-	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "url params not passed"}
+	book := model.Book{}
+
+	query := db.Where("isbn13 = ? OR id IN (SELECT book_id FROM editions WHERE isbn13 = ? AND deleted_at IS NULL)", isbn13, isbn13)
+
+	if err := query.First(&book).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
-	bookId, err := strconv.Atoi(bookIdStr)
-	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid url params"}
+	if notModified(w, r, versionETag(book.ID, book.Version)) {
+		return
+	}
+
+	if err := bookAvailability(db, &book); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	book := model.UpdateBook{}
-	book.ID = uint(bookId)
+	res := SuccessResponse{w, http.StatusOK, book, ""}
+	res.Dispatch()
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+// checkISBNAvailable rejects an ISBN already assigned to another book
+func checkISBNAvailable(db *gorm.DB, book *model.Book) error {
+	if book.ISBN13 == nil {
+		return nil
+	}
+
+	// soft deleted books still hold their ISBN in the unique index
+	count := int64(0)
+	if err := db.Unscoped().Model(&model.Book{}).
+		Where("isbn13 = ? AND id <> ?", *book.ISBN13, book.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrISBNTaken.WithDetail("isbn %s is already used by another book", *book.ISBN13)
+	}
+	return nil
+}
+
+// UpdateBook applies a JSON merge patch (RFC 7396) to a book
+func UpdateBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := validate.Struct(&book); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -117,41 +218,210 @@ func UpdateBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 
 	dbBook := model.Book{}
 
-	if err := db.First(&dbBook, book.ID).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, err.Error()}
+	if err := db.First(&dbBook, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	if dbBook.ID == 0 {
-		res := ErrorResponse{w, http.StatusNotFound, "book does not exist"}
+		res := ErrorResponse{w, r, ErrBookNotFound}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbBook.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	saveBookFields(db, w, r, &dbBook, fields)
+}
+
+// ReplaceBook overwrites every editable field of a book
+func ReplaceBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.BookFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbBook := model.Book{}
+
+	if err := db.First(&dbBook, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	saveBookFields(db, w, r, &dbBook, fields)
+}
+
+// saveBookFields writes fields only if the book still has the version that
+// was read, so concurrent edits are reported instead of overwritten
+func saveBookFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbBook *model.Book, fields model.BookFields) {
+	if _, err := checkIfMatch(r, versionETag(dbBook.ID, dbBook.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	version, isbn, price, copies, byline := dbBook.Version, dbBook.ISBN(), dbBook.Price, dbBook.AvailableCopies, dbBook.Author
+	dbBook.SetFields(fields)
+	dbBook.Version++
+
+	if dbBook.ISBN() != isbn {
+		if err := checkISBNAvailable(db, dbBook); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	if !dbBook.Price.SameCurrency(price) {
+		if err := checkBookCurrency(db, dbBook); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	updated := int64(0)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		columns, err := bookColumns(tx, dbBook, copies, price)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(dbBook).Where("version = ?", version).Select(columns).Updates(dbBook)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if updated = result.RowsAffected; updated == 0 {
+			return nil
+		}
+
+		if dbBook.AvailableCopies != copies {
+			if err := checkWarehouseStock(tx, dbBook.ID, nil); err != nil {
+				return err
+			}
+		}
+
+		if dbBook.Author != byline {
+			if err := model.CreditBylineAuthors(tx, dbBook); err != nil {
+				return err
+			}
+		}
+
+		return recordStock(tx, &model.StockMovement{
+			BookID:    dbBook.ID,
+			Kind:      model.StockAdjustment,
+			Quantity:  dbBook.AvailableCopies - copies,
+			ActorID:   stockActor(r.Context()),
+			Reason:    "book updated",
+			Reference: model.StockRef("books", dbBook.ID),
+		})
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := db.Model(&dbBook).Updates(&book).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if updated == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("book was modified concurrently")}
 		res.Dispatch()
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(dbBook.ID, dbBook.Version))
+
 	res := SuccessResponse{w, http.StatusOK, dbBook, ""}
 	res.Dispatch()
 }
 
+// bookColumns are the columns saving the fields of book writes. A book
+// with editions keeps the stock and price it had, copies and price, as
+// they are derived from its editions.
+func bookColumns(tx *gorm.DB, book *model.Book, copies int, price model.Money) ([]string, error) {
+	count := int64(0)
+	if err := tx.Model(&model.Edition{}).Where("book_id = ?", book.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return model.BookFieldColumns, nil
+	}
+
+	book.AvailableCopies, book.Price.Amount = copies, price.Amount
+	return model.BookEditionColumns, nil
+}
+
+// checkBookCurrency makes sure the book's editions are priced in its new
+// currency, the book price is derived from theirs.
+func checkBookCurrency(db *gorm.DB, book *model.Book) error {
+	count := int64(0)
+	if err := db.Model(&model.Edition{}).
+		Where("book_id = ? AND price_currency <> ?", book.ID, book.Price.Currency).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrCurrencyMismatch.WithDetail("book has editions priced in another currency than %s", book.Price.Currency)
+	}
+	return nil
+}
+
+func bookIdParam(r *http.Request) (int, error) {
+	bookIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	bookId, err := strconv.Atoi(bookIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid book id")
+	}
+
+	return bookId, nil
+}
+
 func DeleteBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bookIdStr, ok := vars["id"]
 
 	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "url params not passed"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("id is required")}
 		res.Dispatch()
 		return
 	}
 
 	bookId, err := strconv.Atoi(bookIdStr)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid book id")}
 		res.Dispatch()
 		return
 	}
@@ -159,13 +429,32 @@ func DeleteBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	book := model.Book{}
 
 	if err := db.First(&book, bookId).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, "book not found"}
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(book.ID, book.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	query := db
+	if conditional {
+		query = db.Where("version = ?", book.Version)
+	}
+
+	result := query.Delete(&book)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := db.Delete(&book).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("book was modified concurrently")}
 		res.Dispatch()
 		return
 	}
@@ -174,17 +463,28 @@ func DeleteBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	res.Dispatch()
 }
 
+// PurchaseBook sells copies of an edition. Books with a single edition can
+// still be bought by book id, books without editions fall back to the stock
+// kept on the book. The purchase is priced with pricePurchase and the
+// stored purchase is returned as the receipt.
 func PurchaseBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	payload := model.PurchasePayload{}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
 		res.Dispatch()
+		return
 	}
 
 	defer r.Body.Close()
 
 	if err := validate.Struct(&payload); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if paymentProvider != nil && payload.PaymentMethod == "" {
+		res := ErrorResponse{w, r, ErrPaymentRequired}
 		res.Dispatch()
 		return
 	}
@@ -196,75 +496,227 @@ func PurchaseBook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 
 	if err := tx.Error; err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
 	user := model.User{}
-	book := model.Book{}
 
 	if err := tx.First(&user, userId).Error; err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusBadRequest, "user not found"}
+		res := ErrorResponse{w, r, ErrUserNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	if user.ID == 0 {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusBadRequest, "user not found"}
+		res := ErrorResponse{w, r, ErrUserNotFound}
 		res.Dispatch()
 		return
 	}
 
-	if err := tx.First(&book, payload.BookId).Error; err != nil {
+	reservation, err := purchaseReservation(tx, userId, &payload)
+	if err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusBadRequest, "book not found"}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if book.ID == 0 {
+	book, edition, err := purchaseItem(tx, &payload)
+	if err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusBadRequest, "book not found"}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if (book.AvailableCopies - payload.Quantity) <= 0 {
+	// the stock before the purchase, to tell when it drops below the
+	// reorder point
+	stock := book.AvailableCopies
+
+	// purchase
+	purchase := model.Purchase{
+		UserID:   userId,
+		BookID:   book.ID,
+		Quantity: payload.Quantity,
+	}
+
+	var price model.Money
+
+	if reservation != nil {
+		// the reserved copies were taken out of stock already
+		if edition != nil {
+			purchase.EditionID = &edition.ID
+			price = edition.Price
+		} else {
+			price = book.Price
+		}
+	} else if edition != nil {
+		// the stock condition is part of the update so concurrent purchases
+		// can not oversell
+		result := tx.Model(edition).Where("available_copies >= ?", payload.Quantity).Updates(map[string]any{
+			"available_copies": gorm.Expr("available_copies - ?", payload.Quantity),
+			"version":          gorm.Expr("version + 1"),
+		})
+		if err := result.Error; err != nil {
+			tx.Rollback()
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			res := ErrorResponse{w, r, ErrInsufficientStock.WithDetail("only %d stock available, can not purchase %d quantities", edition.AvailableCopies, payload.Quantity)}
+			res.Dispatch()
+			return
+		}
+
+		if err := model.SyncBookFromEditions(tx, book.ID); err != nil {
+			tx.Rollback()
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+
+		purchase.EditionID = &edition.ID
+		price = edition.Price
+	} else {
+		result := tx.Model(&book).Where("available_copies >= ?", payload.Quantity).Updates(map[string]any{
+			"available_copies": gorm.Expr("available_copies - ?", payload.Quantity),
+			"version":          gorm.Expr("version + 1"),
+		})
+		if err := result.Error; err != nil {
+			tx.Rollback()
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			res := ErrorResponse{w, r, ErrInsufficientStock.WithDetail("only %d stock available, can not purchase %d quantities", book.AvailableCopies, payload.Quantity)}
+			res.Dispatch()
+			return
+		}
+
+		price = book.Price
+	}
+
+	format := ""
+	if edition != nil {
+		format = edition.Format
+	}
+
+	if err := pricePurchase(r.Context(), tx, &purchase, price, format, payload.CouponCode, payload.Region); err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusBadRequest, fmt.Sprintf("only %d stock available, can not purchase %d quantities", book.AvailableCopies, payload.Quantity)}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	book.AvailableCopies = (book.AvailableCopies - payload.Quantity) - 1
+	// the purchase holds its copies while it is paid for
+	purchase.Status = model.PurchasePaid
+	if paymentProvider != nil {
+		purchase.Status = model.PurchasePending
+	}
 
-	if err := tx.Save(&book).Error; err != nil {
+	if err := tx.Save(&purchase).Error; err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	// purchase
-	purchase := model.Purchase{
-		UserID:   userId,
-		BookID:   uint(payload.BookId),
-		Quantity: payload.Quantity,
-		Amount:   payload.Quantity * book.Price,
+	if reservation != nil {
+		err = convertReservation(tx, reservation, &purchase)
+	} else {
+		err = recordSale(tx, &purchase)
 	}
-
-	if err := db.Save(&purchase).Error; err != nil {
+	if err != nil {
 		tx.Rollback()
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	tx.Commit()
+	// reserved copies left the stock when they were reserved
+	if reservation == nil && lowStock(&book, stock, stock-purchase.Quantity) {
+		alertLowStock(book, stock-purchase.Quantity)
+	}
+
+	// the payment is taken outside the transaction, a slow provider must
+	// not hold row locks
+	if paymentProvider != nil {
+		if err := chargePurchase(r.Context(), db, &purchase, payload.PaymentMethod); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	if purchase.Status == model.PurchasePending {
+		res := SuccessResponse{w, http.StatusAccepted, purchase, "purchase is pending payment"}
+		res.Dispatch()
+		return
+	}
 
-	res := SuccessResponse{w, http.StatusOK, nil, "successfully purchased book"}
+	res := SuccessResponse{w, http.StatusOK, purchase, "successfully purchased book"}
 	res.Dispatch()
 }
+
+// purchaseItem finds the book and edition a purchase is for. The edition is
+// nil for books sold without editions.
+func purchaseItem(tx *gorm.DB, payload *model.PurchasePayload) (model.Book, *model.Edition, error) {
+	book := model.Book{}
+	var edition *model.Edition
+
+	if payload.EditionId != 0 {
+		edition = &model.Edition{}
+
+		if err := tx.First(edition, payload.EditionId).Error; err != nil {
+			return book, nil, ErrEditionNotFound.Wrap(err)
+		}
+
+		if payload.BookId != 0 && uint(payload.BookId) != edition.BookID {
+			return book, nil, ErrEditionNotFound.WithDetail("edition %d is not an edition of book %d", edition.ID, payload.BookId)
+		}
+
+		payload.BookId = int(edition.BookID)
+	}
+
+	if err := tx.First(&book, payload.BookId).Error; err != nil {
+		return book, nil, ErrBookNotFound.Wrap(err)
+	}
+
+	if book.ID == 0 {
+		return book, nil, ErrBookNotFound
+	}
+
+	if edition == nil {
+		editions := []model.Edition{}
+
+		if err := tx.Where("book_id = ?", book.ID).Find(&editions).Error; err != nil {
+			return book, nil, err
+		}
+
+		if len(editions) > 1 {
+			return book, nil, ErrEditionRequired.WithDetail("book %d is sold in %d editions", book.ID, len(editions))
+		}
+
+		if len(editions) == 1 {
+			edition = &editions[0]
+		}
+	}
+
+	return book, edition, nil
+}
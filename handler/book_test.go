@@ -24,6 +24,7 @@ func TestGetBooks(t *testing.T) {
 		AddRow(2, "Book2", "Author2")
 
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").WillReturnRows(mockRows)
+	expectNoAvailability(mock)
 
 	req, err := http.NewRequest(http.MethodGet, "/books/", nil)
 	if err != nil {
@@ -72,6 +73,7 @@ func TestGetBookById(t *testing.T) {
 		AddRow(1, "Book1", "Author1")
 
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").WillReturnRows(mockRows)
+	expectNoAvailability(mock)
 
 	req, err := http.NewRequest(http.MethodGet, "/books/1", nil)
 	req = mux.SetURLVars(req, map[string]string{"id": "1"})
@@ -135,11 +137,32 @@ func TestCreateBook(t *testing.T) {
 			sqlmock.AnyArg(),
 			"Book1",
 			"Author2",
+			nil,
+			nil,
 			1999,
 			12,
+			0,
+			0,
 			200,
+			"USD",
+			nil,
+			1,
 		).
 		WillReturnRows(mock.NewRows([]string{"ID"}).AddRow(1))
+	// the byline is credited to a new author
+	mock.ExpectQuery(`^SELECT (.+) FROM "authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(3, "Author1"))
+	mock.ExpectQuery(`^INSERT INTO "authors"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "Author2", "", nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(`^SELECT (.+) FROM "book_authors"`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "author_id", "role"}))
+	mock.ExpectExec(`^DELETE FROM "book_authors"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^INSERT INTO "book_authors"`).
+		WithArgs(1, 4, model.RoleAuthor, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockMovement(mock, model.StockAdjustment, 12)
 	mock.ExpectCommit()
 
 	req, err := http.NewRequest(http.MethodPost, "/books", bytes.NewReader(payloadByte))
@@ -181,17 +204,19 @@ func TestCreateBook(t *testing.T) {
 func TestUpdateBook(t *testing.T) {
 	db, mock := utils.GetDBMock()
 
-	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "price"}).
-		AddRow(1, "Book1", "Author1", 200)
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "available_copies", "price_minor", "price_currency", "version"}).
+		AddRow(1, "Book1", "Author1", 1999, 4, 200, "USD", 3)
 
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WithArgs(1, 1).
 		WillReturnRows(mockRows)
 
 	mock.ExpectBegin()
+	expectNoEditions(mock)
 	mock.ExpectExec("^UPDATE \"books\" SET").
-		WithArgs(1, "Book2", "Author2", 1).
+		WithArgs(sqlmock.AnyArg(), "Book2", "Author2", nil, nil, 1999, 4, 0, 0, 200, "USD", 4, 3, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectBylineCredits(mock, 1, 2, "Author2")
 	mock.ExpectCommit()
 
 	payload := struct {
@@ -249,8 +274,8 @@ func TestUpdateBook(t *testing.T) {
 func TestDeleteBook(t *testing.T) {
 	db, mock := utils.GetDBMock()
 
-	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "price"}).
-		AddRow(1, "Book1", "Author1", 200)
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "price_minor", "price_currency"}).
+		AddRow(1, "Book1", "Author1", 200, "USD")
 
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WithArgs(1, 1).
@@ -497,13 +522,14 @@ func TestUpdateBook_BookDoesNotExist(t *testing.T) {
 func TestUpdateBook_UpdateError(t *testing.T) {
 	db, mock := utils.GetDBMock()
 
-	mockRows := sqlmock.NewRows([]string{"ID", "name", "author"}).
-		AddRow(1, "Book1", "Author1")
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "price_currency"}).
+		AddRow(1, "Book1", "Author1", 1999, "USD")
 
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(mockRows)
 
 	mock.ExpectBegin()
+	expectNoEditions(mock)
 	mock.ExpectExec("^UPDATE \"books\"").
 		WillReturnError(errors.New("update error"))
 	mock.ExpectRollback()
@@ -643,8 +669,8 @@ func TestPurchaseBook_UserNotFound(t *testing.T) {
 	PurchaseBook(db, w, req)
 	fmt.Println("body:", w.Body)
 
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400, got %d", w.Code)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
 	}
 }
 
@@ -675,8 +701,8 @@ func TestPurchaseBook_BookNotFound(t *testing.T) {
 
 	PurchaseBook(db, w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400, got %d", w.Code)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
 	}
 }
 
@@ -686,14 +712,20 @@ func TestPurchaseBook_OutOfStock(t *testing.T) {
 	userRows := sqlmock.NewRows([]string{"ID", "name", "email", "available_copies"}).
 		AddRow(1, "user1", "user@example.com", 3)
 
+	// the copies were sold since the book was read
 	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies"}).
-		AddRow(1, "Book1", 0)
+		AddRow(1, "Book1", 10)
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
 		WillReturnRows(userRows)
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(bookRows)
+	mock.ExpectQuery("^SELECT (.+) FROM \"editions\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies - \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE available_copies >= \$3`).
+		WithArgs(10, sqlmock.AnyArg(), 10, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectRollback()
 
 	body := map[string]any{
@@ -710,8 +742,8 @@ func TestPurchaseBook_OutOfStock(t *testing.T) {
 
 	PurchaseBook(db, w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("expected status 400, got %d", w.Code)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
 	}
 }
 
@@ -721,14 +753,16 @@ func TestPurchaseBook_SaveBookError(t *testing.T) {
 	userRows := sqlmock.NewRows([]string{"ID", "name", "email"}).
 		AddRow(1, "user1", "user@example.com")
 
-	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price"}).
-		AddRow(1, "Book1", 5, 200)
+	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).
+		AddRow(1, "Book1", 5, 200, "USD")
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
 		WillReturnRows(userRows)
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(bookRows)
+	mock.ExpectQuery("^SELECT (.+) FROM \"editions\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
 	mock.ExpectExec("^UPDATE \"books\"").
 		WillReturnError(errors.New("save error"))
 	mock.ExpectRollback()
@@ -758,18 +792,20 @@ func TestPurchaseBook_PurchaseError(t *testing.T) {
 	userRows := sqlmock.NewRows([]string{"ID", "name", "email"}).
 		AddRow(1, "user1", "user@example.com")
 
-	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price"}).
-		AddRow(1, "Book1", 5, 250)
+	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).
+		AddRow(1, "Book1", 5, 250, "USD")
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
 		WillReturnRows(userRows)
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(bookRows)
+	mock.ExpectQuery("^SELECT (.+) FROM \"editions\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
 	mock.ExpectExec("^UPDATE \"books\"").
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`^INSERT INTO "purchases"`).
 		WillReturnError(errors.New("save error"))
 	mock.ExpectRollback()
@@ -799,18 +835,20 @@ func TestPurchaseBook_CommitError(t *testing.T) {
 	userRows := sqlmock.NewRows([]string{"ID", "name", "email"}).
 		AddRow(1, "user1", "user@example.com")
 
-	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price"}).
-		AddRow(1, "Book1", 5, 200)
+	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).
+		AddRow(1, "Book1", 5, 200, "USD")
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
 		WillReturnRows(userRows)
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(bookRows)
+	mock.ExpectQuery("^SELECT (.+) FROM \"editions\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
 	mock.ExpectExec("^UPDATE \"books\"").
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`^INSERT INTO "purchases"`).
 		WithArgs(
 			sqlmock.AnyArg(),
@@ -818,14 +856,26 @@ func TestPurchaseBook_CommitError(t *testing.T) {
 			sqlmock.AnyArg(),
 			1,
 			1,
+			nil,
 			3,
 			600,
+			"USD",
+			nil,
+			0,
+			"USD",
+			0,
+			"USD",
+			"",
+			false,
+			"paid",
+			"",
 		).
 		WillReturnRows(mock.NewRows([]string{"ID"}).AddRow(1))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -3)
 
 	mock.ExpectCommit().
 		WillReturnError(errors.New("commit error"))
-	mock.ExpectRollback()
 
 	body := map[string]any{
 		"book_id":  1,
@@ -852,18 +902,21 @@ func TestPurchaseBook_Success(t *testing.T) {
 	userRows := sqlmock.NewRows([]string{"ID", "name", "email"}).
 		AddRow(1, "user1", "user@example.com")
 
-	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price"}).
-		AddRow(1, "Book1", 5, 200)
+	bookRows := sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).
+		AddRow(1, "Book1", 5, 200, "USD")
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
 		WillReturnRows(userRows)
 	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
 		WillReturnRows(bookRows)
-	mock.ExpectExec("^UPDATE \"books\"").
+	mock.ExpectQuery("^SELECT (.+) FROM \"editions\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies - \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE available_copies >= \$3`).
+		WithArgs(3, sqlmock.AnyArg(), 3, 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`^INSERT INTO "purchases"`).
 		WithArgs(
 			sqlmock.AnyArg(),
@@ -871,11 +924,23 @@ func TestPurchaseBook_Success(t *testing.T) {
 			sqlmock.AnyArg(),
 			1,
 			1,
+			nil,
 			3,
 			600,
+			"USD",
+			nil,
+			0,
+			"USD",
+			0,
+			"USD",
+			"",
+			false,
+			"paid",
+			"",
 		).
 		WillReturnRows(mock.NewRows([]string{"ID"}).AddRow(1))
-	mock.ExpectCommit()
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -3)
 	mock.ExpectCommit()
 
 	body := map[string]any{
@@ -0,0 +1,581 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+// CategoryNode is a category with its subcategories, in display order
+type CategoryNode struct {
+	model.Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// CategoryOrderPayload lists every child of a parent, or every root category
+// when ParentID is nil, in the new display order
+type CategoryOrderPayload struct {
+	ParentID *uint  `json:"parent_id"`
+	Order    []uint `json:"order" validate:"required,min=1"`
+}
+
+// BookCategoriesPayload replaces the categories a book is filed under
+type BookCategoriesPayload struct {
+	CategoryIDs []uint `json:"category_ids" validate:"dive,required"`
+}
+
+// GetCategories returns the whole category tree
+func GetCategories(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	categories := []model.Category{}
+
+	if err := db.Order("position, name").Find(&categories).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, categoryTree(categories, nil), ""}
+	res.Dispatch()
+}
+
+// GetCategory returns a category by slug with its subtree
+func GetCategory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	category, err := categoryBySlug(db, mux.Vars(r)["slug"])
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	descendants := []model.Category{}
+
+	if err := db.Where("path LIKE ? AND id <> ?", category.Path+"%", category.ID).
+		Order("position, name").
+		Find(&descendants).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	node := &CategoryNode{Category: category, Children: categoryTree(descendants, &category.ID)}
+
+	res := SuccessResponse{w, http.StatusOK, node, ""}
+	res.Dispatch()
+}
+
+// GetCategoryBooks lists the books filed under a category or any of its
+// descendants. It accepts the same filters as the book list.
+func GetCategoryBooks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, err := bookFilters(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	category, err := categoryBySlug(db, mux.Vars(r)["slug"])
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	subtree := db.Model(&model.BookCategory{}).
+		Select("book_categories.book_id").
+		Joins("JOIN categories ON categories.id = book_categories.category_id").
+		Where("categories.path LIKE ?", category.Path+"%")
+
+	books := []model.Book{}
+
+	if err := db.Where("id IN (?)", subtree).Scopes(filters).Order("id").Find(&books).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, books, ""}
+	res.Dispatch()
+}
+
+func CreateCategory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	fields := model.CategoryFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if fields.Slug == "" {
+		fields.Slug = utils.Slugify(fields.Name)
+	}
+
+	category := model.Category{}
+	category.SetFields(fields)
+
+	if err := validate.Struct(&category); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := checkSlugAvailable(db, &category); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	parent, err := categoryParent(db, category.ParentID)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// the path holds the category's own id, so it is set once the row exists
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+
+		category.Path = parent.ChildPath(category.ID)
+		return tx.Model(&category).Update("path", category.Path).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, category, ""}
+	res.Dispatch()
+}
+
+// UpdateCategory applies a JSON merge patch (RFC 7396) to a category.
+// Setting parent_id moves the category along with its subtree.
+func UpdateCategory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	categoryId, err := categoryIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbCategory := model.Category{}
+
+	if err := db.First(&dbCategory, categoryId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCategoryNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if _, err := checkIfMatch(r, versionETag(dbCategory.ID, dbCategory.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbCategory.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if fields.Slug == "" {
+		fields.Slug = utils.Slugify(fields.Name)
+	}
+
+	version, slug, oldPath := dbCategory.Version, dbCategory.Slug, dbCategory.Path
+	dbCategory.SetFields(fields)
+	dbCategory.Version++
+
+	if err := validate.Struct(&dbCategory); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if dbCategory.Slug != slug {
+		if err := checkSlugAvailable(db, &dbCategory); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	parent, err := categoryParent(db, dbCategory.ParentID)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// a category can not be moved below itself
+	if dbCategory.IsAncestorOf(parent) {
+		res := ErrorResponse{w, r, ErrInvalidCategoryMove.WithDetail("category %d is inside the subtree being moved", parent.ID)}
+		res.Dispatch()
+		return
+	}
+
+	dbCategory.Path = parent.ChildPath(dbCategory.ID)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&dbCategory).Where("version = ?", version).Select(model.CategoryFieldColumns).Updates(&dbCategory)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("category was modified concurrently")
+		}
+
+		if dbCategory.Path == oldPath {
+			return nil
+		}
+		return model.MoveSubtree(tx, oldPath, dbCategory.Path)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbCategory.ID, dbCategory.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbCategory, ""}
+	res.Dispatch()
+}
+
+// ReorderCategories sets the display order of the children of a parent
+func ReorderCategories(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := CategoryOrderPayload{}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	siblings := []model.Category{}
+
+	query := db.Where("parent_id IS NULL")
+	if payload.ParentID != nil {
+		query = db.Where("parent_id = ?", *payload.ParentID)
+	}
+
+	if err := query.Find(&siblings).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	positions := make(map[uint]int, len(payload.Order))
+	for i, id := range payload.Order {
+		positions[id] = i
+	}
+
+	if len(positions) != len(payload.Order) || len(positions) != len(siblings) {
+		res := ErrorResponse{w, r, ErrInvalidCategoryMove.WithDetail("order must list each of the %d sibling categories once", len(siblings))}
+		res.Dispatch()
+		return
+	}
+
+	for _, sibling := range siblings {
+		if _, ok := positions[sibling.ID]; !ok {
+			res := ErrorResponse{w, r, ErrInvalidCategoryMove.WithDetail("category %d is missing from order", sibling.ID)}
+			res.Dispatch()
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range siblings {
+			siblings[i].Position = positions[siblings[i].ID]
+			siblings[i].Version++
+
+			err := tx.Model(&siblings[i]).Select("position", "version").Updates(&siblings[i]).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, categoryTree(siblings, payload.ParentID), ""}
+	res.Dispatch()
+}
+
+// DeleteCategory removes an empty category. Books filed under it are
+// unlinked, subcategories have to be moved or deleted first.
+func DeleteCategory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	categoryId, err := categoryIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	category := model.Category{}
+
+	if err := db.First(&category, categoryId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCategoryNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if _, err := checkIfMatch(r, versionETag(category.ID, category.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	count := int64(0)
+	if err := db.Model(&model.Category{}).Where("parent_id = ?", category.ID).Count(&count).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if count > 0 {
+		res := ErrorResponse{w, r, ErrCategoryNotEmpty.WithDetail("category has %d subcategories", count)}
+		res.Dispatch()
+		return
+	}
+
+	// categories are removed for good so their slug can be reused
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", category.ID).Delete(&model.BookCategory{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Where("version = ?", category.Version).Delete(&category)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("category was modified concurrently")
+		}
+		return nil
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, category, ""}
+	res.Dispatch()
+}
+
+// GetBookCategories lists the categories a book is filed under
+func GetBookCategories(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.Preload("Categories").First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, book.Categories, ""}
+	res.Dispatch()
+}
+
+// SetBookCategories replaces the categories a book is filed under
+func SetBookCategories(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := BookCategoriesPayload{}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	categories := []model.Category{}
+	if len(payload.CategoryIDs) > 0 {
+		if err := db.Find(&categories, payload.CategoryIDs).Error; err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	links := make([]model.BookCategory, len(categories))
+	for i, category := range categories {
+		links[i] = model.BookCategory{BookID: book.ID, CategoryID: category.ID}
+	}
+
+	if len(links) != len(uniqueIds(payload.CategoryIDs)) {
+		res := ErrorResponse{w, r, ErrCategoryNotFound.WithDetail("some of the categories do not exist")}
+		res.Dispatch()
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("book_id = ?", book.ID).Delete(&model.BookCategory{}).Error; err != nil {
+			return err
+		}
+
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(&links).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, categories, ""}
+	res.Dispatch()
+}
+
+// categoryTree nests categories under their parents, starting from the
+// children of root. Categories must already be in display order.
+func categoryTree(categories []model.Category, root *uint) []*CategoryNode {
+	nodes := make(map[uint]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{Category: category, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+
+		if category.ParentID == nil || (root != nil && *category.ParentID == *root) {
+			roots = append(roots, node)
+			continue
+		}
+
+		if parent, ok := nodes[*category.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	return roots
+}
+
+func categoryBySlug(db *gorm.DB, slug string) (model.Category, error) {
+	category := model.Category{}
+
+	if err := db.Where("slug = ?", slug).First(&category).Error; err != nil {
+		return category, ErrCategoryNotFound.Wrap(err)
+	}
+	return category, nil
+}
+
+// categoryParent loads the parent category, a nil id returns an empty
+// category standing for the root
+func categoryParent(db *gorm.DB, parentId *uint) (*model.Category, error) {
+	parent := &model.Category{}
+	if parentId == nil {
+		return parent, nil
+	}
+
+	if err := db.First(parent, *parentId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCategoryNotFound.WithDetail("parent category %d does not exist", *parentId)
+		}
+		return nil, err
+	}
+	return parent, nil
+}
+
+func checkSlugAvailable(db *gorm.DB, category *model.Category) error {
+	count := int64(0)
+	if err := db.Model(&model.Category{}).
+		Where("slug = ? AND id <> ?", category.Slug, category.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrSlugTaken.WithDetail("slug %s is already used by another category", category.Slug)
+	}
+	return nil
+}
+
+func categoryIdParam(r *http.Request) (int, error) {
+	categoryIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	categoryId, err := strconv.Atoi(categoryIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid category id")
+	}
+
+	return categoryId, nil
+}
+
+func uniqueIds(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Science Fiction & Fantasy": "science-fiction-fantasy",
+		"  Crème Brûlée Recipes ":   "creme-brulee-recipes",
+		"19th-century novels":       "19th-century-novels",
+		"!!!":                       "",
+	}
+
+	for name, want := range cases {
+		if got := utils.Slugify(name); got != want {
+			t.Errorf("slugify %q: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestCategoryTree(t *testing.T) {
+	one, four := uint(1), uint(4)
+
+	categories := []model.Category{
+		{Name: "Fiction", Path: "/1/"},
+		{Name: "Fantasy", ParentID: &one, Path: "/1/4/"},
+		{Name: "High Fantasy", ParentID: &four, Path: "/1/4/7/"},
+		{Name: "Travel", Path: "/2/"},
+	}
+	for i, id := range []uint{1, 4, 7, 2} {
+		categories[i].ID = id
+	}
+
+	tree := categoryTree(categories, nil)
+
+	if len(tree) != 2 || tree[0].Name != "Fiction" || tree[1].Name != "Travel" {
+		t.Fatalf("unexpected roots %+v", tree)
+	}
+
+	if len(tree[0].Children) != 1 || tree[0].Children[0].Children[0].Name != "High Fantasy" {
+		t.Fatalf("unexpected subtree %+v", tree[0].Children)
+	}
+
+	if subtree := categoryTree(categories[1:3], &one); len(subtree) != 1 || subtree[0].ID != 4 {
+		t.Fatalf("unexpected subtree from category 1 %+v", subtree)
+	}
+}
+
+func TestGetCategoryBooks_IncludesDescendants(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "categories" WHERE slug = \$1`).
+		WithArgs("fantasy", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "slug", "path"}).AddRow(4, "Fantasy", "fantasy", "/1/4/"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE id IN \(SELECT book_categories.book_id FROM "book_categories" JOIN categories ON categories.id = book_categories.category_id WHERE categories.path LIKE \$1\) AND available_copies > 0`).
+		WithArgs("/1/4/%").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(1, "Book1").AddRow(2, "Book2"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/categories/fantasy/books?in_stock=true", nil)
+	req = mux.SetURLVars(req, map[string]string{"slug": "fantasy"})
+	w := httptest.NewRecorder()
+
+	GetCategoryBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateCategory_Move(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "categories"`).
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "slug", "parent_id", "position", "path", "version"}).
+			AddRow(4, "Fantasy", "fantasy", 1, 0, "/1/4/", 2))
+	mock.ExpectQuery(`^SELECT (.+) FROM "categories"`).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "slug", "path", "version"}).
+			AddRow(2, "Genre", "genre", "/2/", 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "categories" SET`).
+		WithArgs(sqlmock.AnyArg(), "Fantasy", "fantasy", 2, 0, "/2/4/", 3, 2, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "categories" SET "path"=\$1 \|\| SUBSTRING\(path FROM \$2\),"updated_at"=\$3 WHERE path LIKE \$4`).
+		WithArgs("/2/4/", 6, sqlmock.AnyArg(), "/1/4/%").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/categories/4", bytes.NewReader([]byte(`{"parent_id":2}`)))
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	w := httptest.NewRecorder()
+
+	UpdateCategory(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateCategory_MoveIntoOwnSubtree(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "categories"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "slug", "path", "version"}).
+			AddRow(1, "Fiction", "fiction", "/1/", 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "categories"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "slug", "parent_id", "path", "version"}).
+			AddRow(7, "High Fantasy", "high-fantasy", 4, "/1/4/7/", 1))
+
+	req, _ := http.NewRequest(http.MethodPatch, "/categories/1", bytes.NewReader([]byte(`{"parent_id":7}`)))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateCategory(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodeInvalidMove {
+		t.Fatalf("expected INVALID_CATEGORY_MOVE, got %s", res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateCategory_DefaultSlug(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "categories" WHERE \(slug = \$1`).
+		WithArgs("science-fiction", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO "categories"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(`^UPDATE "categories" SET "path"=\$1`).
+		WithArgs("/9/", sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPost, "/categories/", bytes.NewReader([]byte(`{"name":"Science Fiction"}`)))
+	w := httptest.NewRecorder()
+
+	CreateCategory(db, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.Category `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.Slug != "science-fiction" || res.Data.Path != "/9/" {
+		t.Fatalf("unexpected category %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/peekeah/book-store/logger"
 )
 
 // respondJSON makes the response with payload as json format
@@ -29,10 +31,11 @@ type SuccessResponse struct {
 	Message string
 }
 
+// ErrorResponse renders Err as an RFC 7807 problem document.
 type ErrorResponse struct {
-	RW     http.ResponseWriter
-	Status int
-	Error  any
+	RW  http.ResponseWriter
+	Req *http.Request
+	Err error
 }
 
 type SuccessJSON struct {
@@ -41,9 +44,16 @@ type SuccessJSON struct {
 	Data    any    `json:"data"`
 }
 
+// ErrorJSON is an application/problem+json body extended with a stable code
+// and per field validation errors.
 type ErrorJSON struct {
-	Status int `json:"status"`
-	Error  any `json:"error"`
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     ErrorCode    `json:"code"`
+	Fields   []FieldError `json:"fields,omitempty"`
 }
 
 func (r *SuccessResponse) Dispatch() {
@@ -55,8 +65,46 @@ func (r *SuccessResponse) Dispatch() {
 }
 
 func (r *ErrorResponse) Dispatch() {
-	sendResponse(r.RW, r.Status, ErrorJSON{
-		Status: r.Status,
-		Error:  r.Error,
-	})
+	apiErr := asAPIError(r.Req, r.Err)
+	status := apiErr.Status()
+
+	l := logger.Get()
+	event := l.Warn()
+	if status >= http.StatusInternalServerError {
+		event = l.Error()
+	}
+
+	event.
+		Err(apiErr.Err).
+		Str("code", string(apiErr.Code)).
+		Str("method", r.Req.Method).
+		Str("url", r.Req.URL.RequestURI()).
+		Int("status", status).
+		Msg(apiErr.Title())
+
+	problem := ErrorJSON{
+		Type:     problemType(apiErr.Code),
+		Title:    apiErr.Title(),
+		Status:   status,
+		Detail:   apiErr.Detail,
+		Instance: r.Req.URL.Path,
+		Code:     apiErr.Code,
+		Fields:   apiErr.Fields,
+	}
+
+	response, err := json.Marshal(problem)
+	if err != nil {
+		r.RW.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	r.RW.Header().Set("Content-Type", "application/problem+json")
+	r.RW.WriteHeader(status)
+	r.RW.Write(response)
+}
+
+// GetErrorCatalog lists the error codes clients may receive.
+func GetErrorCatalog(w http.ResponseWriter, r *http.Request) {
+	res := SuccessResponse{w, http.StatusOK, ErrorCatalog(), ""}
+	res.Dispatch()
 }
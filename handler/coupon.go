@@ -0,0 +1,513 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetCoupons(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	coupons := []model.Coupon{}
+
+	if err := db.Order("code").Find(&coupons).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := loadCouponTargets(db, coupons); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, coupons, ""}
+	res.Dispatch()
+}
+
+func GetCouponById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	couponId, err := couponIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	coupon := model.Coupon{}
+
+	if err := db.First(&coupon, couponId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCouponNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(coupon.ID, coupon.Version)) {
+		return
+	}
+
+	coupons := []model.Coupon{coupon}
+	if err := loadCouponTargets(db, coupons); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, coupons[0], ""}
+	res.Dispatch()
+}
+
+func CreateCoupon(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	fields := model.CouponFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validateCouponFields(db, fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	coupon := model.Coupon{}
+	coupon.SetFields(fields)
+
+	if err := checkCouponCodeAvailable(db, &coupon); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&coupon).Error; err != nil {
+			return err
+		}
+		return saveCouponTargets(tx, &coupon)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, coupon, ""}
+	res.Dispatch()
+}
+
+// UpdateCoupon applies a JSON merge patch (RFC 7396) to a coupon
+func UpdateCoupon(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	couponId, err := couponIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbCoupon := model.Coupon{}
+
+	if err := db.First(&dbCoupon, couponId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCouponNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	coupons := []model.Coupon{dbCoupon}
+	if err := loadCouponTargets(db, coupons); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+	dbCoupon = coupons[0]
+
+	fields := dbCoupon.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	saveCouponFields(db, w, r, &dbCoupon, fields)
+}
+
+// ReplaceCoupon overwrites every editable field of a coupon
+func ReplaceCoupon(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	couponId, err := couponIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.CouponFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbCoupon := model.Coupon{}
+
+	if err := db.First(&dbCoupon, couponId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCouponNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	saveCouponFields(db, w, r, &dbCoupon, fields)
+}
+
+// saveCouponFields writes fields only if the coupon still has the version
+// that was read. Redemptions are kept, the limits apply to them as they are.
+func saveCouponFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbCoupon *model.Coupon, fields model.CouponFields) {
+	if _, err := checkIfMatch(r, versionETag(dbCoupon.ID, dbCoupon.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validateCouponFields(db, fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	version, code := dbCoupon.Version, dbCoupon.Code
+	dbCoupon.SetFields(fields)
+	dbCoupon.Version++
+
+	if dbCoupon.Code != code {
+		if err := checkCouponCodeAvailable(db, dbCoupon); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(dbCoupon).Where("version = ?", version).Select(model.CouponFieldColumns).Updates(dbCoupon)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("coupon was modified concurrently")
+		}
+
+		return saveCouponTargets(tx, dbCoupon)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbCoupon.ID, dbCoupon.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbCoupon, ""}
+	res.Dispatch()
+}
+
+// DeleteCoupon stops a coupon from being redeemed. It is soft deleted so
+// the purchases it was redeemed on keep referring to it, and its code can
+// not be given to another coupon.
+func DeleteCoupon(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	couponId, err := couponIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	coupon := model.Coupon{}
+
+	if err := db.First(&coupon, couponId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrCouponNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(coupon.ID, coupon.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	query := db
+	if conditional {
+		query = query.Where("version = ?", coupon.Version)
+	}
+
+	result := query.Delete(&coupon)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("coupon was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, coupon, ""}
+	res.Dispatch()
+}
+
+// validateCouponFields checks the rules spanning several fields and that the
+// eligible books and categories exist
+func validateCouponFields(db *gorm.DB, fields model.CouponFields) error {
+	if err := validate.Struct(&fields); err != nil {
+		return err
+	}
+
+	if fields.Kind == model.CouponFixed && fields.AmountOff.IsZero() {
+		return ErrValidationFailed.WithDetail("amount_off is required for fixed coupons")
+	}
+
+	if fields.StartsAt != nil && fields.ExpiresAt != nil && !fields.ExpiresAt.After(*fields.StartsAt) {
+		return ErrValidationFailed.WithDetail("expires_at must be after starts_at")
+	}
+
+	if fields.Kind == model.CouponFixed && !fields.MinSpend.IsZero() && !fields.MinSpend.SameCurrency(fields.AmountOff) {
+		return ErrCurrencyMismatch.WithDetail("min_spend and amount_off must be in the same currency")
+	}
+
+	if ids := uniqueIds(fields.BookIDs); len(ids) > 0 {
+		count := int64(0)
+		if err := db.Model(&model.Book{}).Where("id IN ?", fields.BookIDs).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if int(count) != len(ids) {
+			return ErrBookNotFound.WithDetail("some of the books do not exist")
+		}
+	}
+
+	if ids := uniqueIds(fields.CategoryIDs); len(ids) > 0 {
+		count := int64(0)
+		if err := db.Model(&model.Category{}).Where("id IN ?", fields.CategoryIDs).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if int(count) != len(ids) {
+			return ErrCategoryNotFound.WithDetail("some of the categories do not exist")
+		}
+	}
+
+	return nil
+}
+
+// checkCouponCodeAvailable rejects a code another coupon has, deleted
+// coupons included since purchases still refer to them
+func checkCouponCodeAvailable(db *gorm.DB, coupon *model.Coupon) error {
+	count := int64(0)
+	if err := db.Unscoped().Model(&model.Coupon{}).
+		Where("code = ? AND id <> ?", coupon.Code, coupon.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrCouponExists.WithDetail("coupon %s already exists", coupon.Code)
+	}
+	return nil
+}
+
+// loadCouponTargets fills in the books and categories coupons are
+// restricted to
+func loadCouponTargets(db *gorm.DB, coupons []model.Coupon) error {
+	if len(coupons) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(coupons))
+	index := make(map[uint]*model.Coupon, len(coupons))
+	for i := range coupons {
+		ids[i] = coupons[i].ID
+		index[coupons[i].ID] = &coupons[i]
+		coupons[i].BookIDs = []uint{}
+		coupons[i].CategoryIDs = []uint{}
+	}
+
+	books := []model.CouponBook{}
+	if err := db.Where("coupon_id IN ?", ids).Order("book_id").Find(&books).Error; err != nil {
+		return err
+	}
+
+	for _, link := range books {
+		coupon := index[link.CouponID]
+		coupon.BookIDs = append(coupon.BookIDs, link.BookID)
+	}
+
+	categories := []model.CouponCategory{}
+	if err := db.Where("coupon_id IN ?", ids).Order("category_id").Find(&categories).Error; err != nil {
+		return err
+	}
+
+	for _, link := range categories {
+		coupon := index[link.CouponID]
+		coupon.CategoryIDs = append(coupon.CategoryIDs, link.CategoryID)
+	}
+
+	return nil
+}
+
+// saveCouponTargets replaces the books and categories a coupon is
+// restricted to
+func saveCouponTargets(tx *gorm.DB, coupon *model.Coupon) error {
+	if err := tx.Where("coupon_id = ?", coupon.ID).Delete(&model.CouponBook{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("coupon_id = ?", coupon.ID).Delete(&model.CouponCategory{}).Error; err != nil {
+		return err
+	}
+
+	coupon.BookIDs = slices.Compact(slices.Sorted(slices.Values(coupon.BookIDs)))
+	coupon.CategoryIDs = slices.Compact(slices.Sorted(slices.Values(coupon.CategoryIDs)))
+
+	if len(coupon.BookIDs) > 0 {
+		books := make([]model.CouponBook, len(coupon.BookIDs))
+		for i, id := range coupon.BookIDs {
+			books[i] = model.CouponBook{CouponID: coupon.ID, BookID: id}
+		}
+
+		if err := tx.Omit("Coupon", "Book").Create(&books).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(coupon.CategoryIDs) > 0 {
+		categories := make([]model.CouponCategory, len(coupon.CategoryIDs))
+		for i, id := range coupon.CategoryIDs {
+			categories[i] = model.CouponCategory{CouponID: coupon.ID, CategoryID: id}
+		}
+
+		if err := tx.Omit("Coupon", "Category").Create(&categories).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redeemCoupon takes the discount of the coupon with code off what is left
+// of the amount of a purchase after promotions. The coupon row is locked until the purchase
+// transaction ends so concurrent purchases can not exceed its limits, and
+// the purchase itself records the redemption.
+func redeemCoupon(tx *gorm.DB, purchase *model.Purchase, code string) error {
+	coupon := model.Coupon{}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code = ?", model.NormalizeCouponCode(code)).
+		First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCouponNotFound.WithDetail("coupon %s does not exist", code).Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if coupon.StartsAt != nil && now.Before(*coupon.StartsAt) {
+		return ErrCouponInactive.WithDetail("coupon %s is valid from %s", coupon.Code, coupon.StartsAt.UTC().Format(time.RFC3339))
+	}
+	if coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt) {
+		return ErrCouponInactive.WithDetail("coupon %s expired on %s", coupon.Code, coupon.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	if coupon.MaxRedemptions > 0 && coupon.Redemptions >= coupon.MaxRedemptions {
+		return ErrCouponExhausted.WithDetail("coupon %s was redeemed %d times", coupon.Code, coupon.Redemptions)
+	}
+
+	if coupon.MaxPerUser > 0 {
+		count := int64(0)
+		if err := tx.Model(&model.Purchase{}).
+			Where("coupon_id = ? AND user_id = ? AND status <> ?", coupon.ID, purchase.UserID, model.PurchaseFailed).
+			Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count >= int64(coupon.MaxPerUser) {
+			return ErrCouponUserLimit.WithDetail("coupon %s can be redeemed %d times per customer", coupon.Code, coupon.MaxPerUser)
+		}
+	}
+
+	applies, err := coupon.AppliesTo(tx, purchase.BookID)
+	if err != nil {
+		return err
+	}
+	if !applies {
+		return ErrCouponNotEligible.WithDetail("coupon %s does not apply to book %d", coupon.Code, purchase.BookID)
+	}
+
+	if !coupon.MinSpend.IsZero() {
+		cmp, err := purchase.Amount.Cmp(coupon.MinSpend)
+		if errors.Is(err, model.ErrCurrencyMismatch) {
+			return ErrCouponNotEligible.WithDetail("coupon %s only applies to purchases in %s", coupon.Code, coupon.MinSpend.Currency).Wrap(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if cmp < 0 {
+			return ErrCouponMinSpend.WithDetail("coupon %s needs a minimum spend of %s", coupon.Code, coupon.MinSpend)
+		}
+	}
+
+	discount, err := coupon.Discount(purchase.Amount)
+	if errors.Is(err, model.ErrCurrencyMismatch) {
+		return ErrCouponNotEligible.WithDetail("coupon %s only applies to purchases in %s", coupon.Code, coupon.AmountOff.Currency).Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&coupon).UpdateColumn("redemptions", gorm.Expr("redemptions + 1")).Error; err != nil {
+		return err
+	}
+
+	purchase.CouponID = &coupon.ID
+	return discountPurchase(purchase, model.DiscountLine{Name: "Coupon " + coupon.Code, CouponID: &coupon.ID, Amount: discount})
+}
+
+func couponIdParam(r *http.Request) (int, error) {
+	couponIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	couponId, err := strconv.Atoi(couponIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid coupon id")
+	}
+
+	return couponId, nil
+}
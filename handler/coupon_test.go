@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+var couponColumns = []string{"ID", "code", "kind", "percent_off", "amount_off_minor", "amount_off_currency", "min_spend_minor", "min_spend_currency", "expires_at", "max_redemptions", "max_per_user", "redemptions"}
+
+// expectCouponPurchase expects a purchase of two copies of a 10.00 book up to
+// the coupon lookup
+func expectCouponPurchase(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 5, 1000, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+func purchaseWithCoupon(db *gorm.DB, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]any{"book_id": 1, "quantity": 2, "coupon_code": code})
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+	return w
+}
+
+func TestPurchaseBook_Coupon(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	expectCouponPurchase(mock)
+	mock.ExpectQuery(`^SELECT (.+) FROM "coupons" WHERE code = \$1 (.+) FOR UPDATE`).
+		WithArgs("SPRING-10", 1).
+		WillReturnRows(sqlmock.NewRows(couponColumns).AddRow(3, "SPRING-10", "percent", 10, 0, "USD", 1500, "USD", nil, 100, 1, 4))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "purchases" WHERE \(coupon_id = \$1 AND user_id = \$2 AND status <> \$3\)`).
+		WithArgs(3, 1, "failed").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`^SELECT \(NOT EXISTS \(SELECT 1 FROM coupon_books`).
+		WithArgs(3, 3, 3, 1, 1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"applies"}).AddRow(true))
+	mock.ExpectExec(`^UPDATE "coupons" SET "redemptions"=redemptions \+ 1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, nil, 2, 1800, "USD", 3, 200, "USD", 0, "USD", "", false, "paid", "").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1))
+	mock.ExpectQuery(`^INSERT INTO "purchase_discounts"`).
+		WithArgs(1, "Coupon SPRING-10", nil, 3, 200, "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectCommit()
+
+	w := purchaseWithCoupon(db, "spring-10")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_CouponRejected(t *testing.T) {
+	db, mock := utils.GetDBMock()
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	cases := []struct {
+		name   string
+		coupon []driver.Value
+		expect func()
+		status int
+		code   ErrorCode
+	}{
+		{
+			name:   "unknown",
+			status: http.StatusNotFound,
+			code:   CodeCouponNotFound,
+		},
+		{
+			name:   "expired",
+			coupon: []driver.Value{3, "SALE", "percent", 10, 0, "USD", 0, "USD", yesterday, 0, 0, 0},
+			status: http.StatusConflict,
+			code:   CodeCouponInactive,
+		},
+		{
+			name:   "exhausted",
+			coupon: []driver.Value{3, "SALE", "percent", 10, 0, "USD", 0, "USD", nil, 5, 0, 5},
+			status: http.StatusConflict,
+			code:   CodeCouponExhausted,
+		},
+		{
+			name:   "per user",
+			coupon: []driver.Value{3, "SALE", "percent", 10, 0, "USD", 0, "USD", nil, 0, 1, 1},
+			expect: func() {
+				mock.ExpectQuery(`^SELECT count\(\*\) FROM "purchases"`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			},
+			status: http.StatusConflict,
+			code:   CodeCouponUserLimit,
+		},
+		{
+			name:   "not eligible",
+			coupon: []driver.Value{3, "SALE", "percent", 10, 0, "USD", 0, "USD", nil, 0, 0, 0},
+			expect: func() {
+				mock.ExpectQuery(`^SELECT \(NOT EXISTS`).
+					WillReturnRows(sqlmock.NewRows([]string{"applies"}).AddRow(false))
+			},
+			status: http.StatusConflict,
+			code:   CodeCouponNotEligible,
+		},
+		{
+			name:   "min spend",
+			coupon: []driver.Value{3, "SALE", "fixed", 0, 500, "USD", 2500, "USD", nil, 0, 0, 0},
+			expect: func() {
+				mock.ExpectQuery(`^SELECT \(NOT EXISTS`).
+					WillReturnRows(sqlmock.NewRows([]string{"applies"}).AddRow(true))
+			},
+			status: http.StatusConflict,
+			code:   CodeCouponMinSpend,
+		},
+		{
+			name:   "other currency",
+			coupon: []driver.Value{3, "SALE", "fixed", 0, 500, "EUR", 0, "EUR", nil, 0, 0, 0},
+			expect: func() {
+				mock.ExpectQuery(`^SELECT \(NOT EXISTS`).
+					WillReturnRows(sqlmock.NewRows([]string{"applies"}).AddRow(true))
+			},
+			status: http.StatusConflict,
+			code:   CodeCouponNotEligible,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			expectCouponPurchase(mock)
+
+			rows := sqlmock.NewRows(couponColumns)
+			if c.coupon != nil {
+				rows.AddRow(c.coupon...)
+			}
+			mock.ExpectQuery(`^SELECT (.+) FROM "coupons"`).WillReturnRows(rows)
+
+			if c.expect != nil {
+				c.expect()
+			}
+			mock.ExpectRollback()
+
+			w := purchaseWithCoupon(db, "sale")
+
+			if w.Code != c.status {
+				t.Fatalf("expected status %d, got %d: %s", c.status, w.Code, w.Body.String())
+			}
+
+			res := ErrorJSON{}
+			json.Unmarshal(w.Body.Bytes(), &res)
+
+			if res.Code != c.code {
+				t.Fatalf("expected %s, got %+v", c.code, res)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateCoupon(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "books" WHERE id IN \(\$1,\$2\)`).
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "coupons" WHERE code = \$1 AND id <> \$2`).
+		WithArgs("WELCOME", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO "coupons"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "redemptions"}).AddRow(5, 0))
+	mock.ExpectExec(`^DELETE FROM "coupon_books" WHERE coupon_id = \$1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^DELETE FROM "coupon_categories" WHERE coupon_id = \$1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^INSERT INTO "coupon_books" \("coupon_id","book_id"\) VALUES \(\$1,\$2\),\(\$3,\$4\)`).
+		WithArgs(5, 2, 5, 4).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	body := []byte(`{"code":"welcome","kind":"fixed","amount_off":{"amount":500,"currency":"USD"},"max_per_user":1,"book_ids":[4,2]}`)
+	req, _ := http.NewRequest(http.MethodPost, "/coupons/", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	CreateCoupon(db, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateCoupon_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"fixed without amount":  `{"code":"TEN","kind":"fixed"}`,
+		"percent over 100":      `{"code":"TEN","kind":"percent","percent_off":120}`,
+		"code with spaces":      `{"code":"TEN OFF","kind":"percent","percent_off":10}`,
+		"expires before starts": `{"code":"TEN","kind":"percent","percent_off":10,"starts_at":"2025-02-01T00:00:00Z","expires_at":"2025-01-01T00:00:00Z"}`,
+	}
+
+	for name, body := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "/coupons/", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+
+		CreateCoupon(nil, w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+	}
+}
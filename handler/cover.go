@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/peekeah/book-store/logger"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/storage"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxCoverBytes is the largest cover upload accepted
+	MaxCoverBytes = 5 << 20
+	// MaxCoverPixels rejects images that are small on the wire but would
+	// take too much memory once decoded
+	MaxCoverPixels = 40_000_000
+)
+
+// coverSizes are the thumbnails generated for every cover, from the
+// largest down, each fitting a square of Size pixels
+var coverSizes = []struct {
+	Name string
+	Size int
+}{
+	{"large", 800},
+	{"medium", 400},
+	{"small", 160},
+}
+
+// coverTypes are the accepted cover formats with the extension they are
+// stored under. Detection is done on the content, not the declared type.
+var coverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// blobStore keeps cover images, nil disables uploads
+var blobStore storage.BlobStore
+
+func SetBlobStore(store storage.BlobStore) {
+	blobStore = store
+}
+
+// UploadBookCover stores a cover image for a book along with its
+// thumbnails. The image is sent as the request body or as the "file" part
+// of a multipart form.
+func UploadBookCover(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if blobStore == nil {
+		res := ErrorResponse{w, r, ErrInternal.WithDetail("cover storage is not configured")}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if _, err := checkIfMatch(r, versionETag(book.ID, book.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	data, err := readCover(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	cover, renditions, err := renderCover(data)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	ctx := r.Context()
+	cover.URLs = map[string]string{}
+
+	for name, rendition := range renditions {
+		key := coverKey(book.ID, cover, name)
+
+		if err := blobStore.Put(ctx, key, rendition.data, rendition.contentType); err != nil {
+			removeCover(ctx, book.ID, cover)
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+		cover.URLs[name] = blobStore.URL(key)
+	}
+
+	version, previous := book.Version, book.Cover
+	book.Cover = cover
+	book.Version++
+
+	result := db.Model(&book).Where("version = ?", version).Select("cover", "version").Updates(&book)
+	if result.Error != nil || result.RowsAffected == 0 {
+		// the same image uploaded again shares its blobs with the cover in use
+		if previous == nil || previous.Hash != cover.Hash {
+			removeCover(ctx, book.ID, cover)
+		}
+
+		err := result.Error
+		if err == nil {
+			err = ErrPreconditionFailed.WithDetail("book was modified concurrently")
+		}
+
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if previous != nil && previous.Hash != cover.Hash {
+		removeCover(ctx, book.ID, previous)
+	}
+
+	w.Header().Set("ETag", versionETag(book.ID, book.Version))
+
+	res := SuccessResponse{w, http.StatusOK, book, ""}
+	res.Dispatch()
+}
+
+// DeleteBookCover removes the cover of a book and its stored images
+func DeleteBookCover(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if _, err := checkIfMatch(r, versionETag(book.ID, book.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if book.Cover == nil {
+		res := ErrorResponse{w, r, ErrCoverNotFound}
+		res.Dispatch()
+		return
+	}
+
+	version, previous := book.Version, book.Cover
+	book.Cover = nil
+	book.Version++
+
+	result := db.Model(&book).Where("version = ?", version).Select("cover", "version").Updates(&book)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("book was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	if blobStore != nil {
+		removeCover(r.Context(), book.ID, previous)
+	}
+
+	w.Header().Set("ETag", versionETag(book.ID, book.Version))
+
+	res := SuccessResponse{w, http.StatusOK, book, ""}
+	res.Dispatch()
+}
+
+// readCover reads the uploaded image, at most MaxCoverBytes of it
+func readCover(r *http.Request) ([]byte, error) {
+	var src io.Reader = r.Body
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, ErrMalformedRequest.Wrap(err)
+		}
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, ErrMalformedRequest.WithDetail("multipart upload must contain a \"file\" part")
+			}
+			if err != nil {
+				return nil, ErrMalformedRequest.Wrap(err)
+			}
+
+			if part.FormName() == "file" {
+				src = part
+				break
+			}
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(src, MaxCoverBytes+1))
+	if err != nil {
+		return nil, ErrMalformedRequest.Wrap(err)
+	}
+
+	if len(data) > MaxCoverBytes {
+		return nil, ErrCoverTooLarge.WithDetail("cover must not exceed %d MB", MaxCoverBytes>>20)
+	}
+
+	if len(data) == 0 {
+		return nil, ErrMalformedRequest.WithDetail("cover image is empty")
+	}
+
+	return data, nil
+}
+
+type coverRendition struct {
+	data        []byte
+	contentType string
+}
+
+// renderCover checks the image and produces the original and thumbnail
+// renditions, thumbnails are always JPEG
+func renderCover(data []byte) (*model.BookCover, map[string]coverRendition, error) {
+	contentType := mimetype.Detect(data).String()
+	if _, ok := coverTypes[contentType]; !ok {
+		return nil, nil, ErrUnsupportedMediaType.WithDetail("cover must be a JPEG, PNG or GIF image, got %s", contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrUnsupportedMediaType.WithDetail("cover image could not be read: %v", err)
+	}
+
+	if config.Width*config.Height > MaxCoverPixels {
+		return nil, nil, ErrCoverTooLarge.WithDetail("cover must not exceed %d megapixels", MaxCoverPixels/1_000_000)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrUnsupportedMediaType.WithDetail("cover image could not be read: %v", err)
+	}
+
+	hash := sha256.Sum256(data)
+	cover := &model.BookCover{
+		Hash:        hex.EncodeToString(hash[:16]),
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+
+	renditions := map[string]coverRendition{
+		"original": {data, contentType},
+	}
+
+	// each size is scaled from the previous one, which is much cheaper than
+	// starting from a large original every time
+	for _, size := range coverSizes {
+		img = utils.Thumbnail(img, size.Size)
+
+		buf := bytes.Buffer{}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, nil, err
+		}
+		renditions[size.Name] = coverRendition{buf.Bytes(), "image/jpeg"}
+	}
+
+	return cover, renditions, nil
+}
+
+// coverKey is where a rendition of a cover is stored. The content hash
+// makes every key immutable so images can be cached indefinitely.
+func coverKey(bookId uint, cover *model.BookCover, name string) string {
+	ext := ".jpg"
+	if name == "original" {
+		ext = coverTypes[cover.ContentType]
+	}
+	return fmt.Sprintf("covers/%d/%s/%s%s", bookId, cover.Hash, name, ext)
+}
+
+// removeCover deletes every rendition of a cover. Failures only leave
+// unreferenced files behind so they are logged, not returned.
+func removeCover(ctx context.Context, bookId uint, cover *model.BookCover) {
+	names := []string{"original"}
+	for _, size := range coverSizes {
+		names = append(names, size.Name)
+	}
+
+	for _, name := range names {
+		key := coverKey(bookId, cover, name)
+
+		if err := blobStore.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			l := logger.Get()
+			l.Warn().Err(err).Str("key", key).Msg("Removing cover image failed")
+		}
+	}
+}
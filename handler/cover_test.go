@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/storage"
+	"github.com/peekeah/book-store/utils"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStore) URL(key string) string {
+	return "https://cdn.example.com/" + key
+}
+
+func testPNG(width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+
+	if bounds := utils.Thumbnail(src, 800).Bounds(); bounds.Dx() != 800 || bounds.Dy() != 400 {
+		t.Fatalf("unexpected large size %v", bounds)
+	}
+
+	if bounds := utils.Thumbnail(src, 160).Bounds(); bounds.Dx() != 160 || bounds.Dy() != 80 {
+		t.Fatalf("unexpected small size %v", bounds)
+	}
+
+	// transparent pixels are flattened onto white
+	if c := utils.Thumbnail(src, 2000).RGBAAt(0, 0); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("expected white, got %v", c)
+	}
+}
+
+func TestUploadBookCover(t *testing.T) {
+	db, mock := utils.GetDBMock()
+	store := &memoryStore{objects: map[string][]byte{}}
+	SetBlobStore(store)
+	defer SetBlobStore(nil)
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(1, "Book1", 3))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "books" SET "updated_at"=\$1,"cover"=\$2,"version"=\$3 WHERE version = \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 4, 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPost, "/books/1/cover", bytes.NewReader(testPNG(1200, 600)))
+	req.Header.Set("Content-Type", "image/png")
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UploadBookCover(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.Book `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	cover := res.Data.Cover
+	if cover == nil || cover.Width != 1200 || cover.ContentType != "image/png" || len(cover.URLs) != 4 {
+		t.Fatalf("unexpected cover %+v", cover)
+	}
+
+	if !strings.HasSuffix(cover.URLs["original"], "/original.png") || !strings.HasSuffix(cover.URLs["small"], "/small.jpg") {
+		t.Fatalf("unexpected cover urls %v", cover.URLs)
+	}
+
+	small, _, err := image.DecodeConfig(bytes.NewReader(store.objects["covers/1/"+cover.Hash+"/small.jpg"]))
+	if err != nil || small.Width != 160 || small.Height != 80 {
+		t.Fatalf("unexpected small thumbnail %+v %v", small, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUploadBookCover_Rejected(t *testing.T) {
+	db, mock := utils.GetDBMock()
+	SetBlobStore(&memoryStore{objects: map[string][]byte{}})
+	defer SetBlobStore(nil)
+
+	cases := map[string]struct {
+		body   []byte
+		status int
+	}{
+		"not an image": {[]byte("%PDF-1.4 not a cover"), http.StatusUnsupportedMediaType},
+		"too large":    {bytes.Repeat([]byte{0}, MaxCoverBytes+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for name, c := range cases {
+		mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(1, "Book1", 1))
+
+		req, _ := http.NewRequest(http.MethodPost, "/books/1/cover", bytes.NewReader(c.body))
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+
+		UploadBookCover(db, w, req)
+
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", name, c.status, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+// GetBookEditions lists every format a book is sold in
+func GetBookEditions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	editions := []model.Edition{}
+
+	if err := db.Preload("Publisher").Where("book_id = ?", book.ID).Order("id").Find(&editions).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, editions, ""}
+	res.Dispatch()
+}
+
+func GetEditionById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	editionId, err := editionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	edition := model.Edition{}
+
+	if err := db.Preload("Publisher").First(&edition, editionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrEditionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(edition.ID, edition.Version)) {
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, edition, ""}
+	res.Dispatch()
+}
+
+// CreateEdition adds a format to a book, the book's stock and price are
+// recomputed from its editions
+func CreateEdition(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	fields := model.EditionFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	edition := model.Edition{BookID: book.ID}
+	edition.SetFields(fields)
+
+	if !edition.Price.SameCurrency(book.Price) {
+		res := ErrorResponse{w, r, ErrCurrencyMismatch.WithDetail("edition must be priced in %s like its book", book.Price.Currency)}
+		res.Dispatch()
+		return
+	}
+
+	if err := checkEditionReferences(db, &edition, nil); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&edition).Error; err != nil {
+			return err
+		}
+
+		if err := recordStock(tx, &model.StockMovement{
+			BookID:    book.ID,
+			EditionID: &edition.ID,
+			Kind:      model.StockAdjustment,
+			Quantity:  edition.AvailableCopies,
+			ActorID:   stockActor(r.Context()),
+			Reason:    "edition created",
+			Reference: model.StockRef("editions", edition.ID),
+		}); err != nil {
+			return err
+		}
+
+		return model.SyncBookFromEditions(tx, book.ID)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, edition, ""}
+	res.Dispatch()
+}
+
+// UpdateEdition applies a JSON merge patch (RFC 7396) to an edition
+func UpdateEdition(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	editionId, err := editionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbEdition := model.Edition{}
+
+	if err := db.First(&dbEdition, editionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrEditionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbEdition.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	saveEditionFields(db, w, r, &dbEdition, fields)
+}
+
+// ReplaceEdition overwrites every editable field of an edition
+func ReplaceEdition(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	editionId, err := editionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.EditionFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbEdition := model.Edition{}
+
+	if err := db.First(&dbEdition, editionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrEditionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	saveEditionFields(db, w, r, &dbEdition, fields)
+}
+
+// saveEditionFields writes fields only if the edition still has the version
+// that was read, and keeps the book's stock and price in line with it
+func saveEditionFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbEdition *model.Edition, fields model.EditionFields) {
+	if _, err := checkIfMatch(r, versionETag(dbEdition.ID, dbEdition.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	previous := *dbEdition
+	dbEdition.SetFields(fields)
+	dbEdition.Version++
+
+	if err := checkEditionReferences(db, dbEdition, &previous); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(dbEdition).Where("version = ?", previous.Version).Select(model.EditionFieldColumns).Updates(dbEdition)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("edition was modified concurrently")
+		}
+
+		if dbEdition.AvailableCopies != previous.AvailableCopies {
+			if err := checkWarehouseStock(tx, dbEdition.BookID, &dbEdition.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := recordStock(tx, &model.StockMovement{
+			BookID:    dbEdition.BookID,
+			EditionID: &dbEdition.ID,
+			Kind:      model.StockAdjustment,
+			Quantity:  dbEdition.AvailableCopies - previous.AvailableCopies,
+			ActorID:   stockActor(r.Context()),
+			Reason:    "edition updated",
+			Reference: model.StockRef("editions", dbEdition.ID),
+		}); err != nil {
+			return err
+		}
+
+		return model.SyncBookFromEditions(tx, dbEdition.BookID)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbEdition.ID, dbEdition.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbEdition, ""}
+	res.Dispatch()
+}
+
+func DeleteEdition(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	editionId, err := editionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	edition := model.Edition{}
+
+	if err := db.First(&edition, editionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrEditionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(edition.ID, edition.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		query := tx
+		if conditional {
+			query = tx.Where("version = ?", edition.Version)
+		}
+
+		result := query.Delete(&edition)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if conditional && result.RowsAffected == 0 {
+			return ErrPreconditionFailed.WithDetail("edition was modified concurrently")
+		}
+
+		return model.SyncBookFromEditions(tx, edition.BookID)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, edition, ""}
+	res.Dispatch()
+}
+
+// checkEditionReferences checks that a new or changed ISBN is not used by
+// another edition, that the publisher exists and that a changed price is
+// still in the currency of the book. previous is nil for a new edition.
+func checkEditionReferences(db *gorm.DB, edition *model.Edition, previous *model.Edition) error {
+	if edition.ISBN13 != nil && (previous == nil || edition.ISBN() != previous.ISBN()) {
+		// soft deleted editions still hold their ISBN in the unique index
+		count := int64(0)
+		if err := db.Unscoped().Model(&model.Edition{}).
+			Where("isbn13 = ? AND id <> ?", *edition.ISBN13, edition.ID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrISBNTaken.WithDetail("isbn %s is already used by another edition", *edition.ISBN13)
+		}
+	}
+
+	if edition.PublisherID != nil && (previous == nil || previous.PublisherID == nil || *previous.PublisherID != *edition.PublisherID) {
+		if err := db.First(&model.Publisher{}, *edition.PublisherID).Error; err != nil {
+			return ErrPublisherNotFound.Wrap(err)
+		}
+	}
+
+	if previous != nil && !edition.Price.SameCurrency(previous.Price) {
+		book := model.Book{}
+		if err := db.Select("id", "price_currency").First(&book, edition.BookID).Error; err != nil {
+			return ErrBookNotFound.Wrap(err)
+		}
+
+		if !edition.Price.SameCurrency(book.Price) {
+			return ErrCurrencyMismatch.WithDetail("edition must be priced in %s like its book", book.Price.Currency)
+		}
+	}
+
+	return nil
+}
+
+func editionIdParam(r *http.Request) (int, error) {
+	editionIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	editionId, err := strconv.Atoi(editionIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid edition id")
+	}
+
+	return editionId, nil
+}
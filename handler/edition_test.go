@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+// expectNoEditions expects a book without editions to be looked up before
+// its fields are saved
+func expectNoEditions(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE book_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+}
+
+func TestMigrateBookEditions(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE NOT EXISTS \(SELECT 1 FROM editions WHERE editions.book_id = books.id\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "isbn13", "isbn10", "available_copies", "price_minor", "price_currency"}).
+			AddRow(1, "Book1", "9780306406157", "0306406152", 4, 1200, "EUR"))
+	mock.ExpectQuery(`^INSERT INTO "editions"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, model.FormatPaperback, nil, "9780306406157", "0306406152", nil, 4, 1200, "EUR", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, nil, model.StockAdjustment, -4, nil, sqlmock.AnyArg(), "editions:1",
+			sqlmock.AnyArg(), 1, 1, nil, model.StockAdjustment, 4, nil, sqlmock.AnyArg(), "books:1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	if err := model.MigrateBookEditions(db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_Edition(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "book_id", "format", "available_copies", "price_minor", "price_currency"}).AddRow(5, 1, model.FormatHardcover, 4, 2500, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 10, 900, "USD"))
+	mock.ExpectExec(`^UPDATE "editions" SET "available_copies"=available_copies - \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE available_copies >= \$3`).
+		WithArgs(2, sqlmock.AnyArg(), 2, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=\(SELECT COALESCE\(SUM\(available_copies\), 0\) FROM editions WHERE book_id = \$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, 5, 2, 5000, "USD", nil, 0, "USD", 0, "USD", "", false, "paid", "").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectCommit()
+
+	body := []byte(`{"edition_id":5,"quantity":2}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_EditionRequired(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies"}).AddRow(1, "Book1", 10))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions" WHERE book_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "book_id", "format"}).
+			AddRow(5, 1, model.FormatHardcover).
+			AddRow(6, 1, model.FormatPaperback))
+	mock.ExpectRollback()
+
+	body := []byte(`{"book_id":1,"quantity":1}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodeEditionRequired {
+		t.Fatalf("expected EDITION_REQUIRED, got %s", res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateEdition(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "price_currency", "version"}).AddRow(1, "Book1", "USD", 1))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE isbn13 = \$1 AND id <> \$2`).
+		WithArgs("9780306406157", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO "editions"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, model.FormatEbook, nil, "9780306406157", "0306406152", sqlmock.AnyArg(), 100, 499, "USD", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	expectStockMovement(mock, model.StockAdjustment, 100)
+	mock.ExpectExec(`^UPDATE "books" SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"format":"ebook","isbn":"0-306-40615-2","published_on":"2024-03-01","available_copies":100,"price":499}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/1/editions", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	CreateEdition(db, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.Edition `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.ID != 7 || res.Data.PublishedOn == nil || res.Data.Fields().PublishedOn != "2024-03-01" {
+		t.Fatalf("unexpected edition %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateEdition_CurrencyMismatch(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "price_currency", "version"}).AddRow(1, "Book1", "USD", 1))
+
+	body := []byte(`{"format":"ebook","price":{"amount":499,"currency":"eur"}}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/1/editions", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	CreateEdition(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodeCurrencyMismatch {
+		t.Fatalf("expected CURRENCY_MISMATCH, got %s", res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReplaceBook_EditionStock(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "available_copies", "price_minor", "price_currency", "version"}).
+			AddRow(1, "Book1", "Author1", 1999, 7, 1200, "USD", 4))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE book_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	// the stock and price of a book with editions are left out
+	mock.ExpectExec(`^UPDATE "books" SET "updated_at"=\$1,"name"=\$2,"author"=\$3,"isbn13"=\$4,"isbn10"=\$5,"published_year"=\$6,"reorder_point"=\$7,"reorder_quantity"=\$8,"price_currency"=\$9,"version"=\$10 WHERE version = \$11`).
+		WithArgs(sqlmock.AnyArg(), "Book2", "Author1", nil, nil, 1999, 0, 0, "USD", 5, 4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"name":"Book2","author":"Author1","published_year":1999}`)
+	req, _ := http.NewRequest(http.MethodPut, "/books/1", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	ReplaceBook(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	res := struct {
+		Data model.Book `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.AvailableCopies != 7 || res.Data.Price.Amount != 1200 {
+		t.Fatalf("expected derived stock and price to be kept, got %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateEdition_InvalidFormat(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	body := []byte(`{"format":"scroll","price":499}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/1/editions", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	CreateEdition(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestGetBooks_FormatFilter(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE \(id IN \(SELECT book_id FROM editions WHERE format = \$1 AND deleted_at IS NULL\)`).
+		WithArgs(model.FormatAudiobook).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(1, "Book1"))
+	expectNoAvailability(mock)
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/?edition_format=audiobook", nil)
+	w := httptest.NewRecorder()
+
+	GetBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/books/?edition_format=scroll", nil)
+	w = httptest.NewRecorder()
+
+	GetBooks(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestDeletePublisher_InUse(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "publishers"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(2, "Penguin", 1))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE publisher_id = \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	req, _ := http.NewRequest(http.MethodDelete, "/publishers/2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w := httptest.NewRecorder()
+
+	DeletePublisher(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/peekeah/book-store/model"
+)
+
+// ErrorCode is a stable, machine readable error identifier clients can switch on.
+type ErrorCode string
+
+const (
+	CodeMalformedRequest   ErrorCode = "MALFORMED_REQUEST"
+	CodeValidationFailed   ErrorCode = "VALIDATION_FAILED"
+	CodeUnsupportedMedia   ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRequestTooLarge    ErrorCode = "REQUEST_TOO_LARGE"
+	CodeInvalidID          ErrorCode = "INVALID_ID"
+	CodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	CodeInvalidCredentials ErrorCode = "INVALID_CREDENTIALS"
+	CodeForbidden          ErrorCode = "FORBIDDEN"
+	CodeUserNotFound       ErrorCode = "USER_NOT_FOUND"
+	CodeBookNotFound       ErrorCode = "BOOK_NOT_FOUND"
+	CodeAuthorNotFound     ErrorCode = "AUTHOR_NOT_FOUND"
+	CodeAuthorInUse        ErrorCode = "AUTHOR_IN_USE"
+	CodeCategoryNotFound   ErrorCode = "CATEGORY_NOT_FOUND"
+	CodeCategoryNotEmpty   ErrorCode = "CATEGORY_NOT_EMPTY"
+	CodeInvalidMove        ErrorCode = "INVALID_CATEGORY_MOVE"
+	CodeSlugTaken          ErrorCode = "SLUG_TAKEN"
+	CodeCoverNotFound      ErrorCode = "COVER_NOT_FOUND"
+	CodeCoverTooLarge      ErrorCode = "COVER_TOO_LARGE"
+	CodeEditionNotFound    ErrorCode = "EDITION_NOT_FOUND"
+	CodeEditionRequired    ErrorCode = "EDITION_REQUIRED"
+	CodePublisherNotFound  ErrorCode = "PUBLISHER_NOT_FOUND"
+	CodePublisherInUse     ErrorCode = "PUBLISHER_IN_USE"
+	CodePublisherExists    ErrorCode = "PUBLISHER_EXISTS"
+	CodeEmailTaken         ErrorCode = "EMAIL_TAKEN"
+	CodeISBNTaken          ErrorCode = "ISBN_TAKEN"
+	CodeInsufficientStock  ErrorCode = "INSUFFICIENT_STOCK"
+	CodeCurrencyMismatch   ErrorCode = "CURRENCY_MISMATCH"
+	CodeCouponNotFound     ErrorCode = "COUPON_NOT_FOUND"
+	CodeCouponExists       ErrorCode = "COUPON_EXISTS"
+	CodeCouponInactive     ErrorCode = "COUPON_INACTIVE"
+	CodeCouponExhausted    ErrorCode = "COUPON_EXHAUSTED"
+	CodeCouponUserLimit    ErrorCode = "COUPON_USER_LIMIT"
+	CodeCouponNotEligible  ErrorCode = "COUPON_NOT_ELIGIBLE"
+	CodeCouponMinSpend     ErrorCode = "COUPON_MIN_SPEND"
+	CodePromotionNotFound  ErrorCode = "PROMOTION_NOT_FOUND"
+	CodeTaxRegion          ErrorCode = "TAX_REGION_UNSUPPORTED"
+	CodePaymentRequired    ErrorCode = "PAYMENT_METHOD_REQUIRED"
+	CodePaymentDeclined    ErrorCode = "PAYMENT_DECLINED"
+	CodePaymentFailed      ErrorCode = "PAYMENT_FAILED"
+	CodePaymentsDisabled   ErrorCode = "PAYMENTS_DISABLED"
+	CodeWebhookSignature   ErrorCode = "WEBHOOK_SIGNATURE_INVALID"
+	CodePurchaseNotFound   ErrorCode = "PURCHASE_NOT_FOUND"
+	CodeReturnNotFound     ErrorCode = "RETURN_NOT_FOUND"
+	CodeReturnResolved     ErrorCode = "RETURN_RESOLVED"
+	CodeRefundNotAllowed   ErrorCode = "REFUND_NOT_ALLOWED"
+	CodeRefundExceeds      ErrorCode = "REFUND_EXCEEDS_PURCHASE"
+	CodeReservationMissing ErrorCode = "RESERVATION_NOT_FOUND"
+	CodeReservationExpired ErrorCode = "RESERVATION_EXPIRED"
+	CodeWarehouseNotFound  ErrorCode = "WAREHOUSE_NOT_FOUND"
+	CodeWarehouseExists    ErrorCode = "WAREHOUSE_EXISTS"
+	CodeWarehouseStock     ErrorCode = "STOCK_KEPT_PER_WAREHOUSE"
+	CodeSupplierNotFound   ErrorCode = "SUPPLIER_NOT_FOUND"
+	CodeSupplierExists     ErrorCode = "SUPPLIER_EXISTS"
+	CodeOrderNotFound      ErrorCode = "SUPPLIER_ORDER_NOT_FOUND"
+	CodeOrderClosed        ErrorCode = "SUPPLIER_ORDER_CLOSED"
+	CodeStocktakeNotFound  ErrorCode = "STOCKTAKE_NOT_FOUND"
+	CodeStocktakeClosed    ErrorCode = "STOCKTAKE_CLOSED"
+	CodeIdempotencyInvalid ErrorCode = "IDEMPOTENCY_KEY_INVALID"
+	CodeIdempotencyReused  ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	CodeIdempotencyInUse   ErrorCode = "IDEMPOTENCY_KEY_IN_USE"
+	CodePreconditionFailed ErrorCode = "PRECONDITION_FAILED"
+	CodePreconditionNeeded ErrorCode = "PRECONDITION_REQUIRED"
+	CodeInternal           ErrorCode = "INTERNAL_ERROR"
+)
+
+// ErrorDefinition is a catalog entry describing an error code.
+type ErrorDefinition struct {
+	Code   ErrorCode `json:"code"`
+	Status int       `json:"status"`
+	Title  string    `json:"title"`
+}
+
+var errorCatalog = map[ErrorCode]ErrorDefinition{}
+
+func define(code ErrorCode, status int, title string) *APIError {
+	errorCatalog[code] = ErrorDefinition{code, status, title}
+	return &APIError{Code: code}
+}
+
+var (
+	ErrMalformedRequest      = define(CodeMalformedRequest, http.StatusBadRequest, "Request body could not be parsed")
+	ErrValidationFailed      = define(CodeValidationFailed, http.StatusBadRequest, "Request validation failed")
+	ErrUnsupportedMediaType  = define(CodeUnsupportedMedia, http.StatusUnsupportedMediaType, "Unsupported request content type")
+	ErrRequestTooLarge       = define(CodeRequestTooLarge, http.StatusRequestEntityTooLarge, "Request body is too large")
+	ErrInvalidID             = define(CodeInvalidID, http.StatusBadRequest, "Invalid resource id")
+	ErrUnauthorized          = define(CodeUnauthorized, http.StatusUnauthorized, "Authentication required")
+	ErrInvalidCredentials    = define(CodeInvalidCredentials, http.StatusUnauthorized, "Invalid email or password")
+	ErrForbidden             = define(CodeForbidden, http.StatusForbidden, "Not allowed to perform this action")
+	ErrUserNotFound          = define(CodeUserNotFound, http.StatusNotFound, "User not found")
+	ErrBookNotFound          = define(CodeBookNotFound, http.StatusNotFound, "Book not found")
+	ErrAuthorNotFound        = define(CodeAuthorNotFound, http.StatusNotFound, "Author not found")
+	ErrAuthorInUse           = define(CodeAuthorInUse, http.StatusConflict, "Author is still credited on books")
+	ErrCategoryNotFound      = define(CodeCategoryNotFound, http.StatusNotFound, "Category not found")
+	ErrCategoryNotEmpty      = define(CodeCategoryNotEmpty, http.StatusConflict, "Category still has subcategories")
+	ErrInvalidCategoryMove   = define(CodeInvalidMove, http.StatusConflict, "Category can not be placed there")
+	ErrSlugTaken             = define(CodeSlugTaken, http.StatusConflict, "Slug is already in use")
+	ErrCoverNotFound         = define(CodeCoverNotFound, http.StatusNotFound, "Book has no cover")
+	ErrCoverTooLarge         = define(CodeCoverTooLarge, http.StatusRequestEntityTooLarge, "Cover image is too large")
+	ErrEditionNotFound       = define(CodeEditionNotFound, http.StatusNotFound, "Edition not found")
+	ErrEditionRequired       = define(CodeEditionRequired, http.StatusBadRequest, "Book is sold in several editions, edition_id is required")
+	ErrPublisherNotFound     = define(CodePublisherNotFound, http.StatusNotFound, "Publisher not found")
+	ErrPublisherInUse        = define(CodePublisherInUse, http.StatusConflict, "Publisher still has editions")
+	ErrPublisherExists       = define(CodePublisherExists, http.StatusConflict, "A publisher with this name already exists")
+	ErrEmailTaken            = define(CodeEmailTaken, http.StatusConflict, "Email is already registered")
+	ErrISBNTaken             = define(CodeISBNTaken, http.StatusConflict, "ISBN belongs to another book")
+	ErrInsufficientStock     = define(CodeInsufficientStock, http.StatusConflict, "Not enough copies in stock")
+	ErrCurrencyMismatch      = define(CodeCurrencyMismatch, http.StatusConflict, "Amounts in different currencies can not be combined")
+	ErrCouponNotFound        = define(CodeCouponNotFound, http.StatusNotFound, "Coupon not found")
+	ErrCouponExists          = define(CodeCouponExists, http.StatusConflict, "A coupon with this code already exists")
+	ErrCouponInactive        = define(CodeCouponInactive, http.StatusConflict, "Coupon is not valid at this time")
+	ErrCouponExhausted       = define(CodeCouponExhausted, http.StatusConflict, "Coupon has no redemptions left")
+	ErrCouponUserLimit       = define(CodeCouponUserLimit, http.StatusConflict, "Coupon was already redeemed the maximum number of times by this user")
+	ErrCouponNotEligible     = define(CodeCouponNotEligible, http.StatusConflict, "Coupon does not apply to this purchase")
+	ErrCouponMinSpend        = define(CodeCouponMinSpend, http.StatusConflict, "Purchase does not reach the coupon's minimum spend")
+	ErrPromotionNotFound     = define(CodePromotionNotFound, http.StatusNotFound, "Promotion not found")
+	ErrTaxRegionUnsupported  = define(CodeTaxRegion, http.StatusBadRequest, "Purchases can not be taxed in this region")
+	ErrPaymentRequired       = define(CodePaymentRequired, http.StatusBadRequest, "payment_method is required")
+	ErrPaymentDeclined       = define(CodePaymentDeclined, http.StatusPaymentRequired, "Payment was declined")
+	ErrPaymentFailed         = define(CodePaymentFailed, http.StatusBadGateway, "Payment could not be processed")
+	ErrPaymentsDisabled      = define(CodePaymentsDisabled, http.StatusNotFound, "Payments are not enabled")
+	ErrWebhookSignature      = define(CodeWebhookSignature, http.StatusBadRequest, "Webhook signature is invalid")
+	ErrPurchaseNotFound      = define(CodePurchaseNotFound, http.StatusNotFound, "Purchase not found")
+	ErrReturnNotFound        = define(CodeReturnNotFound, http.StatusNotFound, "Return request not found")
+	ErrReturnResolved        = define(CodeReturnResolved, http.StatusConflict, "Return request was already resolved")
+	ErrRefundNotAllowed      = define(CodeRefundNotAllowed, http.StatusConflict, "Purchase can not be refunded")
+	ErrRefundExceeds         = define(CodeRefundExceeds, http.StatusConflict, "Refund exceeds what is left of the purchase")
+	ErrReservationNotFound   = define(CodeReservationMissing, http.StatusNotFound, "Reservation not found")
+	ErrReservationExpired    = define(CodeReservationExpired, http.StatusConflict, "Reservation expired or was released")
+	ErrWarehouseNotFound     = define(CodeWarehouseNotFound, http.StatusNotFound, "Warehouse not found")
+	ErrWarehouseExists       = define(CodeWarehouseExists, http.StatusConflict, "A warehouse with this code already exists")
+	ErrWarehouseStock        = define(CodeWarehouseStock, http.StatusConflict, "Stock is kept per warehouse, change it on the warehouses")
+	ErrSupplierNotFound      = define(CodeSupplierNotFound, http.StatusNotFound, "Supplier not found")
+	ErrSupplierExists        = define(CodeSupplierExists, http.StatusConflict, "A supplier with this name already exists")
+	ErrSupplierOrderNotFound = define(CodeOrderNotFound, http.StatusNotFound, "Supplier order not found")
+	ErrSupplierOrderClosed   = define(CodeOrderClosed, http.StatusConflict, "Supplier order was received or cancelled")
+	ErrStocktakeNotFound     = define(CodeStocktakeNotFound, http.StatusNotFound, "Stocktake not found")
+	ErrStocktakeClosed       = define(CodeStocktakeClosed, http.StatusConflict, "Stocktake was applied or cancelled")
+	ErrIdempotencyKeyInvalid = define(CodeIdempotencyInvalid, http.StatusBadRequest, "Idempotency-Key header is invalid")
+	ErrIdempotencyKeyReused  = define(CodeIdempotencyReused, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+	ErrIdempotencyKeyInUse   = define(CodeIdempotencyInUse, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+	ErrPreconditionFailed    = define(CodePreconditionFailed, http.StatusPreconditionFailed, "Resource was modified by someone else")
+	ErrPreconditionRequired  = define(CodePreconditionNeeded, http.StatusPreconditionRequired, "If-Match header is required")
+	ErrInternal              = define(CodeInternal, http.StatusInternalServerError, "Internal server error")
+)
+
+// APIError is an error with a stable code. Detail is shown to clients while
+// Err holds the internal cause, which is only logged.
+type APIError struct {
+	Code   ErrorCode
+	Detail string
+	Err    error
+	Fields []FieldError
+}
+
+func (e *APIError) Error() string {
+	msg := string(e.Code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any APIError carrying the same code.
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code == e.Code
+}
+
+func (e *APIError) Status() int {
+	if def, ok := errorCatalog[e.Code]; ok {
+		return def.Status
+	}
+	return http.StatusInternalServerError
+}
+
+func (e *APIError) Title() string {
+	return errorCatalog[e.Code].Title
+}
+
+// WithDetail returns a copy of the error with a client facing detail message.
+func (e *APIError) WithDetail(format string, args ...any) *APIError {
+	c := *e
+	c.Detail = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// Wrap returns a copy of the error carrying an internal cause for the logs.
+func (e *APIError) Wrap(err error) *APIError {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// asAPIError converts any error into an APIError, treating unknown errors as
+// internal so their text never reaches the client.
+func asAPIError(r *http.Request, err error) *APIError {
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		e := ErrValidationFailed.Wrap(err)
+		e.Fields = validationErrors(r, err)
+		return e
+	}
+
+	switch {
+	case errors.Is(err, model.ErrCurrencyMismatch):
+		return ErrCurrencyMismatch.Wrap(err)
+	case errors.Is(err, model.ErrAmountOverflow):
+		return ErrMalformedRequest.WithDetail("amount is out of range").Wrap(err)
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+// ErrorCatalog lists every error code the API can return.
+func ErrorCatalog() []ErrorDefinition {
+	defs := make([]ErrorDefinition, 0, len(errorCatalog))
+	for _, def := range errorCatalog {
+		defs = append(defs, def)
+	}
+
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Code < defs[j].Code
+	})
+
+	return defs
+}
+
+// problemType points at the code's entry in the error catalog
+func problemType(code ErrorCode) string {
+	return "/errors#" + string(code)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestErrorResponse_ProblemJSON(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	w := httptest.NewRecorder()
+
+	GetBookById(db, w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected problem+json content type, got %q", ct)
+	}
+
+	res := ErrorJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if res.Code != CodeInvalidID || res.Status != http.StatusBadRequest {
+		t.Fatalf("unexpected problem: %+v", res)
+	}
+
+	if res.Type != "/errors#INVALID_ID" || res.Instance != "/books/abc" || res.Title == "" {
+		t.Fatalf("unexpected problem: %+v", res)
+	}
+}
+
+func TestErrorResponse_HidesInternalErrors(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
+		WillReturnError(errors.New("pq: relation \"books\" does not exist"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/", nil)
+	w := httptest.NewRecorder()
+
+	GetBooks(db, w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+
+	if strings.Contains(w.Body.String(), "relation") {
+		t.Fatalf("internal error leaked to client: %s", w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if res.Code != CodeInternal {
+		t.Fatalf("expected %s, got %s", CodeInternal, res.Code)
+	}
+}
+
+func TestAPIError_Is(t *testing.T) {
+	err := ErrBookNotFound.WithDetail("book 1").Wrap(errors.New("record not found"))
+
+	if !errors.Is(err, ErrBookNotFound) {
+		t.Fatalf("expected wrapped error to match its code")
+	}
+
+	if errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected different codes not to match")
+	}
+
+	if ErrBookNotFound.Detail != "" || ErrBookNotFound.Err != nil {
+		t.Fatalf("expected sentinel error to be left untouched")
+	}
+}
+
+func TestGetErrorCatalog(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/errors", nil)
+	w := httptest.NewRecorder()
+
+	GetErrorCatalog(w, req)
+
+	res := struct {
+		Data []ErrorDefinition `json:"data"`
+	}{}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	statuses := map[ErrorCode]int{}
+	for _, def := range res.Data {
+		statuses[def.Code] = def.Status
+	}
+
+	if statuses[CodeEmailTaken] != http.StatusConflict || statuses[CodeBookNotFound] != http.StatusNotFound {
+		t.Fatalf("unexpected catalog: %+v", res.Data)
+	}
+}
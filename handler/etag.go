@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/peekeah/book-store/config"
+)
+
+// versionETag is a strong validator derived from a row id and its version.
+func versionETag(id, version uint) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// listETag is a weak validator covering every row of a collection.
+func listETag(ids, versions []uint) string {
+	h := sha1.New()
+	for i := range ids {
+		fmt.Fprintf(h, "%d-%d;", ids[i], versions[i])
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// etagMatches implements the list matching used by If-Match and If-None-Match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch validates the If-Match precondition of a write against the
+// current ETag. It reports whether the client sent a precondition at all.
+func checkIfMatch(r *http.Request, etag string) (bool, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if config.GetConfig().Server.RequireIfMatch {
+			return false, ErrPreconditionRequired
+		}
+		return false, nil
+	}
+
+	// a strong comparison is required, weak tags never match
+	if !etagMatches(header, etag, false) {
+		return true, ErrPreconditionFailed.WithDetail("resource has been modified, current ETag is %s", etag)
+	}
+
+	return true, nil
+}
+
+// notModified answers a conditional GET with 304 when If-None-Match matches.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestGetBookById_NotModified(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "version"}).
+		AddRow(1, "Book1", 3)
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").WillReturnRows(mockRows)
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/1", nil)
+	req.Header.Set("If-None-Match", `"1-3"`)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	GetBookById(db, w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %d", w.Code)
+	}
+
+	if w.Body.Len() != 0 {
+		t.Fatalf("expected empty body, got %s", w.Body.String())
+	}
+
+	if etag := w.Header().Get("ETag"); etag != `"1-3"` {
+		t.Fatalf("unexpected etag %s", etag)
+	}
+}
+
+func TestGetBooks_ETag(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "version"}).AddRow(1, 1).AddRow(2, 5))
+	expectNoAvailability(mock)
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/", nil)
+	w := httptest.NewRecorder()
+
+	GetBooks(db, w, req)
+
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with etag, got %d %q", w.Code, etag)
+	}
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "version"}).AddRow(1, 1).AddRow(2, 6))
+	expectNoAvailability(mock)
+
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+
+	GetBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected changed list to return 200, got %d", w.Code)
+	}
+}
+
+func TestUpdateBook_IfMatchMismatch(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "version"}).
+		AddRow(1, "Book1", "Author1", 1999, 4)
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").WillReturnRows(mockRows)
+
+	req, _ := http.NewRequest(http.MethodPatch, "/books/1", bytes.NewReader([]byte(`{"price":10}`)))
+	req.Header.Set("If-Match", `"1-3"`)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateBook(db, w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d", w.Code)
+	}
+}
+
+func TestUpdateBook_ConcurrentWrite(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "price_currency", "version"}).
+		AddRow(1, "Book1", "Author1", 1999, "USD", 4)
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").WillReturnRows(mockRows)
+	mock.ExpectBegin()
+	expectNoEditions(mock)
+	mock.ExpectExec("^UPDATE \"books\" SET").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/books/1", bytes.NewReader([]byte(`{"price":10}`)))
+	req.Header.Set("If-Match", `"1-4"`)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateBook(db, w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d", w.Code)
+	}
+}
+
+func TestDeleteUser_IfMatchRequired(t *testing.T) {
+	t.Setenv("REQUIRE_IF_MATCH", "true")
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"users\"").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "version"}).AddRow(1, "user1", 1))
+
+	req, _ := http.NewRequest(http.MethodDelete, "/users/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	DeleteUser(db, w, req)
+
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected status 428, got %d", w.Code)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	if !etagMatches(`"1-2", "1-3"`, `"1-3"`, false) {
+		t.Fatalf("expected list to match")
+	}
+
+	if etagMatches(`W/"1-3"`, `"1-3"`, false) {
+		t.Fatalf("expected weak tag to fail strong comparison")
+	}
+
+	if !etagMatches(`W/"1-3"`, `"1-3"`, true) {
+		t.Fatalf("expected weak comparison to match")
+	}
+
+	if !etagMatches(`*`, `"1-3"`, false) {
+		t.Fatalf("expected wildcard to match")
+	}
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peekeah/book-store/logger"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+// exportFlushRows is how many rows are buffered before they are pushed to
+// the client.
+const exportFlushRows = 500
+
+var bookExportColumns = []string{"id", "name", "author", "isbn_13", "isbn_10", "published_year", "available_copies", "price", "currency", "created_at", "updated_at"}
+
+var purchaseExportColumns = []string{"id", "purchased_at", "user_id", "user_email", "book_id", "book_name", "quantity", "amount", "discount", "tax", "currency", "tax_region", "coupon_code"}
+
+var refundExportColumns = []string{"id", "refunded_at", "purchase_id", "return_id", "user_id", "book_id", "quantity", "amount", "currency", "restocked", "reason"}
+
+// ExportBooks streams the catalog as CSV, NDJSON or XLSX. It accepts the
+// same filters as the book list.
+func ExportBooks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, err := bookFilters(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	rows, err := db.Model(&model.Book{}).Scopes(filters).Order("id").Rows()
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer rows.Close()
+
+	streamExport(w, r, "books", bookExportColumns, rows, func() ([]any, error) {
+		book := model.Book{}
+		if err := db.ScanRows(rows, &book); err != nil {
+			return nil, err
+		}
+
+		return []any{
+			book.ID,
+			book.Name,
+			book.Author,
+			book.ISBN(),
+			utils.ISBN10(book.ISBN()),
+			book.PublishedYear,
+			book.AvailableCopies,
+			book.Price.Amount,
+			book.Price.Currency,
+			book.CreatedAt.UTC().Format(time.RFC3339),
+			book.UpdatedAt.UTC().Format(time.RFC3339),
+		}, nil
+	})
+}
+
+// ExportPurchases streams sales with the buyer and book they refer to.
+// Purchases can be filtered by date range, user_id and book_id.
+func ExportPurchases(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, err := purchaseFilters(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	rows, err := db.Model(&model.Purchase{}).
+		Select("purchases.id, purchases.created_at, purchases.user_id, users.email, purchases.book_id, books.name, purchases.quantity, purchases.amount_minor, purchases.discount_minor, purchases.tax_minor, purchases.amount_currency, purchases.tax_region, coupons.code").
+		Joins("LEFT JOIN users ON users.id = purchases.user_id").
+		Joins("LEFT JOIN books ON books.id = purchases.book_id").
+		Joins("LEFT JOIN coupons ON coupons.id = purchases.coupon_id").
+		Scopes(filters).
+		Order("purchases.id").
+		Rows()
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer rows.Close()
+
+	streamExport(w, r, "purchases", purchaseExportColumns, rows, func() ([]any, error) {
+		var (
+			id, userId, bookId uint
+			purchasedAt        time.Time
+			email, bookName    sql.NullString
+			quantity           int
+			amount, discount   sql.NullInt64
+			tax                sql.NullInt64
+			currency, region   sql.NullString
+			coupon             sql.NullString
+		)
+
+		if err := rows.Scan(&id, &purchasedAt, &userId, &email, &bookId, &bookName, &quantity, &amount, &discount, &tax, &currency, &region, &coupon); err != nil {
+			return nil, err
+		}
+
+		return []any{
+			id,
+			purchasedAt.UTC().Format(time.RFC3339),
+			userId,
+			email.String,
+			bookId,
+			bookName.String,
+			quantity,
+			amount.Int64,
+			discount.Int64,
+			tax.Int64,
+			currency.String,
+			region.String,
+			coupon.String,
+		}, nil
+	})
+}
+
+// ExportRefunds streams refunds with the purchase they pay back, failed
+// refunds paid nothing and are left out. It takes the purchase filters, the
+// date range applies to the refund date.
+func ExportRefunds(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	filters, err := purchaseFilters(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	rows, err := db.Model(&model.Refund{}).
+		Select("refunds.id, refunds.created_at, refunds.purchase_id, refunds.return_id, purchases.user_id, purchases.book_id, refunds.quantity, refunds.amount_minor, refunds.amount_currency, refunds.restocked, refunds.reason").
+		Joins("JOIN purchases ON purchases.id = refunds.purchase_id").
+		Where("refunds.status <> ?", model.RefundFailed).
+		Scopes(filters).
+		Order("refunds.id").
+		Rows()
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer rows.Close()
+
+	streamExport(w, r, "refunds", refundExportColumns, rows, func() ([]any, error) {
+		var (
+			id, purchaseId, userId, bookId uint
+			refundedAt                     time.Time
+			returnId                       sql.NullInt64
+			quantity                       int
+			amount                         sql.NullInt64
+			currency, reason               sql.NullString
+			restocked                      bool
+		)
+
+		if err := rows.Scan(&id, &refundedAt, &purchaseId, &returnId, &userId, &bookId, &quantity, &amount, &currency, &restocked, &reason); err != nil {
+			return nil, err
+		}
+
+		returnRef := ""
+		if returnId.Valid {
+			returnRef = strconv.FormatInt(returnId.Int64, 10)
+		}
+
+		return []any{
+			id,
+			refundedAt.UTC().Format(time.RFC3339),
+			purchaseId,
+			returnRef,
+			userId,
+			bookId,
+			quantity,
+			amount.Int64,
+			currency.String,
+			restocked,
+			reason.String,
+		}, nil
+	})
+}
+
+// streamExport writes every row of rows with the writer picked by the format
+// query parameter. Once the first byte is sent the status can no longer
+// change, so later failures are logged and the response is cut short.
+func streamExport(w http.ResponseWriter, r *http.Request, name string, columns []string, rows *sql.Rows, scan func() ([]any, error)) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = formatCSV
+	}
+
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("unsupported export format %q, use csv, ndjson or xlsx", format)}
+		res.Dispatch()
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102"), exportFormat.Extension)
+
+	w.Header().Set("Content-Type", exportFormat.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	err := func() error {
+		writer, err := newExportWriter(format, w, columns)
+		if err != nil {
+			return err
+		}
+
+		for count := 1; rows.Next(); count++ {
+			record, err := scan()
+			if err != nil {
+				return err
+			}
+
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+
+			if flusher != nil && count%exportFlushRows == 0 {
+				flusher.Flush()
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return writer.Close()
+	}()
+
+	if err != nil {
+		l := logger.Get()
+		l.Error().
+			Err(err).
+			Str("url", r.URL.RequestURI()).
+			Msg("export aborted")
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestExportBooks_CSV(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockRows := sqlmock.NewRows([]string{"id", "name", "author", "isbn13", "published_year", "available_copies", "price_minor", "price_currency", "created_at", "updated_at"}).
+		AddRow(1, "Book, One", "Author1", "9780306406157", 1999, 3, 200, "USD", created, created).
+		AddRow(2, "Book2", "Author2", nil, 2005, 0, 150, "EUR", created, created)
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE author ILIKE \$1 AND published_year >= \$2 AND "books"."deleted_at" IS NULL ORDER BY id`).
+		WithArgs("%author%", 1990).
+		WillReturnRows(mockRows)
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/export/books?author=author&published_from=1990&format=csv", nil)
+	w := httptest.NewRecorder()
+
+	ExportBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	want := "id,name,author,isbn_13,isbn_10,published_year,available_copies,price,currency,created_at,updated_at\n" +
+		"1,\"Book, One\",Author1,9780306406157,0306406152,1999,3,200,USD,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z\n" +
+		"2,Book2,Author2,,,2005,0,150,EUR,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z\n"
+
+	if w.Body.String() != want {
+		t.Fatalf("unexpected body:\n%s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestExportBooks_InvalidFilter(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/export/books?published_from=recent", nil)
+	w := httptest.NewRecorder()
+
+	ExportBooks(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestExportWriter_XLSX(t *testing.T) {
+	body := &bytes.Buffer{}
+
+	writer, err := newExportWriter(formatXLSX, body, []string{"name", "price"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writer.Write([]any{"Tom & Jerry", 200})
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(body.Bytes()), int64(body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	sheet := &bytes.Buffer{}
+	for _, f := range archive.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			sheet.ReadFrom(rc)
+			rc.Close()
+		}
+	}
+
+	for _, cell := range []string{`<c r="A2" t="inlineStr"><is><t>Tom &amp; Jerry</t></is></c>`, `<c r="B2"><v>200</v></c>`} {
+		if !strings.Contains(sheet.String(), cell) {
+			t.Fatalf("expected sheet to contain %s, got %s", cell, sheet.String())
+		}
+	}
+
+	if xlsxColumn(0) != "A" || xlsxColumn(25) != "Z" || xlsxColumn(26) != "AA" {
+		t.Fatalf("unexpected column names")
+	}
+}
+
+func TestExportWriter_CSVFormulas(t *testing.T) {
+	body := &bytes.Buffer{}
+
+	writer, err := newExportWriter(formatCSV, body, []string{"name", "author", "amount"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writer.Write([]any{"=HYPERLINK(\"http://evil\")", "@SUM(A1)", -200})
+	writer.Write([]any{"-1+2", "\tTab", int64(-5)})
+	writer.Write([]any{"Book1", "Author1", 0})
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "name,author,amount\n" +
+		"\"'=HYPERLINK(\"\"http://evil\"\")\",'@SUM(A1),-200\n" +
+		"'-1+2,'\tTab,-5\n" +
+		"Book1,Author1,0\n"
+	if body.String() != want {
+		t.Fatalf("expected %q, got %q", want, body.String())
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const formatXLSX = "xlsx"
+
+// exportWriter writes one record per call to Write, in the column order
+// given when it was created. Close must be called to finish the document.
+type exportWriter interface {
+	Write(record []any) error
+	Close() error
+}
+
+type exportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var exportFormats = map[string]exportFormat{
+	formatCSV:    {"text/csv; charset=utf-8", "csv"},
+	formatNDJSON: {"application/x-ndjson", "ndjson"},
+	formatXLSX:   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx"},
+}
+
+func newExportWriter(format string, w io.Writer, columns []string) (exportWriter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVExport(w, columns)
+	case formatNDJSON:
+		return &ndjsonExport{enc: json.NewEncoder(w), columns: columns}, nil
+	case formatXLSX:
+		return newXLSXExport(w, columns)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+type csvExport struct {
+	writer *csv.Writer
+}
+
+func newCSVExport(w io.Writer, columns []string) (*csvExport, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+	return &csvExport{writer: writer}, nil
+}
+
+func (c *csvExport) Write(record []any) error {
+	values := make([]string, len(record))
+	for i, value := range record {
+		if s, ok := value.(string); ok {
+			values[i] = csvText(s)
+			continue
+		}
+		values[i] = fmt.Sprint(value)
+	}
+	return c.writer.Write(values)
+}
+
+// csvText quotes text that spreadsheets would run as a formula, so names
+// entered by admins can not run in the spreadsheet of whoever opens the
+// export. Only text is quoted, negative numbers are written as numbers.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvExport) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonExport struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (n *ndjsonExport) Write(record []any) error {
+	object := make(map[string]any, len(record))
+	for i, value := range record {
+		object[n.columns[i]] = value
+	}
+	return n.enc.Encode(object)
+}
+
+func (n *ndjsonExport) Close() error {
+	return nil
+}
+
+// xlsxExport writes a single sheet workbook. The sheet is deflated into the
+// zip as rows arrive, with strings written inline so no shared string table
+// has to be kept in memory.
+type xlsxExport struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newXLSXExport(w io.Writer, columns []string) (*xlsxExport, error) {
+	archive := zip.NewWriter(w)
+
+	for _, part := range xlsxParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	x := &xlsxExport{zip: archive, sheet: bufio.NewWriter(f)}
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+
+	if err := x.Write(header); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xlsxExport) Write(record []any) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+
+	for i, value := range record {
+		ref := xlsxColumn(i) + fmt.Sprint(x.row)
+
+		switch v := value.(type) {
+		case int, uint, int64, uint64, float64:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%v</v></c>`, ref, v)
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t>`, ref)
+			xml.EscapeText(x.sheet, []byte(fmt.Sprint(v)))
+			x.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxExport) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// xlsxColumn converts a zero based index to a column name, 0 is A, 26 is AA.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
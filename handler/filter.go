@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+// bookFilters builds the query scope for the catalog filters shared by the
+// book list and the catalog export.
+//
+//   - name, author: case insensitive substring match
+//   - published_from, published_to: inclusive published year range
+//   - in_stock: true only returns books with available copies
+//   - edition_format: books sold in that edition format, e.g. paperback.
+//     Not named format since the export already uses it for the file type
+func bookFilters(r *http.Request) (func(*gorm.DB) *gorm.DB, error) {
+	query := r.URL.Query()
+
+	publishedFrom, err := intParam(query.Get("published_from"), "published_from")
+	if err != nil {
+		return nil, err
+	}
+
+	publishedTo, err := intParam(query.Get("published_to"), "published_to")
+	if err != nil {
+		return nil, err
+	}
+
+	inStock := false
+	if value := query.Get("in_stock"); value != "" {
+		if inStock, err = strconv.ParseBool(value); err != nil {
+			return nil, ErrMalformedRequest.WithDetail("in_stock must be true or false")
+		}
+	}
+
+	format := query.Get("edition_format")
+	if format != "" && !slices.Contains(model.Formats, format) {
+		return nil, ErrMalformedRequest.WithDetail("edition_format must be one of %s", strings.Join(model.Formats, ", "))
+	}
+
+	name := strings.TrimSpace(query.Get("name"))
+	author := strings.TrimSpace(query.Get("author"))
+
+	return func(db *gorm.DB) *gorm.DB {
+		if name != "" {
+			db = db.Where("name ILIKE ?", "%"+escapeLike(name)+"%")
+		}
+		if author != "" {
+			db = db.Where("author ILIKE ?", "%"+escapeLike(author)+"%")
+		}
+		if publishedFrom != 0 {
+			db = db.Where("published_year >= ?", publishedFrom)
+		}
+		if publishedTo != 0 {
+			db = db.Where("published_year <= ?", publishedTo)
+		}
+		if inStock {
+			db = db.Where("available_copies > 0")
+		}
+		if format != "" {
+			db = db.Where("id IN (SELECT book_id FROM editions WHERE format = ? AND deleted_at IS NULL)", format)
+		}
+		return db
+	}, nil
+}
+
+// purchaseFilters builds the query scope for the sales export.
+//
+//   - from, to: purchase date range as YYYY-MM-DD, to is inclusive
+//   - user_id, book_id: purchases of a single user or book
+func purchaseFilters(r *http.Request) (func(*gorm.DB) *gorm.DB, error) {
+	query := r.URL.Query()
+
+	from, err := dateParam(query.Get("from"), "from")
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := dateParam(query.Get("to"), "to")
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := intParam(query.Get("user_id"), "user_id")
+	if err != nil {
+		return nil, err
+	}
+
+	bookId, err := intParam(query.Get("book_id"), "book_id")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if !from.IsZero() {
+			db = db.Where("purchases.created_at >= ?", from)
+		}
+		if !to.IsZero() {
+			db = db.Where("purchases.created_at < ?", to.AddDate(0, 0, 1))
+		}
+		if userId != 0 {
+			db = db.Where("purchases.user_id = ?", userId)
+		}
+		if bookId != 0 {
+			db = db.Where("purchases.book_id = ?", bookId)
+		}
+		return db
+	}, nil
+}
+
+func intParam(value, name string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ErrMalformedRequest.WithDetail("%s must be a whole number", name)
+	}
+	return n, nil
+}
+
+func dateParam(value, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, ErrMalformedRequest.WithDetail("%s must be a date formatted as YYYY-MM-DD", name)
+	}
+	return date, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
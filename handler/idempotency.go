@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyHeader names the key clients send to make a POST safe to
+// retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// MaxIdempotentBytes is the largest body of a request with an
+// Idempotency-Key, it is read into memory to be hashed. It leaves room for
+// a cover upload, larger uploads such as imports are sent without a key.
+const MaxIdempotentBytes = 8 << 20
+
+// idempotencyWindow is how long a key's response is kept for retries
+var idempotencyWindow = 24 * time.Hour
+
+func SetIdempotencyWindow(window time.Duration) {
+	idempotencyWindow = window
+}
+
+// Idempotent replays the stored response when a POST is retried with the
+// same Idempotency-Key. The first request with a key is handled and its
+// response stored, a retry with the same body gets that response back and
+// a retry with another body is rejected. Keys are released when the
+// request fails with a server error, so it can be retried for real.
+func Idempotent(db *gorm.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			res := ErrorResponse{w, r, ErrIdempotencyKeyInvalid.WithDetail("keys are at most 255 characters")}
+			res.Dispatch()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxIdempotentBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			res := ErrorResponse{w, r, ErrRequestTooLarge.WithDetail("requests with an %s must not exceed %d MB", IdempotencyKeyHeader, MaxIdempotentBytes>>20)}
+			res.Dispatch()
+			return
+		}
+		if err != nil {
+			res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+			res.Dispatch()
+			return
+		}
+
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+		hash.Write(body)
+
+		record := model.IdempotencyKey{
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			ExpiresAt:   time.Now().Add(idempotencyWindow),
+		}
+
+		claimed, err := claimIdempotencyKey(db, &record)
+		if err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+
+		if !claimed {
+			replayIdempotentResponse(w, r, record)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+				db.Where("scope = ? AND key = ?", record.Scope, record.Key).Delete(&model.IdempotencyKey{})
+				return
+			}
+
+			record.Status = recorder.status
+			record.Header = recorder.Header().Clone()
+			record.Body = recorder.body.Bytes()
+			db.Model(&record).Select("status", "header", "body").Updates(&record)
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// idempotencyScope keeps the keys of each user apart. Keys are scoped by
+// user id so a retry still matches after the client refreshed its token,
+// routes without a user are scoped by the Authorization header.
+func idempotencyScope(r *http.Request) string {
+	if userId, ok := r.Context().Value("user_id").(uint); ok {
+		return fmt.Sprintf("user:%d", userId)
+	}
+
+	scope := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+	return hex.EncodeToString(scope[:])
+}
+
+// claimIdempotencyKey stores a new key, it reports false when the key is
+// taken already and loads the stored record instead. Expired keys are
+// claimed again.
+func claimIdempotencyKey(db *gorm.DB, record *model.IdempotencyKey) (bool, error) {
+	for range 2 {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if err := result.Error; err != nil {
+			return false, err
+		}
+
+		if result.RowsAffected == 1 {
+			return true, nil
+		}
+
+		stored := model.IdempotencyKey{}
+		err := db.Where("scope = ? AND key = ?", record.Scope, record.Key).First(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// released in the meantime
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if stored.ExpiresAt.After(time.Now()) {
+			if stored.RequestHash != record.RequestHash {
+				return false, ErrIdempotencyKeyReused.WithDetail("key %s was used with another request", record.Key)
+			}
+
+			*record = stored
+			return false, nil
+		}
+
+		if err := db.Where("scope = ? AND key = ? AND expires_at <= ?", record.Scope, record.Key, time.Now()).
+			Delete(&model.IdempotencyKey{}).Error; err != nil {
+			return false, err
+		}
+	}
+
+	return false, ErrIdempotencyKeyInUse
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, record model.IdempotencyKey) {
+	if record.Status == 0 {
+		res := ErrorResponse{w, r, ErrIdempotencyKeyInUse.WithDetail("the first request with key %s is still being handled", record.Key)}
+		res.Dispatch()
+		return
+	}
+
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// PurgeIdempotencyKeys deletes the keys past their window
+func PurgeIdempotencyKeys(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at <= ?", time.Now()).Delete(&model.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
+
+// responseRecorder copies a response while it is written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/utils"
+)
+
+var idempotencyKeyColumns = []string{"scope", "key", "method", "path", "request_hash", "status", "header", "body", "expires_at"}
+
+func TestIdempotent(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	calls := 0
+	handler := Idempotent(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		res := SuccessResponse{w, http.StatusCreated, map[string]int{"ID": 7}, "successfully purchased book"}
+		res.Dispatch()
+	}))
+
+	body := `{"book_id":1,"quantity":2}`
+	send := func(body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader([]byte(body)))
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(IdempotencyKeyHeader, "order-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// the first request is handled and its response stored
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "idempotency_keys" (.+) ON CONFLICT DO NOTHING`).
+		WithArgs("user:1", "order-1", http.MethodPost, "/books/purchase", sqlmock.AnyArg(), 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "idempotency_keys" SET "status"=\$1,"header"=\$2,"body"=\$3 WHERE "scope" = \$4 AND "key" = \$5`).
+		WithArgs(http.StatusCreated, sqlmock.AnyArg(), sqlmock.AnyArg(), "user:1", "order-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	first := send(body, "token")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", first.Code, first.Body.String())
+	}
+
+	// a retry gets the stored response back, also after the token was
+	// refreshed
+	hash := sha256.Sum256([]byte("POST /books/purchase\n" + body))
+	stored := func() *sqlmock.Rows {
+		return sqlmock.NewRows(idempotencyKeyColumns).
+			AddRow("scope", "order-1", http.MethodPost, "/books/purchase", hex.EncodeToString(hash[:]), http.StatusCreated,
+				`{"Content-Type":["application/json"]}`, first.Body.Bytes(), time.Now().Add(time.Hour))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "idempotency_keys"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`^SELECT (.+) FROM "idempotency_keys" WHERE scope = \$1 AND key = \$2`).
+		WithArgs("user:1", "order-1", 1).
+		WillReturnRows(stored())
+
+	retry := send(body, "refreshed")
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the stored response, got %d: %s", retry.Code, retry.Body.String())
+	}
+
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected replay headers %v", retry.Header())
+	}
+
+	// the key can not be reused for another request
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "idempotency_keys"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`^SELECT (.+) FROM "idempotency_keys"`).
+		WillReturnRows(stored())
+
+	if w := send(`{"book_id":1,"quantity":3}`, "token"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", w.Code)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestIdempotent_InProgress(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	handler := Idempotent(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("handler should not run")
+	}))
+
+	body := `{"book_id":1,"quantity":2}`
+	hash := sha256.Sum256([]byte("POST /books/purchase\n" + body))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "idempotency_keys"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`^SELECT (.+) FROM "idempotency_keys"`).
+		WillReturnRows(sqlmock.NewRows(idempotencyKeyColumns).
+			AddRow("scope", "order-1", http.MethodPost, "/books/purchase", hex.EncodeToString(hash[:]), 0, nil, nil, time.Now().Add(time.Hour)))
+
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader([]byte(body)))
+	req.Header.Set(IdempotencyKeyHeader, "order-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestIdempotent_TooLarge(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	handler := Idempotent(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("handler should not run")
+	}))
+
+	body := bytes.Repeat([]byte("a"), MaxIdempotentBytes+1)
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, "order-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
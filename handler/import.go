@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/peekeah/book-store/logger"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	importTransactional = "transactional"
+	importBestEffort    = "best_effort"
+
+	// maxReportedImportErrors caps the report size for badly broken files
+	maxReportedImportErrors = 1000
+)
+
+// ImportRowError reports why a row of an import was not applied.
+type ImportRowError struct {
+	Row    int          `json:"row"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type ImportReport struct {
+	Format    string           `json:"format"`
+	Mode      string           `json:"mode"`
+	DryRun    bool             `json:"dry_run"`
+	Committed bool             `json:"committed"`
+	Total     int              `json:"total"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Invalid   int              `json:"invalid"`
+	Failed    int              `json:"failed"`
+	Truncated bool             `json:"errors_truncated,omitempty"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+func (rep *ImportReport) addError(rowErr ImportRowError) {
+	if rowErr.Status == "invalid" {
+		rep.Invalid++
+	} else {
+		rep.Failed++
+	}
+
+	if len(rep.Errors) >= maxReportedImportErrors {
+		rep.Truncated = true
+		return
+	}
+	rep.Errors = append(rep.Errors, rowErr)
+}
+
+// ImportBooks loads a catalog file (CSV, JSON array or NDJSON), upserting
+// books matched by ISBN, or by name and author when a row has no ISBN.
+//
+// Query parameters:
+//   - format: csv, json or ndjson, detected from the content type if omitted
+//   - mode: transactional (default) applies nothing unless every row is
+//     valid, best_effort applies every row it can
+//   - dry_run: true validates and reports without saving anything
+func ImportBooks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = importTransactional
+	}
+
+	if mode != importTransactional && mode != importBestEffort {
+		res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("mode must be %s or %s", importTransactional, importBestEffort)}
+		res.Dispatch()
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	body, format, err := importSource(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	rows, err := newBookRowReader(format, body)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	report := ImportReport{Format: format, Mode: mode, DryRun: dryRun, Errors: []ImportRowError{}}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := importRows(tx, r, rows, &report); err != nil {
+		tx.Rollback()
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	rejected := report.Invalid+report.Failed > 0
+
+	if dryRun || (mode == importTransactional && rejected) {
+		tx.Rollback()
+	} else {
+		if err := tx.Commit().Error; err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+		report.Committed = true
+	}
+
+	status := http.StatusOK
+	if !dryRun && !report.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+
+	res := SuccessResponse{w, status, report, importMessage(report)}
+	res.Dispatch()
+}
+
+func importRows(tx *gorm.DB, r *http.Request, rows bookRowReader, report *ImportReport) error {
+	// a failed statement aborts a postgres transaction, so in transactional
+	// mode writing stops at the first failure while validation carries on
+	writable := true
+	bestEffort := report.Mode == importBestEffort
+
+	for line := 1; ; line++ {
+		row, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		report.Total++
+
+		fields := row.Fields
+		if len(fields) == 0 {
+			fields = validationErrors(r, validate.Struct(&row.Book))
+		}
+
+		if len(fields) > 0 {
+			report.addError(ImportRowError{Row: line, Status: "invalid", Fields: fields})
+			continue
+		}
+
+		if !writable {
+			continue
+		}
+
+		if bestEffort {
+			tx.SavePoint("import_row")
+		}
+
+		created, err := upsertBook(tx, row.Book, stockActor(r.Context()))
+		if err != nil {
+			if bestEffort {
+				tx.RollbackTo("import_row")
+			} else {
+				writable = false
+			}
+
+			logImportFailure(r, line, err)
+			report.addError(ImportRowError{Row: line, Status: "failed", Error: "book could not be saved"})
+			continue
+		}
+
+		if created {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+}
+
+func logImportFailure(r *http.Request, row int, err error) {
+	l := logger.Get()
+	l.Error().
+		Err(err).
+		Int("row", row).
+		Str("url", r.URL.RequestURI()).
+		Msg("import row could not be saved")
+}
+
+// upsertBook updates the book with the same ISBN or, for rows without one,
+// the same name and author ignoring case. Otherwise the book is created.
+// It reports whether a new book was created. Stock changes are recorded
+// as adjustments by actor.
+func upsertBook(tx *gorm.DB, fields model.BookFields, actor *uint) (bool, error) {
+	book := model.Book{}
+
+	query := tx.Where("LOWER(name) = LOWER(?) AND LOWER(author) = LOWER(?)", fields.Name, fields.Author)
+	if isbn13 := utils.NormalizeISBN(fields.ISBN); isbn13 != "" {
+		query = tx.Where("isbn13 = ?", isbn13)
+	}
+
+	if err := query.Limit(1).Find(&book).Error; err != nil {
+		return false, err
+	}
+
+	created, copies, price, byline := book.ID == 0, book.AvailableCopies, book.Price, book.Author
+	book.SetFields(fields)
+
+	if created {
+		if err := tx.Create(&book).Error; err != nil {
+			return false, err
+		}
+	} else {
+		columns, err := bookColumns(tx, &book, copies, price)
+		if err != nil {
+			return false, err
+		}
+
+		if book.AvailableCopies != copies {
+			if err := checkWarehouseStock(tx, book.ID, nil); err != nil {
+				return false, err
+			}
+		}
+
+		book.Version++
+
+		if err := tx.Model(&book).Select(columns).Updates(&book).Error; err != nil {
+			return false, err
+		}
+	}
+
+	if created || book.Author != byline {
+		if err := model.CreditBylineAuthors(tx, &book); err != nil {
+			return false, err
+		}
+	}
+
+	return created, recordStock(tx, &model.StockMovement{
+		BookID:    book.ID,
+		Kind:      model.StockAdjustment,
+		Quantity:  book.AvailableCopies - copies,
+		ActorID:   actor,
+		Reason:    "imported",
+		Reference: model.StockRef("books", book.ID),
+	})
+}
+
+// importSource returns the upload stream and its format. Multipart uploads
+// are read part by part instead of being parsed into memory.
+func importSource(r *http.Request) (io.Reader, string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if format == "" {
+			format = formatFromMediaType(mediaType)
+		}
+		return r.Body, format, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", ErrMalformedRequest.Wrap(err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", ErrMalformedRequest.WithDetail("multipart upload must contain a \"file\" part")
+		}
+		if err != nil {
+			return nil, "", ErrMalformedRequest.Wrap(err)
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		if format == "" {
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			format = formatFromMediaType(partType)
+		}
+
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(part.FileName())), ".")
+			if format == "jsonl" {
+				format = formatNDJSON
+			}
+		}
+
+		return part, format, nil
+	}
+}
+
+func formatFromMediaType(mediaType string) string {
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return formatCSV
+	case "application/json":
+		return formatJSON
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return formatNDJSON
+	}
+	return ""
+}
+
+func importMessage(report ImportReport) string {
+	switch {
+	case report.DryRun:
+		return fmt.Sprintf("dry run: %d of %d rows would be imported", report.Created+report.Updated, report.Total)
+	case report.Committed:
+		return fmt.Sprintf("imported %d of %d rows", report.Created+report.Updated, report.Total)
+	}
+	return "import rejected, no rows were saved"
+}
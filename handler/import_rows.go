@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/peekeah/book-store/model"
+)
+
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// importRow is one decoded record of an import file. Fields holds the
+// problems found while decoding, before any validation runs.
+type importRow struct {
+	Book   model.BookFields
+	Fields []FieldError
+}
+
+// bookRowReader streams rows out of an upload so the whole file never has
+// to be held in memory. Next returns io.EOF after the last row.
+type bookRowReader interface {
+	Next() (importRow, error)
+}
+
+func newBookRowReader(format string, r io.Reader) (bookRowReader, error) {
+	switch format {
+	case formatCSV:
+		return newCSVRows(r)
+	case formatJSON:
+		return newJSONArrayRows(r)
+	case formatNDJSON:
+		return &ndjsonRows{dec: json.NewDecoder(r)}, nil
+	}
+	return nil, ErrUnsupportedMediaType.WithDetail("unsupported import format %q, use csv, json or ndjson", format)
+}
+
+type csvRows struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrMalformedRequest.WithDetail("csv header row is missing").Wrap(err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	for _, required := range []string{"name", "author"} {
+		if _, ok := columns[required]; !ok {
+			return nil, ErrMalformedRequest.WithDetail("csv header must contain a %q column", required)
+		}
+	}
+
+	return &csvRows{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRows) Next() (importRow, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importRow{}, ErrMalformedRequest.WithDetail("csv line %d: %v", parseErr.Line, parseErr.Err)
+		}
+		return importRow{}, err
+	}
+
+	row := importRow{}
+
+	text := func(column string) string {
+		i, ok := c.columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	number := func(column string) int {
+		value := text(column)
+		if value == "" {
+			return 0
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			row.Fields = append(row.Fields, FieldError{
+				Field:   column,
+				Rule:    "number",
+				Message: fmt.Sprintf("%s must be a whole number", column),
+			})
+		}
+		return n
+	}
+
+	row.Book = model.BookFields{
+		Name:            text("name"),
+		Author:          text("author"),
+		ISBN:            text("isbn"),
+		PublishedYear:   number("published_year"),
+		AvailableCopies: number("available_copies"),
+		Price:           model.NewMoney(int64(number("price")), cmp.Or(text("currency"), model.DefaultCurrency)),
+	}
+
+	return row, nil
+}
+
+type jsonArrayRows struct {
+	dec *json.Decoder
+}
+
+func newJSONArrayRows(r io.Reader) (*jsonArrayRows, error) {
+	dec := json.NewDecoder(r)
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, ErrMalformedRequest.WithDetail("body is not valid JSON").Wrap(err)
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, ErrMalformedRequest.WithDetail("body must be a JSON array of books")
+	}
+
+	return &jsonArrayRows{dec: dec}, nil
+}
+
+func (j *jsonArrayRows) Next() (importRow, error) {
+	if !j.dec.More() {
+		return importRow{}, io.EOF
+	}
+	return decodeJSONRow(j.dec)
+}
+
+type ndjsonRows struct {
+	dec *json.Decoder
+}
+
+func (n *ndjsonRows) Next() (importRow, error) {
+	if !n.dec.More() {
+		return importRow{}, io.EOF
+	}
+	return decodeJSONRow(n.dec)
+}
+
+// decodeJSONRow reads the next value of a stream. Syntax errors abort the
+// import since the stream can not be resynchronised, type errors only
+// invalidate the row.
+func decodeJSONRow(dec *json.Decoder) (importRow, error) {
+	raw := json.RawMessage{}
+	if err := dec.Decode(&raw); err != nil {
+		return importRow{}, ErrMalformedRequest.WithDetail("body is not valid JSON").Wrap(err)
+	}
+
+	row := importRow{}
+	if err := json.Unmarshal(raw, &row.Book); err != nil {
+		field, rule := "", "json"
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			field, rule = typeErr.Field, "type"
+		}
+
+		row.Fields = append(row.Fields, FieldError{
+			Field:   field,
+			Rule:    rule,
+			Message: jsonErrorDetail(err),
+		})
+	}
+
+	return row, nil
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+func readRows(t *testing.T, format, body string) []importRow {
+	t.Helper()
+
+	rows, err := newBookRowReader(format, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := []importRow{}
+	for {
+		row, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result = append(result, row)
+	}
+}
+
+func TestBookRowReader_CSV(t *testing.T) {
+	body := "\ufeffName,Author,Published_Year,Available_Copies,Price,Currency\n" +
+		"Book1, Author1,1999,3,200,eur\n" +
+		"Book2,Author2,soon,1,100,\n"
+
+	rows := readRows(t, formatCSV, body)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	if book := rows[0].Book; book.Name != "Book1" || book.Author != "Author1" || book.PublishedYear != 1999 || book.Price != model.NewMoney(200, "EUR") {
+		t.Fatalf("unexpected first row %+v", book)
+	}
+
+	if price := rows[1].Book.Price; price.Currency != model.DefaultCurrency {
+		t.Fatalf("expected a row without currency in %s, got %+v", model.DefaultCurrency, price)
+	}
+
+	if len(rows[1].Fields) != 1 || rows[1].Fields[0].Field != "published_year" {
+		t.Fatalf("expected published_year error, got %+v", rows[1].Fields)
+	}
+
+	if _, err := newBookRowReader(formatCSV, strings.NewReader("title,price\n")); err == nil {
+		t.Fatalf("expected header without name and author to be rejected")
+	}
+}
+
+func TestBookRowReader_JSON(t *testing.T) {
+	array := `[{"name":"Book1","author":"Author1","published_year":1999},{"name":"Book2","price":"free"}]`
+
+	rows := readRows(t, formatJSON, array)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	if rows[0].Book.Name != "Book1" || len(rows[0].Fields) != 0 {
+		t.Fatalf("unexpected first row %+v", rows[0])
+	}
+
+	if len(rows[1].Fields) != 1 || rows[1].Fields[0].Rule != "type" {
+		t.Fatalf("expected type error, got %+v", rows[1].Fields)
+	}
+
+	ndjson := "{\"name\":\"Book1\",\"author\":\"Author1\"}\n{\"name\":\"Book2\",\"author\":\"Author2\"}\n"
+	if rows := readRows(t, formatNDJSON, ndjson); len(rows) != 2 || rows[1].Book.Author != "Author2" {
+		t.Fatalf("unexpected ndjson rows %+v", rows)
+	}
+
+	if _, err := newBookRowReader(formatJSON, strings.NewReader(`{"name":"Book1"}`)); err == nil {
+		t.Fatalf("expected non array body to be rejected")
+	}
+}
+
+func TestImportBooks_DryRun(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs("Book1", "Author1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectQuery(`^INSERT INTO "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectBylineCredits(mock, 1, 2, "Author1")
+	expectStockMovement(mock, model.StockAdjustment, 3)
+	mock.ExpectRollback()
+
+	body := "name,author,published_year,available_copies,price\n" +
+		"Book1,Author1,1999,3,200\n" +
+		"Book2,Author2,1200,3,200\n"
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/books/import?dry_run=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	ImportBooks(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	res := struct {
+		Data ImportReport `json:"data"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	report := res.Data
+	if report.Committed || report.Total != 2 || report.Created != 1 || report.Invalid != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if report.Errors[0].Row != 2 || report.Errors[0].Fields[0].Field != "published_year" {
+		t.Fatalf("unexpected row errors %+v", report.Errors)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestImportBooks_MultipartFormat(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "books.xml")
+	part.Write([]byte("<books/>"))
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/books/import", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	ImportBooks(db, w, req)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status 415, got %d", w.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/peekeah/book-store/logger"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/notify"
+	"gorm.io/gorm"
+)
+
+// notifier tells the staff about books running low, nil sends nothing
+var notifier notify.Notifier
+
+func SetNotifier(n notify.Notifier) {
+	notifier = n
+}
+
+// GetLowStock lists the books below their reorder point, the furthest
+// below first
+func GetLowStock(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	books := []model.Book{}
+
+	err := db.Where("reorder_point > 0 AND available_copies < reorder_point").
+		Order("available_copies - reorder_point, id").
+		Find(&books).Error
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, books, ""}
+	res.Dispatch()
+}
+
+// lowStock tells whether taking the stock of book from before to after
+// drops it below its reorder point. Books already below it are not
+// reported again.
+func lowStock(book *model.Book, before, after int) bool {
+	return book.ReorderPoint > 0 && before >= book.ReorderPoint && after < book.ReorderPoint
+}
+
+// alertLowStock notifies the staff that book dropped to copies below its
+// reorder point. The notification is sent in the background, a slow mail
+// server must not hold up the purchase that caused it.
+func alertLowStock(book model.Book, copies int) {
+	if notifier == nil {
+		return
+	}
+
+	msg := notify.Message{
+		Event:   notify.EventLowStock,
+		Subject: fmt.Sprintf("Low stock: %s", book.Name),
+		Body: fmt.Sprintf("%s by %s is down to %d copies, below its reorder point of %d.\nReorder %d copies.",
+			book.Name, book.Author, copies, book.ReorderPoint, book.ReorderQuantity),
+		Data: map[string]any{
+			"book_id":          book.ID,
+			"name":             book.Name,
+			"available_copies": copies,
+			"reorder_point":    book.ReorderPoint,
+			"reorder_quantity": book.ReorderQuantity,
+		},
+		Time: time.Now(),
+	}
+
+	go func(n notify.Notifier) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := n.Notify(ctx, msg); err != nil {
+			l := logger.Get()
+			l.Error().Err(err).Uint("book_id", book.ID).Msg("Low stock notification failed")
+		}
+	}(notifier)
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/notify"
+	"github.com/peekeah/book-store/utils"
+)
+
+// fakeNotifier hands the messages it is sent to the test
+type fakeNotifier chan notify.Message
+
+func (n fakeNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	n <- msg
+	return nil
+}
+
+func TestLowStock(t *testing.T) {
+	book := &model.Book{ReorderPoint: 5}
+
+	cases := []struct {
+		before, after int
+		want          bool
+	}{
+		{6, 4, true},
+		{5, 4, true},
+		{6, 5, false},
+		{4, 2, false}, // reported when it dropped below
+	}
+
+	for _, c := range cases {
+		if got := lowStock(book, c.before, c.after); got != c.want {
+			t.Fatalf("lowStock(%d, %d) = %v, expected %v", c.before, c.after, got, c.want)
+		}
+	}
+
+	if lowStock(&model.Book{}, 1, 0) {
+		t.Fatalf("expected books without reorder point to never be low")
+	}
+}
+
+func TestGetLowStock(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE \(?reorder_point > 0 AND available_copies < reorder_point\)? (.+) ORDER BY available_copies - reorder_point, id`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "reorder_point", "reorder_quantity"}).
+			AddRow(1, "Book1", 0, 5, 20).
+			AddRow(2, "Book2", 3, 4, 10))
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/inventory/low-stock", nil)
+	w := httptest.NewRecorder()
+
+	GetLowStock(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data []model.Book `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if len(res.Data) != 2 || res.Data[0].ReorderQuantity != 20 {
+		t.Fatalf("unexpected books %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_LowStockAlert(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	notifications := make(fakeNotifier, 1)
+	SetNotifier(notifications)
+	defer SetNotifier(nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "available_copies", "reorder_point", "reorder_quantity", "price_minor", "price_currency"}).
+			AddRow(1, "Book1", "Author1", 5, 4, 20, 200, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(map[string]any{"book_id": 1, "quantity": 2})
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	select {
+	case msg := <-notifications:
+		if msg.Event != notify.EventLowStock || msg.Data["available_copies"] != 3 || msg.Data["reorder_quantity"] != 20 {
+			t.Fatalf("unexpected notification %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a low stock notification")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
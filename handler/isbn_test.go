@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	cases := map[string]string{
+		"0-306-40615-2":     "9780306406157",
+		"978-0-306-40615-7": "9780306406157",
+		"080442957X":        "9780804429573",
+		"979-10-90636-07-1": "9791090636071",
+		"0-306-40615-3":     "",
+		"not an isbn":       "",
+	}
+
+	for input, want := range cases {
+		if got := utils.NormalizeISBN(input); got != want {
+			t.Errorf("normalize %s: expected %q, got %q", input, want, got)
+		}
+	}
+
+	if isbn10 := utils.ISBN10("9780804429573"); isbn10 != "080442957X" {
+		t.Fatalf("expected 080442957X, got %s", isbn10)
+	}
+
+	if isbn10 := utils.ISBN10("9791090636071"); isbn10 != "" {
+		t.Fatalf("expected no ISBN-10 for 979 prefix, got %s", isbn10)
+	}
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE \(isbn13 = \$1 OR id IN \(SELECT book_id FROM editions WHERE isbn13 = \$2`).
+		WithArgs("9780306406157", "9780306406157", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "isbn13", "isbn10", "version"}).
+			AddRow(1, "Book1", "9780306406157", "0306406152", 1))
+	expectNoAvailability(mock)
+
+	req, _ := http.NewRequest(http.MethodGet, "/books/isbn/0-306-40615-2", nil)
+	req = mux.SetURLVars(req, map[string]string{"isbn": "0-306-40615-2"})
+	w := httptest.NewRecorder()
+
+	GetBookByISBN(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	res := struct {
+		Data model.Book `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.ISBN() != "9780306406157" || res.Data.ISBN10 == nil || *res.Data.ISBN10 != "0306406152" {
+		t.Fatalf("unexpected book %+v", res.Data)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"isbn": "0-306-40615-3"})
+	w = httptest.NewRecorder()
+
+	GetBookByISBN(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid checksum to return 400, got %d", w.Code)
+	}
+}
+
+func TestCreateBook_PrefillFromMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	os.WriteFile(path, []byte(`{"978-0-306-40615-7":{"name":"Book1","author":"Author1","published_year":1999}}`), 0o600)
+
+	provider, err := NewFileMetadataProvider(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	SetMetadataProvider(provider)
+	defer SetMetadataProvider(nil)
+
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "books" WHERE \(?isbn13 = \$1`).
+		WithArgs("9780306406157", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^INSERT INTO "books"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"Book1", "Author1", "9780306406157", "0306406152", 1999, 5, 0, 0, 300, "USD", nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1))
+	expectBylineCredits(mock, 1, 2, "Author1")
+	expectStockMovement(mock, model.StockAdjustment, 5)
+	mock.ExpectCommit()
+
+	payload := []byte(`{"isbn":"0306406152","available_copies":5,"price":300}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	CreateBook(db, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateBook_ISBNTaken(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	payload := []byte(`{"name":"Book1","author":"Author1","published_year":1999,"isbn":"9780306406157"}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	CreateBook(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodeISBNTaken {
+		t.Fatalf("expected ISBN_TAKEN, got %s", res.Code)
+	}
+}
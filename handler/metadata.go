@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/peekeah/book-store/logger"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+// ErrMetadataNotFound is returned by a BookMetadataProvider that knows
+// nothing about an ISBN.
+var ErrMetadataNotFound = errors.New("book metadata not found")
+
+// BookMetadata is the bibliographic data a provider knows about an ISBN.
+type BookMetadata struct {
+	Name          string `json:"name"`
+	Author        string `json:"author"`
+	PublishedYear int    `json:"published_year"`
+}
+
+// BookMetadataProvider looks up bibliographic data by normalized ISBN-13.
+type BookMetadataProvider interface {
+	LookupISBN(ctx context.Context, isbn13 string) (BookMetadata, error)
+}
+
+// metadataProvider prefills new books, nil disables prefilling
+var metadataProvider BookMetadataProvider
+
+func SetMetadataProvider(provider BookMetadataProvider) {
+	metadataProvider = provider
+}
+
+// FileMetadataProvider serves metadata from a JSON file mapping ISBNs, in
+// either form, to book metadata. It stands in for a real catalog service.
+type FileMetadataProvider struct {
+	books map[string]BookMetadata
+}
+
+func NewFileMetadataProvider(path string) (*FileMetadataProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]BookMetadata{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	books := make(map[string]BookMetadata, len(entries))
+	for isbn, book := range entries {
+		isbn13 := utils.NormalizeISBN(isbn)
+		if isbn13 == "" {
+			return nil, fmt.Errorf("parse %s: invalid isbn %q", path, isbn)
+		}
+		books[isbn13] = book
+	}
+
+	return &FileMetadataProvider{books: books}, nil
+}
+
+func (p *FileMetadataProvider) LookupISBN(_ context.Context, isbn13 string) (BookMetadata, error) {
+	book, ok := p.books[isbn13]
+	if !ok {
+		return BookMetadata{}, ErrMetadataNotFound
+	}
+	return book, nil
+}
+
+// prefillBookFields fills the fields left empty by the client from the
+// metadata provider. Lookup failures are not fatal, validation reports
+// whatever is still missing.
+func prefillBookFields(ctx context.Context, fields *model.BookFields) {
+	if metadataProvider == nil || fields.ISBN == "" {
+		return
+	}
+
+	if fields.Name != "" && fields.Author != "" && fields.PublishedYear != 0 {
+		return
+	}
+
+	isbn13 := utils.NormalizeISBN(fields.ISBN)
+	if isbn13 == "" {
+		return
+	}
+
+	metadata, err := metadataProvider.LookupISBN(ctx, isbn13)
+	if err != nil {
+		if !errors.Is(err, ErrMetadataNotFound) {
+			l := logger.Get()
+			l.Warn().Err(err).Str("isbn", isbn13).Msg("book metadata lookup failed")
+		}
+		return
+	}
+
+	if fields.Name == "" {
+		fields.Name = metadata.Name
+	}
+	if fields.Author == "" {
+		fields.Author = metadata.Author
+	}
+	if fields.PublishedYear == 0 {
+		fields.PublishedYear = metadata.PublishedYear
+	}
+}
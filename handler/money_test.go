@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peekeah/book-store/model"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	price := model.NewMoney(1299, "usd")
+
+	total, err := price.Mul(3)
+	if err != nil || total != model.NewMoney(3897, "USD") {
+		t.Fatalf("unexpected total %+v, %v", total, err)
+	}
+
+	if _, err := price.Add(model.NewMoney(100, "EUR")); !errors.Is(err, model.ErrCurrencyMismatch) {
+		t.Fatalf("expected currency mismatch, got %v", err)
+	}
+
+	if _, err := model.NewMoney(math.MaxInt64, "USD").Mul(2); !errors.Is(err, model.ErrAmountOverflow) {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+
+	// half away from zero
+	cases := []struct {
+		amount, num, den, want int64
+	}{
+		{1000, 75, 1000, 75},
+		{1299, 1, 2, 650},
+		{-1299, 1, 2, -650},
+		{1298, 1, 3, 433},
+		{5, 1, 10, 1},
+		{4, 1, 10, 0},
+	}
+
+	for _, c := range cases {
+		got, err := model.NewMoney(c.amount, "USD").Scale(c.num, c.den)
+		if err != nil || got.Amount != c.want {
+			t.Errorf("scale %d by %d/%d: expected %d, got %d (%v)", c.amount, c.num, c.den, c.want, got.Amount, err)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	formats := map[model.Money]string{
+		model.NewMoney(1299, "USD"): "$12.99",
+		model.NewMoney(5, "EUR"):    "€0.05",
+		model.NewMoney(-250, "GBP"): "-£2.50",
+		model.NewMoney(1500, "JPY"): "¥1500",
+	}
+
+	for money, want := range formats {
+		if got := money.String(); got != want {
+			t.Errorf("format %+v: expected %s, got %s", money, want, got)
+		}
+	}
+
+	body, _ := json.Marshal(model.NewMoney(1299, "EUR"))
+	if string(body) != `{"amount":1299,"currency":"EUR","formatted":"€12.99"}` {
+		t.Fatalf("unexpected json %s", body)
+	}
+
+	money := model.Money{}
+	if err := json.Unmarshal(body, &money); err != nil || money != model.NewMoney(1299, "EUR") {
+		t.Fatalf("unexpected money %+v, %v", money, err)
+	}
+
+	if err := json.Unmarshal([]byte(`450`), &money); err != nil || money != model.NewMoney(450, model.DefaultCurrency) {
+		t.Fatalf("expected a bare amount in the default currency, got %+v, %v", money, err)
+	}
+
+	fields := model.BookFields{}
+	err := json.Unmarshal([]byte(`{"price":"free"}`), &fields)
+
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected a type error, got %v", err)
+	}
+}
+
+func TestCreateBook_InvalidCurrency(t *testing.T) {
+	payload := []byte(`{"name":"Book1","author":"Author1","published_year":1999,"price":{"amount":100,"currency":"XYZ"}}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	CreateBook(nil, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if len(res.Fields) != 1 || res.Fields[0].Field != "price" || res.Fields[0].Rule != "money" {
+		t.Fatalf("expected money error on price, got %+v", res.Fields)
+	}
+}
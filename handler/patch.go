@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// readOnlyFields are the gorm.Model fields present in every representation.
+var readOnlyFields = []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt"}
+
+// decodeMergePatch reads an RFC 7396 merge patch document from the request body.
+func decodeMergePatch(r *http.Request) (map[string]any, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType == jsonPatchMediaType {
+			return nil, ErrUnsupportedMediaType.WithDetail("use %s for partial updates", mergePatchMediaType)
+		}
+	}
+
+	patch := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, ErrMalformedRequest.WithDetail("body must be a JSON object").Wrap(err)
+	}
+
+	// clients often send back the resource they fetched, ignore the
+	// server managed fields instead of rejecting them as unknown
+	for _, key := range readOnlyFields {
+		delete(patch, key)
+	}
+
+	return patch, nil
+}
+
+// decodeReplacement reads the body of a PUT into the empty fields pointed to
+// by fields. A full replacement is a merge patch applied to empty fields, so
+// the read only fields are ignored and absent fields stay empty.
+func decodeReplacement(r *http.Request, fields any) error {
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		return err
+	}
+
+	return applyMergePatch(fields, patch)
+}
+
+// applyMergePatch merges patch into the struct pointed to by target. Fields
+// set to null are reset to their zero value, absent fields are left as is.
+func applyMergePatch(target any, patch map[string]any) error {
+	doc, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]any{}
+	if err := json.Unmarshal(doc, &current); err != nil {
+		return err
+	}
+
+	merged, err := json.Marshal(mergePatch(current, patch))
+	if err != nil {
+		return err
+	}
+
+	reflect.ValueOf(target).Elem().SetZero()
+
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(target); err != nil {
+		return ErrMalformedRequest.WithDetail("%s", jsonErrorDetail(err)).Wrap(err)
+	}
+
+	return nil
+}
+
+// mergePatch implements the MergePatch algorithm from RFC 7396 section 2.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+
+	return targetObj
+}
+
+// jsonErrorDetail describes a decode error without leaking go type names.
+func jsonErrorDetail(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return "invalid value for field " + typeErr.Field
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return "body is not valid JSON"
+	}
+
+	return err.Error()
+}
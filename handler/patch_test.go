@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestMergePatch_RFCExamples(t *testing.T) {
+	cases := []struct {
+		target string
+		patch  string
+		result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, c := range cases {
+		var target, patch, want any
+		json.Unmarshal([]byte(c.target), &target)
+		json.Unmarshal([]byte(c.patch), &patch)
+		json.Unmarshal([]byte(c.result), &want)
+
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge %s with %s: expected %v, got %v", c.target, c.patch, want, got)
+		}
+	}
+}
+
+func TestApplyMergePatch_ZeroAndNull(t *testing.T) {
+	fields := model.UserFields{Name: "user1", City: "Pune", Email: "user@example.com"}
+
+	patch := map[string]any{"city": nil}
+	if err := applyMergePatch(&fields, patch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fields.City != "" || fields.Name != "user1" {
+		t.Fatalf("expected only city to be cleared, got %+v", fields)
+	}
+
+	book := model.BookFields{Name: "Book1", Author: "Author1", PublishedYear: 1999, AvailableCopies: 3, Price: model.NewMoney(200, "EUR")}
+
+	// patching only the amount keeps the currency
+	patch = map[string]any{"available_copies": 0, "price": map[string]any{"amount": 0}}
+	if err := applyMergePatch(&book, patch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if book.AvailableCopies != 0 || book.Price != model.NewMoney(0, "EUR") || book.Name != "Book1" {
+		t.Fatalf("expected zero values to be applied, got %+v", book)
+	}
+
+	if err := applyMergePatch(&book, map[string]any{"titel": "typo"}); err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+}
+
+func TestUpdateBook_SetSoldOut(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "available_copies", "price_minor", "price_currency", "version"}).
+		AddRow(1, "Book1", "Author1", 1999, 4, 200, "USD", 1)
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
+		WillReturnRows(mockRows)
+
+	mock.ExpectBegin()
+	expectNoEditions(mock)
+	mock.ExpectExec("^UPDATE \"books\" SET").
+		WithArgs(sqlmock.AnyArg(), "Book1", "Author1", nil, nil, 1999, 0, 0, 0, 200, "USD", 2, 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseStock(mock)
+	expectStockMovement(mock, model.StockAdjustment, -4)
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/books/1", bytes.NewReader([]byte(`{"available_copies":0}`)))
+	req.Header.Set("Content-Type", mergePatchMediaType)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateBook(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateBook_JSONPatchUnsupported(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/books/1", bytes.NewReader([]byte(`[{"op":"remove","path":"/price"}]`)))
+	req.Header.Set("Content-Type", jsonPatchMediaType)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateBook(db, w, req)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status 415, got %d", w.Code)
+	}
+}
+
+func TestReplaceBook_MissingFields(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mockRows := sqlmock.NewRows([]string{"ID", "name", "author", "published_year"}).
+		AddRow(1, "Book1", "Author1", 1999)
+
+	mock.ExpectQuery("^SELECT (.+) FROM \"books\"").
+		WillReturnRows(mockRows)
+
+	req, _ := http.NewRequest(http.MethodPut, "/books/1", bytes.NewReader([]byte(`{"name":"Book2"}`)))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	ReplaceBook(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if res.Code != CodeValidationFailed || len(res.Fields) != 2 {
+		t.Fatalf("expected author and published_year errors, got %+v", res)
+	}
+}
+
+func TestUpdateUser_ClearCity(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	rows := sqlmock.NewRows([]string{"ID", "name", "email", "city", "version"}).
+		AddRow(1, "user1", "user@example.com", "Pune", 1)
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(rows)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "users"`).
+		WithArgs(sqlmock.AnyArg(), "user1", "", "user@example.com", 2, 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/users/1", bytes.NewReader([]byte(`{"city":null}`)))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateUser(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
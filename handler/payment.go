@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/payment"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// paymentProvider charges purchases, nil marks them paid without charging
+var paymentProvider payment.Provider
+
+func SetPaymentProvider(provider payment.Provider) {
+	paymentProvider = provider
+}
+
+// maxWebhookSize bounds the webhook payloads read
+const maxWebhookSize = 1 << 20
+
+// chargePurchase charges a committed, pending purchase. The amount is
+// authorized and captured straight away; when either fails the purchase
+// is failed, which puts its copies back in stock. Payments the provider
+// can not settle at once leave the purchase pending, a webhook ends it.
+func chargePurchase(ctx context.Context, db *gorm.DB, purchase *model.Purchase, method string) error {
+	// nothing to charge when discounts took the whole price off
+	if purchase.Amount.IsZero() {
+		purchase.Status = model.PurchasePaid
+		return settlePurchase(db, purchase, map[string]any{"status": purchase.Status})
+	}
+
+	charged, err := paymentProvider.Authorize(ctx, payment.Charge{
+		Amount:      purchase.Amount.Amount,
+		Currency:    purchase.Amount.Currency,
+		Source:      method,
+		Reference:   strconv.FormatUint(uint64(purchase.ID), 10),
+		Description: fmt.Sprintf("Book store purchase %d", purchase.ID),
+	})
+	if err == nil && charged.Status == payment.StatusAuthorized {
+		purchase.PaymentID = charged.ID
+
+		if charged, err = paymentProvider.Capture(ctx, charged.ID); err != nil {
+			// an authorization that is not captured would hold the money
+			paymentProvider.Void(ctx, purchase.PaymentID)
+		}
+	}
+
+	if err != nil {
+		if failErr := db.Transaction(func(tx *gorm.DB) error { return failPurchase(tx, purchase) }); failErr != nil {
+			return failErr
+		}
+
+		if errors.Is(err, payment.ErrDeclined) {
+			return ErrPaymentDeclined.WithDetail("%s", err).Wrap(err)
+		}
+		return ErrPaymentFailed.Wrap(err)
+	}
+
+	purchase.PaymentID = charged.ID
+	if charged.Status == payment.StatusCaptured {
+		purchase.Status = model.PurchasePaid
+	}
+
+	return settlePurchase(db, purchase, map[string]any{"status": purchase.Status, "payment_id": purchase.PaymentID})
+}
+
+// settlePurchase writes the outcome of a charge to a purchase that is still
+// pending. A webhook can end the purchase while the provider is called, the
+// purchase is then read back as the webhook left it instead of overwritten.
+func settlePurchase(db *gorm.DB, purchase *model.Purchase, columns map[string]any) error {
+	result := db.Model(purchase).Where("status = ?", model.PurchasePending).Updates(columns)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	if err := db.First(purchase, purchase.ID).Error; err != nil {
+		return err
+	}
+
+	if purchase.Status == model.PurchaseFailed {
+		return ErrPaymentFailed.WithDetail("payment failed while the purchase was charged")
+	}
+	return nil
+}
+
+// failPurchase fails a pending purchase: its copies go back in stock and
+// its coupon redemption is given back. Purchases that are not pending are
+// left alone, so failing one twice is harmless.
+func failPurchase(tx *gorm.DB, purchase *model.Purchase) error {
+	result := tx.Model(purchase).Where("status = ?", model.PurchasePending).Update("status", model.PurchaseFailed)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	purchase.Status = model.PurchaseFailed
+	reference := model.StockRef("purchases", purchase.ID)
+
+	if err := restockCopies(tx, model.StockMovement{
+		BookID:    purchase.BookID,
+		EditionID: purchase.EditionID,
+		Kind:      model.StockRestock,
+		Quantity:  purchase.Quantity,
+		Reason:    "payment failed",
+		Reference: reference,
+	}, reference); err != nil {
+		return err
+	}
+
+	if purchase.CouponID != nil {
+		err := tx.Model(&model.Coupon{}).Where("id = ? AND redemptions > 0", *purchase.CouponID).
+			UpdateColumn("redemptions", gorm.Expr("redemptions - 1")).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PaymentWebhook receives the payment provider's events. Deliveries must
+// carry a valid signature, each event is applied once however often it is
+// delivered.
+func PaymentWebhook(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	if paymentProvider == nil {
+		res := ErrorResponse{w, r, ErrPaymentsDisabled}
+		res.Dispatch()
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	event, err := paymentProvider.ParseWebhook(body, r.Header)
+	if errors.Is(err, payment.ErrInvalidSignature) {
+		res := ErrorResponse{w, r, ErrWebhookSignature.WithDetail("%s", err).Wrap(err)}
+		res.Dispatch()
+		return
+	}
+	if err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if event.ID == "" {
+		res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("webhook event has no id")}
+		res.Dispatch()
+		return
+	}
+
+	applied := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		purchase, err := eventPurchase(tx, event)
+		if err != nil {
+			return err
+		}
+
+		record := model.PaymentEvent{ID: event.ID, Type: event.Type}
+		if purchase != nil {
+			record.PurchaseID = &purchase.ID
+		}
+
+		// the event id is the primary key, a redelivered event inserts nothing
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 || purchase == nil {
+			return nil
+		}
+
+		applied = true
+		return applyPaymentEvent(tx, purchase, event)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	msg := "event processed"
+	if !applied {
+		msg = "event ignored"
+	}
+
+	res := SuccessResponse{w, http.StatusOK, nil, msg}
+	res.Dispatch()
+}
+
+// eventPurchase finds the purchase an event is about, by payment id or by
+// the reference it was charged with. Events about payments that are not
+// purchases, or that carry neither, are ignored, nil is returned for them.
+func eventPurchase(tx *gorm.DB, event payment.Event) (*model.Purchase, error) {
+	id, err := strconv.ParseUint(event.Payment.Reference, 10, 64)
+	hasReference := err == nil
+
+	var query *gorm.DB
+	switch {
+	case event.Payment.ID != "" && hasReference:
+		query = tx.Where("payment_id = ? OR id = ?", event.Payment.ID, id)
+	case event.Payment.ID != "":
+		query = tx.Where("payment_id = ?", event.Payment.ID)
+	case hasReference:
+		query = tx.Where("id = ?", id)
+	default:
+		// purchases not charged yet have an empty payment id
+		return nil, nil
+	}
+
+	purchase := model.Purchase{}
+	err = query.Clauses(clause.Locking{Strength: "UPDATE"}).First(&purchase).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+func applyPaymentEvent(tx *gorm.DB, purchase *model.Purchase, event payment.Event) error {
+	switch event.Type {
+	case payment.EventCaptured:
+		return tx.Model(purchase).Where("status = ?", model.PurchasePending).
+			Updates(map[string]any{"status": model.PurchasePaid, "payment_id": event.Payment.ID}).Error
+	case payment.EventFailed, payment.EventVoided:
+		return failPurchase(tx, purchase)
+	case payment.EventRefunded:
+		// partial refunds leave the purchase paid
+		if event.Payment.Status != payment.StatusRefunded {
+			return nil
+		}
+		return tx.Model(purchase).Where("status = ?", model.PurchasePaid).Update("status", model.PurchaseRefunded).Error
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/payment"
+	"github.com/peekeah/book-store/utils"
+)
+
+// expectPendingPurchase expects a purchase of two copies of a 10.00 book
+// saved as pending
+func expectPendingPurchase(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 5, 1000, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, nil, 2, 2000, "USD", nil, 0, "USD", 0, "USD", "", false, "pending", "").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(7))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectCommit()
+}
+
+func purchaseWithPayment(method string) *httptest.ResponseRecorder {
+	db, _ := utils.GetDBMock()
+
+	body, _ := json.Marshal(map[string]any{"book_id": 1, "quantity": 2, "payment_method": method})
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+	return w
+}
+
+func TestPurchaseBook_Payment(t *testing.T) {
+	_, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	expectPendingPurchase(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "purchases" SET "payment_id"=\$1,"status"=\$2,"updated_at"=\$3 WHERE status = \$4 (.+)"id" = \$5`).
+		WithArgs(sqlmock.AnyArg(), model.PurchasePaid, sqlmock.AnyArg(), model.PurchasePending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := purchaseWithPayment("tok_visa")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.Purchase `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.Status != model.PurchasePaid || res.Data.PaymentID == "" {
+		t.Fatalf("expected a paid purchase with a payment id, got %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_PaymentPending(t *testing.T) {
+	_, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	expectPendingPurchase(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "purchases" SET "payment_id"=\$1,"status"=\$2`).
+		WithArgs(sqlmock.AnyArg(), model.PurchasePending, sqlmock.AnyArg(), model.PurchasePending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := purchaseWithPayment(payment.FakePendingSource)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_PaymentFailedByWebhook(t *testing.T) {
+	_, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	// a webhook failed the purchase while it was charged, it stays failed
+	expectPendingPurchase(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "purchases" SET "payment_id"=\$1,"status"=\$2`).
+		WithArgs(sqlmock.AnyArg(), model.PurchasePaid, sqlmock.AnyArg(), model.PurchasePending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`^SELECT (.+) FROM "purchases"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(7, model.PurchaseFailed))
+
+	w := purchaseWithPayment("tok_visa")
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_PaymentDeclined(t *testing.T) {
+	_, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	expectPendingPurchase(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "purchases" SET "status"=\$1,"updated_at"=\$2 WHERE status = \$3 (.+)"id" = \$4`).
+		WithArgs(model.PurchaseFailed, sqlmock.AnyArg(), model.PurchasePending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(2, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseReturn(mock)
+	expectStockMovement(mock, model.StockRestock, 2)
+	mock.ExpectCommit()
+
+	w := purchaseWithPayment(payment.FakeDeclinedSource)
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodePaymentDeclined {
+		t.Fatalf("expected PAYMENT_DECLINED, got %s", res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_PaymentMethodRequired(t *testing.T) {
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	w := purchaseWithPayment("")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	provider := payment.NewFakeProvider("whsec")
+	SetPaymentProvider(provider)
+	defer SetPaymentProvider(nil)
+
+	pending, _ := provider.Authorize(context.Background(), payment.Charge{Amount: 2000, Currency: "USD", Source: payment.FakePendingSource, Reference: "7"})
+	provider.Settle(pending.ID, false)
+	payload, header, _ := provider.Event("evt_1", payment.EventFailed, pending.ID)
+
+	deliver := func(payload []byte, header http.Header) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/payments/webhook", bytes.NewReader(payload))
+		req.Header = header
+		w := httptest.NewRecorder()
+		PaymentWebhook(db, w, req)
+		return w
+	}
+
+	purchaseRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "book_id", "quantity", "status", "payment_id"}).
+			AddRow(7, 1, 1, 2, model.PurchasePending, pending.ID)
+	}
+
+	// the first delivery fails the purchase and puts its copies back
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "purchases" WHERE \(payment_id = \$1 OR id = \$2\) (.+) FOR UPDATE`).
+		WithArgs(pending.ID, 7, 1).
+		WillReturnRows(purchaseRows())
+	mock.ExpectExec(`^INSERT INTO "payment_events" (.+) ON CONFLICT DO NOTHING`).
+		WithArgs("evt_1", payment.EventFailed, 7, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "purchases" SET "status"=\$1`).
+		WithArgs(model.PurchaseFailed, sqlmock.AnyArg(), model.PurchasePending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2`).
+		WithArgs(2, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseReturn(mock)
+	expectStockMovement(mock, model.StockRestock, 2)
+	mock.ExpectCommit()
+
+	if w := deliver(payload, header); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// a redelivery is recorded already and changes nothing
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "purchases"`).
+		WillReturnRows(purchaseRows())
+	mock.ExpectExec(`^INSERT INTO "payment_events"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if w := deliver(payload, header); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	// forged deliveries are rejected before touching the database
+	forged := http.Header{}
+	forged.Set(payment.SignatureHeader, payment.SignWebhook("other", payload, time.Now()))
+
+	w := deliver(payload, forged)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Code != CodeWebhookSignature {
+		t.Fatalf("expected WEBHOOK_SIGNATURE_INVALID, got %s", res.Code)
+	}
+}
+
+func TestPaymentWebhook_NoPayment(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	// an event without payment id or reference is about no purchase, not
+	// about every purchase that was never charged
+	payload, _ := json.Marshal(payment.Event{ID: "evt_2", Type: payment.EventCaptured})
+	header := http.Header{}
+	header.Set(payment.SignatureHeader, payment.SignWebhook("whsec", payload, time.Now()))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^INSERT INTO "payment_events"`).
+		WithArgs("evt_2", payment.EventCaptured, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPost, "/payments/webhook", bytes.NewReader(payload))
+	req.Header = header
+	w := httptest.NewRecorder()
+	PaymentWebhook(db, w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "event ignored") {
+		t.Fatalf("expected event to be ignored, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+// PriceBreakdown is the itemized price of a purchase: the list price, the
+// discounts taken off it and the tax on what is left.
+type PriceBreakdown struct {
+	BookID       uint                 `json:"book_id"`
+	EditionID    *uint                `json:"edition_id"`
+	Quantity     int                  `json:"quantity"`
+	UnitPrice    model.Money          `json:"unit_price"`
+	ListAmount   model.Money          `json:"list_amount"`
+	Discounts    []model.DiscountLine `json:"discounts"`
+	Discount     model.Money          `json:"discount"`
+	Tax          model.Money          `json:"tax"`
+	TaxRegion    string               `json:"tax_region,omitempty"`
+	TaxInclusive bool                 `json:"tax_inclusive"`
+	Taxes        []model.TaxLine      `json:"taxes"`
+	Total        model.Money          `json:"total"`
+}
+
+// PreviewPurchase prices a purchase without making it. The purchase is
+// priced in a transaction that is rolled back, so the preview applies the
+// same promotions, coupon checks and tax as the purchase would.
+func PreviewPurchase(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.PurchasePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	userId := r.Context().Value("user_id").(uint)
+
+	tx := db.Begin()
+
+	if err := tx.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// nothing a preview does is kept
+	defer tx.Rollback()
+
+	book, edition, err := purchaseItem(tx, &payload)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	purchase := model.Purchase{
+		UserID:   userId,
+		BookID:   book.ID,
+		Quantity: payload.Quantity,
+	}
+
+	price, format := book.Price, ""
+	if edition != nil {
+		purchase.EditionID = &edition.ID
+		price, format = edition.Price, edition.Format
+	}
+
+	if err := pricePurchase(r.Context(), tx, &purchase, price, format, payload.CouponCode, payload.Region); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	breakdown, err := priceBreakdown(&purchase, price)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, breakdown, ""}
+	res.Dispatch()
+}
+
+// pricePurchase works out what a purchase costs, in this order: the list
+// price of its copies, the running promotions, the coupon and finally the
+// tax on what is left. Amount ends up as what the customer pays.
+func pricePurchase(ctx context.Context, tx *gorm.DB, purchase *model.Purchase, unitPrice model.Money, format, couponCode, region string) error {
+	amount, err := unitPrice.Mul(int64(purchase.Quantity))
+	if err != nil {
+		return err
+	}
+
+	purchase.Amount = amount
+	purchase.Discount = model.Zero(amount.Currency)
+	purchase.Discounts = nil
+	purchase.Tax = model.Zero(amount.Currency)
+
+	promotions, err := activePromotions(tx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// categories are only looked up when something depends on them
+	categories := []string{}
+	if taxCalculator != nil || slices.ContainsFunc(promotions, func(p model.Promotion) bool { return p.NeedsCategories() }) {
+		if categories, err = model.BookCategorySlugs(tx, purchase.BookID); err != nil {
+			return err
+		}
+	}
+
+	lines, err := model.ApplyPromotions(promotions, model.PromotionItem{
+		BookID:     purchase.BookID,
+		Format:     format,
+		Categories: categories,
+		Quantity:   purchase.Quantity,
+		UnitPrice:  unitPrice,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if err := discountPurchase(purchase, line); err != nil {
+			return err
+		}
+	}
+
+	if couponCode != "" {
+		if err := redeemCoupon(tx, purchase, couponCode); err != nil {
+			return err
+		}
+	}
+
+	return taxPurchase(ctx, purchase, format, categories, region)
+}
+
+// discountPurchase takes a discount line off the amount of a purchase
+func discountPurchase(purchase *model.Purchase, line model.DiscountLine) error {
+	amount, err := purchase.Amount.Sub(line.Amount)
+	if err != nil {
+		return err
+	}
+
+	discount, err := purchase.Discount.Add(line.Amount)
+	if err != nil {
+		return err
+	}
+
+	purchase.Amount = amount
+	purchase.Discount = discount
+	purchase.Discounts = append(purchase.Discounts, model.PurchaseDiscount{DiscountLine: line})
+	return nil
+}
+
+// activePromotions are the promotions running at t in the order they apply
+func activePromotions(tx *gorm.DB, t time.Time) ([]model.Promotion, error) {
+	promotions := []model.Promotion{}
+
+	err := tx.Where("(starts_at IS NULL OR starts_at <= ?) AND (expires_at IS NULL OR expires_at > ?)", t, t).
+		Order("priority DESC, id").
+		Find(&promotions).Error
+
+	return promotions, err
+}
+
+// priceBreakdown itemizes a priced purchase
+func priceBreakdown(purchase *model.Purchase, unitPrice model.Money) (PriceBreakdown, error) {
+	listAmount, err := unitPrice.Mul(int64(purchase.Quantity))
+	if err != nil {
+		return PriceBreakdown{}, err
+	}
+
+	breakdown := PriceBreakdown{
+		BookID:       purchase.BookID,
+		EditionID:    purchase.EditionID,
+		Quantity:     purchase.Quantity,
+		UnitPrice:    unitPrice,
+		ListAmount:   listAmount,
+		Discounts:    make([]model.DiscountLine, len(purchase.Discounts)),
+		Discount:     purchase.Discount,
+		Tax:          purchase.Tax,
+		TaxRegion:    purchase.TaxRegion,
+		TaxInclusive: purchase.TaxInclusive,
+		Taxes:        make([]model.TaxLine, len(purchase.Taxes)),
+		Total:        purchase.Amount,
+	}
+
+	for i, discount := range purchase.Discounts {
+		breakdown.Discounts[i] = discount.DiscountLine
+	}
+
+	for i, tax := range purchase.Taxes {
+		breakdown.Taxes[i] = tax.TaxLine
+	}
+
+	return breakdown, nil
+}
@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+func GetPromotions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	promotions := []model.Promotion{}
+
+	if err := db.Order("priority DESC, id").Find(&promotions).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, promotions, ""}
+	res.Dispatch()
+}
+
+func GetPromotionById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	promotionId, err := promotionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	promotion := model.Promotion{}
+
+	if err := db.First(&promotion, promotionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPromotionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(promotion.ID, promotion.Version)) {
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, promotion, ""}
+	res.Dispatch()
+}
+
+func CreatePromotion(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	fields := model.PromotionFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validatePromotionFields(db, fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	promotion := model.Promotion{}
+	promotion.SetFields(fields)
+
+	if err := db.Create(&promotion).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, promotion, ""}
+	res.Dispatch()
+}
+
+// UpdatePromotion applies a JSON merge patch (RFC 7396) to a promotion
+func UpdatePromotion(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	promotionId, err := promotionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbPromotion := model.Promotion{}
+
+	if err := db.First(&dbPromotion, promotionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPromotionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbPromotion.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	savePromotionFields(db, w, r, &dbPromotion, fields)
+}
+
+// ReplacePromotion overwrites every editable field of a promotion
+func ReplacePromotion(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	promotionId, err := promotionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.PromotionFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbPromotion := model.Promotion{}
+
+	if err := db.First(&dbPromotion, promotionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPromotionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	savePromotionFields(db, w, r, &dbPromotion, fields)
+}
+
+// savePromotionFields writes fields only if the promotion still has the
+// version that was read
+func savePromotionFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbPromotion *model.Promotion, fields model.PromotionFields) {
+	if _, err := checkIfMatch(r, versionETag(dbPromotion.ID, dbPromotion.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validatePromotionFields(db, fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	version := dbPromotion.Version
+	dbPromotion.SetFields(fields)
+	dbPromotion.Version++
+
+	result := db.Model(dbPromotion).Where("version = ?", version).Select(model.PromotionFieldColumns).Updates(dbPromotion)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("promotion was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbPromotion.ID, dbPromotion.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbPromotion, ""}
+	res.Dispatch()
+}
+
+// DeletePromotion ends a promotion. It is soft deleted so the discount lines
+// of past purchases keep referring to it.
+func DeletePromotion(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	promotionId, err := promotionIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	promotion := model.Promotion{}
+
+	if err := db.First(&promotion, promotionId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPromotionNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(promotion.ID, promotion.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	query := db
+	if conditional {
+		query = query.Where("version = ?", promotion.Version)
+	}
+
+	result := query.Delete(&promotion)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("promotion was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, promotion, ""}
+	res.Dispatch()
+}
+
+// validatePromotionFields checks the rules spanning several fields and
+// that the books and categories the conditions name exist
+func validatePromotionFields(db *gorm.DB, fields model.PromotionFields) error {
+	if err := validate.Struct(&fields); err != nil {
+		return err
+	}
+
+	if fields.StartsAt != nil && fields.ExpiresAt != nil && !fields.ExpiresAt.After(*fields.StartsAt) {
+		return ErrValidationFailed.WithDetail("expires_at must be after starts_at")
+	}
+
+	switch action := fields.Action; action.Type {
+	case model.ActionAmountOff:
+		if action.AmountOff.IsZero() {
+			return ErrValidationFailed.WithDetail("amount_off is required for amount_off promotions")
+		}
+	case model.ActionBundlePrice:
+		if action.BundlePrice.IsZero() {
+			return ErrValidationFailed.WithDetail("bundle_price is required for bundle_price promotions")
+		}
+	}
+
+	bookIds, slugs := []uint{}, map[string]bool{}
+	for _, condition := range fields.Conditions {
+		switch condition.Type {
+		case model.ConditionBook:
+			bookIds = append(bookIds, condition.BookIDs...)
+		case model.ConditionCategory:
+			slugs[condition.Category] = true
+		case model.ConditionMinSpend:
+			if condition.Amount.IsZero() {
+				return ErrValidationFailed.WithDetail("amount is required for min_spend conditions")
+			}
+		}
+	}
+
+	if ids := uniqueIds(bookIds); len(ids) > 0 {
+		count := int64(0)
+		if err := db.Model(&model.Book{}).Where("id IN ?", bookIds).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if int(count) != len(ids) {
+			return ErrBookNotFound.WithDetail("some of the books do not exist")
+		}
+	}
+
+	if len(slugs) > 0 {
+		names := make([]string, 0, len(slugs))
+		for slug := range slugs {
+			names = append(names, slug)
+		}
+
+		count := int64(0)
+		if err := db.Model(&model.Category{}).Where("slug IN ?", names).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if int(count) != len(names) {
+			return ErrCategoryNotFound.WithDetail("some of the categories do not exist")
+		}
+	}
+
+	return nil
+}
+
+func promotionIdParam(r *http.Request) (int, error) {
+	promotionIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	promotionId, err := strconv.Atoi(promotionIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid promotion id")
+	}
+
+	return promotionId, nil
+}
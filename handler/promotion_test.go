@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+func TestApplyPromotions(t *testing.T) {
+	item := model.PromotionItem{
+		BookID:     1,
+		Format:     model.FormatPaperback,
+		Categories: []string{"fiction", "crime"},
+		Quantity:   5,
+		UnitPrice:  model.NewMoney(1000, "USD"),
+	}
+
+	promotion := func(id uint, name string, action model.PromotionAction, conditions ...model.PromotionCondition) model.Promotion {
+		return model.Promotion{Model: gorm.Model{ID: id}, Name: name, Action: action, Conditions: conditions}
+	}
+
+	cases := []struct {
+		name       string
+		promotions []model.Promotion
+		want       []int64
+	}{
+		{
+			name: "buy 2 get 1 on a category",
+			promotions: []model.Promotion{promotion(1, "3 for 2 crime",
+				model.PromotionAction{Type: model.ActionBuyXGetY, Buy: 2, Get: 1},
+				model.PromotionCondition{Type: model.ConditionCategory, Category: "crime"})},
+			want: []int64{1000},
+		},
+		{
+			name: "bundle price",
+			promotions: []model.Promotion{promotion(1, "2 for 15",
+				model.PromotionAction{Type: model.ActionBundlePrice, BundleSize: 2, BundlePrice: model.NewMoney(1500, "USD")})},
+			want: []int64{1000},
+		},
+		{
+			name: "highest tier reached",
+			promotions: []model.Promotion{promotion(1, "bulk",
+				model.PromotionAction{Type: model.ActionTiered, Tiers: []model.PromotionTier{{MinQuantity: 3, PercentOff: 5}, {MinQuantity: 5, PercentOff: 10}, {MinQuantity: 10, PercentOff: 20}}})},
+			want: []int64{500},
+		},
+		{
+			name: "conditions not met",
+			promotions: []model.Promotion{
+				promotion(1, "ebooks", model.PromotionAction{Type: model.ActionPercentOff, PercentOff: 50},
+					model.PromotionCondition{Type: model.ConditionFormat, Format: model.FormatEbook}),
+				promotion(2, "big spenders", model.PromotionAction{Type: model.ActionPercentOff, PercentOff: 50},
+					model.PromotionCondition{Type: model.ConditionMinSpend, Amount: model.NewMoney(10000, "USD")}),
+			},
+			want: []int64{},
+		},
+		{
+			name: "stacked on what is left",
+			promotions: []model.Promotion{
+				promotion(1, "sale", model.PromotionAction{Type: model.ActionPercentOff, PercentOff: 10}),
+				promotion(2, "two off", model.PromotionAction{Type: model.ActionAmountOff, AmountOff: model.NewMoney(200, "USD")}),
+			},
+			want: []int64{500, 1000},
+		},
+		{
+			name: "exclusive ends the evaluation",
+			promotions: []model.Promotion{
+				{Model: gorm.Model{ID: 1}, Name: "clearance", Exclusive: true, Action: model.PromotionAction{Type: model.ActionPercentOff, PercentOff: 30}},
+				promotion(2, "sale", model.PromotionAction{Type: model.ActionPercentOff, PercentOff: 10}),
+			},
+			want: []int64{1500},
+		},
+		{
+			name: "capped at the amount",
+			promotions: []model.Promotion{promotion(1, "free",
+				model.PromotionAction{Type: model.ActionAmountOff, AmountOff: model.NewMoney(5000, "USD")})},
+			want: []int64{5000},
+		},
+	}
+
+	for _, c := range cases {
+		lines, err := model.ApplyPromotions(c.promotions, item)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		got := []int64{}
+		for _, line := range lines {
+			got = append(got, line.Amount.Amount)
+		}
+
+		if len(got) != len(c.want) {
+			t.Errorf("%s: expected discounts %v, got %v", c.name, c.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: expected discounts %v, got %v", c.name, c.want, got)
+			}
+		}
+	}
+}
+
+func TestPreviewPurchase(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 5, 1000, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions" WHERE \(\(starts_at IS NULL OR starts_at <= \$1\) AND \(expires_at IS NULL OR expires_at > \$2\)\) (.+) ORDER BY priority DESC, id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "priority", "conditions", "action"}).
+			AddRow(4, "3 for 2 crime", 10, `[{"type":"category","category":"crime"}]`, `{"type":"buy_x_get_y","buy":2,"get":1}`))
+	mock.ExpectQuery(`^SELECT "slug" FROM "categories"`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("fiction").AddRow("crime"))
+	mock.ExpectRollback()
+
+	body := []byte(`{"book_id":1,"quantity":3}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase/preview", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PreviewPurchase(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data PriceBreakdown `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	breakdown := res.Data
+	if breakdown.ListAmount.Amount != 3000 || breakdown.Discount.Amount != 1000 || breakdown.Total.Amount != 2000 {
+		t.Fatalf("unexpected breakdown %+v", breakdown)
+	}
+
+	if len(breakdown.Discounts) != 1 || breakdown.Discounts[0].Name != "3 for 2 crime" || *breakdown.Discounts[0].PromotionID != 4 {
+		t.Fatalf("unexpected discount lines %+v", breakdown.Discounts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreatePromotion_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"no action":           `{"name":"sale"}`,
+		"unknown action":      `{"name":"sale","action":{"type":"half_price"}}`,
+		"buy without get":     `{"name":"sale","action":{"type":"buy_x_get_y","buy":2}}`,
+		"bundle without size": `{"name":"sale","action":{"type":"bundle_price","bundle_price":1500}}`,
+		"unknown condition":   `{"name":"sale","action":{"type":"percent_off","percent_off":10},"conditions":[{"type":"weekday"}]}`,
+		"category without slug": `{"name":"sale","action":{"type":"percent_off","percent_off":10},` +
+			`"conditions":[{"type":"category"}]}`,
+	}
+
+	for name, body := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "/promotions/", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+
+		CreatePromotion(nil, w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+	}
+}
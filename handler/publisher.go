@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+func GetPublishers(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	publishers := []model.Publisher{}
+
+	query := db.Order("name")
+	if name := strings.TrimSpace(r.URL.Query().Get("name")); name != "" {
+		query = query.Where("name ILIKE ?", "%"+escapeLike(name)+"%")
+	}
+
+	if err := query.Find(&publishers).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, publishers, ""}
+	res.Dispatch()
+}
+
+func GetPublisherById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	publisherId, err := publisherIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	publisher := model.Publisher{}
+
+	if err := db.First(&publisher, publisherId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPublisherNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	if notModified(w, r, versionETag(publisher.ID, publisher.Version)) {
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, publisher, ""}
+	res.Dispatch()
+}
+
+func CreatePublisher(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	fields := model.PublisherFields{}
+
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	publisher := model.Publisher{}
+	publisher.SetFields(fields)
+
+	if err := checkPublisherNameAvailable(db, &publisher); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := db.Create(&publisher).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, publisher, ""}
+	res.Dispatch()
+}
+
+// UpdatePublisher applies a JSON merge patch (RFC 7396) to a publisher
+func UpdatePublisher(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	publisherId, err := publisherIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	patch, err := decodeMergePatch(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	dbPublisher := model.Publisher{}
+
+	if err := db.First(&dbPublisher, publisherId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPublisherNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbPublisher.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	savePublisherFields(db, w, r, &dbPublisher, fields)
+}
+
+// ReplacePublisher overwrites every editable field of a publisher
+func ReplacePublisher(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	publisherId, err := publisherIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	fields := model.PublisherFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	dbPublisher := model.Publisher{}
+
+	if err := db.First(&dbPublisher, publisherId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPublisherNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	savePublisherFields(db, w, r, &dbPublisher, fields)
+}
+
+// savePublisherFields writes fields only if the publisher still has the
+// version that was read
+func savePublisherFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbPublisher *model.Publisher, fields model.PublisherFields) {
+	if _, err := checkIfMatch(r, versionETag(dbPublisher.ID, dbPublisher.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	version, name := dbPublisher.Version, dbPublisher.Name
+	dbPublisher.SetFields(fields)
+	dbPublisher.Version++
+
+	if !strings.EqualFold(dbPublisher.Name, name) {
+		if err := checkPublisherNameAvailable(db, dbPublisher); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	result := db.Model(dbPublisher).Where("version = ?", version).Select(model.PublisherFieldColumns).Updates(dbPublisher)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("publisher was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbPublisher.ID, dbPublisher.Version))
+
+	res := SuccessResponse{w, http.StatusOK, dbPublisher, ""}
+	res.Dispatch()
+}
+
+// DeletePublisher refuses to delete a publisher that editions still refer
+// to, they have to be moved to another publisher first
+func DeletePublisher(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	publisherId, err := publisherIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	publisher := model.Publisher{}
+
+	if err := db.First(&publisher, publisherId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrPublisherNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(publisher.ID, publisher.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	count := int64(0)
+	if err := db.Model(&model.Edition{}).Where("publisher_id = ?", publisher.ID).Count(&count).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if count > 0 {
+		res := ErrorResponse{w, r, ErrPublisherInUse.WithDetail("publisher has %d editions", count)}
+		res.Dispatch()
+		return
+	}
+
+	// publishers are removed for good so the name can be used again
+	query := db.Unscoped()
+	if conditional {
+		query = query.Where("version = ?", publisher.Version)
+	}
+
+	result := query.Delete(&publisher)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("publisher was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, publisher, ""}
+	res.Dispatch()
+}
+
+// checkPublisherNameAvailable rejects a name another publisher already has,
+// ignoring case
+func checkPublisherNameAvailable(db *gorm.DB, publisher *model.Publisher) error {
+	count := int64(0)
+	if err := db.Model(&model.Publisher{}).
+		Where("LOWER(name) = LOWER(?) AND id <> ?", publisher.Name, publisher.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrPublisherExists.WithDetail("publisher %s already exists", publisher.Name)
+	}
+	return nil
+}
+
+func publisherIdParam(r *http.Request) (int, error) {
+	publisherIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	publisherId, err := strconv.Atoi(publisherIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid publisher id")
+	}
+
+	return publisherId, nil
+}
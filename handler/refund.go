@@ -0,0 +1,479 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RequestReturn asks for copies of one of the caller's purchases to be
+// taken back. Copies already refunded or in an open return can not be
+// asked for again.
+func RequestReturn(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	purchaseId, err := purchaseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.ReturnPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	userId := r.Context().Value("user_id").(uint)
+
+	request := model.ReturnRequest{
+		PurchaseID: uint(purchaseId),
+		UserID:     userId,
+		Quantity:   payload.Quantity,
+		Reason:     payload.Reason,
+		Status:     model.ReturnRequested,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		purchase := model.Purchase{}
+
+		// other users' purchases are reported missing rather than forbidden
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userId).
+			First(&purchase, purchaseId).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPurchaseNotFound.Wrap(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if purchase.Status != model.PurchasePaid {
+			return ErrRefundNotAllowed.WithDetail("purchase %d is %s", purchase.ID, purchase.Status)
+		}
+
+		returned, _, err := model.PurchaseRefunds(tx, purchase)
+		if err != nil {
+			return err
+		}
+
+		open := int64(0)
+		if err := tx.Model(&model.ReturnRequest{}).
+			Select("COALESCE(SUM(quantity), 0)").
+			Where("purchase_id = ? AND status = ?", purchase.ID, model.ReturnRequested).
+			Scan(&open).Error; err != nil {
+			return err
+		}
+
+		if left := purchase.Quantity - returned - int(open); payload.Quantity > left {
+			return ErrRefundExceeds.WithDetail("only %d of %d copies can still be returned", max(left, 0), purchase.Quantity)
+		}
+
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, request, "return requested"}
+	res.Dispatch()
+}
+
+// GetMyReturns lists the caller's return requests, newest first
+func GetMyReturns(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("user_id").(uint)
+	returns := []model.ReturnRequest{}
+
+	if err := db.Where("user_id = ?", userId).Order("id DESC").Find(&returns).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, returns, ""}
+	res.Dispatch()
+}
+
+// GetReturns lists every return request, oldest first so the queue is
+// worked in order. It can be filtered by status.
+func GetReturns(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Order("id")
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		if status != model.ReturnRequested && status != model.ReturnApproved && status != model.ReturnRejected {
+			res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("status must be requested, approved or rejected")}
+			res.Dispatch()
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	returns := []model.ReturnRequest{}
+	if err := query.Find(&returns).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, returns, ""}
+	res.Dispatch()
+}
+
+// ApproveReturn refunds a requested return and by default restocks its
+// copies.
+func ApproveReturn(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	resolveReturn(db, w, r, true)
+}
+
+// RejectReturn closes a requested return without refunding it
+func RejectReturn(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	resolveReturn(db, w, r, false)
+}
+
+func resolveReturn(db *gorm.DB, w http.ResponseWriter, r *http.Request, approve bool) {
+	returnId, err := returnIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// the body is optional, approving without one refunds and restocks
+	payload := model.ResolveReturnPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	adminId := r.Context().Value("user_id").(uint)
+	request := model.ReturnRequest{}
+	var refund *model.Refund
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, returnId).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReturnNotFound.Wrap(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if request.Status != model.ReturnRequested {
+			return ErrReturnResolved.WithDetail("return %d was %s", request.ID, request.Status)
+		}
+
+		now := time.Now()
+		request.Status = model.ReturnRejected
+		request.Note = payload.Note
+		request.ResolvedBy = &adminId
+		request.ResolvedAt = &now
+
+		if approve {
+			purchase, err := lockPurchase(tx, request.PurchaseID)
+			if err != nil {
+				return err
+			}
+
+			refund = &model.Refund{
+				ReturnID:  &request.ID,
+				Quantity:  request.Quantity,
+				Amount:    payload.Amount,
+				Reason:    request.Reason,
+				CreatedBy: adminId,
+			}
+
+			if err := refundPurchase(tx, purchase, refund, payload.Restock == nil || *payload.Restock); err != nil {
+				return err
+			}
+
+			request.Status = model.ReturnApproved
+			request.RefundID = &refund.ID
+		}
+
+		return tx.Model(&request).Select("status", "note", "resolved_by", "resolved_at", "refund_id").Updates(&request).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if refund != nil {
+		if err := payRefund(r.Context(), db, refund); err != nil {
+			res := ErrorResponse{w, r, err}
+			res.Dispatch()
+			return
+		}
+	}
+
+	res := SuccessResponse{w, http.StatusOK, request, "return " + request.Status}
+	res.Dispatch()
+}
+
+// RefundPurchase refunds a purchase without a return request, in full or
+// in part.
+func RefundPurchase(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	purchaseId, err := purchaseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.RefundPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if payload.Quantity == 0 && payload.Amount.IsZero() {
+		res := ErrorResponse{w, r, ErrValidationFailed.WithDetail("amount or quantity is required")}
+		res.Dispatch()
+		return
+	}
+
+	refund := model.Refund{
+		Quantity:  payload.Quantity,
+		Amount:    payload.Amount,
+		Reason:    payload.Reason,
+		CreatedBy: r.Context().Value("user_id").(uint),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		purchase, err := lockPurchase(tx, uint(purchaseId))
+		if err != nil {
+			return err
+		}
+
+		return refundPurchase(tx, purchase, &refund, payload.Restock == nil || *payload.Restock)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := payRefund(r.Context(), db, &refund); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, refund, "purchase refunded"}
+	res.Dispatch()
+}
+
+// GetPurchaseRefunds lists the refunds of a purchase
+func GetPurchaseRefunds(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	purchaseId, err := purchaseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	refunds := []model.Refund{}
+	if err := db.Where("purchase_id = ?", purchaseId).Order("id").Find(&refunds).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, refunds, ""}
+	res.Dispatch()
+}
+
+func lockPurchase(tx *gorm.DB, id uint) (*model.Purchase, error) {
+	purchase := model.Purchase{}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&purchase, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPurchaseNotFound.Wrap(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// refundPurchase records a refund of a purchase locked by the caller. A
+// refund without an amount gets the share of the purchase amount of its
+// copies, the last copies take whatever is left so rounding never strands a
+// cent. Refunds of payments made through the payment provider are recorded
+// pending, payRefund pays them back once they are committed.
+func refundPurchase(tx *gorm.DB, purchase *model.Purchase, refund *model.Refund, restock bool) error {
+	if purchase.Status != model.PurchasePaid {
+		return ErrRefundNotAllowed.WithDetail("purchase %d is %s", purchase.ID, purchase.Status)
+	}
+
+	returned, refunded, err := model.PurchaseRefunds(tx, *purchase)
+	if err != nil {
+		return err
+	}
+
+	if left := purchase.Quantity - returned; refund.Quantity > left {
+		return ErrRefundExceeds.WithDetail("only %d of %d copies are left to refund", left, purchase.Quantity)
+	}
+
+	remaining, err := purchase.Amount.Sub(refunded)
+	if err != nil {
+		return err
+	}
+
+	if refund.Amount.IsZero() {
+		if refund.Quantity == purchase.Quantity-returned {
+			refund.Amount = remaining
+		} else if refund.Amount, err = purchase.Amount.Scale(int64(refund.Quantity), int64(purchase.Quantity)); err != nil {
+			return err
+		}
+	}
+
+	cmp, err := refund.Amount.Cmp(remaining)
+	if errors.Is(err, model.ErrCurrencyMismatch) {
+		return ErrCurrencyMismatch.WithDetail("purchase %d was paid in %s", purchase.ID, purchase.Amount.Currency).Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cmp > 0 {
+		return ErrRefundExceeds.WithDetail("only %s of %s is left to refund", remaining, purchase.Amount)
+	}
+
+	refund.PurchaseID = purchase.ID
+	refund.Restocked = restock && refund.Quantity > 0
+	refund.Status = model.RefundSucceeded
+	if paymentProvider != nil && purchase.PaymentID != "" && !refund.Amount.IsZero() {
+		refund.PaymentID = purchase.PaymentID
+		refund.Status = model.RefundPending
+	}
+
+	if err := tx.Create(refund).Error; err != nil {
+		return err
+	}
+
+	if refund.Restocked {
+		if err := restockCopies(tx, model.StockMovement{
+			BookID:    purchase.BookID,
+			EditionID: purchase.EditionID,
+			Kind:      model.StockReturn,
+			Quantity:  refund.Quantity,
+			ActorID:   &refund.CreatedBy,
+			Reason:    refund.Reason,
+			Reference: model.StockRef("refunds", refund.ID),
+		}, model.StockRef("purchases", purchase.ID)); err != nil {
+			return err
+		}
+	}
+
+	// nothing left to pay back
+	if cmp == 0 {
+		purchase.Status = model.PurchaseRefunded
+		if err := tx.Model(purchase).Update("status", purchase.Status).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// payRefund pays a committed, pending refund back through the payment
+// provider. It runs outside the transaction that recorded the refund, a
+// slow provider must not hold row locks, and the refund id is the reference
+// so paying it again never refunds twice. A refund the provider does not
+// accept is failed: its copies stay taken back, its amount can be refunded
+// again.
+func payRefund(ctx context.Context, db *gorm.DB, refund *model.Refund) error {
+	if refund.Status != model.RefundPending {
+		return nil
+	}
+
+	reference := strconv.FormatUint(uint64(refund.ID), 10)
+	_, err := paymentProvider.Refund(ctx, refund.PaymentID, refund.Amount.Amount, reference)
+	if err == nil {
+		refund.Status = model.RefundSucceeded
+		return db.Model(refund).Update("status", refund.Status).Error
+	}
+
+	refund.Status = model.RefundFailed
+	failErr := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(refund).Update("status", refund.Status).Error; err != nil {
+			return err
+		}
+
+		// the purchase is not paid back in full after all
+		return tx.Model(&model.Purchase{}).Where("id = ? AND status = ?", refund.PurchaseID, model.PurchaseRefunded).
+			Update("status", model.PurchasePaid).Error
+	})
+	if failErr != nil {
+		return failErr
+	}
+
+	return ErrPaymentFailed.WithDetail("the refund was not accepted: %s", err).Wrap(err)
+}
+
+func purchaseIdParam(r *http.Request) (int, error) {
+	purchaseIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	purchaseId, err := strconv.Atoi(purchaseIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid purchase id")
+	}
+
+	return purchaseId, nil
+}
+
+func returnIdParam(r *http.Request) (int, error) {
+	returnIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	returnId, err := strconv.Atoi(returnIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid return id")
+	}
+
+	return returnId, nil
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/payment"
+	"github.com/peekeah/book-store/utils"
+)
+
+var purchaseColumns = []string{"id", "user_id", "book_id", "edition_id", "quantity", "amount_minor", "amount_currency", "status", "payment_id"}
+
+func refundRequest(method string, id string, userId uint, payload any) *http.Request {
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(method, "/", bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	return req.WithContext(context.WithValue(req.Context(), "user_id", userId))
+}
+
+func TestRequestReturn(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	// three copies bought, one refunded and one in an open return
+	expectPurchase := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`^SELECT (.+) FROM "purchases" WHERE user_id = \$1 (.+) FOR UPDATE`).
+			WithArgs(1, 7, 1).
+			WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(7, 1, 1, nil, 3, 3000, "USD", model.PurchasePaid, ""))
+		mock.ExpectQuery(`^SELECT COALESCE\(SUM\(quantity\), 0\) AS quantity(.+) FROM "refunds"`).
+			WillReturnRows(sqlmock.NewRows([]string{"quantity", "amount"}).AddRow(1, 1000))
+		mock.ExpectQuery(`^SELECT COALESCE\(SUM\(quantity\), 0\) FROM "return_requests"`).
+			WithArgs(7, model.ReturnRequested).
+			WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(1))
+	}
+
+	expectPurchase()
+	mock.ExpectQuery(`^INSERT INTO "return_requests"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 7, 1, 1, "damaged", model.ReturnRequested, "", nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	RequestReturn(db, w, refundRequest(http.MethodPost, "7", 1, map[string]any{"quantity": 1, "reason": "damaged"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the last copy is already asked for
+	expectPurchase()
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	RequestReturn(db, w, refundRequest(http.MethodPost, "7", 1, map[string]any{"quantity": 2, "reason": "damaged"}))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Code != CodeRefundExceeds {
+		t.Fatalf("expected code %s, got %s", CodeRefundExceeds, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestApproveReturn(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	provider := payment.NewFakeProvider("whsec")
+	SetPaymentProvider(provider)
+	defer SetPaymentProvider(nil)
+
+	ctx := context.Background()
+	paid, _ := provider.Authorize(ctx, payment.Charge{Amount: 2000, Currency: "USD", Source: "tok_visa", Reference: "7"})
+	provider.Capture(ctx, paid.ID)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "return_requests" (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_id", "user_id", "quantity", "reason", "status"}).
+			AddRow(4, 7, 1, 2, "damaged", model.ReturnRequested))
+	mock.ExpectQuery(`^SELECT (.+) FROM "purchases" (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(7, 1, 1, nil, 2, 2000, "USD", model.PurchasePaid, paid.ID))
+	mock.ExpectQuery(`^SELECT (.+) FROM "refunds"`).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity", "amount"}).AddRow(0, 0))
+	mock.ExpectQuery(`^INSERT INTO "refunds"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 7, 4, 2, 2000, "USD", true, "damaged", 9, paid.ID, model.RefundPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(2, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseReturn(mock)
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, nil, model.StockReturn, 2, 9, "damaged", "refunds:11").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^UPDATE "purchases" SET "status"=\$1`).
+		WithArgs(model.PurchaseRefunded, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "return_requests" SET "updated_at"=\$1,"status"=\$2,"note"=\$3,"resolved_by"=\$4,"resolved_at"=\$5,"refund_id"=\$6`).
+		WithArgs(sqlmock.AnyArg(), model.ReturnApproved, "", 9, sqlmock.AnyArg(), 11, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// the payment is refunded once the refund is committed
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "refunds" SET "status"=\$1`).
+		WithArgs(model.RefundSucceeded, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// approving without a body refunds everything and restocks
+	req := refundRequest(http.MethodPost, "4", 9, nil)
+	req.Body = http.NoBody
+	w := httptest.NewRecorder()
+	ApproveReturn(db, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the payment is refunded in full, nothing is left
+	if _, err := provider.Refund(ctx, paid.ID, 0, "check"); !errors.Is(err, payment.ErrInvalidState) {
+		t.Fatalf("expected the payment to be refunded, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRefundPurchase(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	expectPurchase := func(refunded int64) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`^SELECT (.+) FROM "purchases" (.+) FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(7, 1, 1, nil, 3, 3000, "USD", model.PurchasePaid, ""))
+		mock.ExpectQuery(`^SELECT (.+) FROM "refunds"`).
+			WillReturnRows(sqlmock.NewRows([]string{"quantity", "amount"}).AddRow(0, refunded))
+	}
+
+	// one of three copies gets a third of the amount, kept out of stock
+	expectPurchase(0)
+	mock.ExpectQuery(`^INSERT INTO "refunds"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 7, nil, 1, 1000, "USD", false, "", 9, "", model.RefundSucceeded).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	RefundPurchase(db, w, refundRequest(http.MethodPost, "7", 9, map[string]any{"quantity": 1, "restock": false}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// more than is left of the purchase
+	expectPurchase(2500)
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	RefundPurchase(db, w, refundRequest(http.MethodPost, "7", 9, map[string]any{"amount": 1000}))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// an amount or quantity is required
+	w = httptest.NewRecorder()
+	RefundPurchase(db, w, refundRequest(http.MethodPost, "7", 9, map[string]any{"reason": "goodwill"}))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRefundPurchase_PaymentFailed(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	SetPaymentProvider(payment.NewFakeProvider("whsec"))
+	defer SetPaymentProvider(nil)
+
+	// the provider does not know the payment
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "purchases" (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(7, 1, 1, nil, 1, 1000, "USD", model.PurchasePaid, "pay_gone"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "refunds"`).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity", "amount"}).AddRow(0, 0))
+	mock.ExpectQuery(`^INSERT INTO "refunds"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 7, nil, 1, 1000, "USD", false, "", 9, "pay_gone", model.RefundPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec(`^UPDATE "purchases" SET "status"=\$1`).
+		WithArgs(model.PurchaseRefunded, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// the refund fails and the purchase is paid again
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "refunds" SET "status"=\$1`).
+		WithArgs(model.RefundFailed, sqlmock.AnyArg(), 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "purchases" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND status = \$4\)`).
+		WithArgs(model.PurchasePaid, sqlmock.AnyArg(), 7, model.PurchaseRefunded).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	RefundPurchase(db, w, refundRequest(http.MethodPost, "7", 9, map[string]any{"quantity": 1, "restock": false}))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
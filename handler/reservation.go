@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reservationTTL is how long a reservation holds its copies
+var reservationTTL = 15 * time.Minute
+
+func SetReservationTTL(ttl time.Duration) {
+	reservationTTL = ttl
+}
+
+// CreateReservation holds copies of a book for the caller while they check
+// out. The copies are taken out of stock until the reservation is bought,
+// released or expires.
+func CreateReservation(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.ReservationPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	reservation := model.Reservation{
+		UserID:   r.Context().Value("user_id").(uint),
+		Quantity: payload.Quantity,
+		Status:   model.ReservationActive,
+	}
+
+	book := model.Book{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var edition *model.Edition
+		var err error
+
+		book, edition, err = purchaseItem(tx, &model.PurchasePayload{
+			BookId:    payload.BookId,
+			EditionId: payload.EditionId,
+			Quantity:  payload.Quantity,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := holdCopies(tx, &book, edition, payload.Quantity); err != nil {
+			return err
+		}
+
+		reservation.BookID = book.ID
+		if edition != nil {
+			reservation.EditionID = &edition.ID
+		}
+		reservation.ExpiresAt = time.Now().Add(reservationTTL)
+
+		if err := tx.Create(&reservation).Error; err != nil {
+			return err
+		}
+
+		return allocateStock(tx, model.StockMovement{
+			BookID:    reservation.BookID,
+			EditionID: reservation.EditionID,
+			Kind:      model.StockReservation,
+			Quantity:  -reservation.Quantity,
+			ActorID:   &reservation.UserID,
+			Reason:    "reserved",
+			Reference: model.StockRef("reservations", reservation.ID),
+		})
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if copies := book.AvailableCopies - reservation.Quantity; lowStock(&book, book.AvailableCopies, copies) {
+		alertLowStock(book, copies)
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, reservation, "copies reserved"}
+	res.Dispatch()
+}
+
+// GetMyReservations lists the caller's reservations, newest first
+func GetMyReservations(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("user_id").(uint)
+	reservations := []model.Reservation{}
+
+	if err := db.Where("user_id = ?", userId).Order("id DESC").Find(&reservations).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, reservations, ""}
+	res.Dispatch()
+}
+
+// ReleaseReservation gives up one of the caller's active reservations and
+// puts its copies back in stock
+func ReleaseReservation(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	reservationIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("id is required")}
+		res.Dispatch()
+		return
+	}
+
+	reservationId, err := strconv.Atoi(reservationIdStr)
+	if err != nil {
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid reservation id")}
+		res.Dispatch()
+		return
+	}
+
+	userId := r.Context().Value("user_id").(uint)
+	reservation := model.Reservation{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userId).
+			First(&reservation, reservationId).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReservationNotFound.Wrap(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if reservation.Status != model.ReservationActive {
+			return ErrReservationExpired.WithDetail("reservation %d is %s", reservation.ID, reservation.Status)
+		}
+
+		return releaseReservation(tx, &reservation, model.ReservationReleased, &userId)
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, reservation, "reservation released"}
+	res.Dispatch()
+}
+
+// ExpireReservations releases the active reservations past their expiry.
+// Reservations locked by a purchase converting them are skipped, the next
+// run picks them up if the purchase fails.
+func ExpireReservations(db *gorm.DB) (int64, error) {
+	expired := int64(0)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		reservations := []model.Reservation{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND expires_at <= ?", model.ReservationActive, time.Now()).
+			Order("id").
+			Find(&reservations).Error; err != nil {
+			return err
+		}
+
+		for i := range reservations {
+			if err := releaseReservation(tx, &reservations[i], model.ReservationExpired, nil); err != nil {
+				return err
+			}
+		}
+
+		expired = int64(len(reservations))
+		return nil
+	})
+
+	return expired, err
+}
+
+// holdCopies takes copies out of stock, failing when fewer are available.
+// The stock condition is part of the update so concurrent holds can not
+// oversell.
+func holdCopies(tx *gorm.DB, book *model.Book, edition *model.Edition, quantity int) error {
+	if edition != nil {
+		result := tx.Model(edition).Where("available_copies >= ?", quantity).Updates(map[string]any{
+			"available_copies": gorm.Expr("available_copies - ?", quantity),
+			"version":          gorm.Expr("version + 1"),
+		})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock.WithDetail("only %d stock available, can not reserve %d quantities", edition.AvailableCopies, quantity)
+		}
+
+		return model.SyncBookFromEditions(tx, book.ID)
+	}
+
+	result := tx.Model(book).Where("available_copies >= ?", quantity).Updates(map[string]any{
+		"available_copies": gorm.Expr("available_copies - ?", quantity),
+		"version":          gorm.Expr("version + 1"),
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInsufficientStock.WithDetail("only %d stock available, can not reserve %d quantities", book.AvailableCopies, quantity)
+	}
+
+	return nil
+}
+
+// releaseReservation puts the copies of an active reservation back in
+// stock and closes it with status. The actor is nil when the reservation
+// expired.
+func releaseReservation(tx *gorm.DB, reservation *model.Reservation, status string, actor *uint) error {
+	reference := model.StockRef("reservations", reservation.ID)
+
+	if err := restockCopies(tx, model.StockMovement{
+		BookID:    reservation.BookID,
+		EditionID: reservation.EditionID,
+		Kind:      model.StockReservation,
+		Quantity:  reservation.Quantity,
+		ActorID:   actor,
+		Reason:    status,
+		Reference: reference,
+	}, reference); err != nil {
+		return err
+	}
+
+	reservation.Status = status
+	return tx.Model(reservation).Update("status", status).Error
+}
+
+// purchaseReservation locks the reservation a purchase is made from, nil
+// when the purchase is not made from one. The reservation decides the book
+// and edition of the purchase.
+func purchaseReservation(tx *gorm.DB, userId uint, payload *model.PurchasePayload) (*model.Reservation, error) {
+	if payload.ReservationId == 0 {
+		return nil, nil
+	}
+
+	reservation := model.Reservation{}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userId).
+		First(&reservation, payload.ReservationId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReservationNotFound.Wrap(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reservation.Status != model.ReservationActive {
+		return nil, ErrReservationExpired.WithDetail("reservation %d is %s", reservation.ID, reservation.Status)
+	}
+
+	if !reservation.ExpiresAt.After(time.Now()) {
+		return nil, ErrReservationExpired.WithDetail("reservation %d expired at %s", reservation.ID, reservation.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if payload.BookId != 0 && uint(payload.BookId) != reservation.BookID {
+		return nil, ErrReservationNotFound.WithDetail("reservation %d is not for book %d", reservation.ID, payload.BookId)
+	}
+
+	if payload.EditionId != 0 && (reservation.EditionID == nil || uint(payload.EditionId) != *reservation.EditionID) {
+		return nil, ErrReservationNotFound.WithDetail("reservation %d is not for edition %d", reservation.ID, payload.EditionId)
+	}
+
+	if payload.Quantity > reservation.Quantity {
+		return nil, ErrInsufficientStock.WithDetail("reservation %d holds %d copies, can not purchase %d quantities", reservation.ID, reservation.Quantity, payload.Quantity)
+	}
+
+	payload.BookId = int(reservation.BookID)
+	payload.EditionId = 0
+	if reservation.EditionID != nil {
+		payload.EditionId = int(*reservation.EditionID)
+	}
+
+	return &reservation, nil
+}
+
+// convertReservation marks a reservation bought by purchase, the copies it
+// held beyond the purchase go back in stock. The ledger shows the hold
+// released and the copies sold.
+func convertReservation(tx *gorm.DB, reservation *model.Reservation, purchase *model.Purchase) error {
+	if left := reservation.Quantity - purchase.Quantity; left > 0 {
+		if err := adjustStock(tx, reservation.BookID, reservation.EditionID, left); err != nil {
+			return err
+		}
+	}
+
+	reference := model.StockRef("reservations", reservation.ID)
+
+	if err := returnStock(tx, model.StockMovement{
+		BookID:    reservation.BookID,
+		EditionID: reservation.EditionID,
+		Kind:      model.StockReservation,
+		Quantity:  reservation.Quantity,
+		ActorID:   &purchase.UserID,
+		Reason:    model.ReservationConverted,
+		Reference: reference,
+	}, reference); err != nil {
+		return err
+	}
+
+	if err := recordSale(tx, purchase); err != nil {
+		return err
+	}
+
+	reservation.Status = model.ReservationConverted
+	reservation.PurchaseID = &purchase.ID
+
+	return tx.Model(reservation).Updates(map[string]any{
+		"status":      reservation.Status,
+		"purchase_id": purchase.ID,
+	}).Error
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+var reservationColumns = []string{"id", "user_id", "book_id", "edition_id", "quantity", "status", "expires_at"}
+
+func TestCreateReservation(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	reserve := func(quantity int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"book_id": 1, "quantity": quantity})
+		req, _ := http.NewRequest(http.MethodPost, "/reservations/", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+		w := httptest.NewRecorder()
+		CreateReservation(db, w, req)
+		return w
+	}
+
+	expectBook := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies"}).AddRow(1, "Book1", 3))
+		mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	}
+
+	expectBook()
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies - \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE available_copies >= \$3`).
+		WithArgs(2, sqlmock.AnyArg(), 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "reservations"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 1, 1, nil, 2, model.ReservationActive, sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	expectNoWarehouses(mock)
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, nil, model.StockReservation, -2, 1, "reserved", "reservations:5").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	if w := reserve(2); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// another customer took the copies in the meantime
+	expectBook()
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies - \$1,"version"=version \+ 1,"updated_at"=\$2`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	w := reserve(3)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Code != CodeInsufficientStock {
+		t.Fatalf("expected code %s, got %s", CodeInsufficientStock, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_Reservation(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	// three copies reserved, two bought, the stock is not touched but for
+	// the copy left over
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "reservations" WHERE user_id = \$1 (.+) FOR UPDATE`).
+		WithArgs(1, 5, 1).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(5, 1, 1, nil, 3, model.ReservationActive, time.Now().Add(time.Minute)))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 0, 1000, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, nil, 2, 2000, "USD", nil, 0, "USD", 0, "USD", "", false, "paid", "").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(7))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(1, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseReturn(mock)
+	expectStockMovement(mock, model.StockReservation, 3)
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectExec(`^UPDATE "reservations" SET "purchase_id"=\$1,"status"=\$2`).
+		WithArgs(7, model.ReservationConverted, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(map[string]any{"reservation_id": 5, "quantity": 2})
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPurchaseBook_ReservationExpired(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "reservations"`).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(5, 1, 1, nil, 3, model.ReservationActive, time.Now().Add(-time.Minute)))
+	mock.ExpectRollback()
+
+	body, _ := json.Marshal(map[string]any{"reservation_id": 5, "quantity": 2})
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestExpireReservations(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "reservations" WHERE \(status = \$1 AND expires_at <= \$2\) (.+) ORDER BY id FOR UPDATE SKIP LOCKED`).
+		WithArgs(model.ReservationActive, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(reservationColumns).
+			AddRow(5, 1, 1, nil, 3, model.ReservationActive, time.Now().Add(-time.Minute)))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(3, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouseReturn(mock)
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, nil, model.StockReservation, 3, nil, model.ReservationExpired, "reservations:5").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^UPDATE "reservations" SET "status"=\$1`).
+		WithArgs(model.ReservationExpired, sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	expired, err := ExpireReservations(db)
+	if err != nil || expired != 1 {
+		t.Fatalf("expected one expired reservation, got %d: %v", expired, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
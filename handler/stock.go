@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+)
+
+// StockHistory is the stock ledger of a book and its editions
+type StockHistory struct {
+	BookID          uint                  `json:"book_id"`
+	AvailableCopies int                   `json:"available_copies"`
+	Movements       []model.StockMovement `json:"movements"`
+}
+
+// GetStockHistory lists the stock movements of a book and its editions,
+// oldest first, each with the stock it left behind
+func GetStockHistory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	bookId, err := bookIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	book := model.Book{}
+
+	if err := db.First(&book, bookId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrBookNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	movements := []model.StockMovement{}
+	if err := db.Where("book_id = ?", book.ID).Order("id").Find(&movements).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// editions keep their own stock, the book's own only counts while it
+	// has none
+	balances := map[uint]int{}
+	for i, movement := range movements {
+		edition := uint(0)
+		if movement.EditionID != nil {
+			edition = *movement.EditionID
+		}
+
+		balances[edition] += movement.Quantity
+		movements[i].Balance = balances[edition]
+	}
+
+	history := StockHistory{
+		BookID:          book.ID,
+		AvailableCopies: book.AvailableCopies,
+		Movements:       movements,
+	}
+
+	res := SuccessResponse{w, http.StatusOK, history, ""}
+	res.Dispatch()
+}
+
+// ReconcileStock records an adjustment for every edition, and every book
+// without editions, whose stock differs from its ledger. The first run
+// records the opening stock of everything sold before the ledger existed.
+func ReconcileStock(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	movements := []model.StockMovement{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		drift, err := model.FindStockDrift(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range drift {
+			movement := model.StockMovement{
+				BookID:    item.BookID,
+				EditionID: item.EditionID,
+				Kind:      model.StockAdjustment,
+				Quantity:  item.Stock - item.Ledger,
+				ActorID:   stockActor(r.Context()),
+				Reason:    "reconciliation",
+			}
+
+			if err := recordStock(tx, &movement); err != nil {
+				return err
+			}
+
+			movements = append(movements, movement)
+		}
+
+		return nil
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, movements, fmt.Sprintf("%d adjustments recorded", len(movements))}
+	res.Dispatch()
+}
+
+// recordStock appends a movement to the stock ledger, movements that do
+// not change the stock are left out
+func recordStock(tx *gorm.DB, movement *model.StockMovement) error {
+	if movement.Quantity == 0 {
+		return nil
+	}
+	return tx.Create(movement).Error
+}
+
+// recordSale records the copies sold by a purchase, taking them out of
+// the warehouses they are shipped from
+func recordSale(tx *gorm.DB, purchase *model.Purchase) error {
+	return allocateStock(tx, model.StockMovement{
+		BookID:    purchase.BookID,
+		EditionID: purchase.EditionID,
+		Kind:      model.StockSale,
+		Quantity:  -purchase.Quantity,
+		ActorID:   &purchase.UserID,
+		Reference: model.StockRef("purchases", purchase.ID),
+	})
+}
+
+// restockCopies puts the copies of a movement back in stock, on its
+// edition or on the book when it has none, and in the warehouses the
+// movements of from took them out of, and records it
+func restockCopies(tx *gorm.DB, movement model.StockMovement, from string) error {
+	if err := adjustStock(tx, movement.BookID, movement.EditionID, movement.Quantity); err != nil {
+		return err
+	}
+	return returnStock(tx, movement, from)
+}
+
+// adjustStock changes the stock of an edition, or of a book without
+// editions, by quantity without recording it
+func adjustStock(tx *gorm.DB, bookId uint, editionId *uint, quantity int) error {
+	if editionId == nil {
+		return tx.Model(&model.Book{}).Where("id = ?", bookId).Updates(map[string]any{
+			"available_copies": gorm.Expr("available_copies + ?", quantity),
+			"version":          gorm.Expr("version + 1"),
+		}).Error
+	}
+
+	err := tx.Model(&model.Edition{}).Where("id = ?", *editionId).Updates(map[string]any{
+		"available_copies": gorm.Expr("available_copies + ?", quantity),
+		"version":          gorm.Expr("version + 1"),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return model.SyncBookFromEditions(tx, bookId)
+}
+
+// stockActor is the user a request acts for
+func stockActor(ctx context.Context) *uint {
+	if userId, ok := ctx.Value("user_id").(uint); ok {
+		return &userId
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+// expectStockMovement expects a movement of kind appended to the stock
+// ledger
+func expectStockMovement(mock sqlmock.Sqlmock, kind string, quantity int) {
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), kind, quantity, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+var stockColumns = []string{"id", "book_id", "edition_id", "kind", "quantity", "actor_id", "reason", "reference"}
+
+func TestGetStockHistory(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies"}).AddRow(1, "Book1", 4))
+	mock.ExpectQuery(`^SELECT (.+) FROM "stock_movements" WHERE book_id = \$1 ORDER BY id`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(stockColumns).
+			AddRow(1, 1, nil, model.StockAdjustment, 5, 1, "book created", "books:1").
+			AddRow(2, 1, 2, model.StockAdjustment, 3, 1, "edition created", "editions:2").
+			AddRow(3, 1, nil, model.StockSale, -2, 3, "", "purchases:7").
+			AddRow(4, 1, 2, model.StockSale, -1, 3, "", "purchases:8"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/books/1/stock-history", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	GetStockHistory(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data StockHistory `json:"data"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	balances := []int{}
+	for _, movement := range res.Data.Movements {
+		balances = append(balances, movement.Balance)
+	}
+
+	// the book and its edition keep separate balances
+	if fmt.Sprint(balances) != "[5 3 3 2]" {
+		t.Fatalf("unexpected balances %v", balances)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReconcileStock(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM editions e LEFT JOIN stock_movements`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "edition_id", "stock", "ledger"}).
+			AddRow(1, 2, 10, 0).
+			AddRow(3, nil, 4, 6))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, 2, nil, model.StockAdjustment, 10, 1, "reconciliation", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 3, nil, nil, model.StockAdjustment, -2, 1, "reconciliation", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/stock/reconcile", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	ReconcileStock(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetStocktakes lists the stocktakes, newest first, optionally only those
+// with a status
+func GetStocktakes(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Order("id DESC")
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		if status != model.StocktakeOpen && status != model.StocktakeApplied && status != model.StocktakeCancelled {
+			res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("status must be open, applied or cancelled")}
+			res.Dispatch()
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	stocktakes := []model.Stocktake{}
+	if err := query.Find(&stocktakes).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, stocktakes, ""}
+	res.Dispatch()
+}
+
+func GetStocktakeById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	stocktake, err := findStocktake(db, r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, stocktake, ""}
+	res.Dispatch()
+}
+
+// CreateStocktake starts counting the editions of a set of books, and the
+// books without editions. Books kept per warehouse are counted one
+// warehouse at a time.
+func CreateStocktake(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.StocktakePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	stocktake := model.Stocktake{
+		Status:    model.StocktakeOpen,
+		Note:      payload.Note,
+		CreatedBy: r.Context().Value("user_id").(uint),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if payload.WarehouseId != 0 {
+			warehouse := model.Warehouse{}
+			if err := tx.First(&warehouse, payload.WarehouseId).Error; err != nil {
+				return ErrWarehouseNotFound.Wrap(err)
+			}
+			stocktake.WarehouseID = &warehouse.ID
+		}
+
+		books := []model.Book{}
+		if err := tx.Where("id IN ?", payload.BookIds).Order("id").Find(&books).Error; err != nil {
+			return err
+		}
+
+		found := map[int]bool{}
+		for _, book := range books {
+			found[int(book.ID)] = true
+		}
+		for _, bookId := range payload.BookIds {
+			if !found[bookId] {
+				return ErrBookNotFound.WithDetail("book %d does not exist", bookId)
+			}
+		}
+
+		bookIds := make([]uint, len(books))
+		for i, book := range books {
+			bookIds[i] = book.ID
+		}
+
+		if stocktake.WarehouseID == nil {
+			kept := []uint{}
+			if err := tx.Model(&model.StockLevel{}).Where("book_id IN ?", bookIds).Distinct().Pluck("book_id", &kept).Error; err != nil {
+				return err
+			}
+
+			if len(kept) > 0 {
+				return ErrWarehouseStock.WithDetail("copies of book %d are kept per warehouse, count them in a warehouse", kept[0])
+			}
+		}
+
+		editions := []model.Edition{}
+		if err := tx.Where("book_id IN ?", bookIds).Order("book_id, id").Find(&editions).Error; err != nil {
+			return err
+		}
+
+		editioned := map[uint]bool{}
+		for _, edition := range editions {
+			editioned[edition.BookID] = true
+		}
+
+		for _, book := range books {
+			if !editioned[book.ID] {
+				stocktake.Lines = append(stocktake.Lines, model.StocktakeLine{BookID: book.ID})
+				continue
+			}
+			for _, edition := range editions {
+				if edition.BookID == book.ID {
+					stocktake.Lines = append(stocktake.Lines, model.StocktakeLine{BookID: book.ID, EditionID: &edition.ID})
+				}
+			}
+		}
+
+		return tx.Create(&stocktake).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, stocktake, "stocktake started"}
+	res.Dispatch()
+}
+
+// SubmitStocktakeCounts records the counted copies of lines of an open
+// stocktake, in bulk, along with the stock they had, in the warehouse of
+// the stocktake when it has one
+func SubmitStocktakeCounts(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	stocktakeId, err := stocktakeIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.StocktakeCountsPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	counter := r.Context().Value("user_id").(uint)
+	stocktake := model.Stocktake{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockStocktake(tx, &stocktake, stocktakeId); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i, count := range payload.Counts {
+			line := stocktakeLine(stocktake.Lines, count)
+			if line == nil {
+				return ErrValidationFailed.WithDetail("counts[%d]: book %d, edition %d is not part of stocktake %d", i, count.BookId, count.EditionId, stocktake.ID)
+			}
+
+			expected, err := expectedCopies(tx, &stocktake, line)
+			if err != nil {
+				return err
+			}
+
+			line.Counted = count.Counted
+			line.Expected = &expected
+			line.CountedBy = &counter
+			line.CountedAt = &now
+
+			if err := tx.Model(line).Select("counted", "expected", "counted_by", "counted_at").Updates(line).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, stocktake, "counts recorded"}
+	res.Dispatch()
+}
+
+// GetStocktakeVariances lists the counted lines of a stocktake that did not
+// match the stock they had when they were counted
+func GetStocktakeVariances(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	stocktake, err := findStocktake(db, r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	variances := []model.StocktakeLine{}
+
+	for _, line := range stocktake.Lines {
+		if line.Counted == nil || line.Expected == nil {
+			continue
+		}
+
+		variance := *line.Counted - *line.Expected
+		if variance == 0 {
+			continue
+		}
+
+		line.Variance = &variance
+		variances = append(variances, line)
+	}
+
+	res := SuccessResponse{w, http.StatusOK, variances, ""}
+	res.Dispatch()
+}
+
+// ApplyStocktake adjusts the stock of every counted line of a stocktake by
+// its variance in one transaction, recording the adjustments with a reason
+// code. Lines never counted are left alone.
+func ApplyStocktake(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	stocktakeId, err := stocktakeIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.ApplyStocktakePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	adminId := r.Context().Value("user_id").(uint)
+	stocktake := model.Stocktake{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockStocktake(tx, &stocktake, stocktakeId); err != nil {
+			return err
+		}
+
+		counted := 0
+		for i := range stocktake.Lines {
+			line := &stocktake.Lines[i]
+			if line.Counted == nil {
+				continue
+			}
+			counted++
+
+			if err := applyCount(tx, &stocktake, line, model.StockMovement{
+				Kind:      model.StockAdjustment,
+				ActorID:   &adminId,
+				Reason:    payload.Reason,
+				Reference: model.StockRef("stocktakes", stocktake.ID),
+			}); err != nil {
+				return err
+			}
+
+			if line.Adjustment != 0 {
+				if err := tx.Model(line).Update("adjustment", line.Adjustment).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if counted == 0 {
+			return ErrValidationFailed.WithDetail("no line of stocktake %d was counted", stocktake.ID)
+		}
+
+		now := time.Now()
+		stocktake.Status = model.StocktakeApplied
+		stocktake.Reason = payload.Reason
+		stocktake.AppliedBy = &adminId
+		stocktake.AppliedAt = &now
+
+		return tx.Model(&stocktake).Select("status", "reason", "applied_by", "applied_at").Updates(&stocktake).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, stocktake, "stocktake applied"}
+	res.Dispatch()
+}
+
+// CancelStocktake drops an open stocktake without changing the stock
+func CancelStocktake(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	stocktakeId, err := stocktakeIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	stocktake := model.Stocktake{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockStocktake(tx, &stocktake, stocktakeId); err != nil {
+			return err
+		}
+
+		stocktake.Status = model.StocktakeCancelled
+		return tx.Model(&stocktake).Update("status", stocktake.Status).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, stocktake, "stocktake cancelled"}
+	res.Dispatch()
+}
+
+// applyCount changes the stock of a counted line by how far its count was
+// off and records the change as movement. Copies sold or received since the
+// line was counted stay accounted for, but the stock does not go below
+// zero.
+func applyCount(tx *gorm.DB, stocktake *model.Stocktake, line *model.StocktakeLine, movement model.StockMovement) error {
+	variance := *line.Counted - *line.Expected
+
+	if stocktake.WarehouseID != nil {
+		level, err := stockLevel(tx, *stocktake.WarehouseID, line.BookID, line.EditionID)
+		if err != nil {
+			return err
+		}
+
+		line.Adjustment = max(variance, -level.Quantity)
+		return setStockLevel(tx, &level, level.Quantity+line.Adjustment, movement)
+	}
+
+	// the copies may have been moved to warehouses since the count started
+	if err := checkWarehouseStock(tx, line.BookID, line.EditionID); err != nil {
+		return err
+	}
+
+	copies, err := itemCopies(tx.Clauses(clause.Locking{Strength: "UPDATE"}), line.BookID, line.EditionID)
+	if err != nil {
+		return err
+	}
+
+	line.Adjustment = max(variance, -copies)
+	if line.Adjustment == 0 {
+		return nil
+	}
+
+	if err := adjustStock(tx, line.BookID, line.EditionID, line.Adjustment); err != nil {
+		return err
+	}
+
+	movement.BookID = line.BookID
+	movement.EditionID = line.EditionID
+	movement.Quantity = line.Adjustment
+
+	return recordStock(tx, &movement)
+}
+
+// expectedCopies are the copies a line is expected to count, the stock of
+// its edition, or book, in the warehouse of the stocktake when it has one
+func expectedCopies(tx *gorm.DB, stocktake *model.Stocktake, line *model.StocktakeLine) (int, error) {
+	if stocktake.WarehouseID == nil {
+		return itemCopies(tx, line.BookID, line.EditionID)
+	}
+
+	level, err := stockLevel(tx, *stocktake.WarehouseID, line.BookID, line.EditionID)
+	return level.Quantity, err
+}
+
+// stocktakeLine finds the line a count is for
+func stocktakeLine(lines []model.StocktakeLine, count model.StocktakeCount) *model.StocktakeLine {
+	for i := range lines {
+		line := &lines[i]
+
+		if count.EditionId != 0 {
+			if line.EditionID != nil && *line.EditionID == uint(count.EditionId) && (count.BookId == 0 || line.BookID == uint(count.BookId)) {
+				return line
+			}
+			continue
+		}
+
+		if line.EditionID == nil && line.BookID == uint(count.BookId) {
+			return line
+		}
+	}
+
+	return nil
+}
+
+func findStocktake(db *gorm.DB, r *http.Request) (*model.Stocktake, error) {
+	stocktakeId, err := stocktakeIdParam(r)
+	if err != nil {
+		return nil, err
+	}
+
+	stocktake := model.Stocktake{}
+	if err := db.Preload("Lines", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&stocktake, stocktakeId).Error; err != nil {
+		return nil, ErrStocktakeNotFound.Wrap(err)
+	}
+
+	return &stocktake, nil
+}
+
+// lockStocktake loads an open stocktake with its lines
+func lockStocktake(tx *gorm.DB, stocktake *model.Stocktake, id int) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(stocktake, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrStocktakeNotFound.Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if stocktake.Status != model.StocktakeOpen {
+		return ErrStocktakeClosed.WithDetail("stocktake %d was %s", stocktake.ID, stocktake.Status)
+	}
+
+	return tx.Where("stocktake_id = ?", stocktake.ID).Order("id").Find(&stocktake.Lines).Error
+}
+
+func stocktakeIdParam(r *http.Request) (int, error) {
+	stocktakeIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	stocktakeId, err := strconv.Atoi(stocktakeIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid stocktake id")
+	}
+
+	return stocktakeId, nil
+}
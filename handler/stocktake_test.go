@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+var stocktakeLineColumns = []string{"id", "stocktake_id", "book_id", "edition_id", "counted", "expected", "adjustment"}
+
+func stocktakeRequest(method, path, body string) *http.Request {
+	req, _ := http.NewRequest(method, path, bytes.NewReader([]byte(body)))
+	req = mux.SetURLVars(req, map[string]string{"id": "6"})
+	return req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+}
+
+func TestCreateStocktake(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "books" WHERE id IN \(\$1,\$2\)`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(1, "Book1").AddRow(2, "Book2"))
+	mock.ExpectQuery(`^SELECT DISTINCT "book_id" FROM "stock_levels" WHERE book_id IN \(\$1,\$2\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions" WHERE book_id IN \(\$1,\$2\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "book_id"}).AddRow(3, 2).AddRow(4, 2))
+	mock.ExpectQuery(`^INSERT INTO "stocktakes"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, model.StocktakeOpen, "", "", 1, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+	mock.ExpectQuery(`^INSERT INTO "stocktake_lines"`).
+		WithArgs(6, 1, nil, nil, nil, nil, nil, 0,
+			6, 2, 3, nil, nil, nil, nil, 0,
+			6, 2, 4, nil, nil, nil, nil, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	CreateStocktake(db, w, stocktakeRequest(http.MethodPost, "/admin/stocktakes", `{"book_ids":[1,2]}`))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// copies kept per warehouse are counted in a warehouse
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(1, "Book1"))
+	mock.ExpectQuery(`^SELECT DISTINCT "book_id" FROM "stock_levels"`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	CreateStocktake(db, w, stocktakeRequest(http.MethodPost, "/admin/stocktakes", `{"book_ids":[1]}`))
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusConflict || res.Code != CodeWarehouseStock {
+		t.Fatalf("expected status 409 %s, got %d: %s", CodeWarehouseStock, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func expectOpenStocktake(mock sqlmock.Sqlmock, lines *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "stocktakes" (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(6, model.StocktakeOpen))
+	mock.ExpectQuery(`^SELECT (.+) FROM "stocktake_lines" WHERE stocktake_id = \$1 ORDER BY id`).
+		WithArgs(6).
+		WillReturnRows(lines)
+}
+
+func TestSubmitStocktakeCounts(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	lines := func() *sqlmock.Rows {
+		return sqlmock.NewRows(stocktakeLineColumns).
+			AddRow(1, 6, 1, nil, nil, nil, 0).
+			AddRow(2, 6, 2, 3, nil, nil, 0)
+	}
+
+	// the stock is kept along with each count
+	expectOpenStocktake(mock, lines())
+	mock.ExpectQuery(`^SELECT "available_copies" FROM "books" WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"available_copies"}).AddRow(2))
+	mock.ExpectExec(`^UPDATE "stocktake_lines" SET "counted"=\$1,"expected"=\$2,"counted_by"=\$3,"counted_at"=\$4 WHERE "id" = \$5`).
+		WithArgs(0, 2, 1, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT "available_copies" FROM "editions" WHERE id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"available_copies"}).AddRow(9))
+	mock.ExpectExec(`^UPDATE "stocktake_lines" SET "counted"=\$1,"expected"=\$2,"counted_by"=\$3,"counted_at"=\$4 WHERE "id" = \$5`).
+		WithArgs(7, 9, 1, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	SubmitStocktakeCounts(db, w, stocktakeRequest(http.MethodPut, "/admin/stocktakes/6/counts",
+		`{"counts":[{"book_id":1,"counted":0},{"edition_id":3,"counted":7}]}`))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the book is counted per edition
+	expectOpenStocktake(mock, lines())
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	SubmitStocktakeCounts(db, w, stocktakeRequest(http.MethodPut, "/admin/stocktakes/6/counts",
+		`{"counts":[{"book_id":2,"counted":7}]}`))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetStocktakeVariances(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "stocktakes"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(6, model.StocktakeOpen))
+	mock.ExpectQuery(`^SELECT (.+) FROM "stocktake_lines"`).
+		WillReturnRows(sqlmock.NewRows(stocktakeLineColumns).
+			AddRow(1, 6, 1, nil, 4, 4, 0).
+			AddRow(2, 6, 2, 3, 7, 9, 0).
+			AddRow(3, 6, 2, 4, nil, nil, 0))
+
+	w := httptest.NewRecorder()
+	GetStocktakeVariances(db, w, stocktakeRequest(http.MethodGet, "/admin/stocktakes/6/variances", ""))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data []model.StocktakeLine `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if len(res.Data) != 1 || res.Data[0].ID != 2 || *res.Data[0].Expected != 9 || *res.Data[0].Variance != -2 {
+		t.Fatalf("expected edition 3 to be 2 copies short, got %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestApplyStocktake(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	expectOpenStocktake(mock, sqlmock.NewRows(stocktakeLineColumns).
+		AddRow(1, 6, 1, nil, 4, 4, 0).
+		AddRow(2, 6, 2, 3, 7, 9, 0).
+		AddRow(3, 6, 2, 4, nil, nil, 0))
+
+	// the book matches its count
+	expectNoWarehouseStock(mock)
+	mock.ExpectQuery(`^SELECT "available_copies" FROM "books" WHERE id = \$1 (.+) FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"available_copies"}).AddRow(4))
+
+	// the edition was 2 copies short, copies received since it was counted
+	// are kept
+	expectNoWarehouseStock(mock)
+	mock.ExpectQuery(`^SELECT "available_copies" FROM "editions" WHERE id = \$1 (.+) FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"available_copies"}).AddRow(12))
+	mock.ExpectExec(`^UPDATE "editions" SET "available_copies"=available_copies \+ \$1`).
+		WithArgs(-2, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 2, 3, nil, model.StockAdjustment, -2, 1, model.CountDamaged, "stocktakes:6").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^UPDATE "stocktake_lines" SET "adjustment"=\$1 WHERE "id" = \$2`).
+		WithArgs(-2, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec(`^UPDATE "stocktakes" SET (.+)"status"=\$2,"reason"=\$3,"applied_by"=\$4,"applied_at"=\$5`).
+		WithArgs(sqlmock.AnyArg(), model.StocktakeApplied, model.CountDamaged, 1, sqlmock.AnyArg(), 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	ApplyStocktake(db, w, stocktakeRequest(http.MethodPost, "/admin/stocktakes/6/apply", `{"reason":"damaged"}`))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	// reason codes are fixed
+	w = httptest.NewRecorder()
+	ApplyStocktake(db, w, stocktakeRequest(http.MethodPost, "/admin/stocktakes/6/apply", `{"reason":"gremlins"}`))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
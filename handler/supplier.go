@@ -0,0 +1,517 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetSuppliers(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	suppliers := []model.Supplier{}
+
+	if err := db.Order("name, id").Find(&suppliers).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, suppliers, ""}
+	res.Dispatch()
+}
+
+func CreateSupplier(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.SupplierPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	supplier := model.Supplier{}
+	setSupplierFields(&supplier, payload)
+
+	if err := checkSupplier(db, &supplier); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := db.Create(&supplier).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, supplier, ""}
+	res.Dispatch()
+}
+
+// ReplaceSupplier overwrites every editable field of a supplier
+func ReplaceSupplier(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	supplierId, err := supplierIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.SupplierPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	supplier := model.Supplier{}
+
+	if err := db.First(&supplier, supplierId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrSupplierNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	setSupplierFields(&supplier, payload)
+
+	if err := checkSupplier(db, &supplier); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := db.Save(&supplier).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, supplier, ""}
+	res.Dispatch()
+}
+
+// GetSupplierOrders lists the supplier orders with their items, optionally
+// only those with a status
+func GetSupplierOrders(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Order("id")
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		switch status {
+		case model.SupplierOrderOpen, model.SupplierOrderPartial, model.SupplierOrderReceived, model.SupplierOrderCancelled:
+		default:
+			res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("status must be open, partially_received, received or cancelled")}
+			res.Dispatch()
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	if supplierId := r.URL.Query().Get("supplier_id"); supplierId != "" {
+		id, err := strconv.Atoi(supplierId)
+		if err != nil {
+			res := ErrorResponse{w, r, ErrMalformedRequest.WithDetail("invalid supplier_id")}
+			res.Dispatch()
+			return
+		}
+		query = query.Where("supplier_id = ?", id)
+	}
+
+	orders := []model.SupplierOrder{}
+	if err := query.Find(&orders).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, orders, ""}
+	res.Dispatch()
+}
+
+func GetSupplierOrderById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	orderId, err := supplierOrderIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	order := model.SupplierOrder{}
+	if err := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&order, orderId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrSupplierOrderNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, order, ""}
+	res.Dispatch()
+}
+
+// CreateSupplierOrder orders copies from a supplier. Items without a
+// quantity order the reorder quantity of their book, orders without an
+// expected delivery date are expected after the supplier's lead time.
+func CreateSupplierOrder(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.SupplierOrderPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	order := model.SupplierOrder{
+		Status:     model.SupplierOrderOpen,
+		ExpectedAt: payload.ExpectedAt,
+		Note:       payload.Note,
+		CreatedBy:  r.Context().Value("user_id").(uint),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		supplier := model.Supplier{}
+		if err := tx.First(&supplier, payload.SupplierId).Error; err != nil {
+			return ErrSupplierNotFound.Wrap(err)
+		}
+
+		order.SupplierID = supplier.ID
+		if order.ExpectedAt == nil && supplier.LeadTimeDays > 0 {
+			expected := time.Now().AddDate(0, 0, supplier.LeadTimeDays)
+			order.ExpectedAt = &expected
+		}
+
+		for i, p := range payload.Items {
+			book, item, err := orderItem(tx, p)
+			if err != nil {
+				return err
+			}
+
+			quantity := p.Quantity
+			if quantity == 0 {
+				quantity = book.ReorderQuantity
+			}
+			if quantity == 0 {
+				return ErrValidationFailed.WithDetail("items[%d]: quantity is required, book %d has no reorder quantity", i, book.ID)
+			}
+
+			item.Quantity = quantity
+			order.Items = append(order.Items, item)
+		}
+
+		return tx.Create(&order).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, order, "supplier order created"}
+	res.Dispatch()
+}
+
+// orderItem resolves an item of a supplier order payload to the edition or
+// the book without editions it orders. A book sold in editions is ordered
+// by edition, its copies are stocked per edition.
+func orderItem(tx *gorm.DB, p model.SupplierOrderItemPayload) (model.Book, model.SupplierOrderItem, error) {
+	book := model.Book{}
+	item := model.SupplierOrderItem{}
+
+	if p.EditionId != 0 {
+		edition := model.Edition{}
+		if err := tx.First(&edition, p.EditionId).Error; err != nil {
+			return book, item, ErrEditionNotFound.Wrap(err)
+		}
+
+		if p.BookId != 0 && uint(p.BookId) != edition.BookID {
+			return book, item, ErrEditionNotFound.WithDetail("edition %d is not an edition of book %d", edition.ID, p.BookId)
+		}
+
+		if err := tx.First(&book, edition.BookID).Error; err != nil {
+			return book, item, ErrBookNotFound.Wrap(err)
+		}
+
+		return book, model.SupplierOrderItem{BookID: book.ID, EditionID: &edition.ID}, nil
+	}
+
+	if err := tx.First(&book, p.BookId).Error; err != nil {
+		return book, item, ErrBookNotFound.Wrap(err)
+	}
+
+	var editions int64
+	if err := tx.Model(&model.Edition{}).Where("book_id = ?", book.ID).Count(&editions).Error; err != nil {
+		return book, item, err
+	}
+
+	if editions > 0 {
+		return book, item, ErrEditionRequired.WithDetail("book %d is sold in editions, order an edition", book.ID)
+	}
+
+	return book, model.SupplierOrderItem{BookID: book.ID}, nil
+}
+
+// ReceiveSupplierOrder puts the copies of a delivery in stock. A delivery
+// may bring part of the order, the order stays partially received until
+// every copy arrived.
+func ReceiveSupplierOrder(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	orderId, err := supplierOrderIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	// the body is optional, receiving without one receives everything
+	payload := model.ReceivePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	order := model.SupplierOrder{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockSupplierOrder(tx, &order, orderId); err != nil {
+			return err
+		}
+
+		if payload.WarehouseId != 0 {
+			if err := tx.First(&model.Warehouse{}, payload.WarehouseId).Error; err != nil {
+				return ErrWarehouseNotFound.Wrap(err)
+			}
+		}
+
+		received, err := receivedCopies(order.Items, payload.Items)
+		if err != nil {
+			return err
+		}
+
+		for i := range order.Items {
+			item := &order.Items[i]
+			quantity := received[item.ID]
+			if quantity == 0 {
+				continue
+			}
+
+			item.Received += quantity
+			if err := tx.Model(item).Update("received", item.Received).Error; err != nil {
+				return err
+			}
+
+			if err := receiveStock(tx, model.StockMovement{
+				BookID:    item.BookID,
+				EditionID: item.EditionID,
+				Kind:      model.StockReceipt,
+				Quantity:  quantity,
+				ActorID:   stockActor(r.Context()),
+				Reference: model.StockRef("supplier_orders", order.ID),
+			}, uint(payload.WarehouseId)); err != nil {
+				return err
+			}
+		}
+
+		order.Status = model.SupplierOrderReceived
+		for _, item := range order.Items {
+			if item.Outstanding() > 0 {
+				order.Status = model.SupplierOrderPartial
+			}
+		}
+
+		if order.Status == model.SupplierOrderReceived {
+			now := time.Now()
+			order.ReceivedAt = &now
+		}
+
+		return tx.Model(&order).Select("status", "received_at").Updates(&order).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, order, "supplier order " + order.Status}
+	res.Dispatch()
+}
+
+// CancelSupplierOrder stops expecting the copies of an order still
+// outstanding. Copies already received stay in stock.
+func CancelSupplierOrder(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	orderId, err := supplierOrderIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	order := model.SupplierOrder{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockSupplierOrder(tx, &order, orderId); err != nil {
+			return err
+		}
+
+		order.Status = model.SupplierOrderCancelled
+		return tx.Model(&order).Update("status", order.Status).Error
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, order, "supplier order cancelled"}
+	res.Dispatch()
+}
+
+// lockSupplierOrder loads an order still expecting copies with its items
+func lockSupplierOrder(tx *gorm.DB, order *model.SupplierOrder, id int) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSupplierOrderNotFound.Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if order.Status != model.SupplierOrderOpen && order.Status != model.SupplierOrderPartial {
+		return ErrSupplierOrderClosed.WithDetail("supplier order %d was %s", order.ID, order.Status)
+	}
+
+	return tx.Where("supplier_order_id = ?", order.ID).Order("id").Find(&order.Items).Error
+}
+
+// receivedCopies are the copies a delivery brings by item id, every copy
+// outstanding when it does not list its items
+func receivedCopies(items []model.SupplierOrderItem, delivered []model.ReceiveItemPayload) (map[uint]int, error) {
+	received := map[uint]int{}
+
+	if len(delivered) == 0 {
+		for _, item := range items {
+			received[item.ID] = item.Outstanding()
+		}
+		return received, nil
+	}
+
+	outstanding := map[uint]int{}
+	for _, item := range items {
+		outstanding[item.ID] = item.Outstanding()
+	}
+
+	for i, d := range delivered {
+		left, ok := outstanding[uint(d.ItemId)]
+		if !ok {
+			return nil, ErrValidationFailed.WithDetail("items[%d]: item %d is not part of the order", i, d.ItemId)
+		}
+
+		if received[uint(d.ItemId)]+d.Quantity > left {
+			return nil, ErrValidationFailed.WithDetail("items[%d]: item %d has %d copies outstanding", i, d.ItemId, left)
+		}
+
+		received[uint(d.ItemId)] += d.Quantity
+	}
+
+	return received, nil
+}
+
+func setSupplierFields(supplier *model.Supplier, payload model.SupplierPayload) {
+	supplier.Name = payload.Name
+	supplier.Email = payload.Email
+	supplier.Phone = payload.Phone
+	supplier.LeadTimeDays = payload.LeadTimeDays
+	supplier.PublisherID = nil
+	if payload.PublisherId != 0 {
+		publisherId := uint(payload.PublisherId)
+		supplier.PublisherID = &publisherId
+	}
+}
+
+// checkSupplier rejects a supplier named like another one or of a
+// publisher that does not exist
+func checkSupplier(db *gorm.DB, supplier *model.Supplier) error {
+	count := int64(0)
+	if err := db.Unscoped().Model(&model.Supplier{}).
+		Where("LOWER(name) = LOWER(?) AND id <> ?", supplier.Name, supplier.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrSupplierExists.WithDetail("supplier %s already exists", supplier.Name)
+	}
+
+	if supplier.PublisherID != nil {
+		if err := db.First(&model.Publisher{}, *supplier.PublisherID).Error; err != nil {
+			return ErrPublisherNotFound.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func supplierIdParam(r *http.Request) (int, error) {
+	supplierIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	supplierId, err := strconv.Atoi(supplierIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid supplier id")
+	}
+
+	return supplierId, nil
+}
+
+func supplierOrderIdParam(r *http.Request) (int, error) {
+	orderIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	orderId, err := strconv.Atoi(orderIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid supplier order id")
+	}
+
+	return orderId, nil
+}
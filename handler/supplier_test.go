@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+var supplierOrderItemColumns = []string{"id", "supplier_order_id", "book_id", "edition_id", "quantity", "received"}
+
+func TestCreateSupplierOrder(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "suppliers"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "lead_time_days"}).AddRow(2, "Penguin", 5))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "reorder_quantity"}).AddRow(1, "Book1", 1, 20))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE book_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "book_id"}).AddRow(5, 2))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(2, "Book2"))
+	mock.ExpectQuery(`^INSERT INTO "supplier_orders"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 2, model.SupplierOrderOpen, sqlmock.AnyArg(), nil, "", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(`^INSERT INTO "supplier_order_items"`).
+		WithArgs(3, 1, nil, 20, 0, 3, 2, 5, 4, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4).AddRow(5))
+	mock.ExpectCommit()
+
+	create := func(items ...map[string]any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"supplier_id": 2, "items": items})
+		req, _ := http.NewRequest(http.MethodPost, "/admin/supplier-orders", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+		w := httptest.NewRecorder()
+		CreateSupplierOrder(db, w, req)
+		return w
+	}
+
+	w := create(map[string]any{"book_id": 1}, map[string]any{"edition_id": 5, "quantity": 4})
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.SupplierOrder `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.ExpectedAt == nil || len(res.Data.Items) != 2 || res.Data.Items[0].Quantity != 20 {
+		t.Fatalf("expected 20 copies expected after the lead time, got %+v", res.Data)
+	}
+
+	if *res.Data.Items[1].EditionID != 5 || res.Data.Items[1].Quantity != 4 {
+		t.Fatalf("expected 4 copies of edition 5, got %+v", res.Data.Items)
+	}
+
+	// a book sold in editions is ordered by edition
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "suppliers"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "lead_time_days"}).AddRow(2, "Penguin", 5))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(2, "Book2"))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "editions" WHERE book_id = \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	w = create(map[string]any{"book_id": 2, "quantity": 4})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	errRes := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &errRes)
+	if errRes.Code != CodeEditionRequired {
+		t.Fatalf("expected code %s, got %s", CodeEditionRequired, errRes.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReceiveSupplierOrder(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	receive := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/supplier-orders/3/receive", bytes.NewReader([]byte(body)))
+		req = mux.SetURLVars(req, map[string]string{"id": "3"})
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+		w := httptest.NewRecorder()
+		ReceiveSupplierOrder(db, w, req)
+		return w
+	}
+
+	expectOrder := func(status string, received int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`^SELECT (.+) FROM "supplier_orders" (.+) FOR UPDATE`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "supplier_id", "status"}).AddRow(3, 2, status))
+		mock.ExpectQuery(`^SELECT (.+) FROM "supplier_order_items" WHERE supplier_order_id = \$1 ORDER BY id`).
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(supplierOrderItemColumns).
+				AddRow(4, 3, 1, nil, 20, received).
+				AddRow(5, 3, 2, nil, 10, 0))
+	}
+
+	// part of the first item arrives
+	expectOrder(model.SupplierOrderOpen, 0)
+	mock.ExpectExec(`^UPDATE "supplier_order_items" SET "received"=\$1 WHERE "id" = \$2`).
+		WithArgs(12, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoWarehouses(mock)
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(12, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, nil, model.StockReceipt, 12, 1, "", "supplier_orders:3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^UPDATE "supplier_orders" SET (.+)"status"=\$2,"received_at"=\$3`).
+		WithArgs(sqlmock.AnyArg(), model.SupplierOrderPartial, nil, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if w := receive(`{"items":[{"item_id":4,"quantity":12}]}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// more copies than were ordered
+	expectOrder(model.SupplierOrderPartial, 12)
+	mock.ExpectRollback()
+
+	if w := receive(`{"items":[{"item_id":4,"quantity":9}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the rest arrives
+	expectOrder(model.SupplierOrderPartial, 12)
+	for _, item := range []struct{ id, book, quantity int }{{4, 1, 8}, {5, 2, 10}} {
+		mock.ExpectExec(`^UPDATE "supplier_order_items" SET "received"=\$1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectNoWarehouses(mock)
+		mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+			WithArgs(item.quantity, sqlmock.AnyArg(), item.book).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectStockMovement(mock, model.StockReceipt, item.quantity)
+	}
+	mock.ExpectExec(`^UPDATE "supplier_orders" SET (.+)"status"=\$2,"received_at"=\$3`).
+		WithArgs(sqlmock.AnyArg(), model.SupplierOrderReceived, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if w := receive(""); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// nothing is left to receive
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "supplier_orders"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(3, model.SupplierOrderReceived))
+	mock.ExpectRollback()
+
+	w := receive("")
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusConflict || res.Code != CodeOrderClosed {
+		t.Fatalf("expected status 409 %s, got %d: %s", CodeOrderClosed, w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetSupplierOrders_Status(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "supplier_orders" WHERE status = \$1`).
+		WithArgs(model.SupplierOrderPartial).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(3, model.SupplierOrderPartial))
+	mock.ExpectQuery(`^SELECT (.+) FROM "supplier_order_items" WHERE "supplier_order_items"."supplier_order_id" = \$1 ORDER BY id`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(supplierOrderItemColumns).AddRow(4, 3, 1, nil, 20, 12))
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/supplier-orders?status=partially_received", nil)
+	w := httptest.NewRecorder()
+
+	GetSupplierOrders(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/supplier-orders?status=lost", nil)
+	w = httptest.NewRecorder()
+
+	GetSupplierOrders(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
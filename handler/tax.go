@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/peekeah/book-store/model"
+)
+
+// ErrUnknownTaxRegion is returned by a TaxCalculator that has no rules for
+// the region of a purchase.
+var ErrUnknownTaxRegion = errors.New("no tax rules for region")
+
+// TaxItem is a purchase line to be taxed. Amount is the quantity times the
+// listed unit price.
+type TaxItem struct {
+	Region string
+	// Format is the edition format, empty for books without editions
+	Format string
+	// Categories are the slugs of the book's categories and their ancestors
+	Categories []string
+	Amount     model.Money
+}
+
+// TaxResult is the tax due on an item. Total is what the customer pays, the
+// listed amount when prices include tax and the amount plus Tax otherwise.
+type TaxResult struct {
+	Region    string
+	Inclusive bool
+	Total     model.Money
+	Tax       model.Money
+	Lines     []model.TaxLine
+}
+
+// TaxCalculator works out the tax due on a purchase.
+type TaxCalculator interface {
+	CalculateTax(ctx context.Context, item TaxItem) (TaxResult, error)
+}
+
+// taxCalculator taxes purchases, nil sells tax free
+var taxCalculator TaxCalculator
+
+func SetTaxCalculator(calculator TaxCalculator) {
+	taxCalculator = calculator
+}
+
+// TaxRule charges the tax Name at Rate. Rules can be narrowed to an edition
+// format or a category, the most specific matching rule of each tax
+// applies, so a 0% rule on paperbacks zero-rates them under a general one.
+type TaxRule struct {
+	Name     string        `json:"name"`
+	Rate     model.TaxRate `json:"rate"`
+	Format   string        `json:"format,omitempty"`
+	Category string        `json:"category,omitempty"`
+}
+
+func (t TaxRule) matches(item TaxItem) bool {
+	return (t.Format == "" || t.Format == item.Format) &&
+		(t.Category == "" || slices.Contains(item.Categories, t.Category))
+}
+
+// specificity ranks matching rules, a category is narrower than a format
+func (t TaxRule) specificity() int {
+	score := 0
+	if t.Format != "" {
+		score++
+	}
+	if t.Category != "" {
+		score += 2
+	}
+	return score
+}
+
+// TaxRegion holds the rules of a country, GB, or subdivision, US-CA.
+// Inclusive regions list prices with tax included.
+type TaxRegion struct {
+	Inclusive bool      `json:"inclusive"`
+	Rules     []TaxRule `json:"rules"`
+}
+
+// RulesTaxCalculator taxes purchases with per region rules. A subdivision
+// without rules of its own falls back to its country.
+type RulesTaxCalculator struct {
+	DefaultRegion string               `json:"default_region"`
+	Regions       map[string]TaxRegion `json:"regions"`
+}
+
+// NewFileTaxCalculator loads tax rules from a JSON file, e.g.
+//
+//	{"default_region": "GB", "regions": {"GB": {"inclusive": true, "rules": [
+//	  {"name": "VAT", "rate": "20"}, {"name": "VAT", "format": "paperback", "rate": "0"}]}}}
+func NewFileTaxCalculator(path string) (*RulesTaxCalculator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := RulesTaxCalculator{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	calculator := &RulesTaxCalculator{
+		DefaultRegion: normalizeRegion(file.DefaultRegion),
+		Regions:       make(map[string]TaxRegion, len(file.Regions)),
+	}
+
+	for code, region := range file.Regions {
+		for _, rule := range region.Rules {
+			if rule.Name == "" {
+				return nil, fmt.Errorf("parse %s: rule without a name in region %s", path, code)
+			}
+			if rule.Format != "" && !slices.Contains(model.Formats, rule.Format) {
+				return nil, fmt.Errorf("parse %s: unknown format %q in region %s", path, rule.Format, code)
+			}
+		}
+		calculator.Regions[normalizeRegion(code)] = region
+	}
+
+	return calculator, nil
+}
+
+func (c *RulesTaxCalculator) CalculateTax(_ context.Context, item TaxItem) (TaxResult, error) {
+	code := normalizeRegion(item.Region)
+	if code == "" {
+		code = c.DefaultRegion
+	}
+
+	region, ok := c.Regions[code]
+	if !ok {
+		country, _, _ := strings.Cut(code, "-")
+		if region, ok = c.Regions[country]; !ok {
+			return TaxResult{}, ErrUnknownTaxRegion
+		}
+	}
+
+	// the most specific matching rule of each tax, in the order the taxes
+	// are first listed
+	rules := []TaxRule{}
+	for _, rule := range region.Rules {
+		if !rule.matches(item) {
+			continue
+		}
+
+		i := slices.IndexFunc(rules, func(r TaxRule) bool { return r.Name == rule.Name })
+		switch {
+		case i < 0:
+			rules = append(rules, rule)
+		case rule.specificity() > rules[i].specificity():
+			rules[i] = rule
+		}
+	}
+
+	result, err := applyTaxRules(item.Amount, region.Inclusive, rules)
+	if err != nil {
+		return TaxResult{}, err
+	}
+
+	result.Region = code
+	return result, nil
+}
+
+// applyTaxRules computes each tax line of amount. Exclusive taxes are added
+// on top of the amount, each rounded on its own. Inclusive taxes are taken
+// out of the amount: the total tax is rounded once and the largest tax
+// absorbs the rounding so the lines add up.
+func applyTaxRules(amount model.Money, inclusive bool, rules []TaxRule) (TaxResult, error) {
+	result := TaxResult{
+		Inclusive: inclusive,
+		Total:     amount,
+		Tax:       model.Zero(amount.Currency),
+		Lines:     make([]model.TaxLine, len(rules)),
+	}
+
+	if len(rules) == 0 {
+		return result, nil
+	}
+
+	divisor := int64(model.TaxRateScale)
+	if inclusive {
+		for _, rule := range rules {
+			divisor += int64(rule.Rate)
+		}
+	}
+
+	largest := 0
+	for i, rule := range rules {
+		tax, err := amount.Scale(int64(rule.Rate), divisor)
+		if err != nil {
+			return TaxResult{}, err
+		}
+
+		result.Lines[i] = model.TaxLine{Name: rule.Name, Rate: rule.Rate, Amount: tax}
+		if result.Tax, err = result.Tax.Add(tax); err != nil {
+			return TaxResult{}, err
+		}
+
+		if rule.Rate > rules[largest].Rate {
+			largest = i
+		}
+	}
+
+	if !inclusive {
+		total, err := amount.Add(result.Tax)
+		if err != nil {
+			return TaxResult{}, err
+		}
+
+		result.Total = total
+		return result, nil
+	}
+
+	net, err := amount.Scale(model.TaxRateScale, divisor)
+	if err != nil {
+		return TaxResult{}, err
+	}
+
+	tax, err := amount.Sub(net)
+	if err != nil {
+		return TaxResult{}, err
+	}
+
+	diff, err := tax.Sub(result.Tax)
+	if err != nil {
+		return TaxResult{}, err
+	}
+
+	line := &result.Lines[largest]
+	if line.Amount, err = line.Amount.Add(diff); err != nil {
+		return TaxResult{}, err
+	}
+
+	result.Tax = tax
+	return result, nil
+}
+
+// taxPurchase replaces the amount of a purchase by the amount due with tax
+// and records the tax lines. It is a no-op without a calculator.
+func taxPurchase(ctx context.Context, purchase *model.Purchase, format string, categories []string, region string) error {
+	if taxCalculator == nil {
+		return nil
+	}
+
+	result, err := taxCalculator.CalculateTax(ctx, TaxItem{
+		Region:     region,
+		Format:     format,
+		Categories: categories,
+		Amount:     purchase.Amount,
+	})
+	if errors.Is(err, ErrUnknownTaxRegion) {
+		return ErrTaxRegionUnsupported.WithDetail("no tax rules for region %q", region).Wrap(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	purchase.Amount = result.Total
+	purchase.Tax = result.Tax
+	purchase.TaxRegion = result.Region
+	purchase.TaxInclusive = result.Inclusive
+
+	purchase.Taxes = make([]model.PurchaseTax, len(result.Lines))
+	for i, line := range result.Lines {
+		purchase.Taxes[i] = model.PurchaseTax{TaxLine: line}
+	}
+
+	return nil
+}
+
+func normalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+const testTaxRules = `{
+	"default_region": "gb",
+	"regions": {
+		"GB": {"inclusive": true, "rules": [
+			{"name": "VAT", "rate": "20"},
+			{"name": "VAT", "format": "paperback", "rate": 0}
+		]},
+		"US-CA": {"rules": [
+			{"name": "Sales tax", "rate": "7.25"},
+			{"name": "Sales tax", "category": "textbooks", "rate": "0"},
+			{"name": "District tax", "rate": "1"}
+		]}
+	}
+}`
+
+func newTestTaxCalculator(t *testing.T) *RulesTaxCalculator {
+	path := filepath.Join(t.TempDir(), "tax.json")
+	os.WriteFile(path, []byte(testTaxRules), 0o600)
+
+	calculator, err := NewFileTaxCalculator(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return calculator
+}
+
+func TestTaxRate(t *testing.T) {
+	rate, err := model.ParseTaxRate("7.25")
+	if err != nil || rate != 72500 || rate.String() != "7.25" {
+		t.Fatalf("unexpected rate %d (%s), %v", rate, rate, err)
+	}
+
+	if rate, _ := model.ParseTaxRate("20"); rate.String() != "20" {
+		t.Fatalf("expected 20, got %s", rate)
+	}
+
+	if _, err := model.ParseTaxRate("0.00001"); err == nil {
+		t.Fatalf("expected a rate below the precision to be rejected")
+	}
+}
+
+func TestRulesTaxCalculator(t *testing.T) {
+	calculator := newTestTaxCalculator(t)
+	ctx := context.Background()
+
+	// prices include VAT in GB, the default region
+	result, err := calculator.CalculateTax(ctx, TaxItem{Format: model.FormatEbook, Amount: model.NewMoney(1299, "GBP")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Region != "GB" || !result.Inclusive || result.Total.Amount != 1299 || result.Tax.Amount != 216 {
+		t.Fatalf("unexpected inclusive result %+v", result)
+	}
+
+	// the paperback rule is more specific than the general one
+	result, _ = calculator.CalculateTax(ctx, TaxItem{Region: "GB", Format: model.FormatPaperback, Amount: model.NewMoney(1299, "GBP")})
+	if len(result.Lines) != 1 || result.Lines[0].Rate != 0 || result.Tax.Amount != 0 {
+		t.Fatalf("expected paperbacks to be zero rated, got %+v", result)
+	}
+
+	// taxes are added on top in the US, each line rounded on its own
+	result, _ = calculator.CalculateTax(ctx, TaxItem{Region: "us-ca", Amount: model.NewMoney(1000, "USD")})
+	if result.Inclusive || result.Tax.Amount != 83 || result.Total.Amount != 1083 || len(result.Lines) != 2 {
+		t.Fatalf("unexpected exclusive result %+v", result)
+	}
+
+	if line := result.Lines[0]; line.Name != "Sales tax" || line.Amount.Amount != 73 {
+		t.Fatalf("unexpected sales tax line %+v", line)
+	}
+
+	result, _ = calculator.CalculateTax(ctx, TaxItem{Region: "US-CA", Categories: []string{"science", "textbooks"}, Amount: model.NewMoney(1000, "USD")})
+	if result.Tax.Amount != 10 {
+		t.Fatalf("expected only the district tax on textbooks, got %+v", result)
+	}
+
+	if _, err := calculator.CalculateTax(ctx, TaxItem{Region: "FR", Amount: model.NewMoney(1000, "EUR")}); !errors.Is(err, ErrUnknownTaxRegion) {
+		t.Fatalf("expected unknown region, got %v", err)
+	}
+}
+
+func TestApplyTaxRules_InclusiveLinesAddUp(t *testing.T) {
+	rules := []TaxRule{{Name: "GST", Rate: 50000}, {Name: "PST", Rate: 70000}}
+
+	result, err := applyTaxRules(model.NewMoney(999, "CAD"), true, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 999 / 1.12 = 891.96, so 107 of tax split over the two lines
+	if result.Tax.Amount != 107 || result.Lines[0].Amount.Amount+result.Lines[1].Amount.Amount != 107 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestPurchaseBook_Taxed(t *testing.T) {
+	SetTaxCalculator(newTestTaxCalculator(t))
+	defer SetTaxCalculator(nil)
+
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "email"}).AddRow(1, "user1", "user@example.com"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies", "price_minor", "price_currency"}).AddRow(1, "Book1", 5, 1000, "USD"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	mock.ExpectExec(`^UPDATE "books"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`^SELECT (.+) FROM "promotions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^SELECT "slug" FROM "categories" WHERE \(?EXISTS \(SELECT 1 FROM book_categories`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("fiction"))
+	mock.ExpectQuery(`^INSERT INTO "purchases"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 1, nil, 2, 2165, "USD", nil, 0, "USD", 165, "USD", "US-CA", false, "paid", "").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(9))
+	mock.ExpectQuery(`^INSERT INTO "purchase_taxes"`).
+		WithArgs(9, "Sales tax", 72500, 145, "USD", 9, "District tax", 10000, 20, "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	expectNoWarehouses(mock)
+	expectStockMovement(mock, model.StockSale, -2)
+	mock.ExpectCommit()
+
+	body := []byte(`{"book_id":1,"quantity":2,"region":"US-CA"}`)
+	req, _ := http.NewRequest(http.MethodPost, "/books/purchase", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(1)))
+	w := httptest.NewRecorder()
+
+	PurchaseBook(db, w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := struct {
+		Data model.Purchase `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Data.Amount.Amount != 2165 || len(res.Data.Taxes) != 2 || res.Data.Taxes[0].Rate.String() != "7.25" {
+		t.Fatalf("unexpected receipt %+v", res.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
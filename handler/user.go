@@ -5,20 +5,17 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/peekeah/book-store/model"
 	"github.com/peekeah/book-store/utils"
 	"gorm.io/gorm"
 )
 
-var validate = validator.New()
-
 func GetUsers(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	users := []model.User{}
 
 	if err := db.Omit("password").Find(&users).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -31,14 +28,14 @@ func GetUserById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
 	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "id is required"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("id is required")}
 		res.Dispatch()
 		return
 	}
 
 	userIdInt, err := strconv.Atoi(id)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid user id"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid user id")}
 		res.Dispatch()
 		return
 	}
@@ -46,11 +43,15 @@ func GetUserById(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	user := model.User{}
 
 	if err := db.Omit("password").First(&user, userIdInt).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, "user not found"}
+		res := ErrorResponse{w, r, ErrUserNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
+	if notModified(w, r, versionETag(user.ID, user.Version)) {
+		return
+	}
+
 	res := SuccessResponse{w, http.StatusOK, user, ""}
 	res.Dispatch()
 }
@@ -59,7 +60,7 @@ func CreateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	payload := model.User{}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
 		res.Dispatch()
 		return
 	}
@@ -67,14 +68,14 @@ func CreateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if validationErr := validate.Struct(&payload); validationErr != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, validationErr.Error()}
+		res := ErrorResponse{w, r, validationErr}
 		res.Dispatch()
 		return
 	}
 
 	hashedPwd, err := utils.HashPassword(payload.Password)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -83,7 +84,7 @@ func CreateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	existUser.Email = payload.Email
 
 	if err := db.First(&existUser, model.User{Email: payload.Email}).Error; err == nil {
-		res := ErrorResponse{w, http.StatusNotFound, "user already exist"}
+		res := ErrorResponse{w, r, ErrEmailTaken}
 		res.Dispatch()
 		return
 	}
@@ -97,7 +98,7 @@ func CreateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := db.Save(&payload).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
@@ -106,70 +107,131 @@ func CreateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	res.Dispatch()
 }
 
+// UpdateUser applies a JSON merge patch (RFC 7396) to a user profile
 func UpdateUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userId, ok := vars["id"]
-
-	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid user id"}
+	userId, err := userIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	userIdInt, err := strconv.Atoi(userId)
+	patch, err := decodeMergePatch(r)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid user id"}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	payload := model.UpdateUserPayload{}
-	payload.ID = uint(userIdInt)
+	defer r.Body.Close()
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+	dbUser := model.User{}
+
+	if err := db.Omit("password").First(&dbUser, userId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrUserNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	fields := dbUser.Fields()
+
+	if err := applyMergePatch(&fields, patch); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	saveUserFields(db, w, r, &dbUser, fields)
+}
+
+// ReplaceUser overwrites every editable profile field of a user
+func ReplaceUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
 	defer r.Body.Close()
 
-	if validationErr := validate.Struct(&payload); validationErr != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, validationErr.Error()}
+	fields := model.UserFields{}
+
+	if err := decodeReplacement(r, &fields); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
 	dbUser := model.User{}
 
-	if err := db.Omit("password").First(&dbUser, payload.ID).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if err := db.Omit("password").First(&dbUser, userId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrUserNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
-	if dbUser.ID == 0 {
-		res := ErrorResponse{w, http.StatusNotFound, "user does not exist"}
+	saveUserFields(db, w, r, &dbUser, fields)
+}
+
+// saveUserFields writes fields only if the user still has the version that
+// was read, so concurrent edits are reported instead of overwritten
+func saveUserFields(db *gorm.DB, w http.ResponseWriter, r *http.Request, dbUser *model.User, fields model.UserFields) {
+	if _, err := checkIfMatch(r, versionETag(dbUser.ID, dbUser.Version)); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := db.Model(&dbUser).Updates(payload).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if err := validate.Struct(&fields); err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
+	version := dbUser.Version
+	dbUser.SetFields(fields)
+	dbUser.Version++
+
+	result := db.Model(dbUser).Where("version = ?", version).Select(model.UserFieldColumns).Updates(dbUser)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("user was modified concurrently")}
+		res.Dispatch()
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(dbUser.ID, dbUser.Version))
+
 	res := SuccessResponse{w, http.StatusOK, dbUser, ""}
 	res.Dispatch()
 }
 
+func userIdParam(r *http.Request) (int, error) {
+	userIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	userId, err := strconv.Atoi(userIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid user id")
+	}
+
+	return userId, nil
+}
+
 func DeleteUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userId, ok := vars["id"]
 
 	if !ok {
-		res := ErrorResponse{w, http.StatusBadRequest, "url param not found"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("id is required")}
 		res.Dispatch()
 		return
 	}
@@ -178,25 +240,44 @@ func DeleteUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 
 	userIdInt, err := strconv.Atoi(userId)
 	if err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, "invalid user id"}
+		res := ErrorResponse{w, r, ErrInvalidID.WithDetail("invalid user id")}
 		res.Dispatch()
 		return
 	}
 
 	if err := db.First(&user, userIdInt).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, "user not found"}
+		res := ErrorResponse{w, r, ErrUserNotFound.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	if user.ID == 0 {
-		res := ErrorResponse{w, http.StatusNotFound, "user not found"}
+		res := ErrorResponse{w, r, ErrUserNotFound}
+		res.Dispatch()
+		return
+	}
+
+	conditional, err := checkIfMatch(r, versionETag(user.ID, user.Version))
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	query := db
+	if conditional {
+		query = db.Where("version = ?", user.Version)
+	}
+
+	result := query.Delete(&user, userId)
+	if err := result.Error; err != nil {
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
 
-	if err := db.Delete(&user, userId).Error; err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+	if conditional && result.RowsAffected == 0 {
+		res := ErrorResponse{w, r, ErrPreconditionFailed.WithDetail("user was modified concurrently")}
 		res.Dispatch()
 		return
 	}
@@ -219,35 +300,35 @@ func UserLogin(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	user := model.User{}
 
 	if err := decoder.Decode(&body); err != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, err.Error()}
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	// payload validation
 	if validationErr := validate.Struct(&body); validationErr != nil {
-		res := ErrorResponse{w, http.StatusBadRequest, validationErr.Error()}
+		res := ErrorResponse{w, r, validationErr}
 		res.Dispatch()
 		return
 	}
 
 	// check user id db
 	if err := db.First(&user, model.User{Email: body.Email}).Error; err != nil {
-		res := ErrorResponse{w, http.StatusNotFound, "user does not exist"}
+		res := ErrorResponse{w, r, ErrInvalidCredentials.Wrap(err)}
 		res.Dispatch()
 		return
 	}
 
 	// Validate password
 	if !utils.ComparePassword(body.Password, user.Password) {
-		res := ErrorResponse{w, http.StatusNotFound, "password does not match"}
+		res := ErrorResponse{w, r, ErrInvalidCredentials}
 		res.Dispatch()
 		return
 	}
 
 	token, err := utils.CreateJWTToken(utils.JWTTokenBody{ID: user.ID, Email: user.Email, Name: user.Name})
 	if err != nil {
-		res := ErrorResponse{w, http.StatusInternalServerError, err.Error()}
+		res := ErrorResponse{w, r, err}
 		res.Dispatch()
 		return
 	}
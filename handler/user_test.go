@@ -13,6 +13,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/peekeah/book-store/model"
 	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
 )
 
 func TestGetUsers(t *testing.T) {
@@ -140,6 +141,7 @@ func TestCreateUser(t *testing.T) {
 			"user@example.com",
 			sqlmock.AnyArg(),
 			"user",
+			1,
 		).
 		WillReturnRows(mock.NewRows([]string{"ID"}).AddRow(1))
 	mock.ExpectCommit()
@@ -252,8 +254,8 @@ func TestUpdateUser(t *testing.T) {
 		t.Error("error while parsing payload")
 	}
 
-	rows := sqlmock.NewRows([]string{"ID", "name", "email", "city"}).
-		AddRow(1, "user2", "user1@example.com", "Pune")
+	rows := sqlmock.NewRows([]string{"ID", "name", "email", "city", "version"}).
+		AddRow(1, "user2", "user1@example.com", "Pune", 1)
 
 	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
 		WillReturnRows(rows)
@@ -262,10 +264,12 @@ func TestUpdateUser(t *testing.T) {
 
 	mock.ExpectExec(`^UPDATE "users"`).
 		WithArgs(
-			1,
+			sqlmock.AnyArg(),
 			"user1",
 			"Bengaluru",
 			"user@example.com",
+			2,
+			1,
 			1,
 		).
 		WillReturnResult(sqlmock.NewResult(0, 1))
@@ -418,8 +422,8 @@ func TestCreateUser_DecodeError(t *testing.T) {
 
 	CreateUser(db, w, req)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Fatalf("expected status 500, got %d", w.Code)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
 	}
 }
 
@@ -484,8 +488,8 @@ func TestCreateUser_UserExists(t *testing.T) {
 
 	CreateUser(db, w, req)
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404, got %d", w.Code)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
 	}
 }
 
@@ -515,6 +519,7 @@ func TestCreateUser_WithAdminRole(t *testing.T) {
 			"admin@example.com",
 			sqlmock.AnyArg(),
 			"admin",
+			1,
 		).
 		WillReturnRows(mock.NewRows([]string{"ID"}).AddRow(1))
 	mock.ExpectCommit()
@@ -608,7 +613,7 @@ func TestUpdateUser_UserNotFound(t *testing.T) {
 	payloadByte, _ := json.Marshal(payload)
 
 	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
-		WillReturnError(errors.New("not found"))
+		WillReturnError(gorm.ErrRecordNotFound)
 
 	req, _ := http.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(payloadByte))
 	req = mux.SetURLVars(req, map[string]string{"id": "1"})
@@ -616,8 +621,8 @@ func TestUpdateUser_UserNotFound(t *testing.T) {
 
 	UpdateUser(db, w, req)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Fatalf("expected status 500, got %d", w.Code)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
 	}
 }
 
@@ -627,8 +632,7 @@ func TestUpdateUser_UserDoesNotExist(t *testing.T) {
 	payload := map[string]any{"name": "user1"}
 	payloadByte, _ := json.Marshal(payload)
 
-	rows := sqlmock.NewRows([]string{"ID", "name", "email"}).
-		AddRow(0, "user1", "user@example.com")
+	rows := sqlmock.NewRows([]string{"ID", "name", "email"})
 	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
 		WillReturnRows(rows)
 
@@ -807,8 +811,8 @@ func TestUserLogin_UserNotFound(t *testing.T) {
 
 	UserLogin(db, w, req)
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404, got %d", w.Code)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", w.Code)
 	}
 }
 
@@ -834,8 +838,8 @@ func TestUserLogin_PasswordMismatch(t *testing.T) {
 
 	UserLogin(db, w, req)
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("expected status 404, got %d", w.Code)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", w.Code)
 	}
 }
 
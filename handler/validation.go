@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+)
+
+// MinPublishedYear is the earliest year accepted by the "year" rule.
+const MinPublishedYear = 1450
+
+var (
+	validate   = newValidator()
+	translator = newTranslator(validate)
+)
+
+// FieldError describes a single failed rule on a request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// report json names instead of go struct field names
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return fld.Name
+		}
+		return name
+	})
+
+	v.RegisterValidation("email", validateEmail)
+	v.RegisterValidation("isbn", validateISBN)
+	v.RegisterValidation("slug", validateSlug)
+	v.RegisterValidation("year", validateYear)
+	v.RegisterValidation("money", validateMoney)
+	v.RegisterValidation("coupon_code", validateCouponCode)
+
+	return v
+}
+
+func validateEmail(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return false
+	}
+
+	domain := value[strings.LastIndex(value, "@")+1:]
+	return strings.Contains(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+func validateISBN(fl validator.FieldLevel) bool {
+	return utils.ValidISBN(fl.Field().String())
+}
+
+func validateSlug(fl validator.FieldLevel) bool {
+	return utils.ValidSlug(fl.Field().String())
+}
+
+func validateYear(fl validator.FieldLevel) bool {
+	year := fl.Field().Int()
+	return year >= MinPublishedYear && year <= int64(maxPublishedYear())
+}
+
+// validateMoney accepts prices: not negative and in a supported currency,
+// or none for the default one
+func validateMoney(fl validator.FieldLevel) bool {
+	money, ok := fl.Field().Interface().(model.Money)
+	if !ok {
+		return false
+	}
+
+	_, supported := model.LookupCurrency(money.Currency)
+	return (supported || money.Currency == "") && !money.IsNegative()
+}
+
+// validateCouponCode accepts letters, digits, hyphens and underscores,
+// codes are compared ignoring case
+func validateCouponCode(fl validator.FieldLevel) bool {
+	code := strings.TrimSpace(fl.Field().String())
+	if code == "" {
+		return false
+	}
+
+	for _, c := range code {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// maxPublishedYear allows pre-orders for next year's releases
+func maxPublishedYear() int {
+	return time.Now().Year() + 1
+}
+
+func newTranslator(v *validator.Validate) *ut.UniversalTranslator {
+	english := en.New()
+	uni := ut.New(english, english, fr.New(), es.New())
+
+	defaults := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"fr": fr_translations.RegisterDefaultTranslations,
+		"es": es_translations.RegisterDefaultTranslations,
+	}
+
+	custom := map[string]map[string]string{
+		"en": {
+			"email":       "{0} must be a valid email address",
+			"isbn":        "{0} must be a valid ISBN-10 or ISBN-13",
+			"slug":        "{0} must only contain lower case letters, digits and hyphens",
+			"year":        "{0} must be a year between {1} and {2}",
+			"money":       "{0} must be a non-negative amount in a supported currency",
+			"coupon_code": "{0} must only contain letters, digits, hyphens and underscores",
+		},
+		"fr": {
+			"email":       "{0} doit être une adresse email valide",
+			"isbn":        "{0} doit être un ISBN-10 ou ISBN-13 valide",
+			"slug":        "{0} ne doit contenir que des minuscules, des chiffres et des tirets",
+			"year":        "{0} doit être une année entre {1} et {2}",
+			"money":       "{0} doit être un montant non négatif dans une devise prise en charge",
+			"coupon_code": "{0} ne doit contenir que des lettres, des chiffres, des tirets et des tirets bas",
+		},
+		"es": {
+			"email":       "{0} debe ser una dirección de correo electrónico válida",
+			"isbn":        "{0} debe ser un ISBN-10 o ISBN-13 válido",
+			"slug":        "{0} solo puede contener minúsculas, dígitos y guiones",
+			"year":        "{0} debe ser un año entre {1} y {2}",
+			"money":       "{0} debe ser un importe no negativo en una moneda admitida",
+			"coupon_code": "{0} solo puede contener letras, dígitos, guiones y guiones bajos",
+		},
+	}
+
+	for locale, register := range defaults {
+		trans, _ := uni.GetTranslator(locale)
+		if err := register(v, trans); err != nil {
+			panic(fmt.Sprintf("register %s translations: %v", locale, err))
+		}
+
+		for tag, text := range custom[locale] {
+			tag, text := tag, text
+			err := v.RegisterTranslation(tag, trans, func(ut ut.Translator) error {
+				return ut.Add(tag, text, true)
+			}, translateCustom)
+			if err != nil {
+				panic(fmt.Sprintf("register %s %s translation: %v", locale, tag, err))
+			}
+		}
+	}
+
+	return uni
+}
+
+func translateCustom(trans ut.Translator, fe validator.FieldError) string {
+	params := []string{fe.Field()}
+	if fe.Tag() == "year" {
+		params = append(params, strconv.Itoa(MinPublishedYear), strconv.Itoa(maxPublishedYear()))
+	}
+
+	msg, err := trans.T(fe.Tag(), params...)
+	if err != nil {
+		return fe.Error()
+	}
+	return msg
+}
+
+// requestTranslator picks the best supported locale from Accept-Language
+func requestTranslator(r *http.Request) ut.Translator {
+	trans, _ := translator.FindTranslator(acceptLanguages(r)...)
+	return trans
+}
+
+func acceptLanguages(r *http.Request) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	langs := []lang{}
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		// q=0 marks a language as not acceptable
+		if q <= 0 {
+			continue
+		}
+
+		// match on the base language only, e.g. fr-CH -> fr
+		tag, _, _ = strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+		langs = append(langs, lang{tag, q})
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	tags := make([]string, 0, len(langs))
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+	}
+
+	return tags
+}
+
+// validationErrors translates validator errors into field errors
+func validationErrors(r *http.Request, err error) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+
+	trans := requestTranslator(r)
+	fields := make([]FieldError, 0, len(errs))
+
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: fe.Translate(trans),
+		})
+	}
+
+	return fields
+}
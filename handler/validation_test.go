@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peekeah/book-store/utils"
+)
+
+func TestCreateBook_FieldErrors(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	payload := map[string]any{
+		"name":             "Book1",
+		"available_copies": 2,
+		"published_year":   1200,
+		"price":            100,
+	}
+
+	payloadByte, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(http.MethodPost, "/books", bytes.NewReader(payloadByte))
+	w := httptest.NewRecorder()
+
+	CreateBook(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	rules := map[string]string{}
+	for _, f := range res.Fields {
+		rules[f.Field] = f.Rule
+	}
+
+	if rules["author"] != "required" {
+		t.Fatalf("expected author required error, got %+v", res.Fields)
+	}
+
+	if rules["published_year"] != "year" {
+		t.Fatalf("expected published_year year error, got %+v", res.Fields)
+	}
+}
+
+func TestCreateUser_TranslatedFieldErrors(t *testing.T) {
+	db, _ := utils.GetDBMock()
+
+	payload := map[string]any{
+		"name":     "user",
+		"email":    "not-an-email",
+		"password": "secret",
+	}
+
+	payloadByte, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(http.MethodPost, "/auth/signup", bytes.NewReader(payloadByte))
+	req.Header.Set("Accept-Language", "de;q=0.9, fr-CH, en;q=0.5")
+	w := httptest.NewRecorder()
+
+	CreateUser(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	res := ErrorJSON{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(res.Fields) != 1 || res.Fields[0].Field != "email" || res.Fields[0].Rule != "email" {
+		t.Fatalf("expected email field error, got %+v", res.Fields)
+	}
+
+	if !strings.Contains(res.Fields[0].Message, "adresse email valide") {
+		t.Fatalf("expected french message, got %q", res.Fields[0].Message)
+	}
+}
+
+func TestCustomValidators(t *testing.T) {
+	cases := []struct {
+		value any
+		tag   string
+		valid bool
+	}{
+		{"user@example.com", "email", true},
+		{"user@localhost", "email", false},
+		{"User <user@example.com>", "email", false},
+		{"978-0-306-40615-7", "isbn", true},
+		{"0-306-40615-2", "isbn", true},
+		{"0-8044-2957-X", "isbn", true},
+		{"978-0-306-40615-8", "isbn", false},
+		{"12345", "isbn", false},
+		{1999, "year", true},
+		{1449, "year", false},
+		{3000, "year", false},
+	}
+
+	for _, c := range cases {
+		err := validate.Var(c.value, c.tag)
+		if (err == nil) != c.valid {
+			t.Errorf("%s(%v): expected valid=%v, got %v", c.tag, c.value, c.valid, err)
+		}
+	}
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Accept-Language", "fr;q=0, es-MX;q=0.5, de;q=0.8, *")
+
+	tags := acceptLanguages(req)
+	if strings.Join(tags, ",") != "de,es" {
+		t.Fatalf("expected de,es, got %v", tags)
+	}
+
+	if locale := requestTranslator(req).Locale(); locale != "es" {
+		t.Fatalf("expected es translator, got %s", locale)
+	}
+}
@@ -0,0 +1,702 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Allocation rules
+const (
+	// AllocationNearest takes copies from the warehouses in the customer's
+	// city first, then by priority
+	AllocationNearest = "nearest"
+	// AllocationPriority takes copies by warehouse priority only
+	AllocationPriority = "priority"
+)
+
+// allocationRule decides which warehouses sold copies are taken from
+var allocationRule = AllocationNearest
+
+func SetAllocationRule(rule string) error {
+	if rule != AllocationNearest && rule != AllocationPriority {
+		return fmt.Errorf("unknown allocation rule %q", rule)
+	}
+	allocationRule = rule
+	return nil
+}
+
+func GetWarehouses(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	warehouses := []model.Warehouse{}
+
+	if err := db.Order("priority, id").Find(&warehouses).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, warehouses, ""}
+	res.Dispatch()
+}
+
+func CreateWarehouse(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.WarehousePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	warehouse := model.Warehouse{}
+	setWarehouseFields(&warehouse, payload)
+
+	if err := checkWarehouseCodeAvailable(db, &warehouse); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := db.Create(&warehouse).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, warehouse, ""}
+	res.Dispatch()
+}
+
+// ReplaceWarehouse overwrites every editable field of a warehouse
+func ReplaceWarehouse(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	warehouseId, err := warehouseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.WarehousePayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	warehouse := model.Warehouse{}
+
+	if err := db.First(&warehouse, warehouseId).Error; err != nil {
+		res := ErrorResponse{w, r, ErrWarehouseNotFound.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	setWarehouseFields(&warehouse, payload)
+
+	if err := checkWarehouseCodeAvailable(db, &warehouse); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	if err := db.Save(&warehouse).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, warehouse, ""}
+	res.Dispatch()
+}
+
+// GetWarehouseStock lists the stock levels of a warehouse
+func GetWarehouseStock(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	warehouseId, err := warehouseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	levels := []model.StockLevel{}
+
+	if err := db.Where("warehouse_id = ?", warehouseId).Order("book_id, edition_id").Find(&levels).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, levels, ""}
+	res.Dispatch()
+}
+
+// SetWarehouseStock sets the stock of a book, or one of its editions, in a
+// warehouse, e.g. after counting it. The first time a book or edition is
+// stocked in a warehouse its copies are taken to be kept there, from then
+// on its available copies are the sum of its warehouses.
+func SetWarehouseStock(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	warehouseId, err := warehouseIdParam(r)
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	payload := model.StockLevelPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	level := model.StockLevel{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		warehouse := model.Warehouse{}
+		if err := tx.First(&warehouse, warehouseId).Error; err != nil {
+			return ErrWarehouseNotFound.Wrap(err)
+		}
+
+		book, edition, err := purchaseItem(tx, &model.PurchasePayload{BookId: payload.BookId, EditionId: payload.EditionId})
+		if err != nil {
+			return err
+		}
+
+		var editionId *uint
+		if edition != nil {
+			editionId = &edition.ID
+		}
+
+		if level, err = stockLevel(tx, warehouse.ID, book.ID, editionId); err != nil {
+			return err
+		}
+
+		return setStockLevel(tx, &level, payload.Quantity, model.StockMovement{
+			Kind:      model.StockAdjustment,
+			ActorID:   stockActor(r.Context()),
+			Reason:    "stock count",
+			Reference: model.StockRef("warehouses", warehouse.ID),
+		})
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, level, ""}
+	res.Dispatch()
+}
+
+// stockLevel locks the stock levels of an edition, or a book without
+// editions, and returns its level in a warehouse. A level it has no stock
+// in yet is new and empty, unless it has no levels at all, then its copies
+// are taken to be kept there.
+func stockLevel(tx *gorm.DB, warehouseId uint, bookId uint, editionId *uint) (model.StockLevel, error) {
+	levels := []model.StockLevel{}
+	if err := itemLevels(tx.Clauses(clause.Locking{Strength: "UPDATE"}), bookId, editionId).Order("id").Find(&levels).Error; err != nil {
+		return model.StockLevel{}, err
+	}
+
+	for _, level := range levels {
+		if level.WarehouseID == warehouseId {
+			return level, nil
+		}
+	}
+
+	level := model.StockLevel{WarehouseID: warehouseId, BookID: bookId, EditionID: editionId}
+	if len(levels) == 0 {
+		copies, err := itemCopies(tx, bookId, editionId)
+		if err != nil {
+			return level, err
+		}
+		level.Quantity = copies
+	}
+
+	return level, nil
+}
+
+// setStockLevel saves a stock level with quantity copies and changes the
+// available copies of its edition, or book, by the difference, recorded as
+// movement
+func setStockLevel(tx *gorm.DB, level *model.StockLevel, quantity int, movement model.StockMovement) error {
+	change := quantity - level.Quantity
+	level.Quantity = quantity
+
+	if err := tx.Save(level).Error; err != nil {
+		return err
+	}
+
+	if change == 0 {
+		return nil
+	}
+
+	if err := adjustStock(tx, level.BookID, level.EditionID, change); err != nil {
+		return err
+	}
+
+	movement.BookID = level.BookID
+	movement.EditionID = level.EditionID
+	movement.WarehouseID = &level.WarehouseID
+	movement.Quantity = change
+
+	return recordStock(tx, &movement)
+}
+
+// GetTransfers lists the transfers between warehouses, newest first
+func GetTransfers(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	transfers := []model.WarehouseTransfer{}
+
+	if err := db.Order("id DESC").Find(&transfers).Error; err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusOK, transfers, ""}
+	res.Dispatch()
+}
+
+// TransferStock moves copies of a book, or one of its editions, from one
+// warehouse to another. The available copies do not change.
+func TransferStock(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	payload := model.WarehouseTransferPayload{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		res := ErrorResponse{w, r, ErrMalformedRequest.Wrap(err)}
+		res.Dispatch()
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := validate.Struct(&payload); err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	transfer := model.WarehouseTransfer{
+		FromWarehouseID: uint(payload.FromWarehouseId),
+		ToWarehouseID:   uint(payload.ToWarehouseId),
+		Quantity:        payload.Quantity,
+		CreatedBy:       r.Context().Value("user_id").(uint),
+		Note:            payload.Note,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		count := int64(0)
+		if err := tx.Model(&model.Warehouse{}).Where("id IN ?", []uint{transfer.FromWarehouseID, transfer.ToWarehouseID}).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count != 2 {
+			return ErrWarehouseNotFound.WithDetail("warehouse %d or %d does not exist", transfer.FromWarehouseID, transfer.ToWarehouseID)
+		}
+
+		book, edition, err := purchaseItem(tx, &model.PurchasePayload{BookId: payload.BookId, EditionId: payload.EditionId})
+		if err != nil {
+			return err
+		}
+
+		transfer.BookID = book.ID
+		if edition != nil {
+			transfer.EditionID = &edition.ID
+		}
+
+		result := itemLevels(tx.Model(&model.StockLevel{}), transfer.BookID, transfer.EditionID).
+			Where("warehouse_id = ? AND quantity >= ?", transfer.FromWarehouseID, transfer.Quantity).
+			UpdateColumn("quantity", gorm.Expr("quantity - ?", transfer.Quantity))
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock.WithDetail("warehouse %d has fewer than %d copies to transfer", transfer.FromWarehouseID, transfer.Quantity)
+		}
+
+		result = itemLevels(tx.Model(&model.StockLevel{}), transfer.BookID, transfer.EditionID).
+			Where("warehouse_id = ?", transfer.ToWarehouseID).
+			UpdateColumn("quantity", gorm.Expr("quantity + ?", transfer.Quantity))
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			if err := tx.Create(&model.StockLevel{
+				WarehouseID: transfer.ToWarehouseID,
+				BookID:      transfer.BookID,
+				EditionID:   transfer.EditionID,
+				Quantity:    transfer.Quantity,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		// books show their stock per warehouse, so a transfer changes them
+		err = tx.Model(&model.Book{}).Where("id = ?", transfer.BookID).
+			UpdateColumn("version", gorm.Expr("version + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+
+		for _, side := range []struct {
+			warehouseId uint
+			quantity    int
+		}{
+			{transfer.FromWarehouseID, -transfer.Quantity},
+			{transfer.ToWarehouseID, transfer.Quantity},
+		} {
+			if err := recordStock(tx, &model.StockMovement{
+				BookID:      transfer.BookID,
+				EditionID:   transfer.EditionID,
+				WarehouseID: &side.warehouseId,
+				Kind:        model.StockTransfer,
+				Quantity:    side.quantity,
+				ActorID:     &transfer.CreatedBy,
+				Reason:      transfer.Note,
+				Reference:   model.StockRef("warehouse_transfers", transfer.ID),
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		res := ErrorResponse{w, r, err}
+		res.Dispatch()
+		return
+	}
+
+	res := SuccessResponse{w, http.StatusCreated, transfer, "copies transferred"}
+	res.Dispatch()
+}
+
+// warehouseLevel is a stock level with what allocation orders it by
+type warehouseLevel struct {
+	model.StockLevel
+	City     string
+	Priority int
+}
+
+// warehouseLevels locks the stock levels of an edition, or of a book
+// without editions, in priority order
+func warehouseLevels(tx *gorm.DB, bookId uint, editionId *uint) ([]warehouseLevel, error) {
+	levels := []warehouseLevel{}
+
+	err := itemLevels(tx.Table("stock_levels"), bookId, editionId).
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "stock_levels"}}).
+		Select("stock_levels.*, warehouses.city, warehouses.priority").
+		Joins("JOIN warehouses ON warehouses.id = stock_levels.warehouse_id AND warehouses.deleted_at IS NULL").
+		Order("warehouses.priority, warehouses.id").
+		Scan(&levels).Error
+
+	return levels, err
+}
+
+// allocateStock takes the copies of a sale or hold out of the warehouses
+// keeping them and records the movement once per warehouse. A warehouse
+// able to ship every copy is preferred to splitting them. Copies not kept
+// per warehouse are recorded as they are.
+func allocateStock(tx *gorm.DB, movement model.StockMovement) error {
+	levels, err := warehouseLevels(tx, movement.BookID, movement.EditionID)
+	if err != nil {
+		return err
+	}
+
+	if len(levels) == 0 {
+		return recordStock(tx, &movement)
+	}
+
+	// the warehouses in the city of the customer go first
+	if allocationRule == AllocationNearest && len(levels) > 1 && movement.ActorID != nil {
+		city := ""
+		if err := tx.Model(&model.User{}).Where("id = ?", *movement.ActorID).Select("city").Scan(&city).Error; err != nil {
+			return err
+		}
+
+		sort.SliceStable(levels, func(i, j int) bool {
+			return city != "" && strings.EqualFold(levels[i].City, city) && !strings.EqualFold(levels[j].City, city)
+		})
+	}
+
+	picks := pickWarehouses(levels, -movement.Quantity)
+	if picks == nil {
+		return ErrInsufficientStock.WithDetail("the warehouses hold fewer than %d copies", -movement.Quantity)
+	}
+
+	for _, pick := range picks {
+		if err := tx.Model(&model.StockLevel{}).Where("id = ?", pick.ID).
+			UpdateColumn("quantity", gorm.Expr("quantity - ?", pick.Quantity)).Error; err != nil {
+			return err
+		}
+
+		taken := movement
+		taken.WarehouseID = &pick.WarehouseID
+		taken.Quantity = -pick.Quantity
+
+		if err := recordStock(tx, &taken); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pickWarehouses picks the levels quantity copies are taken from, in
+// order, each with the copies taken from it. It is nil when the levels
+// hold fewer copies.
+func pickWarehouses(levels []warehouseLevel, quantity int) []warehouseLevel {
+	for _, level := range levels {
+		if level.Quantity >= quantity {
+			level.Quantity = quantity
+			return []warehouseLevel{level}
+		}
+	}
+
+	picks := []warehouseLevel{}
+	for _, level := range levels {
+		if quantity == 0 {
+			break
+		}
+		if level.Quantity <= 0 {
+			continue
+		}
+
+		level.Quantity = min(level.Quantity, quantity)
+		quantity -= level.Quantity
+		picks = append(picks, level)
+	}
+
+	if quantity > 0 {
+		return nil
+	}
+	return picks
+}
+
+// returnStock puts the copies of a movement back in the warehouses the
+// movements of from took them out of, and records it once per warehouse.
+// Copies taken before their book was kept per warehouse go to its first
+// warehouse, copies not kept per warehouse are recorded as they are.
+func returnStock(tx *gorm.DB, movement model.StockMovement, from string) error {
+	type warehouseCopies struct {
+		WarehouseID uint
+		Quantity    int
+	}
+
+	taken := []warehouseCopies{}
+
+	err := tx.Model(&model.StockMovement{}).
+		Select("warehouse_id, -SUM(quantity) AS quantity").
+		Where("reference = ? AND warehouse_id IS NOT NULL", from).
+		Group("warehouse_id").
+		Having("SUM(quantity) < 0").
+		Order("warehouse_id").
+		Scan(&taken).Error
+	if err != nil {
+		return err
+	}
+
+	if len(taken) == 0 {
+		levels, err := warehouseLevels(tx, movement.BookID, movement.EditionID)
+		if err != nil {
+			return err
+		}
+
+		if len(levels) == 0 {
+			return recordStock(tx, &movement)
+		}
+
+		taken = append(taken, warehouseCopies{levels[0].WarehouseID, movement.Quantity})
+	}
+
+	left := movement.Quantity
+	for i, t := range taken {
+		quantity := min(left, t.Quantity)
+		// copies beyond what was taken go to the last warehouse
+		if i == len(taken)-1 {
+			quantity = left
+		}
+		if quantity <= 0 {
+			continue
+		}
+
+		if err := itemLevels(tx.Model(&model.StockLevel{}), movement.BookID, movement.EditionID).
+			Where("warehouse_id = ?", t.WarehouseID).
+			UpdateColumn("quantity", gorm.Expr("quantity + ?", quantity)).Error; err != nil {
+			return err
+		}
+
+		returned := movement
+		returned.WarehouseID = &t.WarehouseID
+		returned.Quantity = quantity
+
+		if err := recordStock(tx, &returned); err != nil {
+			return err
+		}
+
+		left -= quantity
+	}
+
+	return nil
+}
+
+// receiveStock puts delivered copies in stock, in warehouseId or, when it
+// is 0, the first warehouse keeping them, and records it. The first
+// delivery of an edition, or a book without editions, to a warehouse takes
+// its copies to be kept there, like its first stock count. Copies not kept
+// per warehouse are recorded as they are.
+func receiveStock(tx *gorm.DB, movement model.StockMovement, warehouseId uint) error {
+	if warehouseId == 0 {
+		levels, err := warehouseLevels(tx, movement.BookID, movement.EditionID)
+		if err != nil {
+			return err
+		}
+
+		if len(levels) == 0 {
+			if err := adjustStock(tx, movement.BookID, movement.EditionID, movement.Quantity); err != nil {
+				return err
+			}
+			return recordStock(tx, &movement)
+		}
+
+		warehouseId = levels[0].WarehouseID
+	}
+
+	level, err := stockLevel(tx, warehouseId, movement.BookID, movement.EditionID)
+	if err != nil {
+		return err
+	}
+
+	return setStockLevel(tx, &level, level.Quantity+movement.Quantity, movement)
+}
+
+// itemCopies are the available copies of an edition, or of a book without
+// editions
+func itemCopies(tx *gorm.DB, bookId uint, editionId *uint) (int, error) {
+	copies := 0
+
+	if editionId != nil {
+		err := tx.Model(&model.Edition{}).Where("id = ?", *editionId).Select("available_copies").Scan(&copies).Error
+		return copies, err
+	}
+
+	err := tx.Model(&model.Book{}).Where("id = ?", bookId).Select("available_copies").Scan(&copies).Error
+	return copies, err
+}
+
+// checkWarehouseStock rejects changing the copies of an edition, or a book
+// without editions, kept per warehouse directly
+func checkWarehouseStock(tx *gorm.DB, bookId uint, editionId *uint) error {
+	count := int64(0)
+	if err := itemLevels(tx.Model(&model.StockLevel{}), bookId, editionId).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrWarehouseStock.WithDetail("copies of book %d are kept in %d warehouses", bookId, count)
+	}
+	return nil
+}
+
+// itemLevels scopes stock levels to an edition, or to a book without
+// editions when editionId is nil
+func itemLevels(tx *gorm.DB, bookId uint, editionId *uint) *gorm.DB {
+	if editionId == nil {
+		return tx.Where("stock_levels.book_id = ? AND stock_levels.edition_id IS NULL", bookId)
+	}
+	return tx.Where("stock_levels.edition_id = ?", *editionId)
+}
+
+// bookAvailability fills in the stock of books per warehouse
+func bookAvailability(db *gorm.DB, books ...*model.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+
+	stock, err := model.FindWarehouseStock(db, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		book.Availability = stock[book.ID]
+	}
+
+	return nil
+}
+
+func setWarehouseFields(warehouse *model.Warehouse, payload model.WarehousePayload) {
+	warehouse.Code = payload.Code
+	warehouse.Name = payload.Name
+	warehouse.City = payload.City
+	warehouse.Priority = payload.Priority
+}
+
+func checkWarehouseCodeAvailable(db *gorm.DB, warehouse *model.Warehouse) error {
+	count := int64(0)
+	if err := db.Unscoped().Model(&model.Warehouse{}).
+		Where("LOWER(code) = LOWER(?) AND id <> ?", warehouse.Code, warehouse.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrWarehouseExists.WithDetail("warehouse %s already exists", warehouse.Code)
+	}
+	return nil
+}
+
+func warehouseIdParam(r *http.Request) (int, error) {
+	warehouseIdStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, ErrInvalidID.WithDetail("id is required")
+	}
+
+	warehouseId, err := strconv.Atoi(warehouseIdStr)
+	if err != nil {
+		return 0, ErrInvalidID.WithDetail("invalid warehouse id")
+	}
+
+	return warehouseId, nil
+}
@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/peekeah/book-store/model"
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
+
+// expectNoWarehouses expects the stock levels of an item not kept per
+// warehouse to be looked up
+func expectNoWarehouses(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`^SELECT (.+) FROM "stock_levels" JOIN warehouses`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectNoWarehouseReturn expects copies not kept per warehouse to be
+// looked up before they are put back
+func expectNoWarehouseReturn(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`^SELECT warehouse_id, -SUM\(quantity\) AS quantity FROM "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "quantity"}))
+	expectNoWarehouses(mock)
+}
+
+// expectNoAvailability expects books not kept per warehouse to be looked up
+// for their availability
+func expectNoAvailability(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM stock_levels l`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "warehouse_id", "code", "city", "copies"}))
+}
+
+// expectNoWarehouseStock expects an item not kept per warehouse to be
+// looked up before its copies are changed
+func expectNoWarehouseStock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "stock_levels"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+}
+
+var levelColumns = []string{"id", "warehouse_id", "book_id", "edition_id", "quantity", "city", "priority"}
+
+func TestPickWarehouses(t *testing.T) {
+	levels := []warehouseLevel{
+		{StockLevel: model.StockLevel{WarehouseID: 1, Quantity: 2}},
+		{StockLevel: model.StockLevel{WarehouseID: 2, Quantity: 0}},
+		{StockLevel: model.StockLevel{WarehouseID: 3, Quantity: 4}},
+	}
+
+	tests := []struct {
+		quantity int
+		want     string
+	}{
+		{2, "[1:2]"},
+		{3, "[3:3]"},
+		{5, "[1:2 3:3]"},
+		{6, "[1:2 3:4]"},
+		{7, "[]"},
+	}
+
+	for _, tc := range tests {
+		picks := []string{}
+		for _, pick := range pickWarehouses(levels, tc.quantity) {
+			picks = append(picks, fmt.Sprintf("%d:%d", pick.WarehouseID, pick.Quantity))
+		}
+
+		if got := fmt.Sprint(picks); got != tc.want {
+			t.Errorf("picking %d copies: expected %s, got %s", tc.quantity, tc.want, got)
+		}
+	}
+}
+
+func TestAllocateStock_Nearest(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	actor := uint(3)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "stock_levels" JOIN warehouses (.+) FOR UPDATE OF "stock_levels"`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(levelColumns).
+			AddRow(10, 1, 1, nil, 5, "Pune", 0).
+			AddRow(11, 2, 1, nil, 5, "Delhi", 1))
+	mock.ExpectQuery(`^SELECT "city" FROM "users" WHERE id = \$1`).
+		WithArgs(actor).
+		WillReturnRows(sqlmock.NewRows([]string{"city"}).AddRow("delhi"))
+	mock.ExpectExec(`^UPDATE "stock_levels" SET "quantity"=quantity - \$1 WHERE id = \$2`).
+		WithArgs(2, 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, 2, model.StockSale, -2, actor, "", "purchases:7").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return allocateStock(tx, model.StockMovement{
+			BookID:    1,
+			Kind:      model.StockSale,
+			Quantity:  -2,
+			ActorID:   &actor,
+			Reference: "purchases:7",
+		})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestTransferStock(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	transfer := func(quantity int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"from_warehouse_id": 1, "to_warehouse_id": 2, "book_id": 1, "quantity": quantity})
+		req, _ := http.NewRequest(http.MethodPost, "/warehouses/transfers", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(9)))
+		w := httptest.NewRecorder()
+		TransferStock(db, w, req)
+		return w
+	}
+
+	expectItem := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`^SELECT count\(\*\) FROM "warehouses" WHERE id IN \(\$1,\$2\)`).
+			WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "available_copies"}).AddRow(1, "Book1", 5))
+		mock.ExpectQuery(`^SELECT (.+) FROM "editions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+	}
+
+	expectItem()
+	mock.ExpectExec(`^UPDATE "stock_levels" SET "quantity"=quantity - \$1 WHERE (.+) AND \(warehouse_id = \$3 AND quantity >= \$4\)`).
+		WithArgs(3, 1, 1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`^UPDATE "stock_levels" SET "quantity"=quantity \+ \$1 WHERE (.+) AND warehouse_id = \$3`).
+		WithArgs(3, 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`^INSERT INTO "stock_levels"`).
+		WithArgs(sqlmock.AnyArg(), 2, 1, nil, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec(`^UPDATE "books" SET "version"=version \+ 1 WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "warehouse_transfers"`).
+		WithArgs(sqlmock.AnyArg(), 1, 2, 1, nil, 3, 9, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, 1, model.StockTransfer, -3, 9, "", "warehouse_transfers:4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, 2, model.StockTransfer, 3, 9, "", "warehouse_transfers:4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	if w := transfer(3); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the source warehouse does not hold enough copies
+	expectItem()
+	mock.ExpectExec(`^UPDATE "stock_levels" SET "quantity"=quantity - \$1`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	w := transfer(8)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Code != CodeInsufficientStock {
+		t.Fatalf("expected code %s, got %s", CodeInsufficientStock, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestTransferStock_SameWarehouse(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	body := []byte(`{"from_warehouse_id":1,"to_warehouse_id":1,"book_id":1,"quantity":3}`)
+	req, _ := http.NewRequest(http.MethodPost, "/warehouses/transfers", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", uint(9)))
+	w := httptest.NewRecorder()
+
+	TransferStock(db, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Code != CodeValidationFailed || len(res.Fields) != 1 || res.Fields[0].Field != "to_warehouse_id" {
+		t.Fatalf("expected to_warehouse_id to be rejected, got %s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateBook_WarehouseStock(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "published_year", "available_copies", "price_minor", "price_currency", "version"}).
+			AddRow(1, "Book1", "Author1", 1999, 4, 200, "USD", 1))
+	mock.ExpectBegin()
+	expectNoEditions(mock)
+	mock.ExpectExec(`^UPDATE "books" SET`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "stock_levels" WHERE stock_levels.book_id = \$1 AND stock_levels.edition_id IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	req, _ := http.NewRequest(http.MethodPatch, "/books/1", bytes.NewReader([]byte(`{"available_copies":0}`)))
+	req.Header.Set("Content-Type", mergePatchMediaType)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	UpdateBook(db, w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	res := ErrorJSON{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Code != CodeWarehouseStock {
+		t.Fatalf("expected code %s, got %s", CodeWarehouseStock, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReceiveStock_FirstWarehouse(t *testing.T) {
+	db, mock := utils.GetDBMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT (.+) FROM "stock_levels" WHERE (.+) FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`^SELECT "available_copies" FROM "books" WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"available_copies"}).AddRow(4))
+	mock.ExpectQuery(`^INSERT INTO "stock_levels"`).
+		WithArgs(sqlmock.AnyArg(), 2, 1, nil, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec(`^UPDATE "books" SET "available_copies"=available_copies \+ \$1,"version"=version \+ 1,"updated_at"=\$2 WHERE id = \$3`).
+		WithArgs(6, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^INSERT INTO "stock_movements"`).
+		WithArgs(sqlmock.AnyArg(), 1, nil, 2, model.StockReceipt, 6, nil, "", "supplier_orders:3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// the copies already in stock are taken to be kept in the warehouse too
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return receiveStock(tx, model.StockMovement{
+			BookID:    1,
+			Kind:      model.StockReceipt,
+			Quantity:  6,
+			Reference: "supplier_orders:3",
+		}, 2)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Roles a contributor can have on a book
+const (
+	RoleAuthor      = "author"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+)
+
+type Author struct {
+	gorm.Model
+
+	Name    string   `json:"name" validate:"required"`
+	Bio     string   `json:"bio,omitempty"`
+	Aliases []string `json:"aliases" gorm:"serializer:json"`
+	Version uint     `json:"version" gorm:"not null;default:1"`
+}
+
+func (a *Author) BeforeCreate(tx *gorm.DB) error {
+	if a.Version == 0 {
+		a.Version = 1
+	}
+	return nil
+}
+
+// AuthorFields are the client editable attributes of an author.
+type AuthorFields struct {
+	Name    string   `json:"name" validate:"required"`
+	Bio     string   `json:"bio"`
+	Aliases []string `json:"aliases" validate:"dive,required"`
+}
+
+// AuthorFieldColumns are the columns written when AuthorFields are saved,
+// version is bumped along with them.
+var AuthorFieldColumns = []string{"name", "bio", "aliases", "version"}
+
+func (a *Author) Fields() AuthorFields {
+	return AuthorFields{
+		Name:    a.Name,
+		Bio:     a.Bio,
+		Aliases: a.Aliases,
+	}
+}
+
+func (a *Author) SetFields(f AuthorFields) {
+	a.Name = f.Name
+	a.Bio = f.Bio
+	a.Aliases = f.Aliases
+}
+
+// Matches reports whether name is the author's name or one of the aliases,
+// ignoring case, spacing and punctuation.
+func (a *Author) Matches(name string) bool {
+	key := AuthorKey(name)
+	if key == AuthorKey(a.Name) {
+		return true
+	}
+
+	for _, alias := range a.Aliases {
+		if key == AuthorKey(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorKey reduces a name to lower case letters and digits so spelling
+// variants such as "J.R.R. Tolkien" and "JRR Tolkien" compare equal.
+func AuthorKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// BookAuthor credits an author on a book with a role. Position orders the
+// credits of a book.
+type BookAuthor struct {
+	BookID   uint   `json:"book_id" gorm:"primaryKey"`
+	AuthorID uint   `json:"author_id" gorm:"primaryKey;index"`
+	Role     string `json:"role" gorm:"primaryKey;size:20"`
+	Position int    `json:"position"`
+
+	// Relations
+	Author *Author `json:"author,omitempty" gorm:"foreignKey:AuthorID;constraint:OnDelete:CASCADE;"`
+}
+
+// SplitAuthorNames splits a free text byline such as "Terry Pratchett & Neil
+// Gaiman" into the individual names.
+func SplitAuthorNames(byline string) []string {
+	byline = strings.NewReplacer(" & ", ",", " and ", ",", ";", ",").Replace(byline)
+
+	names := []string{}
+	for _, name := range strings.Split(byline, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Byline joins the names credited with the author role, in position order,
+// as stored in Book.Author.
+func Byline(credits []BookAuthor) string {
+	names := []string{}
+	for _, credit := range credits {
+		if credit.Role == RoleAuthor && credit.Author != nil {
+			names = append(names, credit.Author.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// RefreshBylines rewrites the byline of every book an author is credited on
+// in the author role, after the author was renamed.
+func RefreshBylines(tx *gorm.DB, authorId uint) error {
+	bookIds := []uint{}
+	err := tx.Model(&BookAuthor{}).Where("author_id = ? AND role = ?", authorId, RoleAuthor).
+		Order("book_id").Pluck("book_id", &bookIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, bookId := range bookIds {
+		credits := []BookAuthor{}
+		if err := tx.Preload("Author").Where("book_id = ?", bookId).Order("position").Find(&credits).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(&Book{}).Where("id = ?", bookId).
+			Updates(map[string]any{"author": Byline(credits), "version": gorm.Expr("version + 1")}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreditBylineAuthors replaces the author credits of a book with the
+// authors named in its byline, creating the authors that do not exist yet.
+// Credits in other roles are kept, after the authors.
+func CreditBylineAuthors(tx *gorm.DB, book *Book) error {
+	finder, err := newAuthorFinder(tx)
+	if err != nil {
+		return err
+	}
+
+	credits, err := finder.credits(book)
+	if err != nil {
+		return err
+	}
+
+	others := []BookAuthor{}
+	if err := tx.Where("book_id = ? AND role <> ?", book.ID, RoleAuthor).Order("position").Find(&others).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("book_id = ?", book.ID).Delete(&BookAuthor{}).Error; err != nil {
+		return err
+	}
+
+	for _, credit := range others {
+		credit.Position = len(credits)
+		credits = append(credits, credit)
+	}
+
+	if len(credits) == 0 {
+		return nil
+	}
+
+	return tx.Create(&credits).Error
+}
+
+// MigrateBookAuthors links every book without credits to authors parsed
+// from its Author string, creating the authors that do not exist yet. It
+// runs once, books created later are credited when they are saved.
+func MigrateBookAuthors(db *gorm.DB) error {
+	return runOnce(db, "book_authors", func(tx *gorm.DB) error {
+		books := []Book{}
+		err := tx.Where("author <> '' AND NOT EXISTS (SELECT 1 FROM book_authors WHERE book_authors.book_id = books.id)").
+			Find(&books).Error
+		if err != nil || len(books) == 0 {
+			return err
+		}
+
+		finder, err := newAuthorFinder(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, book := range books {
+			credits, err := finder.credits(&book)
+			if err != nil {
+				return err
+			}
+
+			if len(credits) == 0 {
+				continue
+			}
+
+			if err := tx.Create(&credits).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// authorFinder matches names to the existing authors, by name or alias
+type authorFinder struct {
+	tx      *gorm.DB
+	authors []*Author
+}
+
+func newAuthorFinder(tx *gorm.DB) (*authorFinder, error) {
+	authors := []*Author{}
+	if err := tx.Find(&authors).Error; err != nil {
+		return nil, err
+	}
+	return &authorFinder{tx: tx, authors: authors}, nil
+}
+
+// find returns the author called name, creating it when there is none
+func (f *authorFinder) find(name string) (*Author, error) {
+	for _, author := range f.authors {
+		if author.Matches(name) {
+			return author, nil
+		}
+	}
+
+	author := &Author{Name: name}
+	if err := f.tx.Create(author).Error; err != nil {
+		return nil, err
+	}
+	f.authors = append(f.authors, author)
+	return author, nil
+}
+
+// credits credits the authors named in the byline of book, in order
+func (f *authorFinder) credits(book *Book) ([]BookAuthor, error) {
+	credits := []BookAuthor{}
+	for _, name := range SplitAuthorNames(book.Author) {
+		author, err := f.find(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if containsAuthor(credits, author.ID) {
+			continue
+		}
+
+		credits = append(credits, BookAuthor{
+			BookID:   book.ID,
+			AuthorID: author.ID,
+			Role:     RoleAuthor,
+			Position: len(credits),
+		})
+	}
+	return credits, nil
+}
+
+func containsAuthor(credits []BookAuthor, authorId uint) bool {
+	for _, credit := range credits {
+		if credit.AuthorID == authorId {
+			return true
+		}
+	}
+	return false
+}
@@ -1,23 +1,121 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"github.com/peekeah/book-store/utils"
+	"gorm.io/gorm"
+)
 
 type Book struct {
 	gorm.Model
 
+	Name            string  `json:"name" validate:"required"`
+	Author          string  `json:"author" validate:"required"`
+	ISBN13          *string `json:"isbn,omitempty" gorm:"column:isbn13;size:13;uniqueIndex"`
+	ISBN10          *string `json:"isbn_10,omitempty" gorm:"column:isbn10;size:10"`
+	PublishedYear   int     `json:"published_year" validate:"required,year"`
+	AvailableCopies int     `json:"available_copies" validate:"min=0"`
+	// ReorderPoint is the stock below which the book is reordered, 0 turns
+	// low stock alerts off. ReorderQuantity is how many copies to reorder.
+	ReorderPoint    int        `json:"reorder_point" validate:"min=0"`
+	ReorderQuantity int        `json:"reorder_quantity" validate:"min=0"`
+	Price           Money      `json:"price" gorm:"embedded;embeddedPrefix:price_" validate:"money"`
+	Cover           *BookCover `json:"cover,omitempty" gorm:"serializer:json"`
+	Version         uint       `json:"version" gorm:"not null;default:1"`
+	Purchases       []Purchase
+	Credits         []BookAuthor `json:"credits,omitempty" gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+	Categories      []Category   `json:"categories,omitempty" gorm:"many2many:book_categories;constraint:OnDelete:CASCADE;"`
+	Editions        []Edition    `json:"editions,omitempty" gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+	// Availability is the stock of the book per warehouse, its available
+	// copies are the sum of them. It is not stored.
+	Availability []WarehouseStock `json:"availability,omitempty" gorm:"-"`
+}
+
+func (b *Book) BeforeCreate(tx *gorm.DB) error {
+	if b.Version == 0 {
+		b.Version = 1
+	}
+	return nil
+}
+
+// BookCover is an uploaded cover image. URLs maps each rendition, the
+// original and the thumbnail sizes, to where it can be fetched.
+type BookCover struct {
+	Hash        string            `json:"hash"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	URLs        map[string]string `json:"urls"`
+}
+
+// BookFields are the client editable attributes of a book. Unlike Book they
+// have no omitempty so zero values are written on update. ISBN accepts
+// either form and is stored as ISBN-13.
+type BookFields struct {
 	Name            string `json:"name" validate:"required"`
 	Author          string `json:"author" validate:"required"`
-	PublishedYear   int    `json:"published_year" validate:"required"`
-	AvailableCopies int    `json:"available_copies" validate:"required"`
-	Price           int    `json:"price" validate:"required"`
-	Purchases       []Purchase
+	ISBN            string `json:"isbn" validate:"omitempty,isbn"`
+	PublishedYear   int    `json:"published_year" validate:"required,year"`
+	AvailableCopies int    `json:"available_copies" validate:"min=0"`
+	ReorderPoint    int    `json:"reorder_point" validate:"min=0"`
+	ReorderQuantity int    `json:"reorder_quantity" validate:"min=0"`
+	Price           Money  `json:"price" validate:"money"`
 }
 
-type UpdateBook struct {
-	ID              uint   `json:"ID,omitempty"`
-	Name            string `json:"name,omitempty"`
-	Author          string `json:"author,omitempty"`
-	Price           int    `json:"price,omitempty"`
-	PublishedYear   int    `json:"published_year,omitempty"`
-	AvailableCopies int    `json:"available_copies,omitempty"`
+// BookFieldColumns are the columns written when BookFields are saved,
+// version is bumped along with them.
+var BookFieldColumns = []string{"name", "author", "isbn13", "isbn10", "published_year", "available_copies", "reorder_point", "reorder_quantity", "price_minor", "price_currency", "version"}
+
+// BookEditionColumns are the BookFieldColumns of a book with editions, its
+// stock and price are derived from them.
+var BookEditionColumns = []string{"name", "author", "isbn13", "isbn10", "published_year", "reorder_point", "reorder_quantity", "price_currency", "version"}
+
+func (b *Book) Fields() BookFields {
+	return BookFields{
+		Name:            b.Name,
+		Author:          b.Author,
+		ISBN:            b.ISBN(),
+		PublishedYear:   b.PublishedYear,
+		AvailableCopies: b.AvailableCopies,
+		ReorderPoint:    b.ReorderPoint,
+		ReorderQuantity: b.ReorderQuantity,
+		Price:           b.Price,
+	}
+}
+
+func (b *Book) SetFields(f BookFields) {
+	b.Name = f.Name
+	b.Author = f.Author
+	b.SetISBN(f.ISBN)
+	b.PublishedYear = f.PublishedYear
+	b.AvailableCopies = f.AvailableCopies
+	b.ReorderPoint = f.ReorderPoint
+	b.ReorderQuantity = f.ReorderQuantity
+	b.Price = f.Price.orDefaultCurrency()
+}
+
+// ISBN returns the normalized ISBN-13 of the book, or "" when it has none.
+func (b *Book) ISBN() string {
+	if b.ISBN13 == nil {
+		return ""
+	}
+	return *b.ISBN13
+}
+
+// SetISBN stores isbn in both forms. An empty or invalid isbn clears them.
+func (b *Book) SetISBN(isbn string) {
+	b.ISBN13, b.ISBN10 = splitISBN(isbn)
+}
+
+// splitISBN normalizes isbn into its ISBN-13 and, when one exists, ISBN-10
+// form. Both are nil for an empty or invalid isbn.
+func splitISBN(isbn string) (*string, *string) {
+	isbn13 := utils.NormalizeISBN(isbn)
+	if isbn13 == "" {
+		return nil, nil
+	}
+
+	if isbn10 := utils.ISBN10(isbn13); isbn10 != "" {
+		return &isbn13, &isbn10
+	}
+	return &isbn13, nil
 }
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Category is a node of the browsing tree. Path is a materialized path of
+// ancestor ids, "/1/4/" for category 4 under category 1, so a subtree is a
+// single prefix match.
+type Category struct {
+	gorm.Model
+
+	Name     string `json:"name" validate:"required"`
+	Slug     string `json:"slug" gorm:"size:100;uniqueIndex" validate:"required,slug"`
+	ParentID *uint  `json:"parent_id" gorm:"index"`
+	Position int    `json:"position"`
+	Path     string `json:"path" gorm:"size:255;index"`
+	Version  uint   `json:"version" gorm:"not null;default:1"`
+
+	// Relations
+	Parent *Category `json:"-" gorm:"foreignKey:ParentID;constraint:OnDelete:RESTRICT;"`
+}
+
+func (c *Category) BeforeCreate(tx *gorm.DB) error {
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	return nil
+}
+
+// CategoryFields are the client editable attributes of a category. Changing
+// ParentID moves the category with its subtree, Position orders siblings.
+type CategoryFields struct {
+	Name     string `json:"name" validate:"required"`
+	Slug     string `json:"slug" validate:"omitempty,slug,max=100"`
+	ParentID *uint  `json:"parent_id"`
+	Position int    `json:"position" validate:"min=0"`
+}
+
+// CategoryFieldColumns are the columns written when CategoryFields are
+// saved. The path is rewritten along with the parent.
+var CategoryFieldColumns = []string{"name", "slug", "parent_id", "position", "path", "version"}
+
+func (c *Category) Fields() CategoryFields {
+	return CategoryFields{
+		Name:     c.Name,
+		Slug:     c.Slug,
+		ParentID: c.ParentID,
+		Position: c.Position,
+	}
+}
+
+func (c *Category) SetFields(f CategoryFields) {
+	c.Name = f.Name
+	c.Slug = f.Slug
+	c.ParentID = f.ParentID
+	c.Position = f.Position
+}
+
+// ChildPath is the path of a direct child with the given id.
+func (c *Category) ChildPath(id uint) string {
+	parent := c.Path
+	if parent == "" {
+		parent = "/"
+	}
+	return fmt.Sprintf("%s%d/", parent, id)
+}
+
+// IsAncestorOf reports whether other is c or lies in the subtree of c.
+func (c *Category) IsAncestorOf(other *Category) bool {
+	return c.Path != "" && strings.HasPrefix(other.Path, c.Path)
+}
+
+// MoveSubtree rewrites the path of every category under oldPath to start
+// with newPath instead.
+func MoveSubtree(tx *gorm.DB, oldPath, newPath string) error {
+	return tx.Model(&Category{}).
+		Where("path LIKE ?", oldPath+"%").
+		Update("path", gorm.Expr("? || SUBSTRING(path FROM ?)", newPath, len(oldPath)+1)).Error
+}
+
+// BookCategorySlugs returns the slugs of the categories a book is filed
+// under along with all their ancestors.
+func BookCategorySlugs(tx *gorm.DB, bookID uint) ([]string, error) {
+	slugs := []string{}
+
+	err := tx.Model(&Category{}).
+		Where("EXISTS (SELECT 1 FROM book_categories JOIN categories AS filed ON filed.id = book_categories.category_id "+
+			"WHERE book_categories.book_id = ? AND filed.path LIKE '%/' || categories.id || '/%')", bookID).
+		Pluck("slug", &slugs).Error
+
+	return slugs, err
+}
+
+// BookCategory files a book under a category.
+type BookCategory struct {
+	BookID     uint `json:"book_id" gorm:"primaryKey"`
+	CategoryID uint `json:"category_id" gorm:"primaryKey;index"`
+}
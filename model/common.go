@@ -1,8 +1,37 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
 
 func DBMigrate(db *gorm.DB) *gorm.DB {
-	db.AutoMigrate(&User{}, &Book{}, &Purchase{})
+	db.SetupJoinTable(&Book{}, "Categories", &BookCategory{})
+	db.AutoMigrate(&User{}, &Book{}, &Purchase{}, &Author{}, &BookAuthor{}, &Category{}, &Publisher{}, &Edition{}, &PurchaseTax{}, &Coupon{}, &CouponBook{}, &CouponCategory{}, &Promotion{}, &PurchaseDiscount{}, &PaymentEvent{}, &IdempotencyKey{}, &ReturnRequest{}, &Refund{}, &Reservation{}, &StockMovement{}, &Warehouse{}, &StockLevel{}, &WarehouseTransfer{}, &Supplier{}, &SupplierOrder{}, &SupplierOrderItem{}, &Stocktake{}, &StocktakeLine{}, &DataMigration{})
 	return db
 }
+
+// DataMigration marks a one-off data migration as done
+type DataMigration struct {
+	Name      string `gorm:"primaryKey;size:100"`
+	AppliedAt time.Time
+}
+
+// runOnce runs migrate in a transaction unless the migration called name
+// was done before. Servers starting together wait for the first one.
+func runOnce(db *gorm.DB, name string, migrate func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&DataMigration{Name: name, AppliedAt: time.Now()})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		return migrate(tx)
+	})
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon kinds
+const (
+	CouponPercent = "percent"
+	CouponFixed   = "fixed"
+)
+
+// Coupon is a discount code redeemed at purchase time. Percent coupons take
+// PercentOff percent off the purchase, fixed ones AmountOff. A coupon with
+// eligible books or categories only applies to those books and the books
+// filed under those categories or their subcategories. Zero limits are
+// unlimited.
+type Coupon struct {
+	gorm.Model
+
+	Code           string     `json:"code" gorm:"size:50;uniqueIndex;not null"`
+	Kind           string     `json:"kind" gorm:"size:10;not null"`
+	PercentOff     int        `json:"percent_off"`
+	AmountOff      Money      `json:"amount_off" gorm:"embedded;embeddedPrefix:amount_off_"`
+	MinSpend       Money      `json:"min_spend" gorm:"embedded;embeddedPrefix:min_spend_"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	MaxPerUser     int        `json:"max_per_user"`
+	Redemptions    int        `json:"redemptions" gorm:"not null;default:0"`
+	Version        uint       `json:"version" gorm:"not null;default:1"`
+
+	// BookIDs and CategoryIDs are stored in coupon_books and
+	// coupon_categories
+	BookIDs     []uint `json:"book_ids" gorm:"-"`
+	CategoryIDs []uint `json:"category_ids" gorm:"-"`
+}
+
+func (c *Coupon) BeforeCreate(tx *gorm.DB) error {
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	return nil
+}
+
+// CouponFields are the client editable attributes of a coupon.
+type CouponFields struct {
+	Code           string     `json:"code" validate:"required,max=50,coupon_code"`
+	Kind           string     `json:"kind" validate:"required,oneof=percent fixed"`
+	PercentOff     int        `json:"percent_off" validate:"required_if=Kind percent,min=0,max=100"`
+	AmountOff      Money      `json:"amount_off" validate:"money"`
+	MinSpend       Money      `json:"min_spend" validate:"money"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions" validate:"min=0"`
+	MaxPerUser     int        `json:"max_per_user" validate:"min=0"`
+	BookIDs        []uint     `json:"book_ids"`
+	CategoryIDs    []uint     `json:"category_ids"`
+}
+
+// CouponFieldColumns are the columns written when CouponFields are saved,
+// version is bumped along with them. Eligible books and categories are
+// saved separately.
+var CouponFieldColumns = []string{
+	"code", "kind", "percent_off", "amount_off_minor", "amount_off_currency", "min_spend_minor", "min_spend_currency",
+	"starts_at", "expires_at", "max_redemptions", "max_per_user", "version",
+}
+
+func (c *Coupon) Fields() CouponFields {
+	return CouponFields{
+		Code:           c.Code,
+		Kind:           c.Kind,
+		PercentOff:     c.PercentOff,
+		AmountOff:      c.AmountOff,
+		MinSpend:       c.MinSpend,
+		StartsAt:       c.StartsAt,
+		ExpiresAt:      c.ExpiresAt,
+		MaxRedemptions: c.MaxRedemptions,
+		MaxPerUser:     c.MaxPerUser,
+		BookIDs:        c.BookIDs,
+		CategoryIDs:    c.CategoryIDs,
+	}
+}
+
+func (c *Coupon) SetFields(f CouponFields) {
+	c.Code = NormalizeCouponCode(f.Code)
+	c.Kind = f.Kind
+	c.PercentOff = f.PercentOff
+	c.AmountOff = f.AmountOff.orDefaultCurrency()
+	c.MinSpend = f.MinSpend.orDefaultCurrency()
+	c.StartsAt = f.StartsAt
+	c.ExpiresAt = f.ExpiresAt
+	c.MaxRedemptions = f.MaxRedemptions
+	c.MaxPerUser = f.MaxPerUser
+	c.BookIDs = f.BookIDs
+	c.CategoryIDs = f.CategoryIDs
+
+	// only the amount of the coupon's kind is kept
+	if c.Kind == CouponPercent {
+		c.AmountOff = Zero(c.AmountOff.Currency)
+	} else {
+		c.PercentOff = 0
+	}
+}
+
+// Discount is what the coupon takes off amount, never more than amount.
+func (c *Coupon) Discount(amount Money) (Money, error) {
+	if c.Kind == CouponPercent {
+		return amount.Scale(int64(c.PercentOff), 100)
+	}
+
+	cmp, err := c.AmountOff.Cmp(amount)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if cmp > 0 {
+		return amount, nil
+	}
+	return c.AmountOff, nil
+}
+
+// AppliesTo reports whether the coupon can be used on a book. Coupons
+// without eligible books or categories apply to every book.
+func (c *Coupon) AppliesTo(tx *gorm.DB, bookID uint) (bool, error) {
+	applies := false
+
+	err := tx.Raw("SELECT (NOT EXISTS (SELECT 1 FROM coupon_books WHERE coupon_id = ?) "+
+		"AND NOT EXISTS (SELECT 1 FROM coupon_categories WHERE coupon_id = ?)) "+
+		"OR EXISTS (SELECT 1 FROM coupon_books WHERE coupon_id = ? AND book_id = ?) "+
+		"OR EXISTS (SELECT 1 FROM coupon_categories JOIN book_categories ON book_categories.book_id = ? "+
+		"JOIN categories AS filed ON filed.id = book_categories.category_id "+
+		"WHERE coupon_categories.coupon_id = ? AND filed.path LIKE '%/' || coupon_categories.category_id || '/%')",
+		c.ID, c.ID, c.ID, bookID, bookID, c.ID).
+		Scan(&applies).Error
+
+	return applies, err
+}
+
+// NormalizeCouponCode makes codes case insensitive, they are stored upper
+// case.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CouponBook makes a coupon eligible on a book.
+type CouponBook struct {
+	CouponID uint `json:"coupon_id" gorm:"primaryKey"`
+	BookID   uint `json:"book_id" gorm:"primaryKey;index"`
+
+	Coupon Coupon `json:"-" gorm:"foreignKey:CouponID;constraint:OnDelete:CASCADE;"`
+	Book   Book   `json:"-" gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+}
+
+// CouponCategory makes a coupon eligible on the books of a category and its
+// subcategories.
+type CouponCategory struct {
+	CouponID   uint `json:"coupon_id" gorm:"primaryKey"`
+	CategoryID uint `json:"category_id" gorm:"primaryKey;index"`
+
+	Coupon   Coupon   `json:"-" gorm:"foreignKey:CouponID;constraint:OnDelete:CASCADE;"`
+	Category Category `json:"-" gorm:"foreignKey:CategoryID;constraint:OnDelete:CASCADE;"`
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Edition formats a book can be sold in
+const (
+	FormatHardcover = "hardcover"
+	FormatPaperback = "paperback"
+	FormatEbook     = "ebook"
+	FormatAudiobook = "audiobook"
+)
+
+// Formats lists every edition format
+var Formats = []string{FormatHardcover, FormatPaperback, FormatEbook, FormatAudiobook}
+
+// Edition is the product that is actually sold: one format of a book with
+// its own ISBN, price and stock. The book keeps the total stock and the
+// lowest price of its editions for catalog listings.
+type Edition struct {
+	gorm.Model
+
+	BookID          uint       `json:"book_id" gorm:"index;not null"`
+	Format          string     `json:"format" gorm:"size:20;not null"`
+	PublisherID     *uint      `json:"publisher_id" gorm:"index"`
+	ISBN13          *string    `json:"isbn,omitempty" gorm:"column:isbn13;size:13;uniqueIndex"`
+	ISBN10          *string    `json:"isbn_10,omitempty" gorm:"column:isbn10;size:10"`
+	PublishedOn     *time.Time `json:"published_on,omitempty" gorm:"type:date"`
+	AvailableCopies int        `json:"available_copies"`
+	Price           Money      `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Version         uint       `json:"version" gorm:"not null;default:1"`
+
+	// Relations
+	Publisher *Publisher `json:"publisher,omitempty" gorm:"foreignKey:PublisherID;constraint:OnDelete:RESTRICT;"`
+}
+
+func (e *Edition) BeforeCreate(tx *gorm.DB) error {
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return nil
+}
+
+// EditionFields are the client editable attributes of an edition.
+// PublishedOn is a plain date, 2006-01-02.
+type EditionFields struct {
+	Format          string `json:"format" validate:"required,oneof=hardcover paperback ebook audiobook"`
+	PublisherID     *uint  `json:"publisher_id"`
+	ISBN            string `json:"isbn" validate:"omitempty,isbn"`
+	PublishedOn     string `json:"published_on" validate:"omitempty,datetime=2006-01-02"`
+	AvailableCopies int    `json:"available_copies" validate:"min=0"`
+	Price           Money  `json:"price" validate:"money"`
+}
+
+// EditionFieldColumns are the columns written when EditionFields are saved,
+// version is bumped along with them.
+var EditionFieldColumns = []string{"format", "publisher_id", "isbn13", "isbn10", "published_on", "available_copies", "price_minor", "price_currency", "version"}
+
+func (e *Edition) Fields() EditionFields {
+	fields := EditionFields{
+		Format:          e.Format,
+		PublisherID:     e.PublisherID,
+		ISBN:            e.ISBN(),
+		AvailableCopies: e.AvailableCopies,
+		Price:           e.Price,
+	}
+
+	if e.PublishedOn != nil {
+		fields.PublishedOn = e.PublishedOn.Format(time.DateOnly)
+	}
+	return fields
+}
+
+// SetFields expects validated fields, an unparsable date is cleared.
+func (e *Edition) SetFields(f EditionFields) {
+	e.Format = f.Format
+	e.PublisherID = f.PublisherID
+	e.SetISBN(f.ISBN)
+	e.AvailableCopies = f.AvailableCopies
+	e.Price = f.Price.orDefaultCurrency()
+
+	e.PublishedOn = nil
+	if date, err := time.Parse(time.DateOnly, f.PublishedOn); err == nil {
+		e.PublishedOn = &date
+	}
+}
+
+// ISBN returns the normalized ISBN-13 of the edition, or "" when it has none.
+func (e *Edition) ISBN() string {
+	if e.ISBN13 == nil {
+		return ""
+	}
+	return *e.ISBN13
+}
+
+// SetISBN stores isbn in both forms. An empty or invalid isbn clears them.
+func (e *Edition) SetISBN(isbn string) {
+	e.ISBN13, e.ISBN10 = splitISBN(isbn)
+}
+
+// SyncBookFromEditions recomputes the stock of a book as the total of its
+// editions and its price as the cheapest edition. Editions are priced in
+// the currency of their book so only the amount is taken. The book version
+// is bumped since its representation changed.
+func SyncBookFromEditions(tx *gorm.DB, bookID uint) error {
+	editions := "FROM editions WHERE book_id = ? AND deleted_at IS NULL"
+
+	return tx.Model(&Book{}).Where("id = ?", bookID).Updates(map[string]any{
+		"available_copies": gorm.Expr("(SELECT COALESCE(SUM(available_copies), 0) "+editions+")", bookID),
+		"price_minor":      gorm.Expr("COALESCE((SELECT MIN(price_minor) "+editions+"), price_minor)", bookID),
+		"version":          gorm.Expr("version + 1"),
+	}).Error
+}
+
+// MigrateBookEditions gives every book that never had an edition a single
+// paperback edition carrying the book's ISBN, price and stock, so the
+// catalog keeps selling once purchases go through editions.
+func MigrateBookEditions(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		books := []Book{}
+
+		if err := tx.Where("NOT EXISTS (SELECT 1 FROM editions WHERE editions.book_id = books.id)").Find(&books).Error; err != nil {
+			return err
+		}
+
+		if len(books) == 0 {
+			return nil
+		}
+
+		editions := make([]Edition, len(books))
+		for i, book := range books {
+			editions[i] = Edition{
+				BookID:          book.ID,
+				Format:          FormatPaperback,
+				ISBN13:          book.ISBN13,
+				ISBN10:          book.ISBN10,
+				AvailableCopies: book.AvailableCopies,
+				Price:           book.Price,
+			}
+		}
+
+		if err := tx.Create(&editions).Error; err != nil {
+			return err
+		}
+
+		// the stock moves from the book to its new edition
+		movements := []StockMovement{}
+		for _, edition := range editions {
+			if edition.AvailableCopies == 0 {
+				continue
+			}
+
+			movements = append(movements,
+				StockMovement{BookID: edition.BookID, Kind: StockAdjustment, Quantity: -edition.AvailableCopies, Reason: "moved to edition", Reference: StockRef("editions", edition.ID)},
+				StockMovement{BookID: edition.BookID, EditionID: &edition.ID, Kind: StockAdjustment, Quantity: edition.AvailableCopies, Reason: "moved from book", Reference: StockRef("books", edition.BookID)},
+			)
+		}
+
+		if len(movements) == 0 {
+			return nil
+		}
+		return tx.Create(&movements).Error
+	})
+}
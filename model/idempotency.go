@@ -0,0 +1,24 @@
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header so a retry gets the same response instead of
+// repeating the request. Keys are scoped to the caller, Scope is the user
+// id or, for requests without a user, a hash of the Authorization header.
+// Status is 0 while the first request is still being handled.
+type IdempotencyKey struct {
+	Scope       string      `gorm:"primaryKey;size:64"`
+	Key         string      `gorm:"primaryKey;size:255"`
+	Method      string      `gorm:"size:10"`
+	Path        string      `gorm:"size:255"`
+	RequestHash string      `gorm:"size:64"`
+	Status      int         `gorm:"not null"`
+	Header      http.Header `gorm:"serializer:json"`
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
@@ -0,0 +1,300 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+	ErrAmountOverflow   = errors.New("amount is out of range")
+)
+
+// Currency describes an ISO 4217 currency: how many decimal digits its
+// minor unit has and the symbol used when formatting amounts.
+type Currency struct {
+	Code   string
+	Digits int
+	Symbol string
+}
+
+// currencies are the currencies prices can be set in
+var currencies = map[string]Currency{
+	"AUD": {"AUD", 2, "A$"},
+	"CAD": {"CAD", 2, "CA$"},
+	"CHF": {"CHF", 2, "CHF "},
+	"EUR": {"EUR", 2, "€"},
+	"GBP": {"GBP", 2, "£"},
+	"INR": {"INR", 2, "₹"},
+	"JPY": {"JPY", 0, "¥"},
+	"USD": {"USD", 2, "$"},
+}
+
+// DefaultCurrency is used for amounts sent without a currency and for
+// prices stored before currencies were introduced.
+var DefaultCurrency = "USD"
+
+// LookupCurrency returns the currency for an ISO 4217 code.
+func LookupCurrency(code string) (Currency, bool) {
+	c, ok := currencies[strings.ToUpper(code)]
+	return c, ok
+}
+
+// SetDefaultCurrency changes DefaultCurrency, code must be supported.
+func SetDefaultCurrency(code string) error {
+	c, ok := LookupCurrency(code)
+	if !ok {
+		return fmt.Errorf("unsupported currency %q", code)
+	}
+
+	DefaultCurrency = c.Code
+	return nil
+}
+
+// Money is an amount in the minor unit of its currency, e.g. cents for USD,
+// so arithmetic is exact. It is stored in two columns, <prefix>minor and
+// <prefix>currency, when embedded with an embeddedPrefix.
+//
+// In JSON it is {"amount": 1299, "currency": "USD", "formatted": "$12.99"}.
+// A bare number is also accepted and read as an amount in DefaultCurrency.
+type Money struct {
+	Amount   int64  `gorm:"column:minor"`
+	Currency string `gorm:"column:currency;size:3"`
+}
+
+// NewMoney returns amount minor units of currency.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Zero returns no money in currency, the starting point of a sum.
+func Zero(currency string) Money {
+	return NewMoney(0, currency)
+}
+
+// orDefaultCurrency puts amounts that came without a currency, such as a
+// price left out of a request, in DefaultCurrency.
+func (m Money) orDefaultCurrency() Money {
+	if m.Currency == "" {
+		m.Currency = DefaultCurrency
+	}
+	return m
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// SameCurrency reports whether m and o can be combined.
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	sum := m.Amount + o.Amount
+	if (sum > m.Amount) != (o.Amount > 0) {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{sum, m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrAmountOverflow
+	}
+	return m.Add(Money{-o.Amount, o.Currency})
+}
+
+// Mul multiplies m by a whole number, e.g. a unit price by a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{0, m.Currency}, nil
+	}
+
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{product, m.Currency}, nil
+}
+
+// Scale multiplies m by num/den, rounding half away from zero to the
+// nearest minor unit. A 7.5% rate is Scale(75, 1000).
+func (m Money) Scale(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("scale by zero denominator")
+	}
+
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num))
+	divisor := big.NewInt(den)
+
+	quo, rem := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// round half away from zero: |2*rem| >= |den|
+	if rem.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(rem, 1)).Cmp(new(big.Int).Abs(divisor)) >= 0 {
+		if product.Sign()*divisor.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+
+	if !quo.IsInt64() {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{quo.Int64(), m.Currency}, nil
+}
+
+// Cmp compares m and o, -1 if m is less, 0 if equal and 1 if greater.
+func (m Money) Cmp(o Money) (int, error) {
+	if !m.SameCurrency(o) {
+		return 0, ErrCurrencyMismatch
+	}
+
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Decimal renders the amount in major units without a symbol, e.g. 12.99.
+func (m Money) Decimal() string {
+	digits := 2
+	if c, ok := LookupCurrency(m.Currency); ok {
+		digits = c.Digits
+	}
+
+	sign := ""
+	amount := new(big.Int).SetInt64(m.Amount)
+	if amount.Sign() < 0 {
+		sign = "-"
+		amount.Neg(amount)
+	}
+
+	text := amount.String()
+	if digits == 0 {
+		return sign + text
+	}
+
+	if len(text) <= digits {
+		text = strings.Repeat("0", digits-len(text)+1) + text
+	}
+	return sign + text[:len(text)-digits] + "." + text[len(text)-digits:]
+}
+
+// String formats the amount for display, e.g. $12.99 or -€5.00. Unknown
+// currencies fall back to the code, 12.99 XYZ.
+func (m Money) String() string {
+	c, ok := LookupCurrency(m.Currency)
+	if !ok {
+		return strings.TrimSpace(m.Decimal() + " " + m.Currency)
+	}
+
+	if m.Amount < 0 {
+		return "-" + c.Symbol + strings.TrimPrefix(m.Decimal(), "-")
+	}
+	return c.Symbol + m.Decimal()
+}
+
+type moneyJSON struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Formatted string `json:"formatted,omitempty"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{m.Amount, m.Currency, m.String()})
+}
+
+// UnmarshalJSON accepts the object form, where currency may be left out,
+// or a bare amount. Either way a missing currency means DefaultCurrency.
+// formatted is output only and ignored.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount int64
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*m = Money{amount, DefaultCurrency}
+		return nil
+	}
+
+	value := moneyJSON{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		// reported like any other type error, with the field name filled in
+		// by the decoder
+		return &json.UnmarshalTypeError{Value: jsonKind(data), Type: reflect.TypeOf(m).Elem()}
+	}
+
+	if value.Currency == "" {
+		value.Currency = DefaultCurrency
+	}
+
+	*m = NewMoney(value.Amount, value.Currency)
+	return nil
+}
+
+// jsonKind names the kind of a JSON value for error messages
+func jsonKind(data []byte) string {
+	switch data[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	}
+	return "number"
+}
+
+// MigrateMoney moves prices and purchase amounts out of the integer columns
+// used before currencies existed into their Money columns, as amounts in
+// DefaultCurrency, then drops the old columns.
+func MigrateMoney(db *gorm.DB) error {
+	columns := []struct {
+		model  any
+		table  string
+		from   string
+		prefix string
+	}{
+		{&Book{}, "books", "price", "price_"},
+		{&Edition{}, "editions", "price", "price_"},
+		{&Purchase{}, "purchases", "amount", "amount_"},
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, c := range columns {
+			if !tx.Migrator().HasColumn(c.model, c.from) {
+				continue
+			}
+
+			query := fmt.Sprintf("UPDATE %s SET %sminor = %s, %scurrency = ? WHERE %scurrency IS NULL",
+				c.table, c.prefix, c.from, c.prefix, c.prefix)
+			if err := tx.Exec(query, DefaultCurrency).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Migrator().DropColumn(c.model, c.from); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
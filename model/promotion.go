@@ -0,0 +1,307 @@
+package model
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Promotion condition types
+const (
+	ConditionBook        = "book"
+	ConditionCategory    = "category"
+	ConditionFormat      = "format"
+	ConditionMinQuantity = "min_quantity"
+	ConditionMinSpend    = "min_spend"
+)
+
+// Promotion action types
+const (
+	ActionPercentOff  = "percent_off"
+	ActionAmountOff   = "amount_off"
+	ActionBuyXGetY    = "buy_x_get_y"
+	ActionBundlePrice = "bundle_price"
+	ActionTiered      = "tiered"
+)
+
+// Promotion is a discount applied automatically to every purchase meeting
+// all of its conditions while it runs. Promotions are applied by priority,
+// highest first and the oldest first among equal priorities, each to what
+// the previous ones left of the amount. An exclusive promotion that applies
+// ends the evaluation.
+type Promotion struct {
+	gorm.Model
+
+	Name       string               `json:"name" gorm:"size:200;not null"`
+	Priority   int                  `json:"priority" gorm:"not null;default:0"`
+	Exclusive  bool                 `json:"exclusive"`
+	StartsAt   *time.Time           `json:"starts_at"`
+	ExpiresAt  *time.Time           `json:"expires_at"`
+	Conditions []PromotionCondition `json:"conditions" gorm:"serializer:json"`
+	Action     PromotionAction      `json:"action" gorm:"serializer:json"`
+	Version    uint                 `json:"version" gorm:"not null;default:1"`
+}
+
+func (p *Promotion) BeforeCreate(tx *gorm.DB) error {
+	if p.Version == 0 {
+		p.Version = 1
+	}
+	return nil
+}
+
+// PromotionCondition restricts a promotion to some books, a category and
+// its subcategories, an edition format, a minimum quantity or a minimum
+// spend at list price.
+type PromotionCondition struct {
+	Type     string `json:"type" validate:"required,oneof=book category format min_quantity min_spend"`
+	BookIDs  []uint `json:"book_ids,omitempty" validate:"required_if=Type book"`
+	Category string `json:"category,omitempty" validate:"required_if=Type category,omitempty,slug"`
+	Format   string `json:"format,omitempty" validate:"required_if=Type format,omitempty,oneof=hardcover paperback ebook audiobook"`
+	Quantity int    `json:"quantity,omitempty" validate:"required_if=Type min_quantity,min=0"`
+	Amount   Money  `json:"amount,omitzero" validate:"money"`
+}
+
+// PromotionAction is the discount of a promotion:
+//
+//   - percent_off takes PercentOff percent off
+//   - amount_off takes AmountOff off every copy
+//   - buy_x_get_y gives Get copies free for every Buy copies paid
+//   - bundle_price sells every BundleSize copies for BundlePrice
+//   - tiered takes the percent off of the highest tier the quantity reaches
+type PromotionAction struct {
+	Type        string          `json:"type" validate:"required,oneof=percent_off amount_off buy_x_get_y bundle_price tiered"`
+	PercentOff  int             `json:"percent_off,omitempty" validate:"required_if=Type percent_off,min=0,max=100"`
+	AmountOff   Money           `json:"amount_off,omitzero" validate:"money"`
+	Buy         int             `json:"buy,omitempty" validate:"required_if=Type buy_x_get_y,min=0"`
+	Get         int             `json:"get,omitempty" validate:"required_if=Type buy_x_get_y,min=0"`
+	BundleSize  int             `json:"bundle_size,omitempty" validate:"required_if=Type bundle_price,min=0"`
+	BundlePrice Money           `json:"bundle_price,omitzero" validate:"money"`
+	Tiers       []PromotionTier `json:"tiers,omitempty" validate:"required_if=Type tiered,dive"`
+}
+
+// PromotionTier takes PercentOff percent off purchases of at least
+// MinQuantity copies.
+type PromotionTier struct {
+	MinQuantity int `json:"min_quantity" validate:"min=1"`
+	PercentOff  int `json:"percent_off" validate:"min=1,max=100"`
+}
+
+// PromotionFields are the client editable attributes of a promotion.
+type PromotionFields struct {
+	Name       string               `json:"name" validate:"required,max=200"`
+	Priority   int                  `json:"priority"`
+	Exclusive  bool                 `json:"exclusive"`
+	StartsAt   *time.Time           `json:"starts_at"`
+	ExpiresAt  *time.Time           `json:"expires_at"`
+	Conditions []PromotionCondition `json:"conditions" validate:"dive"`
+	Action     PromotionAction      `json:"action"`
+}
+
+// PromotionFieldColumns are the columns written when PromotionFields are
+// saved, version is bumped along with them.
+var PromotionFieldColumns = []string{"name", "priority", "exclusive", "starts_at", "expires_at", "conditions", "action", "version"}
+
+func (p *Promotion) Fields() PromotionFields {
+	return PromotionFields{
+		Name:       p.Name,
+		Priority:   p.Priority,
+		Exclusive:  p.Exclusive,
+		StartsAt:   p.StartsAt,
+		ExpiresAt:  p.ExpiresAt,
+		Conditions: p.Conditions,
+		Action:     p.Action,
+	}
+}
+
+func (p *Promotion) SetFields(f PromotionFields) {
+	p.Name = f.Name
+	p.Priority = f.Priority
+	p.Exclusive = f.Exclusive
+	p.StartsAt = f.StartsAt
+	p.ExpiresAt = f.ExpiresAt
+	p.Conditions = f.Conditions
+	p.Action = f.Action
+
+	if p.Conditions == nil {
+		p.Conditions = []PromotionCondition{}
+	}
+}
+
+// PromotionItem is a purchase line promotions are evaluated against.
+type PromotionItem struct {
+	BookID uint
+	// Format is the edition format, empty for books without editions
+	Format string
+	// Categories are the slugs of the book's categories and their ancestors
+	Categories []string
+	Quantity   int
+	UnitPrice  Money
+}
+
+// ListAmount is the price of the item before any discount.
+func (i PromotionItem) ListAmount() (Money, error) {
+	return i.UnitPrice.Mul(int64(i.Quantity))
+}
+
+// NeedsCategories reports whether any of the conditions is on a category.
+func (p *Promotion) NeedsCategories() bool {
+	return slices.ContainsFunc(p.Conditions, func(c PromotionCondition) bool {
+		return c.Type == ConditionCategory
+	})
+}
+
+// Matches reports whether item meets all of the promotion's conditions.
+func (p *Promotion) Matches(item PromotionItem) bool {
+	for _, c := range p.Conditions {
+		if !c.matches(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c PromotionCondition) matches(item PromotionItem) bool {
+	switch c.Type {
+	case ConditionBook:
+		return slices.Contains(c.BookIDs, item.BookID)
+	case ConditionCategory:
+		return slices.Contains(item.Categories, c.Category)
+	case ConditionFormat:
+		return c.Format == item.Format
+	case ConditionMinQuantity:
+		return item.Quantity >= c.Quantity
+	case ConditionMinSpend:
+		amount, err := item.ListAmount()
+		if err != nil {
+			return false
+		}
+
+		// a minimum in another currency is never reached
+		cmp, err := amount.Cmp(c.Amount)
+		return err == nil && cmp >= 0
+	}
+	return false
+}
+
+// Discount is what the action takes off item when remaining is what is
+// left to pay, never more than remaining. Actions priced in another
+// currency than the item take nothing off.
+func (a PromotionAction) Discount(item PromotionItem, remaining Money) (Money, error) {
+	discount := Zero(remaining.Currency)
+	var err error
+
+	switch a.Type {
+	case ActionPercentOff:
+		discount, err = remaining.Scale(int64(a.PercentOff), 100)
+
+	case ActionAmountOff:
+		if a.AmountOff.SameCurrency(remaining) {
+			discount, err = a.AmountOff.Mul(int64(item.Quantity))
+		}
+
+	case ActionBuyXGetY:
+		if a.Buy+a.Get > 0 {
+			discount, err = item.UnitPrice.Mul(int64(item.Quantity / (a.Buy + a.Get) * a.Get))
+		}
+
+	case ActionBundlePrice:
+		if a.BundleSize > 0 && a.BundlePrice.SameCurrency(remaining) {
+			discount, err = a.bundleDiscount(item)
+		}
+
+	case ActionTiered:
+		percent := 0
+		for _, tier := range a.Tiers {
+			if item.Quantity >= tier.MinQuantity && tier.PercentOff > percent {
+				percent = tier.PercentOff
+			}
+		}
+		discount, err = remaining.Scale(int64(percent), 100)
+	}
+
+	if err != nil {
+		return Money{}, err
+	}
+
+	if discount.IsNegative() {
+		return Zero(remaining.Currency), nil
+	}
+
+	if cmp, err := discount.Cmp(remaining); err != nil || cmp > 0 {
+		return remaining, err
+	}
+	return discount, nil
+}
+
+// bundleDiscount is the list price of the bundled copies less their bundle
+// price
+func (a PromotionAction) bundleDiscount(item PromotionItem) (Money, error) {
+	bundles := int64(item.Quantity / a.BundleSize)
+
+	listed, err := item.UnitPrice.Mul(bundles * int64(a.BundleSize))
+	if err != nil {
+		return Money{}, err
+	}
+
+	priced, err := a.BundlePrice.Mul(bundles)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return listed.Sub(priced)
+}
+
+// ApplyPromotions evaluates promotions, already in priority order, against
+// item and returns a discount line for every promotion that took something
+// off.
+func ApplyPromotions(promotions []Promotion, item PromotionItem) ([]DiscountLine, error) {
+	remaining, err := item.ListAmount()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []DiscountLine{}
+	for _, promotion := range promotions {
+		if !promotion.Matches(item) {
+			continue
+		}
+
+		discount, err := promotion.Action.Discount(item, remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		if discount.IsZero() {
+			continue
+		}
+
+		if remaining, err = remaining.Sub(discount); err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, DiscountLine{Name: promotion.Name, PromotionID: &promotion.ID, Amount: discount})
+
+		if promotion.Exclusive {
+			break
+		}
+	}
+
+	return lines, nil
+}
+
+// DiscountLine is one discount taken off a purchase, by a promotion or a
+// coupon.
+type DiscountLine struct {
+	Name        string `json:"name" gorm:"size:200;not null"`
+	PromotionID *uint  `json:"promotion_id,omitempty"`
+	CouponID    *uint  `json:"coupon_id,omitempty"`
+	Amount      Money  `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
+
+// PurchaseDiscount is a discount line stored with a purchase.
+type PurchaseDiscount struct {
+	ID         uint `json:"-" gorm:"primaryKey"`
+	PurchaseID uint `json:"-" gorm:"index;not null"`
+	DiscountLine
+}
@@ -0,0 +1,40 @@
+package model
+
+import "gorm.io/gorm"
+
+type Publisher struct {
+	gorm.Model
+
+	Name    string `json:"name" gorm:"size:200;uniqueIndex" validate:"required"`
+	Website string `json:"website,omitempty"`
+	Version uint   `json:"version" gorm:"not null;default:1"`
+}
+
+func (p *Publisher) BeforeCreate(tx *gorm.DB) error {
+	if p.Version == 0 {
+		p.Version = 1
+	}
+	return nil
+}
+
+// PublisherFields are the client editable attributes of a publisher.
+type PublisherFields struct {
+	Name    string `json:"name" validate:"required,max=200"`
+	Website string `json:"website" validate:"omitempty,url"`
+}
+
+// PublisherFieldColumns are the columns written when PublisherFields are
+// saved, version is bumped along with them.
+var PublisherFieldColumns = []string{"name", "website", "version"}
+
+func (p *Publisher) Fields() PublisherFields {
+	return PublisherFields{
+		Name:    p.Name,
+		Website: p.Website,
+	}
+}
+
+func (p *Publisher) SetFields(f PublisherFields) {
+	p.Name = f.Name
+	p.Website = f.Website
+}
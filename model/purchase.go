@@ -1,23 +1,79 @@
 package model
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Purchase statuses
+const (
+	// PurchasePending purchases hold their copies while the payment is
+	// processed
+	PurchasePending  = "pending"
+	PurchasePaid     = "paid"
+	PurchaseFailed   = "failed"
+	PurchaseRefunded = "refunded"
+)
+
 type Purchase struct {
 	gorm.Model
 
-	UserID   uint `json:"user_id" gorm:"index;not null"`
-	BookID   uint `json:"book_id" gorm:"index;not null"`
-	Quantity int  `json:"quantity"`
-	Amount   int  `json:"amount" gorm:"not null"`
+	UserID    uint  `json:"user_id" gorm:"index;not null"`
+	BookID    uint  `json:"book_id" gorm:"index;not null"`
+	EditionID *uint `json:"edition_id" gorm:"index"`
+	Quantity  int   `json:"quantity"`
+	Amount    Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+
+	// Discount is what promotions and the coupon took off the listed
+	// price, before tax, broken down in Discounts
+	CouponID  *uint              `json:"coupon_id,omitempty" gorm:"index"`
+	Discount  Money              `json:"discount" gorm:"embedded;embeddedPrefix:discount_"`
+	Discounts []PurchaseDiscount `json:"discounts,omitempty" gorm:"foreignKey:PurchaseID;constraint:OnDelete:CASCADE;"`
+
+	// Amount is what the customer paid, Tax the part of it that is tax.
+	// TaxInclusive records whether the price already contained the tax.
+	Tax          Money         `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`
+	TaxRegion    string        `json:"tax_region,omitempty" gorm:"size:10"`
+	TaxInclusive bool          `json:"tax_inclusive"`
+	Taxes        []PurchaseTax `json:"taxes,omitempty" gorm:"foreignKey:PurchaseID;constraint:OnDelete:CASCADE;"`
+
+	// Status follows the payment, PaymentID is the provider's id of it
+	Status    string `json:"status" gorm:"size:20;index;default:paid"`
+	PaymentID string `json:"payment_id,omitempty" gorm:"size:100;index"`
 
 	// Relations
-	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
-	Book Book `gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+	User    User     `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Book    Book     `json:"-" gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+	Edition *Edition `json:"-" gorm:"foreignKey:EditionID;constraint:OnDelete:SET NULL;"`
+	Coupon  *Coupon  `json:"-" gorm:"foreignKey:CouponID;constraint:OnDelete:SET NULL;"`
 }
 
+// PurchasePayload names the edition to buy. A book id alone is accepted for
+// books sold in a single edition.
 type PurchasePayload struct {
-	BookId   int `json:"book_id" validate:"required"`
-	Quantity int `json:"quantity" validate:"required"`
+	BookId    int `json:"book_id" validate:"required_without_all=EditionId ReservationId"`
+	EditionId int `json:"edition_id" validate:"required_without_all=BookId ReservationId"`
+	Quantity  int `json:"quantity" validate:"required,min=1"`
+	// Region is where the purchase is taxed, e.g. GB or US-CA. The tax
+	// rules' default region is used when it is left out.
+	Region string `json:"region" validate:"omitempty,max=10"`
+	// CouponCode is redeemed on the purchase, codes are case insensitive
+	CouponCode string `json:"coupon_code" validate:"omitempty,max=50"`
+	// PaymentMethod is the token of the payment method the client set up
+	// with the payment provider
+	PaymentMethod string `json:"payment_method" validate:"omitempty,max=255"`
+	// ReservationId buys copies held by one of the caller's reservations,
+	// the reservation decides the book and edition. Copies it holds beyond
+	// Quantity go back in stock.
+	ReservationId int `json:"reservation_id" validate:"omitempty,min=1"`
+}
+
+// PaymentEvent records a processed payment provider webhook, providers
+// deliver events at least once and a recorded event is not applied again.
+type PaymentEvent struct {
+	ID         string    `json:"id" gorm:"primaryKey;size:255"`
+	Type       string    `json:"type" gorm:"size:100"`
+	PurchaseID *uint     `json:"purchase_id" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
 }
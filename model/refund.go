@@ -0,0 +1,106 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Return request statuses
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+)
+
+// ReturnRequest is a customer asking to send back copies of a purchase.
+// An admin approves it, which refunds the copies, or rejects it with a
+// note.
+type ReturnRequest struct {
+	gorm.Model
+
+	PurchaseID uint       `json:"purchase_id" gorm:"index;not null"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Quantity   int        `json:"quantity"`
+	Reason     string     `json:"reason" gorm:"size:500"`
+	Status     string     `json:"status" gorm:"size:20;index"`
+	Note       string     `json:"note,omitempty" gorm:"size:500"`
+	ResolvedBy *uint      `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	RefundID   *uint      `json:"refund_id,omitempty"`
+
+	// Relations
+	Purchase Purchase `json:"-" gorm:"foreignKey:PurchaseID;constraint:OnDelete:CASCADE;"`
+	User     User     `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+}
+
+type ReturnPayload struct {
+	Quantity int    `json:"quantity" validate:"required,min=1"`
+	Reason   string `json:"reason" validate:"required,max=500"`
+}
+
+// ResolveReturnPayload approves or rejects a return. An approved return is
+// refunded its share of the purchase amount unless Amount says otherwise,
+// and its copies are restocked unless Restock is false, e.g. when they
+// came back damaged.
+type ResolveReturnPayload struct {
+	Amount  Money  `json:"amount" validate:"money"`
+	Restock *bool  `json:"restock"`
+	Note    string `json:"note" validate:"max=500"`
+}
+
+// Refund statuses. Refunds paid back through the payment provider are
+// pending until the provider accepted them, a failed refund took its copies
+// back but paid nothing.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// Refund pays back part or all of a purchase. Quantity is the number of
+// copies taken back, 0 for a refund of money only, and Restocked whether
+// they went back in stock. PaymentID is the refunded payment, empty for
+// purchases that were not charged through a payment provider.
+type Refund struct {
+	gorm.Model
+
+	PurchaseID uint   `json:"purchase_id" gorm:"index;not null"`
+	ReturnID   *uint  `json:"return_id,omitempty" gorm:"index"`
+	Quantity   int    `json:"quantity"`
+	Amount     Money  `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Restocked  bool   `json:"restocked"`
+	Reason     string `json:"reason,omitempty" gorm:"size:500"`
+	CreatedBy  uint   `json:"created_by"`
+	PaymentID  string `json:"payment_id,omitempty" gorm:"size:100"`
+	Status     string `json:"status" gorm:"size:20;index"`
+
+	// Relations
+	Purchase Purchase `json:"-" gorm:"foreignKey:PurchaseID;constraint:OnDelete:CASCADE;"`
+}
+
+// RefundPayload refunds a purchase directly. Amount defaults to the share
+// of the purchase amount of Quantity, at least one of them is required.
+type RefundPayload struct {
+	Amount   Money  `json:"amount" validate:"money"`
+	Quantity int    `json:"quantity" validate:"min=0"`
+	Restock  *bool  `json:"restock"`
+	Reason   string `json:"reason" validate:"max=500"`
+}
+
+// PurchaseRefunds sums what was refunded of a purchase so far, the copies
+// taken back and the amount paid back. Failed refunds paid nothing back,
+// only their copies count.
+func PurchaseRefunds(tx *gorm.DB, purchase Purchase) (int, Money, error) {
+	totals := struct {
+		Quantity int
+		Amount   int64
+	}{}
+
+	err := tx.Model(&Refund{}).
+		Select("COALESCE(SUM(quantity), 0) AS quantity, COALESCE(SUM(CASE WHEN status = ? THEN 0 ELSE amount_minor END), 0) AS amount", RefundFailed).
+		Where("purchase_id = ?", purchase.ID).
+		Scan(&totals).Error
+
+	return totals.Quantity, NewMoney(totals.Amount, purchase.Amount.Currency), err
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reservation statuses
+const (
+	ReservationActive    = "active"
+	ReservationConverted = "converted"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds copies of a book for a customer while they check out.
+// The held copies are taken out of AvailableCopies when the reservation is
+// made, so what is available is what is on hand less the active
+// reservations. They go back in stock when the reservation is released or
+// expires, and are sold when a purchase converts it.
+type Reservation struct {
+	gorm.Model
+
+	UserID     uint      `json:"user_id" gorm:"index;not null"`
+	BookID     uint      `json:"book_id" gorm:"index;not null"`
+	EditionID  *uint     `json:"edition_id" gorm:"index"`
+	Quantity   int       `json:"quantity"`
+	Status     string    `json:"status" gorm:"size:20;index"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index"`
+	PurchaseID *uint     `json:"purchase_id,omitempty"`
+
+	// Relations
+	User User `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Book Book `json:"-" gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE;"`
+}
+
+type ReservationPayload struct {
+	BookId    int `json:"book_id" validate:"required_without=EditionId"`
+	EditionId int `json:"edition_id" validate:"required_without=BookId"`
+	Quantity  int `json:"quantity" validate:"required,min=1"`
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Stock movement kinds
+const (
+	StockSale        = "sale"
+	StockRestock     = "restock"
+	StockAdjustment  = "adjustment"
+	StockReturn      = "return"
+	StockReservation = "reservation"
+	StockTransfer    = "transfer"
+	StockReceipt     = "receipt"
+)
+
+// StockMovement is an entry of the append-only stock ledger. Quantity is
+// the signed change to the available copies of an edition, or of a book
+// without editions when EditionID is nil, so their stock is the sum of
+// their movements. ActorID is the user who caused the change, nil for the
+// background jobs, and Reference the record behind it, e.g. "purchases:7".
+// WarehouseID is the warehouse whose stock changed, nil for stock that is
+// not kept per warehouse.
+type StockMovement struct {
+	ID          uint      `json:"ID" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"CreatedAt" gorm:"index"`
+	BookID      uint      `json:"book_id" gorm:"index;not null"`
+	EditionID   *uint     `json:"edition_id" gorm:"index"`
+	WarehouseID *uint     `json:"warehouse_id,omitempty" gorm:"index"`
+	Kind        string    `json:"kind" gorm:"size:20;index"`
+	Quantity    int       `json:"quantity"`
+	ActorID     *uint     `json:"actor_id,omitempty"`
+	Reason      string    `json:"reason,omitempty" gorm:"size:255"`
+	Reference   string    `json:"reference,omitempty" gorm:"size:100;index"`
+	// Balance is the stock of the edition, or book, after the movement.
+	// It is not stored, the stock history adds it up.
+	Balance int `json:"balance" gorm:"-"`
+}
+
+// StockRef references the record behind a stock movement
+func StockRef(table string, id uint) string {
+	return fmt.Sprintf("%s:%d", table, id)
+}
+
+// StockDrift is an edition, or a book without editions, whose stock does
+// not match its ledger
+type StockDrift struct {
+	BookID    uint
+	EditionID *uint
+	Stock     int
+	Ledger    int
+}
+
+// FindStockDrift lists the editions and the books without editions whose
+// available copies differ from the sum of their stock movements
+func FindStockDrift(tx *gorm.DB) ([]StockDrift, error) {
+	drift := []StockDrift{}
+
+	err := tx.Raw(`
+		SELECT e.book_id, e.id AS edition_id, e.available_copies AS stock, COALESCE(SUM(m.quantity), 0) AS ledger
+		FROM editions e
+		LEFT JOIN stock_movements m ON m.edition_id = e.id
+		WHERE e.deleted_at IS NULL
+		GROUP BY e.id
+		HAVING e.available_copies <> COALESCE(SUM(m.quantity), 0)
+		UNION ALL
+		SELECT b.id, NULL, b.available_copies, COALESCE(SUM(m.quantity), 0)
+		FROM books b
+		LEFT JOIN stock_movements m ON m.book_id = b.id AND m.edition_id IS NULL
+		WHERE b.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM editions e WHERE e.book_id = b.id AND e.deleted_at IS NULL)
+		GROUP BY b.id
+		HAVING b.available_copies <> COALESCE(SUM(m.quantity), 0)
+		ORDER BY 1, 2`).Scan(&drift).Error
+
+	return drift, err
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Stocktake statuses
+const (
+	StocktakeOpen      = "open"
+	StocktakeApplied   = "applied"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake reason codes, recorded as the reason of the adjustments a
+// stocktake makes
+const (
+	CountMiscount = "miscount"
+	CountDamaged  = "damaged"
+	CountLost     = "lost"
+	CountTheft    = "theft"
+	CountFound    = "found"
+)
+
+// Stocktake is a count of the copies of a set of books, in a warehouse
+// when WarehouseID is set. Counts are submitted while it is open, applying
+// it adjusts the stock by how far they were off.
+type Stocktake struct {
+	gorm.Model
+
+	WarehouseID *uint           `json:"warehouse_id,omitempty" gorm:"index"`
+	Status      string          `json:"status" gorm:"size:20;index"`
+	Note        string          `json:"note,omitempty" gorm:"size:500"`
+	Reason      string          `json:"reason,omitempty" gorm:"size:20"`
+	CreatedBy   uint            `json:"created_by"`
+	AppliedBy   *uint           `json:"applied_by,omitempty"`
+	AppliedAt   *time.Time      `json:"applied_at,omitempty"`
+	Lines       []StocktakeLine `json:"lines"`
+}
+
+// StocktakeLine is the count of an edition, or of a book without editions
+// when EditionID is nil. Counted is nil until it is counted and Expected
+// the stock it had when it was counted, Adjustment is the change applying
+// the stocktake made.
+type StocktakeLine struct {
+	ID          uint       `json:"ID" gorm:"primaryKey"`
+	StocktakeID uint       `json:"stocktake_id" gorm:"index;not null"`
+	BookID      uint       `json:"book_id" gorm:"index;not null"`
+	EditionID   *uint      `json:"edition_id"`
+	Counted     *int       `json:"counted"`
+	Expected    *int       `json:"expected"`
+	CountedBy   *uint      `json:"counted_by,omitempty"`
+	CountedAt   *time.Time `json:"counted_at,omitempty"`
+	Adjustment  int        `json:"adjustment"`
+	// Variance is how far the count is off, nil while uncounted. It is not
+	// stored.
+	Variance *int `json:"variance" gorm:"-"`
+}
+
+// StocktakePayload starts a stocktake of the editions, or the books
+// without editions, of BookIds
+type StocktakePayload struct {
+	WarehouseId int    `json:"warehouse_id" validate:"min=0"`
+	BookIds     []int  `json:"book_ids" validate:"required,min=1,max=500,dive,min=1"`
+	Note        string `json:"note" validate:"max=500"`
+}
+
+type StocktakeCountsPayload struct {
+	Counts []StocktakeCount `json:"counts" validate:"required,min=1,dive"`
+}
+
+// StocktakeCount is the counted copies of a book, or one of its editions.
+// Counting a line again replaces its count.
+type StocktakeCount struct {
+	BookId    int  `json:"book_id" validate:"required_without=EditionId"`
+	EditionId int  `json:"edition_id" validate:"required_without=BookId"`
+	Counted   *int `json:"counted" validate:"required,min=0"`
+}
+
+type ApplyStocktakePayload struct {
+	Reason string `json:"reason" validate:"required,oneof=miscount damaged lost theft found"`
+}
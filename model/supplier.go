@@ -0,0 +1,100 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Supplier order statuses
+const (
+	SupplierOrderOpen      = "open"
+	SupplierOrderPartial   = "partially_received"
+	SupplierOrderReceived  = "received"
+	SupplierOrderCancelled = "cancelled"
+)
+
+// Supplier sells copies to the store, usually a publisher. LeadTimeDays is
+// how long its deliveries usually take, orders sent without an expected
+// delivery date are expected that many days out.
+type Supplier struct {
+	gorm.Model
+
+	Name         string `json:"name" gorm:"size:200;uniqueIndex"`
+	Email        string `json:"email,omitempty"`
+	Phone        string `json:"phone,omitempty" gorm:"size:50"`
+	PublisherID  *uint  `json:"publisher_id,omitempty" gorm:"index"`
+	LeadTimeDays int    `json:"lead_time_days"`
+}
+
+// SupplierPayload are the client editable attributes of a supplier
+type SupplierPayload struct {
+	Name         string `json:"name" validate:"required,max=200"`
+	Email        string `json:"email" validate:"omitempty,email"`
+	Phone        string `json:"phone" validate:"max=50"`
+	PublisherId  int    `json:"publisher_id" validate:"min=0"`
+	LeadTimeDays int    `json:"lead_time_days" validate:"min=0"`
+}
+
+// SupplierOrder asks a supplier for copies. It is open until its items are
+// received, in one delivery or several, or it is cancelled.
+type SupplierOrder struct {
+	gorm.Model
+
+	SupplierID uint                `json:"supplier_id" gorm:"index;not null"`
+	Status     string              `json:"status" gorm:"size:20;index"`
+	ExpectedAt *time.Time          `json:"expected_at,omitempty"`
+	ReceivedAt *time.Time          `json:"received_at,omitempty"`
+	Note       string              `json:"note,omitempty" gorm:"size:500"`
+	CreatedBy  uint                `json:"created_by"`
+	Items      []SupplierOrderItem `json:"items"`
+
+	// Relations
+	Supplier Supplier `json:"-" gorm:"foreignKey:SupplierID"`
+}
+
+// SupplierOrderItem is the copies of an edition, or of a book without
+// editions when EditionID is nil, ordered from a supplier and how many of
+// them were received so far
+type SupplierOrderItem struct {
+	ID              uint  `json:"ID" gorm:"primaryKey"`
+	SupplierOrderID uint  `json:"supplier_order_id" gorm:"index;not null"`
+	BookID          uint  `json:"book_id" gorm:"index;not null"`
+	EditionID       *uint `json:"edition_id"`
+	Quantity        int   `json:"quantity"`
+	Received        int   `json:"received"`
+}
+
+// Outstanding are the copies still to be received
+func (i *SupplierOrderItem) Outstanding() int {
+	return max(i.Quantity-i.Received, 0)
+}
+
+type SupplierOrderPayload struct {
+	SupplierId int                        `json:"supplier_id" validate:"required,min=1"`
+	ExpectedAt *time.Time                 `json:"expected_at"`
+	Note       string                     `json:"note" validate:"max=500"`
+	Items      []SupplierOrderItemPayload `json:"items" validate:"required,min=1,dive"`
+}
+
+// SupplierOrderItemPayload orders copies of a book or edition, a book sold
+// in editions is ordered by edition. Without a quantity the reorder
+// quantity of the book is ordered.
+type SupplierOrderItemPayload struct {
+	BookId    int `json:"book_id" validate:"required_without=EditionId"`
+	EditionId int `json:"edition_id" validate:"required_without=BookId"`
+	Quantity  int `json:"quantity" validate:"min=0"`
+}
+
+// ReceivePayload records a delivery of a supplier order, into WarehouseId
+// when the store keeps stock per warehouse. Without items every copy still
+// outstanding is received.
+type ReceivePayload struct {
+	WarehouseId int                  `json:"warehouse_id" validate:"min=0"`
+	Items       []ReceiveItemPayload `json:"items" validate:"dive"`
+}
+
+type ReceiveItemPayload struct {
+	ItemId   int `json:"item_id" validate:"required,min=1"`
+	Quantity int `json:"quantity" validate:"required,min=1"`
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// TaxRate is a tax rate in parts per million of the taxed amount, so 20%
+// is 200000 and 7.25% is 72500. In JSON it is a percentage, "7.25".
+type TaxRate int64
+
+// TaxRateScale is the TaxRate of 100%
+const TaxRateScale = 1_000_000
+
+// ParseTaxRate reads a percentage such as "20" or "7.25". Rates finer than
+// a ten thousandth of a percent are rejected rather than rounded.
+func ParseTaxRate(percent string) (TaxRate, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(percent))
+	if !ok || rat.Sign() < 0 {
+		return 0, fmt.Errorf("invalid tax rate %q", percent)
+	}
+
+	rat.Mul(rat, big.NewRat(TaxRateScale/100, 1))
+	if !rat.IsInt() || !rat.Num().IsInt64() {
+		return 0, fmt.Errorf("invalid tax rate %q", percent)
+	}
+	return TaxRate(rat.Num().Int64()), nil
+}
+
+func (r TaxRate) String() string {
+	percent := new(big.Rat).SetFrac64(int64(r), TaxRateScale/100)
+	return strings.TrimRight(strings.TrimRight(percent.FloatString(4), "0"), ".")
+}
+
+func (r TaxRate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON accepts the percentage as a string or a number.
+func (r *TaxRate) UnmarshalJSON(data []byte) error {
+	text := string(data)
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		text = s
+	}
+
+	rate, err := ParseTaxRate(text)
+	if err != nil {
+		return err
+	}
+
+	*r = rate
+	return nil
+}
+
+// TaxLine is one tax charged on a purchase, e.g. VAT at 20%.
+type TaxLine struct {
+	Name   string  `json:"name" gorm:"size:100;not null"`
+	Rate   TaxRate `json:"rate"`
+	Amount Money   `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
+
+// PurchaseTax is a tax line stored with a purchase so receipts and reports
+// can break the tax down.
+type PurchaseTax struct {
+	ID         uint `json:"-" gorm:"primaryKey"`
+	PurchaseID uint `json:"-" gorm:"index;not null"`
+	TaxLine
+}
@@ -7,16 +7,42 @@ type User struct {
 
 	Name      string `json:"name" validate:"required"`
 	City      string `json:"city,omitempty"`
-	Email     string `json:"email" validate:"required"`
+	Email     string `json:"email" validate:"required,email"`
 	Password  string `json:"password,omitempty" validate:"required"`
 	Role      string `json:"role"`
+	Version   uint   `json:"version" gorm:"not null;default:1"`
 	Purchases []Purchase
 }
 
-type UpdateUserPayload struct {
-	ID    uint   `json:"ID,omitempty"`
-	Name  string `json:"name,omitempty"`
-	City  string `json:"city,omitempty"`
-	Email string `json:"email,omitempty"`
-	Role  string `json:"role,omitempty"`
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	return nil
+}
+
+// UserFields are the profile attributes a user can edit. Role and password
+// are managed separately.
+type UserFields struct {
+	Name  string `json:"name" validate:"required"`
+	City  string `json:"city"`
+	Email string `json:"email" validate:"required,email"`
+}
+
+// UserFieldColumns are the columns written when UserFields are saved,
+// version is bumped along with them.
+var UserFieldColumns = []string{"name", "city", "email", "version"}
+
+func (u *User) Fields() UserFields {
+	return UserFields{
+		Name:  u.Name,
+		City:  u.City,
+		Email: u.Email,
+	}
+}
+
+func (u *User) SetFields(f UserFields) {
+	u.Name = f.Name
+	u.City = f.City
+	u.Email = f.Email
 }
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Warehouse keeps stock. Purchases are allocated to the warehouses in the
+// city of the customer first, then by Priority, lowest first.
+type Warehouse struct {
+	gorm.Model
+
+	Code     string `json:"code" gorm:"size:20;uniqueIndex"`
+	Name     string `json:"name"`
+	City     string `json:"city"`
+	Priority int    `json:"priority"`
+}
+
+// WarehousePayload are the client editable attributes of a warehouse
+type WarehousePayload struct {
+	Code     string `json:"code" validate:"required,max=20"`
+	Name     string `json:"name" validate:"required"`
+	City     string `json:"city" validate:"required"`
+	Priority int    `json:"priority" validate:"min=0"`
+}
+
+// StockLevel is the stock of an edition, or of a book without editions
+// when EditionID is nil, in a warehouse. Once an edition or book has stock
+// levels its available copies are the sum of them.
+type StockLevel struct {
+	ID          uint       `json:"ID" gorm:"primaryKey"`
+	UpdatedAt   time.Time  `json:"UpdatedAt"`
+	WarehouseID uint       `json:"warehouse_id" gorm:"index;not null"`
+	BookID      uint       `json:"book_id" gorm:"index;not null"`
+	EditionID   *uint      `json:"edition_id" gorm:"index"`
+	Quantity    int        `json:"quantity"`
+	Warehouse   *Warehouse `json:"warehouse,omitempty"`
+}
+
+// StockLevelPayload sets the stock of a book, or one of its editions, in a
+// warehouse
+type StockLevelPayload struct {
+	BookId    int `json:"book_id" validate:"required_without=EditionId"`
+	EditionId int `json:"edition_id" validate:"required_without=BookId"`
+	Quantity  int `json:"quantity" validate:"min=0"`
+}
+
+// WarehouseTransfer moves copies from one warehouse to another
+type WarehouseTransfer struct {
+	ID              uint      `json:"ID" gorm:"primaryKey"`
+	CreatedAt       time.Time `json:"CreatedAt" gorm:"index"`
+	FromWarehouseID uint      `json:"from_warehouse_id" gorm:"index;not null"`
+	ToWarehouseID   uint      `json:"to_warehouse_id" gorm:"index;not null"`
+	BookID          uint      `json:"book_id" gorm:"index;not null"`
+	EditionID       *uint     `json:"edition_id"`
+	Quantity        int       `json:"quantity"`
+	CreatedBy       uint      `json:"created_by"`
+	Note            string    `json:"note,omitempty" gorm:"size:255"`
+}
+
+type WarehouseTransferPayload struct {
+	FromWarehouseId int    `json:"from_warehouse_id" validate:"required,min=1"`
+	ToWarehouseId   int    `json:"to_warehouse_id" validate:"required,min=1,nefield=FromWarehouseId"`
+	BookId          int    `json:"book_id" validate:"required_without=EditionId"`
+	EditionId       int    `json:"edition_id" validate:"required_without=BookId"`
+	Quantity        int    `json:"quantity" validate:"required,min=1"`
+	Note            string `json:"note" validate:"max=255"`
+}
+
+// WarehouseStock is the stock of a book, all its editions together, in a
+// warehouse
+type WarehouseStock struct {
+	WarehouseID uint   `json:"warehouse_id"`
+	Code        string `json:"code"`
+	City        string `json:"city"`
+	Copies      int    `json:"copies"`
+}
+
+// FindWarehouseStock adds up the stock of books per warehouse, by book id
+func FindWarehouseStock(tx *gorm.DB, bookIds []uint) (map[uint][]WarehouseStock, error) {
+	rows := []struct {
+		BookID uint
+		WarehouseStock
+	}{}
+
+	err := tx.Raw(`
+		SELECT l.book_id, w.id AS warehouse_id, w.code, w.city, SUM(l.quantity) AS copies
+		FROM stock_levels l
+		JOIN warehouses w ON w.id = l.warehouse_id AND w.deleted_at IS NULL
+		WHERE l.book_id IN ?
+		GROUP BY l.book_id, w.id
+		ORDER BY l.book_id, w.priority, w.id`, bookIds).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stock := map[uint][]WarehouseStock{}
+	for _, row := range rows {
+		stock[row.BookID] = append(stock[row.BookID], row.WarehouseStock)
+	}
+
+	return stock, nil
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// EmailConfig points an EmailNotifier at an SMTP server. Username and
+// Password are optional, servers on localhost often take mail without.
+type EmailConfig struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// EmailNotifier mails messages as plain text.
+type EmailNotifier struct {
+	config EmailConfig
+	send   func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+	now    func() time.Time
+}
+
+func NewEmailNotifier(config EmailConfig) (*EmailNotifier, error) {
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		return nil, fmt.Errorf("notify: invalid smtp address %q", config.Addr)
+	}
+
+	if config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("notify: email sender and recipients are required")
+	}
+
+	return &EmailNotifier{
+		config: config,
+		send:   smtp.SendMail,
+		now:    time.Now,
+	}, nil
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		host, _, _ := net.SplitHostPort(n.config.Addr)
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := n.send(n.config.Addr, auth, n.config.From, n.config.To, n.message(msg)); err != nil {
+		return fmt.Errorf("notify: sending email failed: %w", err)
+	}
+
+	return nil
+}
+
+func (n *EmailNotifier) message(msg Message) []byte {
+	b := strings.Builder{}
+
+	fmt.Fprintf(&b, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
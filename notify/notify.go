@@ -0,0 +1,48 @@
+// Package notify tells the store's staff about things that need their
+// attention, such as books running out of stock.
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Event types
+const (
+	EventLowStock = "stock.low"
+)
+
+// Message is a notification. Subject and Body are meant for people, Data
+// carries the same facts for machines.
+type Message struct {
+	Event   string         `json:"event"`
+	Subject string         `json:"subject"`
+	Body    string         `json:"body"`
+	Data    map[string]any `json:"data,omitempty"`
+	Time    time.Time      `json:"time"`
+}
+
+// Notifier delivers messages to the store's staff.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to a log, for stores without anywhere else
+// to send them.
+type LogNotifier struct {
+	log zerolog.Logger
+}
+
+func NewLogNotifier(log zerolog.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, msg Message) error {
+	n.log.Warn().
+		Str("event", msg.Event).
+		Fields(msg.Data).
+		Msg(msg.Subject)
+	return nil
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	received := Message{}
+	signature := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+
+		signature = r.Header.Get(SignatureHeader)
+		if signature != sign("secret", body, now) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(server.URL, "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	notifier.now = func() time.Time { return now }
+
+	msg := Message{Event: EventLowStock, Subject: "Book1 is running low", Data: map[string]any{"book_id": 1}}
+	if err := notifier.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if received.Event != EventLowStock || received.Data["book_id"] != float64(1) {
+		t.Fatalf("unexpected delivery %+v", received)
+	}
+
+	if !strings.HasPrefix(signature, "t=1700000000,v1=") {
+		t.Fatalf("unexpected signature %q", signature)
+	}
+
+	notifier.secret = "other"
+	if err := notifier.Notify(context.Background(), msg); err == nil {
+		t.Fatalf("expected rejected delivery to fail")
+	}
+
+	if _, err := NewWebhookNotifier("ftp://example.com", ""); err == nil {
+		t.Fatalf("expected non http url to be rejected")
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	notifier, err := NewEmailNotifier(EmailConfig{
+		Addr: "localhost:25",
+		From: "store@example.com",
+		To:   []string{"staff@example.com", "owner@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := ""
+	notifier.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "localhost:25" || auth != nil || len(to) != 2 {
+			t.Fatalf("unexpected envelope %s %v %v", addr, auth, to)
+		}
+		sent = string(msg)
+		return nil
+	}
+
+	msg := Message{Event: EventLowStock, Subject: "Book1 is running low", Body: "2 copies left\nreorder 10"}
+	if err := notifier.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{"To: staff@example.com, owner@example.com\r\n", "Subject: Book1 is running low\r\n", "\r\n\r\n2 copies left\r\nreorder 10\r\n"} {
+		if !strings.Contains(sent, want) {
+			t.Fatalf("expected message to contain %q, got %q", want, sent)
+		}
+	}
+
+	if _, err := NewEmailNotifier(EmailConfig{Addr: "localhost:25", From: "store@example.com"}); err == nil {
+		t.Fatalf("expected config without recipients to be rejected")
+	}
+}
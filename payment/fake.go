@@ -0,0 +1,184 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sources with a special meaning to the FakeProvider, every other source
+// is charged successfully.
+const (
+	FakeDeclinedSource = "tok_declined"
+	// FakePendingSource leaves the payment pending, settle it with a
+	// webhook built by Event.
+	FakePendingSource = "tok_pending"
+)
+
+// FakeProvider keeps payments in memory, for development and tests. Its
+// webhooks are signed like Stripe's with the secret it was created with.
+type FakeProvider struct {
+	secret string
+	now    func() time.Time
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+	charges  map[string]string
+	refunds  map[string]bool
+	next     int
+}
+
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		secret:   webhookSecret,
+		now:      time.Now,
+		payments: map[string]*Payment{},
+		charges:  map[string]string{},
+		refunds:  map[string]bool{},
+	}
+}
+
+func (p *FakeProvider) Authorize(_ context.Context, charge Charge) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the reference makes retried charges return the first payment
+	if id, ok := p.charges[charge.Reference]; ok && charge.Reference != "" {
+		return *p.payments[id], nil
+	}
+
+	p.next++
+	payment := &Payment{
+		ID:        fmt.Sprintf("fake_pay_%d", p.next),
+		Reference: charge.Reference,
+		Status:    StatusAuthorized,
+		Amount:    charge.Amount,
+		Currency:  charge.Currency,
+	}
+
+	p.payments[payment.ID] = payment
+	p.charges[charge.Reference] = payment.ID
+
+	switch charge.Source {
+	case FakeDeclinedSource:
+		payment.Status = StatusFailed
+		payment.FailureReason = "card declined"
+		return *payment, fmt.Errorf("%w: card declined", ErrDeclined)
+	case FakePendingSource:
+		payment.Status = StatusPending
+	}
+
+	return *payment, nil
+}
+
+func (p *FakeProvider) Capture(_ context.Context, id string) (Payment, error) {
+	return p.transition(id, StatusAuthorized, StatusCaptured)
+}
+
+func (p *FakeProvider) Void(_ context.Context, id string) (Payment, error) {
+	return p.transition(id, StatusAuthorized, StatusVoided)
+}
+
+// Refund pays back amount, or everything not refunded yet when amount is 0.
+func (p *FakeProvider) Refund(_ context.Context, id string, amount int64, reference string) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[id]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+
+	// the reference makes retried refunds return the payment as refunded
+	if reference != "" && p.refunds[reference] {
+		return *payment, nil
+	}
+
+	if payment.Status != StatusCaptured && payment.Status != StatusRefunded {
+		return *payment, ErrInvalidState
+	}
+
+	if amount == 0 {
+		amount = payment.Amount - payment.Refunded
+	}
+
+	if amount <= 0 || payment.Refunded+amount > payment.Amount {
+		return *payment, fmt.Errorf("%w: can not refund %d of %d, %d already refunded", ErrInvalidState, amount, payment.Amount, payment.Refunded)
+	}
+
+	payment.Refunded += amount
+	if payment.Refunded == payment.Amount {
+		payment.Status = StatusRefunded
+	}
+
+	if reference != "" {
+		p.refunds[reference] = true
+	}
+	return *payment, nil
+}
+
+func (p *FakeProvider) transition(id string, from, to Status) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[id]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+
+	if payment.Status != from {
+		return *payment, fmt.Errorf("%w: payment is %s", ErrInvalidState, payment.Status)
+	}
+
+	payment.Status = to
+	return *payment, nil
+}
+
+// Settle ends a pending payment as captured or failed, the way an
+// asynchronous payment method would.
+func (p *FakeProvider) Settle(id string, captured bool) (Payment, error) {
+	if captured {
+		return p.transition(id, StatusPending, StatusCaptured)
+	}
+	return p.transition(id, StatusPending, StatusFailed)
+}
+
+// Event builds a signed webhook delivery reporting the payment's current
+// state with the given event type.
+func (p *FakeProvider) Event(eventID, eventType, paymentID string) ([]byte, http.Header, error) {
+	p.mu.Lock()
+	stored, ok := p.payments[paymentID]
+	payment := Payment{}
+	if ok {
+		payment = *stored
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+
+	payload, err := json.Marshal(Event{ID: eventID, Type: eventType, Payment: payment})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	header.Set(SignatureHeader, SignWebhook(p.secret, payload, p.now()))
+	return payload, header, nil
+}
+
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := VerifyWebhook(p.secret, payload, header.Get(SignatureHeader), p.now()); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, fmt.Errorf("payment: decode webhook: %w", err)
+	}
+	return event, nil
+}
@@ -0,0 +1,187 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	signature := SignWebhook("whsec", payload, now)
+
+	cases := []struct {
+		name      string
+		secret    string
+		payload   string
+		signature string
+		now       time.Time
+		valid     bool
+	}{
+		{"valid", "whsec", string(payload), signature, now, true},
+		{"rotated secret", "whsec", string(payload), signature + ",v1=deadbeef", now.Add(time.Minute), true},
+		{"other secret", "other", string(payload), signature, now, false},
+		{"tampered payload", "whsec", `{"id":"evt_2"}`, signature, now, false},
+		{"too old", "whsec", string(payload), signature, now.Add(SignatureTolerance + time.Second), false},
+		{"malformed", "whsec", string(payload), "v1=abc", now, false},
+		{"no secret", "", string(payload), signature, now, false},
+	}
+
+	for _, c := range cases {
+		err := VerifyWebhook(c.secret, []byte(c.payload), c.signature, c.now)
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if !c.valid && !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", c.name, err)
+		}
+	}
+}
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakeProvider("whsec")
+
+	payment, err := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: "tok_visa", Reference: "1"})
+	if err != nil || payment.Status != StatusAuthorized {
+		t.Fatalf("expected an authorized payment, got %+v, %v", payment, err)
+	}
+
+	if retried, _ := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: "tok_visa", Reference: "1"}); retried.ID != payment.ID {
+		t.Fatalf("expected a retried charge to return %s, got %s", payment.ID, retried.ID)
+	}
+
+	if payment, err = provider.Capture(ctx, payment.ID); err != nil || payment.Status != StatusCaptured {
+		t.Fatalf("expected a captured payment, got %+v, %v", payment, err)
+	}
+
+	if _, err := provider.Void(ctx, payment.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected voiding a captured payment to fail, got %v", err)
+	}
+
+	if payment, _ = provider.Refund(ctx, payment.ID, 800, "r1"); payment.Status != StatusCaptured || payment.Refunded != 800 {
+		t.Fatalf("expected a partial refund, got %+v", payment)
+	}
+
+	if payment, _ = provider.Refund(ctx, payment.ID, 800, "r1"); payment.Refunded != 800 {
+		t.Fatalf("expected a retried refund to pay nothing back, got %+v", payment)
+	}
+
+	if payment, _ = provider.Refund(ctx, payment.ID, 0, "r2"); payment.Status != StatusRefunded || payment.Refunded != 1800 {
+		t.Fatalf("expected the rest refunded, got %+v", payment)
+	}
+
+	if _, err := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: FakeDeclinedSource, Reference: "2"}); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected ErrDeclined, got %v", err)
+	}
+
+	pending, _ := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: FakePendingSource, Reference: "3"})
+	provider.Settle(pending.ID, true)
+
+	payload, header, err := provider.Event("evt_1", EventCaptured, pending.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if event.ID != "evt_1" || event.Payment.Reference != "3" || event.Payment.Status != StatusCaptured {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestStripeProvider(t *testing.T) {
+	requests := []*http.Request{}
+	forms := []url.Values{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		requests, forms = append(requests, r), append(forms, form)
+
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/payment_intents":
+			if form.Get("payment_method") == "pm_card_chargeDeclined" {
+				w.WriteHeader(http.StatusPaymentRequired)
+				io.WriteString(w, `{"error":{"type":"card_error","code":"card_declined","message":"Your card was declined."}}`)
+				return
+			}
+			io.WriteString(w, `{"id":"pi_1","object":"payment_intent","status":"requires_capture","amount":1800,"currency":"usd","metadata":{"reference":"7"}}`)
+		case "/v1/payment_intents/pi_1/capture":
+			io.WriteString(w, `{"id":"pi_1","object":"payment_intent","status":"succeeded","amount":1800,"currency":"usd","metadata":{"reference":"7"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewStripeProvider(StripeConfig{BaseURL: server.URL, SecretKey: "sk_test", WebhookSecret: "whsec"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := context.Background()
+	payment, err := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: "pm_card_visa", Reference: "7"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if payment.ID != "pi_1" || payment.Status != StatusAuthorized || payment.Currency != "USD" || payment.Reference != "7" {
+		t.Fatalf("unexpected payment %+v", payment)
+	}
+
+	form := forms[0]
+	if form.Get("amount") != "1800" || form.Get("currency") != "usd" || form.Get("capture_method") != "manual" || form.Get("metadata[reference]") != "7" {
+		t.Fatalf("unexpected authorize form %v", form)
+	}
+
+	if key := requests[0].Header.Get("Idempotency-Key"); key != "authorize-7" {
+		t.Fatalf("expected idempotency key authorize-7, got %q", key)
+	}
+
+	if payment, err = provider.Capture(ctx, "pi_1"); err != nil || payment.Status != StatusCaptured {
+		t.Fatalf("expected a captured payment, got %+v, %v", payment, err)
+	}
+
+	if _, err := provider.Authorize(ctx, Charge{Amount: 1800, Currency: "USD", Source: "pm_card_chargeDeclined", Reference: "8"}); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected ErrDeclined, got %v", err)
+	}
+
+	if _, err := provider.Void(ctx, "pi_2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	payload := []byte(`{"id":"evt_1","type":"charge.refunded","data":{"object":{"id":"ch_1","object":"charge",` +
+		`"payment_intent":"pi_1","status":"succeeded","amount":1800,"amount_refunded":1800,"currency":"usd","metadata":{"reference":"7"}}}}`)
+	header := http.Header{}
+	header.Set(SignatureHeader, SignWebhook("whsec", payload, time.Now()))
+
+	event, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if event.Type != EventRefunded || event.Payment.ID != "pi_1" || event.Payment.Status != StatusRefunded || event.Payment.Reference != "7" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	header.Set(SignatureHeader, strings.Replace(header.Get(SignatureHeader), "v1=", "v1=0", 1))
+	if _, err := provider.ParseWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Package payment charges customers through a payment provider and reads
+// the webhooks it sends back.
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrDeclined is returned when the provider refused the charge, the
+	// customer has to use another payment method.
+	ErrDeclined = errors.New("payment declined")
+	// ErrNotFound is returned for an unknown payment id.
+	ErrNotFound = errors.New("payment not found")
+	// ErrInvalidState is returned when a payment can not go through the
+	// requested step, e.g. capturing a voided payment.
+	ErrInvalidState = errors.New("payment can not be changed in its current state")
+	// ErrInvalidSignature is returned for webhook deliveries that were not
+	// signed by the provider, or too long ago.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Status is where a payment stands.
+type Status string
+
+const (
+	// StatusPending payments wait on the customer or the provider, a
+	// webhook reports how they end.
+	StatusPending    Status = "pending"
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusVoided     Status = "voided"
+	StatusRefunded   Status = "refunded"
+	StatusFailed     Status = "failed"
+)
+
+// Charge asks for Amount, in minor units of Currency, to be charged to
+// Source, a payment method token obtained by the client from the provider.
+// Reference identifies the purchase, it comes back with every payment and
+// webhook event and makes retried charges idempotent.
+type Charge struct {
+	Amount      int64
+	Currency    string
+	Source      string
+	Reference   string
+	Description string
+}
+
+// Payment is the state of a charge at the provider. Refunded is the total
+// refunded so far.
+type Payment struct {
+	ID            string `json:"id"`
+	Reference     string `json:"reference"`
+	Status        Status `json:"status"`
+	Amount        int64  `json:"amount"`
+	Refunded      int64  `json:"refunded,omitempty"`
+	Currency      string `json:"currency"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// Event types
+const (
+	EventCaptured = "payment.captured"
+	EventFailed   = "payment.failed"
+	EventVoided   = "payment.voided"
+	EventRefunded = "payment.refunded"
+)
+
+// Event is a webhook notification of a payment changing. Providers retry
+// deliveries, so the same event can arrive more than once.
+type Event struct {
+	ID      string  `json:"id"`
+	Type    string  `json:"type"`
+	Payment Payment `json:"payment"`
+}
+
+// Provider charges payments in two steps: Authorize reserves the amount
+// and Capture collects it. Void releases an authorization that is not
+// captured and Refund pays captured money back, in full or in part. Refunds
+// are made once per reference, a retried refund does not pay back twice.
+type Provider interface {
+	Authorize(ctx context.Context, charge Charge) (Payment, error)
+	Capture(ctx context.Context, id string) (Payment, error)
+	Void(ctx context.Context, id string) (Payment, error)
+	Refund(ctx context.Context, id string, amount int64, reference string) (Payment, error)
+	// ParseWebhook checks the signature of a webhook delivery and decodes
+	// its event.
+	ParseWebhook(payload []byte, header http.Header) (Event, error)
+}
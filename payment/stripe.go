@@ -0,0 +1,277 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeConfig points a StripeProvider at the Stripe API, or any service
+// speaking it.
+type StripeConfig struct {
+	// BaseURL defaults to https://api.stripe.com
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+}
+
+// StripeProvider charges through Stripe payment intents. Authorize confirms
+// an intent with manual capture, so the amount is only held until Capture.
+type StripeProvider struct {
+	config StripeConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewStripeProvider(config StripeConfig) (*StripeProvider, error) {
+	if config.SecretKey == "" {
+		return nil, fmt.Errorf("payment: stripe secret key is required")
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.stripe.com"
+	}
+
+	if _, err := url.Parse(config.BaseURL); err != nil {
+		return nil, fmt.Errorf("payment: invalid stripe url: %w", err)
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &StripeProvider{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+// stripeIntent is the part of a payment intent the provider reads
+type stripeIntent struct {
+	ID             string            `json:"id"`
+	Object         string            `json:"object"`
+	Status         string            `json:"status"`
+	Amount         int64             `json:"amount"`
+	Currency       string            `json:"currency"`
+	Metadata       map[string]string `json:"metadata"`
+	PaymentIntent  string            `json:"payment_intent"`
+	AmountRefunded int64             `json:"amount_refunded"`
+	LastError      *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+func (i stripeIntent) payment() Payment {
+	payment := Payment{
+		ID:        i.ID,
+		Reference: i.Metadata["reference"],
+		Amount:    i.Amount,
+		Refunded:  i.AmountRefunded,
+		Currency:  strings.ToUpper(i.Currency),
+	}
+
+	// charges carry the intent they belong to, payments are identified by
+	// their intent
+	if i.Object == "charge" && i.PaymentIntent != "" {
+		payment.ID = i.PaymentIntent
+	}
+
+	switch i.Status {
+	case "requires_capture":
+		payment.Status = StatusAuthorized
+	case "succeeded":
+		payment.Status = StatusCaptured
+		if i.AmountRefunded > 0 && i.AmountRefunded >= i.Amount {
+			payment.Status = StatusRefunded
+		}
+	case "canceled":
+		payment.Status = StatusVoided
+	case "requires_payment_method", "failed":
+		payment.Status = StatusFailed
+	default:
+		payment.Status = StatusPending
+	}
+
+	if i.LastError != nil {
+		payment.FailureReason = i.LastError.Message
+	}
+
+	return payment
+}
+
+func (s *StripeProvider) Authorize(ctx context.Context, charge Charge) (Payment, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(charge.Amount, 10))
+	form.Set("currency", strings.ToLower(charge.Currency))
+	form.Set("payment_method", charge.Source)
+	form.Set("capture_method", "manual")
+	form.Set("confirm", "true")
+	form.Set("metadata[reference]", charge.Reference)
+	if charge.Description != "" {
+		form.Set("description", charge.Description)
+	}
+
+	intent := stripeIntent{}
+	if err := s.post(ctx, "/v1/payment_intents", form, "authorize-"+charge.Reference, &intent); err != nil {
+		return Payment{}, err
+	}
+
+	payment := intent.payment()
+	if payment.Status == StatusFailed {
+		return payment, fmt.Errorf("%w: %s", ErrDeclined, payment.FailureReason)
+	}
+	return payment, nil
+}
+
+func (s *StripeProvider) Capture(ctx context.Context, id string) (Payment, error) {
+	intent := stripeIntent{}
+	err := s.post(ctx, "/v1/payment_intents/"+url.PathEscape(id)+"/capture", url.Values{}, "capture-"+id, &intent)
+	return intent.payment(), err
+}
+
+func (s *StripeProvider) Void(ctx context.Context, id string) (Payment, error) {
+	intent := stripeIntent{}
+	err := s.post(ctx, "/v1/payment_intents/"+url.PathEscape(id)+"/cancel", url.Values{}, "void-"+id, &intent)
+	return intent.payment(), err
+}
+
+// Refund pays back amount, or everything not refunded yet when amount is 0.
+func (s *StripeProvider) Refund(ctx context.Context, id string, amount int64, reference string) (Payment, error) {
+	form := url.Values{}
+	form.Set("payment_intent", id)
+	if amount > 0 {
+		form.Set("amount", strconv.FormatInt(amount, 10))
+	}
+
+	key := ""
+	if reference != "" {
+		form.Set("metadata[reference]", reference)
+		key = "refund-" + reference
+	}
+
+	refund := struct {
+		Status string `json:"status"`
+	}{}
+	if err := s.post(ctx, "/v1/refunds", form, key, &refund); err != nil {
+		return Payment{}, err
+	}
+
+	if refund.Status == "failed" || refund.Status == "canceled" {
+		return Payment{}, fmt.Errorf("%w: refund %s", ErrInvalidState, refund.Status)
+	}
+
+	// the refunded total is on the charge of the intent
+	intent := struct {
+		stripeIntent
+		LatestCharge struct {
+			AmountRefunded int64 `json:"amount_refunded"`
+		} `json:"latest_charge"`
+	}{}
+	if err := s.get(ctx, "/v1/payment_intents/"+url.PathEscape(id)+"?expand[]=latest_charge", &intent); err != nil {
+		return Payment{}, err
+	}
+
+	intent.AmountRefunded = intent.LatestCharge.AmountRefunded
+	return intent.payment(), nil
+}
+
+func (s *StripeProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := VerifyWebhook(s.config.WebhookSecret, payload, header.Get(SignatureHeader), s.now()); err != nil {
+		return Event{}, err
+	}
+
+	webhook := struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object stripeIntent `json:"object"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return Event{}, fmt.Errorf("payment: decode webhook: %w", err)
+	}
+
+	event := Event{ID: webhook.ID, Payment: webhook.Data.Object.payment()}
+
+	switch webhook.Type {
+	case "payment_intent.succeeded":
+		event.Type = EventCaptured
+	case "payment_intent.payment_failed":
+		event.Type = EventFailed
+	case "payment_intent.canceled":
+		event.Type = EventVoided
+	case "charge.refunded":
+		event.Type = EventRefunded
+	default:
+		// other events are acknowledged and ignored
+		event.Type = webhook.Type
+	}
+
+	return event, nil
+}
+
+func (s *StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	return s.do(req, out)
+}
+
+func (s *StripeProvider) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, out)
+}
+
+func (s *StripeProvider) do(req *http.Request, out any) error {
+	req.Header.Set("Authorization", "Bearer "+s.config.SecretKey)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 300 {
+		failure := struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		json.Unmarshal(body, &failure)
+
+		switch {
+		case failure.Error.Type == "card_error" || res.StatusCode == http.StatusPaymentRequired:
+			return fmt.Errorf("%w: %s", ErrDeclined, failure.Error.Message)
+		case res.StatusCode == http.StatusNotFound:
+			return ErrNotFound
+		case failure.Error.Code == "payment_intent_unexpected_state":
+			return fmt.Errorf("%w: %s", ErrInvalidState, failure.Error.Message)
+		}
+
+		return fmt.Errorf("payment: stripe %s %s: %s %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(failure.Error.Message))
+	}
+
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Login exchanges email and password for a token. The credentials are kept
+// so that an expired token can be refreshed transparently.
+func (c *Client) Login(ctx context.Context, email, password string) (string, error) {
+	creds := Credentials{Email: email, Password: password}
+
+	payload, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+
+	out := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.send(ctx, http.MethodPost, "/auth/login", payload, "", &out); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.token = out.Token
+	c.credentials = &creds
+	c.mu.Unlock()
+
+	return out.Token, nil
+}
+
+// Signup registers a new user account.
+func (c *Client) Signup(ctx context.Context, req SignupRequest) (*User, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{}
+	if err := c.send(ctx, http.MethodPost, "/auth/signup", payload, "", &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
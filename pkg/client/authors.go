@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ListAuthors returns every author, or those whose name contains name when
+// it is not empty.
+func (c *Client) ListAuthors(ctx context.Context, name string) ([]Author, error) {
+	path := "/authors/"
+	if name != "" {
+		path += "?" + url.Values{"name": {name}}.Encode()
+	}
+
+	authors := []Author{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &authors); err != nil {
+		return nil, err
+	}
+	return authors, nil
+}
+
+func (c *Client) GetAuthor(ctx context.Context, id uint) (*Author, error) {
+	author := Author{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/authors/%d", id), nil, &author); err != nil {
+		return nil, err
+	}
+	return &author, nil
+}
+
+// GetAuthorBooks lists the books an author is credited on, in any role.
+func (c *Client) GetAuthorBooks(ctx context.Context, id uint) ([]AuthorCredit, error) {
+	credits := []AuthorCredit{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/authors/%d/books", id), nil, &credits); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// CreateAuthor requires an admin token.
+func (c *Client) CreateAuthor(ctx context.Context, fields AuthorFields) (*Author, error) {
+	author := Author{}
+	if err := c.do(ctx, http.MethodPost, "/authors/", fields, &author); err != nil {
+		return nil, err
+	}
+	return &author, nil
+}
+
+// ReplaceAuthor overwrites every editable field. Requires an admin token.
+func (c *Client) ReplaceAuthor(ctx context.Context, id uint, fields AuthorFields) (*Author, error) {
+	author := Author{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/authors/%d", id), fields, &author); err != nil {
+		return nil, err
+	}
+	return &author, nil
+}
+
+// DeleteAuthor fails with CodeAuthorInUse while the author is credited on a
+// book. Requires an admin token.
+func (c *Client) DeleteAuthor(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/authors/%d", id), nil, nil)
+}
+
+// GetBookAuthors lists the credits of a book in display order.
+func (c *Client) GetBookAuthors(ctx context.Context, bookID uint) ([]BookAuthor, error) {
+	credits := []BookAuthor{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/books/%d/authors", bookID), nil, &credits); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// SetBookAuthors replaces the credits of a book, the authors among them
+// become its byline. It fails with CodeValidationFailed when none is
+// credited as author. Requires an admin token.
+func (c *Client) SetBookAuthors(ctx context.Context, bookID uint, credits []BookCredit) ([]BookAuthor, error) {
+	result := []BookAuthor{}
+	payload := struct {
+		Credits []BookCredit `json:"credits"`
+	}{credits}
+
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/books/%d/authors", bookID), payload, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+func (c *Client) ListBooks(ctx context.Context) ([]Book, error) {
+	books := []Book{}
+	if err := c.do(ctx, http.MethodGet, "/books/", nil, &books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (c *Client) GetBook(ctx context.Context, id uint) (*Book, error) {
+	book := Book{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/books/%d", id), nil, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// GetBookByISBN accepts either an ISBN-10 or an ISBN-13, hyphens allowed.
+func (c *Client) GetBookByISBN(ctx context.Context, isbn string) (*Book, error) {
+	book := Book{}
+	if err := c.do(ctx, http.MethodGet, "/books/isbn/"+url.PathEscape(isbn), nil, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// Purchase buys quantity copies of a book for the authenticated user. Books
+// sold in several editions have to be bought with PurchaseEdition.
+func (c *Client) Purchase(ctx context.Context, bookID uint, quantity int) error {
+	return c.do(ctx, http.MethodPost, "/books/purchase", PurchaseRequest{
+		BookID:   int(bookID),
+		Quantity: quantity,
+	}, nil)
+}
+
+// PurchaseEdition buys quantity copies of a single edition.
+func (c *Client) PurchaseEdition(ctx context.Context, editionID uint, quantity int) error {
+	return c.do(ctx, http.MethodPost, "/books/purchase", PurchaseRequest{
+		EditionID: int(editionID),
+		Quantity:  quantity,
+	}, nil)
+}
+
+// Checkout places a purchase and returns its receipt with the tax charged.
+// A purchase whose payment is still processed comes back with Status
+// pending, a declined payment fails with CodePaymentDeclined.
+func (c *Client) Checkout(ctx context.Context, req PurchaseRequest) (*Purchase, error) {
+	purchase := Purchase{}
+	if err := c.do(ctx, http.MethodPost, "/books/purchase", req, &purchase); err != nil {
+		return nil, err
+	}
+	return &purchase, nil
+}
+
+// PreviewPurchase prices a purchase with the running promotions, the coupon
+// and tax without making it.
+func (c *Client) PreviewPurchase(ctx context.Context, req PurchaseRequest) (*PriceBreakdown, error) {
+	breakdown := PriceBreakdown{}
+	if err := c.do(ctx, http.MethodPost, "/books/purchase/preview", req, &breakdown); err != nil {
+		return nil, err
+	}
+	return &breakdown, nil
+}
+
+// CreateBook requires an admin token.
+func (c *Client) CreateBook(ctx context.Context, book Book) (*Book, error) {
+	created := Book{}
+	if err := c.do(ctx, http.MethodPost, "/books/", book, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateBook patches only the fields set in update. Requires an admin token.
+func (c *Client) UpdateBook(ctx context.Context, id uint, update BookUpdate) (*Book, error) {
+	book := Book{}
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/books/%d", id), update, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// ReplaceBook overwrites every editable field. Requires an admin token.
+func (c *Client) ReplaceBook(ctx context.Context, id uint, fields BookFields) (*Book, error) {
+	book := Book{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/books/%d", id), fields, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// DeleteBook requires an admin token.
+func (c *Client) DeleteBook(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/books/%d", id), nil, nil)
+}
+
+// Reserve holds copies of a book for the caller while they check out, buy
+// them with PurchaseRequest.ReservationID before the reservation expires.
+func (c *Client) Reserve(ctx context.Context, req ReservationRequest) (*Reservation, error) {
+	reservation := Reservation{}
+	if err := c.do(ctx, http.MethodPost, "/reservations/", req, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// MyReservations lists the caller's reservations, newest first.
+func (c *Client) MyReservations(ctx context.Context) ([]Reservation, error) {
+	reservations := []Reservation{}
+	if err := c.do(ctx, http.MethodGet, "/reservations/", nil, &reservations); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// ReleaseReservation gives up a reservation and puts its copies back in
+// stock.
+func (c *Client) ReleaseReservation(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/reservations/%d", id), nil, nil)
+}
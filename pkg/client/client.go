@@ -0,0 +1,205 @@
+// Package client is a typed Go client for the book store HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Client talks to the book store API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	token       string
+	credentials *Credentials
+}
+
+// Credentials are remembered after a successful Login so the client can
+// re-authenticate when its token is rejected.
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type Option func(*Client)
+
+// WithHTTPClient overrides the http.Client used for requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken starts the client with an already issued bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithCredentials lets the client log in lazily and refresh its token.
+func WithCredentials(email, password string) Option {
+	return func(c *Client) {
+		c.credentials = &Credentials{Email: email, Password: password}
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Token returns the bearer token currently used by the client.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken replaces the bearer token used by the client.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes the POST sent with ctx carry an Idempotency-Key.
+// Sending it again with the same key and body, after a timeout for
+// instance, returns the first response instead of repeating the request.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+type envelope struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do sends an authenticated request and decodes the response data into out.
+// A 401 triggers a single re-login when credentials are known.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("client: encode request: %w", err)
+		}
+	}
+
+	if c.Token() == "" && c.hasCredentials() {
+		if err := c.refresh(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := c.send(ctx, method, path, payload, c.Token(), out)
+	if !IsUnauthorized(err) || !c.hasCredentials() {
+		return err
+	}
+
+	if err := c.refresh(ctx); err != nil {
+		return err
+	}
+
+	return c.send(ctx, method, path, payload, c.Token(), out)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, token string, out any) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("client: build request: %w", err)
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok && key != "" && method == http.MethodPost {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("client: %s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("client: read response: %w", err)
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return decodeError(res.StatusCode, raw)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("client: decode response: %w", err)
+	}
+
+	if len(env.Data) == 0 || string(env.Data) == "null" {
+		return nil
+	}
+
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return fmt.Errorf("client: decode response data: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) hasCredentials() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.credentials != nil
+}
+
+func (c *Client) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	creds := c.credentials
+	c.mu.Unlock()
+
+	if creds == nil {
+		return &Error{StatusCode: http.StatusUnauthorized, Message: "no credentials to refresh token"}
+	}
+
+	_, err := c.Login(ctx, creds.Email, creds.Password)
+	return err
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/peekeah/book-store/app"
+	"github.com/peekeah/book-store/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, sqlmock.Sqlmock) {
+	t.Helper()
+	t.Setenv("JWT_SECRET_KEY", "client-test-secret")
+
+	db, mock := utils.GetDBMock()
+	server := &app.Server{DB: db}
+
+	ts := httptest.NewServer(server.Router())
+	t.Cleanup(ts.Close)
+
+	return ts, mock
+}
+
+func expectLogin(t *testing.T, mock sqlmock.Sqlmock, id int, email, password string) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error while hashing password")
+	}
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "email", "password", "role"}).
+			AddRow(id, email, string(hash), "user"))
+}
+
+func expectAuthenticated(mock sqlmock.Sqlmock, id int, role string) {
+	mock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "role"}).AddRow(id, role))
+}
+
+func TestLoginAndGetBook(t *testing.T) {
+	ts, mock := newTestServer(t)
+	c := New(ts.URL)
+	ctx := context.Background()
+
+	expectLogin(t, mock, 1, "user@example.com", "secret")
+
+	token, err := c.Login(ctx, "user@example.com", "secret")
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	if token == "" || c.Token() != token {
+		t.Fatalf("expected token to be stored")
+	}
+
+	expectAuthenticated(mock, 1, "user")
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name", "author", "price_minor", "price_currency"}).
+			AddRow(7, "Book7", "Author7", 300, "EUR"))
+	mock.ExpectQuery(`FROM stock_levels l`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "warehouse_id", "code", "city", "copies"}).
+			AddRow(7, 2, "PNQ", "Pune", 4))
+
+	book, err := c.GetBook(ctx, 7)
+	if err != nil {
+		t.Fatalf("get book failed: %v", err)
+	}
+
+	if book.ID != 7 || book.Name != "Book7" || book.Price.Amount != 300 || book.Price.Formatted != "€3.00" ||
+		len(book.Availability) != 1 || book.Availability[0].Code != "PNQ" || book.Availability[0].Copies != 4 {
+		t.Fatalf("unexpected book: %+v", book)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetBookNotFound(t *testing.T) {
+	ts, mock := newTestServer(t)
+	c := New(ts.URL)
+	ctx := context.Background()
+
+	expectLogin(t, mock, 1, "user@example.com", "secret")
+
+	if _, err := c.Login(ctx, "user@example.com", "secret"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	expectAuthenticated(mock, 1, "user")
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}))
+
+	_, err := c.GetBook(ctx, 99)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	if !HasCode(err, CodeBookNotFound) {
+		t.Fatalf("expected %s code, got %v", CodeBookNotFound, err)
+	}
+}
+
+func TestUnauthorizedWithoutCredentials(t *testing.T) {
+	ts, _ := newTestServer(t)
+	c := New(ts.URL)
+
+	_, err := c.ListBooks(context.Background())
+	if !IsUnauthorized(err) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestTokenRefreshOnUnauthorized(t *testing.T) {
+	ts, mock := newTestServer(t)
+	c := New(ts.URL, WithToken("stale-token"), WithCredentials("user@example.com", "secret"))
+
+	expectLogin(t, mock, 1, "user@example.com", "secret")
+	expectAuthenticated(mock, 1, "user")
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).
+			AddRow(1, "Book1").
+			AddRow(2, "Book2"))
+	mock.ExpectQuery(`FROM stock_levels l`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "warehouse_id", "code", "city", "copies"}))
+
+	books, err := c.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("list books failed: %v", err)
+	}
+
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books, got %d", len(books))
+	}
+
+	if c.Token() == "stale-token" {
+		t.Fatalf("expected token to be refreshed")
+	}
+}
+
+func TestAdminDeleteBook(t *testing.T) {
+	ts, mock := newTestServer(t)
+	c := New(ts.URL)
+	ctx := context.Background()
+
+	expectLogin(t, mock, 1, "admin@example.com", "secret")
+
+	if _, err := c.Login(ctx, "admin@example.com", "secret"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	expectAuthenticated(mock, 1, "admin")
+	expectAuthenticated(mock, 1, "admin")
+	mock.ExpectQuery(`^SELECT (.+) FROM "books"`).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "name"}).AddRow(3, "Book3"))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^UPDATE "books" SET "deleted_at"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := c.DeleteBook(ctx, 3); err != nil {
+		t.Fatalf("delete book failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	ts, _ := newTestServer(t)
+	c := New(ts.URL, WithToken("token"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.ListBooks(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancelled, got %v", err)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	keys := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":200,"data":{"ID":7,"status":"paid"}}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	c := New(ts.URL, WithToken("token"))
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+
+	purchase, err := c.Checkout(ctx, PurchaseRequest{BookID: 1, Quantity: 2})
+	if err != nil {
+		t.Fatalf("checkout failed: %v", err)
+	}
+
+	if purchase.ID != 7 || purchase.Status != PurchasePaid {
+		t.Fatalf("unexpected purchase: %+v", purchase)
+	}
+
+	// only POSTs carry the key
+	c.ListBooks(ctx)
+
+	if len(keys) != 2 || keys[0] != "order-1" || keys[1] != "" {
+		t.Fatalf("unexpected idempotency keys %q", keys)
+	}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ListCoupons requires an admin token.
+func (c *Client) ListCoupons(ctx context.Context) ([]Coupon, error) {
+	coupons := []Coupon{}
+	if err := c.do(ctx, http.MethodGet, "/coupons/", nil, &coupons); err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// GetCoupon requires an admin token.
+func (c *Client) GetCoupon(ctx context.Context, id uint) (*Coupon, error) {
+	coupon := Coupon{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/coupons/%d", id), nil, &coupon); err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// CreateCoupon fails with CodeCouponExists when the code was ever used.
+// Requires an admin token.
+func (c *Client) CreateCoupon(ctx context.Context, fields CouponFields) (*Coupon, error) {
+	coupon := Coupon{}
+	if err := c.do(ctx, http.MethodPost, "/coupons/", fields, &coupon); err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// ReplaceCoupon overwrites every editable field. Requires an admin token.
+func (c *Client) ReplaceCoupon(ctx context.Context, id uint, fields CouponFields) (*Coupon, error) {
+	coupon := Coupon{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/coupons/%d", id), fields, &coupon); err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// DeleteCoupon stops the coupon from being redeemed. Requires an admin
+// token.
+func (c *Client) DeleteCoupon(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/coupons/%d", id), nil, nil)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ListBookEditions returns every format a book is sold in.
+func (c *Client) ListBookEditions(ctx context.Context, bookID uint) ([]Edition, error) {
+	editions := []Edition{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/books/%d/editions", bookID), nil, &editions); err != nil {
+		return nil, err
+	}
+	return editions, nil
+}
+
+func (c *Client) GetEdition(ctx context.Context, id uint) (*Edition, error) {
+	edition := Edition{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/editions/%d", id), nil, &edition); err != nil {
+		return nil, err
+	}
+	return &edition, nil
+}
+
+// CreateEdition adds a format to a book. Requires an admin token.
+func (c *Client) CreateEdition(ctx context.Context, bookID uint, fields EditionFields) (*Edition, error) {
+	edition := Edition{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/books/%d/editions", bookID), fields, &edition); err != nil {
+		return nil, err
+	}
+	return &edition, nil
+}
+
+// ReplaceEdition overwrites every editable field. Requires an admin token.
+func (c *Client) ReplaceEdition(ctx context.Context, id uint, fields EditionFields) (*Edition, error) {
+	edition := Edition{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/editions/%d", id), fields, &edition); err != nil {
+		return nil, err
+	}
+	return &edition, nil
+}
+
+// DeleteEdition requires an admin token.
+func (c *Client) DeleteEdition(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/editions/%d", id), nil, nil)
+}
+
+func (c *Client) ListPublishers(ctx context.Context) ([]Publisher, error) {
+	publishers := []Publisher{}
+	if err := c.do(ctx, http.MethodGet, "/publishers/", nil, &publishers); err != nil {
+		return nil, err
+	}
+	return publishers, nil
+}
+
+// CreatePublisher fails with CodePublisherExists when the name is taken.
+// Requires an admin token.
+func (c *Client) CreatePublisher(ctx context.Context, fields PublisherFields) (*Publisher, error) {
+	publisher := Publisher{}
+	if err := c.do(ctx, http.MethodPost, "/publishers/", fields, &publisher); err != nil {
+		return nil, err
+	}
+	return &publisher, nil
+}
+
+// DeletePublisher fails with CodePublisherInUse while editions refer to the
+// publisher. Requires an admin token.
+func (c *Client) DeletePublisher(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/publishers/%d", id), nil, nil)
+}
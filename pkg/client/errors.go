@@ -0,0 +1,162 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrServer       = errors.New("server error")
+)
+
+// Error is returned for any non 2xx response and mirrors the API problem
+// document. Code is stable and meant to be switched on.
+type Error struct {
+	StatusCode int
+	Code       string
+	Title      string
+	Message    string
+	Fields     []FieldError
+}
+
+// FieldError is a translated validation failure on a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error codes returned by the API, see GET /errors for the full catalog.
+const (
+	CodeMalformedRequest   = "MALFORMED_REQUEST"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeRequestTooLarge    = "REQUEST_TOO_LARGE"
+	CodeInvalidID          = "INVALID_ID"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeForbidden          = "FORBIDDEN"
+	CodeUserNotFound       = "USER_NOT_FOUND"
+	CodeBookNotFound       = "BOOK_NOT_FOUND"
+	CodeAuthorNotFound     = "AUTHOR_NOT_FOUND"
+	CodeAuthorInUse        = "AUTHOR_IN_USE"
+	CodeCoverNotFound      = "COVER_NOT_FOUND"
+	CodeCoverTooLarge      = "COVER_TOO_LARGE"
+	CodeEditionNotFound    = "EDITION_NOT_FOUND"
+	CodeEditionRequired    = "EDITION_REQUIRED"
+	CodePublisherNotFound  = "PUBLISHER_NOT_FOUND"
+	CodePublisherInUse     = "PUBLISHER_IN_USE"
+	CodePublisherExists    = "PUBLISHER_EXISTS"
+	CodeEmailTaken         = "EMAIL_TAKEN"
+	CodeISBNTaken          = "ISBN_TAKEN"
+	CodeInsufficientStock  = "INSUFFICIENT_STOCK"
+	CodeCurrencyMismatch   = "CURRENCY_MISMATCH"
+	CodeCouponNotFound     = "COUPON_NOT_FOUND"
+	CodeCouponExists       = "COUPON_EXISTS"
+	CodeCouponInactive     = "COUPON_INACTIVE"
+	CodeCouponExhausted    = "COUPON_EXHAUSTED"
+	CodeCouponUserLimit    = "COUPON_USER_LIMIT"
+	CodeCouponNotEligible  = "COUPON_NOT_ELIGIBLE"
+	CodeCouponMinSpend     = "COUPON_MIN_SPEND"
+	CodePromotionNotFound  = "PROMOTION_NOT_FOUND"
+	CodeTaxRegion          = "TAX_REGION_UNSUPPORTED"
+	CodePaymentRequired    = "PAYMENT_METHOD_REQUIRED"
+	CodePaymentDeclined    = "PAYMENT_DECLINED"
+	CodePaymentFailed      = "PAYMENT_FAILED"
+	CodePaymentsDisabled   = "PAYMENTS_DISABLED"
+	CodeWebhookSignature   = "WEBHOOK_SIGNATURE_INVALID"
+	CodeIdempotencyInvalid = "IDEMPOTENCY_KEY_INVALID"
+	CodeIdempotencyReused  = "IDEMPOTENCY_KEY_REUSED"
+	CodeIdempotencyInUse   = "IDEMPOTENCY_KEY_IN_USE"
+	CodePurchaseNotFound   = "PURCHASE_NOT_FOUND"
+	CodeReturnNotFound     = "RETURN_NOT_FOUND"
+	CodeReturnResolved     = "RETURN_RESOLVED"
+	CodeRefundNotAllowed   = "REFUND_NOT_ALLOWED"
+	CodeRefundExceeds      = "REFUND_EXCEEDS_PURCHASE"
+	CodeReservationMissing = "RESERVATION_NOT_FOUND"
+	CodeReservationExpired = "RESERVATION_EXPIRED"
+	CodeWarehouseNotFound  = "WAREHOUSE_NOT_FOUND"
+	CodeWarehouseExists    = "WAREHOUSE_EXISTS"
+	CodeWarehouseStock     = "STOCK_KEPT_PER_WAREHOUSE"
+	CodeSupplierNotFound   = "SUPPLIER_NOT_FOUND"
+	CodeSupplierExists     = "SUPPLIER_EXISTS"
+	CodeOrderNotFound      = "SUPPLIER_ORDER_NOT_FOUND"
+	CodeOrderClosed        = "SUPPLIER_ORDER_CLOSED"
+	CodeStocktakeNotFound  = "STOCKTAKE_NOT_FOUND"
+	CodeStocktakeClosed    = "STOCKTAKE_CLOSED"
+	CodeInternal           = "INTERNAL_ERROR"
+)
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("book-store: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("book-store: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is lets callers match an Error against the sentinel errors above.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// HasCode reports whether err is an API error carrying code.
+func HasCode(err error, code string) bool {
+	apiErr := &Error{}
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrUnauthorized)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+func decodeError(status int, raw []byte) error {
+	problem := struct {
+		Title  string       `json:"title"`
+		Detail string       `json:"detail"`
+		Code   string       `json:"code"`
+		Fields []FieldError `json:"fields"`
+	}{}
+
+	apiErr := &Error{StatusCode: status, Message: http.StatusText(status)}
+
+	if err := json.Unmarshal(raw, &problem); err != nil {
+		return apiErr
+	}
+
+	apiErr.Code = problem.Code
+	apiErr.Title = problem.Title
+	apiErr.Fields = problem.Fields
+
+	switch {
+	case problem.Detail != "":
+		apiErr.Message = problem.Detail
+	case problem.Title != "":
+		apiErr.Message = problem.Title
+	}
+
+	return apiErr
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ListPromotions requires an admin token.
+func (c *Client) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	promotions := []Promotion{}
+	if err := c.do(ctx, http.MethodGet, "/promotions/", nil, &promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// CreatePromotion requires an admin token.
+func (c *Client) CreatePromotion(ctx context.Context, fields PromotionFields) (*Promotion, error) {
+	promotion := Promotion{}
+	if err := c.do(ctx, http.MethodPost, "/promotions/", fields, &promotion); err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+// ReplacePromotion overwrites every editable field. Requires an admin token.
+func (c *Client) ReplacePromotion(ctx context.Context, id uint, fields PromotionFields) (*Promotion, error) {
+	promotion := Promotion{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/promotions/%d", id), fields, &promotion); err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+// DeletePromotion ends a promotion. Requires an admin token.
+func (c *Client) DeletePromotion(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/promotions/%d", id), nil, nil)
+}
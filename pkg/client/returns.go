@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// RequestReturn asks for quantity copies of one of the caller's purchases
+// to be taken back. It fails with CodeRefundExceeds when more copies are
+// asked for than are left to return.
+func (c *Client) RequestReturn(ctx context.Context, purchaseID uint, quantity int, reason string) (*ReturnRequest, error) {
+	body := map[string]any{"quantity": quantity, "reason": reason}
+	request := ReturnRequest{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/purchases/%d/returns", purchaseID), body, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// MyReturns lists the caller's return requests, newest first.
+func (c *Client) MyReturns(ctx context.Context) ([]ReturnRequest, error) {
+	returns := []ReturnRequest{}
+	if err := c.do(ctx, http.MethodGet, "/returns/", nil, &returns); err != nil {
+		return nil, err
+	}
+	return returns, nil
+}
+
+// ListReturns lists every return request, filtered by status unless it is
+// empty. Requires an admin token.
+func (c *Client) ListReturns(ctx context.Context, status string) ([]ReturnRequest, error) {
+	path := "/admin/returns"
+	if status != "" {
+		path += "?status=" + status
+	}
+
+	returns := []ReturnRequest{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &returns); err != nil {
+		return nil, err
+	}
+	return returns, nil
+}
+
+// ApproveReturn refunds the return and restocks its copies unless the
+// resolution says otherwise. Requires an admin token.
+func (c *Client) ApproveReturn(ctx context.Context, id uint, resolution ReturnResolution) (*ReturnRequest, error) {
+	request := ReturnRequest{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/returns/%d/approve", id), resolution, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// RejectReturn closes the return with a note. Requires an admin token.
+func (c *Client) RejectReturn(ctx context.Context, id uint, note string) (*ReturnRequest, error) {
+	request := ReturnRequest{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/returns/%d/reject", id), ReturnResolution{Note: note}, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// RefundPurchase refunds a purchase without a return request. It fails with
+// CodePaymentFailed when the payment provider does not accept the refund,
+// the refund is then kept with status failed. Requires an admin token.
+func (c *Client) RefundPurchase(ctx context.Context, purchaseID uint, req RefundRequest) (*Refund, error) {
+	refund := Refund{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/purchases/%d/refunds", purchaseID), req, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// PurchaseRefunds lists the refunds of a purchase. Requires an admin token.
+func (c *Client) PurchaseRefunds(ctx context.Context, purchaseID uint) ([]Refund, error) {
+	refunds := []Refund{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/purchases/%d/refunds", purchaseID), nil, &refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// StockHistory returns the stock ledger of a book and its editions, oldest
+// first. Requires an admin token.
+func (c *Client) StockHistory(ctx context.Context, bookID uint) (*StockHistory, error) {
+	history := StockHistory{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/books/%d/stock-history", bookID), nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// ReconcileStock records an adjustment for every stock that differs from
+// its ledger and returns them. Requires an admin token.
+func (c *Client) ReconcileStock(ctx context.Context) ([]StockMovement, error) {
+	movements := []StockMovement{}
+	if err := c.do(ctx, http.MethodPost, "/admin/stock/reconcile", nil, &movements); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// LowStock lists the books below their reorder point, the furthest below
+// first. Requires an admin token.
+func (c *Client) LowStock(ctx context.Context) ([]Book, error) {
+	books := []Book{}
+	if err := c.do(ctx, http.MethodGet, "/admin/inventory/low-stock", nil, &books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
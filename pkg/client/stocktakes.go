@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ListStocktakes lists the stocktakes, newest first, only those with
+// status when it is not empty. Requires an admin token.
+func (c *Client) ListStocktakes(ctx context.Context, status string) ([]Stocktake, error) {
+	path := "/admin/stocktakes"
+	if status != "" {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+
+	stocktakes := []Stocktake{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &stocktakes); err != nil {
+		return nil, err
+	}
+	return stocktakes, nil
+}
+
+// GetStocktake fetches a stocktake with its lines. Requires an admin token.
+func (c *Client) GetStocktake(ctx context.Context, id uint) (*Stocktake, error) {
+	stocktake := Stocktake{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/stocktakes/%d", id), nil, &stocktake); err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
+
+// StartStocktake starts counting a set of books. It fails with
+// CodeWarehouseStock when one is kept per warehouse and no warehouse is
+// given. Requires an admin token.
+func (c *Client) StartStocktake(ctx context.Context, req StocktakeRequest) (*Stocktake, error) {
+	stocktake := Stocktake{}
+	if err := c.do(ctx, http.MethodPost, "/admin/stocktakes", req, &stocktake); err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
+
+// SubmitCounts records counted copies, replacing earlier counts of the
+// same lines. Requires an admin token.
+func (c *Client) SubmitCounts(ctx context.Context, id uint, counts []StocktakeCount) (*Stocktake, error) {
+	stocktake := Stocktake{}
+	body := map[string]any{"counts": counts}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/admin/stocktakes/%d/counts", id), body, &stocktake); err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
+
+// StocktakeVariances lists the counted lines that did not match the stock
+// when they were counted. Requires an admin token.
+func (c *Client) StocktakeVariances(ctx context.Context, id uint) ([]StocktakeLine, error) {
+	lines := []StocktakeLine{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/stocktakes/%d/variances", id), nil, &lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// ApplyStocktake adjusts the stock by how far the counts were off when they
+// were counted, recording reason, one of the Count* reason codes. It fails
+// with CodeStocktakeClosed when the stocktake was applied or cancelled.
+// Requires an admin token.
+func (c *Client) ApplyStocktake(ctx context.Context, id uint, reason string) (*Stocktake, error) {
+	stocktake := Stocktake{}
+	body := map[string]any{"reason": reason}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/stocktakes/%d/apply", id), body, &stocktake); err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
+
+// CancelStocktake drops a stocktake without changing the stock. Requires an
+// admin token.
+func (c *Client) CancelStocktake(ctx context.Context, id uint) (*Stocktake, error) {
+	stocktake := Stocktake{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/stocktakes/%d/cancel", id), nil, &stocktake); err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
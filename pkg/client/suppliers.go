@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ListSuppliers lists the suppliers by name. Requires an admin token.
+func (c *Client) ListSuppliers(ctx context.Context) ([]Supplier, error) {
+	suppliers := []Supplier{}
+	if err := c.do(ctx, http.MethodGet, "/admin/suppliers", nil, &suppliers); err != nil {
+		return nil, err
+	}
+	return suppliers, nil
+}
+
+// CreateSupplier fails with CodeSupplierExists when the name is taken.
+// Requires an admin token.
+func (c *Client) CreateSupplier(ctx context.Context, supplier Supplier) (*Supplier, error) {
+	created := Supplier{}
+	if err := c.do(ctx, http.MethodPost, "/admin/suppliers", supplier, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ReplaceSupplier overwrites every editable field of a supplier. Requires
+// an admin token.
+func (c *Client) ReplaceSupplier(ctx context.Context, id uint, supplier Supplier) (*Supplier, error) {
+	updated := Supplier{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/admin/suppliers/%d", id), supplier, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// ListSupplierOrders lists the supplier orders, only those with status
+// when it is not empty. Requires an admin token.
+func (c *Client) ListSupplierOrders(ctx context.Context, status string) ([]SupplierOrder, error) {
+	path := "/admin/supplier-orders"
+	if status != "" {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+
+	orders := []SupplierOrder{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetSupplierOrder fetches a supplier order with its items. Requires an
+// admin token.
+func (c *Client) GetSupplierOrder(ctx context.Context, id uint) (*SupplierOrder, error) {
+	order := SupplierOrder{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/supplier-orders/%d", id), nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// CreateSupplierOrder orders copies from a supplier. Requires an admin
+// token.
+func (c *Client) CreateSupplierOrder(ctx context.Context, req SupplierOrderRequest) (*SupplierOrder, error) {
+	order := SupplierOrder{}
+	if err := c.do(ctx, http.MethodPost, "/admin/supplier-orders", req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ReceiveSupplierOrder puts the copies of a delivery in stock. It fails
+// with CodeOrderClosed when the order was received or cancelled. Requires
+// an admin token.
+func (c *Client) ReceiveSupplierOrder(ctx context.Context, id uint, req ReceiveRequest) (*SupplierOrder, error) {
+	order := SupplierOrder{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/supplier-orders/%d/receive", id), req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// CancelSupplierOrder stops expecting the copies still outstanding.
+// Requires an admin token.
+func (c *Client) CancelSupplierOrder(ctx context.Context, id uint) (*SupplierOrder, error) {
+	order := SupplierOrder{}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/supplier-orders/%d/cancel", id), nil, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
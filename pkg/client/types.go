@@ -0,0 +1,717 @@
+package client
+
+import "time"
+
+type Book struct {
+	ID              uint      `json:"ID,omitempty"`
+	CreatedAt       time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt       time.Time `json:"UpdatedAt,omitempty"`
+	Name            string    `json:"name"`
+	Author          string    `json:"author"`
+	ISBN            string    `json:"isbn,omitempty"`
+	ISBN10          string    `json:"isbn_10,omitempty"`
+	PublishedYear   int       `json:"published_year"`
+	AvailableCopies int       `json:"available_copies"`
+	ReorderPoint    int       `json:"reorder_point,omitempty"`
+	ReorderQuantity int       `json:"reorder_quantity,omitempty"`
+	Price           Money     `json:"price"`
+	Cover           *Cover    `json:"cover,omitempty"`
+	Version         uint      `json:"version,omitempty"`
+	// Availability is the stock per warehouse, AvailableCopies adds it up.
+	Availability []WarehouseStock `json:"availability,omitempty"`
+}
+
+// Money is an amount in the minor unit of its currency, e.g. cents. The
+// server fills in Formatted, an empty Currency means the store default.
+type Money struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency,omitempty"`
+	Formatted string `json:"formatted,omitempty"`
+}
+
+// Cover is the uploaded cover image of a book. URLs holds the "original"
+// image and the "large", "medium" and "small" JPEG thumbnails.
+type Cover struct {
+	Hash        string            `json:"hash"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	URLs        map[string]string `json:"urls"`
+}
+
+// BookFields is the full set of editable book fields sent on replace.
+type BookFields struct {
+	Name            string `json:"name"`
+	Author          string `json:"author"`
+	ISBN            string `json:"isbn"`
+	PublishedYear   int    `json:"published_year"`
+	AvailableCopies int    `json:"available_copies"`
+	ReorderPoint    int    `json:"reorder_point"`
+	ReorderQuantity int    `json:"reorder_quantity"`
+	Price           Money  `json:"price"`
+}
+
+// BookUpdate is sent as a JSON merge patch, nil fields are left unchanged.
+type BookUpdate struct {
+	Name            *string `json:"name,omitempty"`
+	Author          *string `json:"author,omitempty"`
+	ISBN            *string `json:"isbn,omitempty"`
+	Price           *Money  `json:"price,omitempty"`
+	PublishedYear   *int    `json:"published_year,omitempty"`
+	AvailableCopies *int    `json:"available_copies,omitempty"`
+	ReorderPoint    *int    `json:"reorder_point,omitempty"`
+	ReorderQuantity *int    `json:"reorder_quantity,omitempty"`
+}
+
+// Contributor roles on a book
+const (
+	RoleAuthor      = "author"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+)
+
+type Author struct {
+	ID        uint      `json:"ID,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	Name      string    `json:"name"`
+	Bio       string    `json:"bio,omitempty"`
+	Aliases   []string  `json:"aliases"`
+	Version   uint      `json:"version,omitempty"`
+}
+
+// AuthorFields is the full set of editable author fields.
+type AuthorFields struct {
+	Name    string   `json:"name"`
+	Bio     string   `json:"bio"`
+	Aliases []string `json:"aliases"`
+}
+
+// AuthorCredit is a book an author is credited on.
+type AuthorCredit struct {
+	Role string `json:"role"`
+	Book Book   `json:"book"`
+}
+
+// BookAuthor is a credit of a book as returned by the API.
+type BookAuthor struct {
+	BookID   uint    `json:"book_id"`
+	AuthorID uint    `json:"author_id"`
+	Role     string  `json:"role"`
+	Position int     `json:"position"`
+	Author   *Author `json:"author,omitempty"`
+}
+
+// BookCredit assigns an author to a book in a role.
+type BookCredit struct {
+	AuthorID uint   `json:"author_id"`
+	Role     string `json:"role"`
+}
+
+// Edition formats
+const (
+	FormatHardcover = "hardcover"
+	FormatPaperback = "paperback"
+	FormatEbook     = "ebook"
+	FormatAudiobook = "audiobook"
+)
+
+// Edition is one format of a book with its own ISBN, price and stock.
+type Edition struct {
+	ID              uint       `json:"ID,omitempty"`
+	CreatedAt       time.Time  `json:"CreatedAt,omitempty"`
+	UpdatedAt       time.Time  `json:"UpdatedAt,omitempty"`
+	BookID          uint       `json:"book_id"`
+	Format          string     `json:"format"`
+	PublisherID     *uint      `json:"publisher_id"`
+	ISBN            string     `json:"isbn,omitempty"`
+	ISBN10          string     `json:"isbn_10,omitempty"`
+	PublishedOn     *time.Time `json:"published_on,omitempty"`
+	AvailableCopies int        `json:"available_copies"`
+	Price           Money      `json:"price"`
+	Version         uint       `json:"version,omitempty"`
+	Publisher       *Publisher `json:"publisher,omitempty"`
+}
+
+// EditionFields is the full set of editable edition fields. PublishedOn is
+// a date formatted as 2006-01-02.
+type EditionFields struct {
+	Format          string `json:"format"`
+	PublisherID     *uint  `json:"publisher_id"`
+	ISBN            string `json:"isbn"`
+	PublishedOn     string `json:"published_on"`
+	AvailableCopies int    `json:"available_copies"`
+	Price           Money  `json:"price"`
+}
+
+type Publisher struct {
+	ID        uint      `json:"ID,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	Name      string    `json:"name"`
+	Website   string    `json:"website,omitempty"`
+	Version   uint      `json:"version,omitempty"`
+}
+
+// PublisherFields is the full set of editable publisher fields.
+type PublisherFields struct {
+	Name    string `json:"name"`
+	Website string `json:"website"`
+}
+
+type User struct {
+	ID        uint      `json:"ID,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	Name      string    `json:"name"`
+	City      string    `json:"city,omitempty"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Version   uint      `json:"version,omitempty"`
+}
+
+type SignupRequest struct {
+	Name     string `json:"name"`
+	City     string `json:"city,omitempty"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// UserFields is the full set of editable profile fields sent on replace.
+type UserFields struct {
+	Name  string `json:"name"`
+	City  string `json:"city"`
+	Email string `json:"email"`
+}
+
+// UserUpdate is sent as a JSON merge patch, nil fields are left unchanged.
+type UserUpdate struct {
+	Name  *string `json:"name,omitempty"`
+	City  *string `json:"city,omitempty"`
+	Email *string `json:"email,omitempty"`
+}
+
+type PurchaseRequest struct {
+	BookID    int `json:"book_id,omitempty"`
+	EditionID int `json:"edition_id,omitempty"`
+	Quantity  int `json:"quantity"`
+	// Region is where the purchase is taxed, e.g. GB or US-CA. The
+	// server's default region is used when empty.
+	Region string `json:"region,omitempty"`
+	// CouponCode is redeemed on the purchase, codes are case insensitive.
+	CouponCode string `json:"coupon_code,omitempty"`
+	// PaymentMethod is the payment method token set up with the payment
+	// provider, required when the server charges purchases.
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// ReservationID buys copies held by a reservation, the reservation
+	// decides the book and edition.
+	ReservationID uint `json:"reservation_id,omitempty"`
+}
+
+// Reservation statuses
+const (
+	ReservationActive    = "active"
+	ReservationConverted = "converted"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+type ReservationRequest struct {
+	BookID    int `json:"book_id,omitempty"`
+	EditionID int `json:"edition_id,omitempty"`
+	Quantity  int `json:"quantity"`
+}
+
+// Reservation holds copies of a book until ExpiresAt. Its copies are out of
+// stock while it is active.
+type Reservation struct {
+	ID         uint      `json:"ID"`
+	CreatedAt  time.Time `json:"CreatedAt"`
+	UserID     uint      `json:"user_id"`
+	BookID     uint      `json:"book_id"`
+	EditionID  *uint     `json:"edition_id"`
+	Quantity   int       `json:"quantity"`
+	Status     string    `json:"status"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	PurchaseID *uint     `json:"purchase_id,omitempty"`
+}
+
+// Stock movement kinds
+const (
+	StockSale        = "sale"
+	StockRestock     = "restock"
+	StockAdjustment  = "adjustment"
+	StockReturn      = "return"
+	StockReservation = "reservation"
+	StockTransfer    = "transfer"
+	StockReceipt     = "receipt"
+)
+
+// StockMovement is an entry of the stock ledger. Quantity is signed, copies
+// out of stock are negative. Balance is the stock the movement left behind
+// on its edition, or on the book when it has none.
+type StockMovement struct {
+	ID          uint      `json:"ID"`
+	CreatedAt   time.Time `json:"CreatedAt"`
+	BookID      uint      `json:"book_id"`
+	EditionID   *uint     `json:"edition_id"`
+	WarehouseID *uint     `json:"warehouse_id,omitempty"`
+	Kind        string    `json:"kind"`
+	Quantity    int       `json:"quantity"`
+	ActorID     *uint     `json:"actor_id,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Reference   string    `json:"reference,omitempty"`
+	Balance     int       `json:"balance"`
+}
+
+type StockHistory struct {
+	BookID          uint            `json:"book_id"`
+	AvailableCopies int             `json:"available_copies"`
+	Movements       []StockMovement `json:"movements"`
+}
+
+// Purchase statuses
+const (
+	PurchasePending  = "pending"
+	PurchasePaid     = "paid"
+	PurchaseFailed   = "failed"
+	PurchaseRefunded = "refunded"
+)
+
+// Purchase is the receipt of a purchase. Amount is what was paid, Tax the
+// part of it that is tax, broken down in Taxes.
+type Purchase struct {
+	ID           uint           `json:"ID"`
+	CreatedAt    time.Time      `json:"CreatedAt"`
+	UserID       uint           `json:"user_id"`
+	BookID       uint           `json:"book_id"`
+	EditionID    *uint          `json:"edition_id"`
+	Quantity     int            `json:"quantity"`
+	Amount       Money          `json:"amount"`
+	CouponID     *uint          `json:"coupon_id,omitempty"`
+	Discount     Money          `json:"discount"`
+	Discounts    []DiscountLine `json:"discounts,omitempty"`
+	Tax          Money          `json:"tax"`
+	TaxRegion    string         `json:"tax_region,omitempty"`
+	TaxInclusive bool           `json:"tax_inclusive"`
+	Taxes        []TaxLine      `json:"taxes,omitempty"`
+	// Status is pending while the payment is processed
+	Status    string `json:"status"`
+	PaymentID string `json:"payment_id,omitempty"`
+}
+
+// DiscountLine is one discount taken off a purchase, by a promotion or a
+// coupon.
+type DiscountLine struct {
+	Name        string `json:"name"`
+	PromotionID *uint  `json:"promotion_id,omitempty"`
+	CouponID    *uint  `json:"coupon_id,omitempty"`
+	Amount      Money  `json:"amount"`
+}
+
+// PriceBreakdown is the itemized price of a purchase, see PreviewPurchase.
+type PriceBreakdown struct {
+	BookID       uint           `json:"book_id"`
+	EditionID    *uint          `json:"edition_id"`
+	Quantity     int            `json:"quantity"`
+	UnitPrice    Money          `json:"unit_price"`
+	ListAmount   Money          `json:"list_amount"`
+	Discounts    []DiscountLine `json:"discounts"`
+	Discount     Money          `json:"discount"`
+	Tax          Money          `json:"tax"`
+	TaxRegion    string         `json:"tax_region,omitempty"`
+	TaxInclusive bool           `json:"tax_inclusive"`
+	Taxes        []TaxLine      `json:"taxes"`
+	Total        Money          `json:"total"`
+}
+
+// TaxLine is one tax charged on a purchase. Rate is a percentage, "7.25".
+type TaxLine struct {
+	Name   string `json:"name"`
+	Rate   string `json:"rate"`
+	Amount Money  `json:"amount"`
+}
+
+// Return request statuses
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+)
+
+// ReturnRequest is a request to send back copies of a purchase. RefundID is
+// set once it is approved.
+type ReturnRequest struct {
+	ID         uint       `json:"ID"`
+	CreatedAt  time.Time  `json:"CreatedAt"`
+	PurchaseID uint       `json:"purchase_id"`
+	UserID     uint       `json:"user_id"`
+	Quantity   int        `json:"quantity"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Note       string     `json:"note,omitempty"`
+	ResolvedBy *uint      `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	RefundID   *uint      `json:"refund_id,omitempty"`
+}
+
+// ReturnResolution approves or rejects a return. Amount overrides the
+// refunded share of the purchase and Restock set to false keeps the copies
+// out of stock, both only apply to approvals.
+type ReturnResolution struct {
+	Amount  *Money `json:"amount,omitempty"`
+	Restock *bool  `json:"restock,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// RefundRequest refunds a purchase directly. Amount defaults to the share
+// of the purchase of Quantity, at least one of them is required.
+type RefundRequest struct {
+	Amount   *Money `json:"amount,omitempty"`
+	Quantity int    `json:"quantity,omitempty"`
+	Restock  *bool  `json:"restock,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Refund is money paid back on a purchase, with the copies taken back.
+type Refund struct {
+	ID         uint      `json:"ID"`
+	CreatedAt  time.Time `json:"CreatedAt"`
+	PurchaseID uint      `json:"purchase_id"`
+	ReturnID   *uint     `json:"return_id,omitempty"`
+	Quantity   int       `json:"quantity"`
+	Amount     Money     `json:"amount"`
+	Restocked  bool      `json:"restocked"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedBy  uint      `json:"created_by"`
+	PaymentID  string    `json:"payment_id,omitempty"`
+	Status     string    `json:"status"`
+}
+
+// Coupon kinds
+const (
+	CouponPercent = "percent"
+	CouponFixed   = "fixed"
+)
+
+// Coupon is a discount code. Zero limits are unlimited, and a coupon without
+// book or category ids applies to every book.
+type Coupon struct {
+	ID             uint       `json:"ID,omitempty"`
+	CreatedAt      time.Time  `json:"CreatedAt,omitempty"`
+	UpdatedAt      time.Time  `json:"UpdatedAt,omitempty"`
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	PercentOff     int        `json:"percent_off"`
+	AmountOff      Money      `json:"amount_off"`
+	MinSpend       Money      `json:"min_spend"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	MaxPerUser     int        `json:"max_per_user"`
+	Redemptions    int        `json:"redemptions"`
+	BookIDs        []uint     `json:"book_ids"`
+	CategoryIDs    []uint     `json:"category_ids"`
+	Version        uint       `json:"version,omitempty"`
+}
+
+// CouponFields is the full set of editable coupon fields.
+type CouponFields struct {
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	PercentOff     int        `json:"percent_off"`
+	AmountOff      Money      `json:"amount_off"`
+	MinSpend       Money      `json:"min_spend"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	MaxPerUser     int        `json:"max_per_user"`
+	BookIDs        []uint     `json:"book_ids"`
+	CategoryIDs    []uint     `json:"category_ids"`
+}
+
+// Promotion condition and action types
+const (
+	ConditionBook        = "book"
+	ConditionCategory    = "category"
+	ConditionFormat      = "format"
+	ConditionMinQuantity = "min_quantity"
+	ConditionMinSpend    = "min_spend"
+
+	ActionPercentOff  = "percent_off"
+	ActionAmountOff   = "amount_off"
+	ActionBuyXGetY    = "buy_x_get_y"
+	ActionBundlePrice = "bundle_price"
+	ActionTiered      = "tiered"
+)
+
+// Promotion is a discount applied automatically to purchases meeting all of
+// its conditions. Promotions apply by descending priority, an exclusive one
+// that applies stops the ones after it.
+type Promotion struct {
+	ID         uint                 `json:"ID,omitempty"`
+	CreatedAt  time.Time            `json:"CreatedAt,omitempty"`
+	UpdatedAt  time.Time            `json:"UpdatedAt,omitempty"`
+	Name       string               `json:"name"`
+	Priority   int                  `json:"priority"`
+	Exclusive  bool                 `json:"exclusive"`
+	StartsAt   *time.Time           `json:"starts_at"`
+	ExpiresAt  *time.Time           `json:"expires_at"`
+	Conditions []PromotionCondition `json:"conditions"`
+	Action     PromotionAction      `json:"action"`
+	Version    uint                 `json:"version,omitempty"`
+}
+
+// PromotionFields is the full set of editable promotion fields.
+type PromotionFields struct {
+	Name       string               `json:"name"`
+	Priority   int                  `json:"priority"`
+	Exclusive  bool                 `json:"exclusive"`
+	StartsAt   *time.Time           `json:"starts_at"`
+	ExpiresAt  *time.Time           `json:"expires_at"`
+	Conditions []PromotionCondition `json:"conditions"`
+	Action     PromotionAction      `json:"action"`
+}
+
+// PromotionCondition sets the field matching its Type.
+type PromotionCondition struct {
+	Type     string `json:"type"`
+	BookIDs  []uint `json:"book_ids,omitempty"`
+	Category string `json:"category,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Quantity int    `json:"quantity,omitempty"`
+	Amount   *Money `json:"amount,omitempty"`
+}
+
+// PromotionAction sets the fields matching its Type.
+type PromotionAction struct {
+	Type        string          `json:"type"`
+	PercentOff  int             `json:"percent_off,omitempty"`
+	AmountOff   *Money          `json:"amount_off,omitempty"`
+	Buy         int             `json:"buy,omitempty"`
+	Get         int             `json:"get,omitempty"`
+	BundleSize  int             `json:"bundle_size,omitempty"`
+	BundlePrice *Money          `json:"bundle_price,omitempty"`
+	Tiers       []PromotionTier `json:"tiers,omitempty"`
+}
+
+type PromotionTier struct {
+	MinQuantity int `json:"min_quantity"`
+	PercentOff  int `json:"percent_off"`
+}
+
+// String returns a pointer to s, handy for building updates.
+func String(s string) *string {
+	return &s
+}
+
+// Int returns a pointer to i, handy for building updates.
+func Int(i int) *int {
+	return &i
+}
+
+// Bool returns a pointer to b, handy for building updates.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Stock allocation rules of the server
+const (
+	AllocationNearest  = "nearest"
+	AllocationPriority = "priority"
+)
+
+type Warehouse struct {
+	ID        uint      `json:"ID,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	City      string    `json:"city"`
+	// Priority orders the warehouses purchases are shipped from, lowest
+	// first
+	Priority int `json:"priority"`
+}
+
+// WarehouseStock is the stock of a book, all its editions together, in a
+// warehouse.
+type WarehouseStock struct {
+	WarehouseID uint   `json:"warehouse_id"`
+	Code        string `json:"code"`
+	City        string `json:"city"`
+	Copies      int    `json:"copies"`
+}
+
+// StockLevel is the stock of a book, or one of its editions, in a
+// warehouse.
+type StockLevel struct {
+	ID          uint      `json:"ID"`
+	UpdatedAt   time.Time `json:"UpdatedAt"`
+	WarehouseID uint      `json:"warehouse_id"`
+	BookID      uint      `json:"book_id"`
+	EditionID   *uint     `json:"edition_id"`
+	Quantity    int       `json:"quantity"`
+}
+
+// StockLevelRequest sets the stock of a book, or of one of its editions
+// when EditionID is set, in a warehouse.
+type StockLevelRequest struct {
+	BookID    int `json:"book_id,omitempty"`
+	EditionID int `json:"edition_id,omitempty"`
+	Quantity  int `json:"quantity"`
+}
+
+type TransferRequest struct {
+	FromWarehouseID uint   `json:"from_warehouse_id"`
+	ToWarehouseID   uint   `json:"to_warehouse_id"`
+	BookID          int    `json:"book_id,omitempty"`
+	EditionID       int    `json:"edition_id,omitempty"`
+	Quantity        int    `json:"quantity"`
+	Note            string `json:"note,omitempty"`
+}
+
+type Transfer struct {
+	ID              uint      `json:"ID"`
+	CreatedAt       time.Time `json:"CreatedAt"`
+	FromWarehouseID uint      `json:"from_warehouse_id"`
+	ToWarehouseID   uint      `json:"to_warehouse_id"`
+	BookID          uint      `json:"book_id"`
+	EditionID       *uint     `json:"edition_id"`
+	Quantity        int       `json:"quantity"`
+	CreatedBy       uint      `json:"created_by"`
+	Note            string    `json:"note,omitempty"`
+}
+
+// Supplier order statuses
+const (
+	SupplierOrderOpen      = "open"
+	SupplierOrderPartial   = "partially_received"
+	SupplierOrderReceived  = "received"
+	SupplierOrderCancelled = "cancelled"
+)
+
+type Supplier struct {
+	ID          uint      `json:"ID,omitempty"`
+	CreatedAt   time.Time `json:"CreatedAt,omitempty"`
+	UpdatedAt   time.Time `json:"UpdatedAt,omitempty"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email,omitempty"`
+	Phone       string    `json:"phone,omitempty"`
+	PublisherID *uint     `json:"publisher_id,omitempty"`
+	// LeadTimeDays sets when orders sent without an expected delivery date
+	// are expected
+	LeadTimeDays int `json:"lead_time_days"`
+}
+
+type SupplierOrder struct {
+	ID         uint                `json:"ID"`
+	CreatedAt  time.Time           `json:"CreatedAt"`
+	UpdatedAt  time.Time           `json:"UpdatedAt"`
+	SupplierID uint                `json:"supplier_id"`
+	Status     string              `json:"status"`
+	ExpectedAt *time.Time          `json:"expected_at,omitempty"`
+	ReceivedAt *time.Time          `json:"received_at,omitempty"`
+	Note       string              `json:"note,omitempty"`
+	CreatedBy  uint                `json:"created_by"`
+	Items      []SupplierOrderItem `json:"items"`
+}
+
+type SupplierOrderItem struct {
+	ID              uint  `json:"ID"`
+	SupplierOrderID uint  `json:"supplier_order_id"`
+	BookID          uint  `json:"book_id"`
+	EditionID       *uint `json:"edition_id"`
+	Quantity        int   `json:"quantity"`
+	Received        int   `json:"received"`
+}
+
+type SupplierOrderRequest struct {
+	SupplierID uint                       `json:"supplier_id"`
+	ExpectedAt *time.Time                 `json:"expected_at,omitempty"`
+	Note       string                     `json:"note,omitempty"`
+	Items      []SupplierOrderItemRequest `json:"items"`
+}
+
+// SupplierOrderItemRequest orders copies of a book, or of one of its
+// editions when EditionID is set. A book sold in editions is ordered by
+// edition, ordering the book fails with CodeEditionRequired. Without a
+// quantity the reorder quantity of the book is ordered.
+type SupplierOrderItemRequest struct {
+	BookID    int `json:"book_id,omitempty"`
+	EditionID int `json:"edition_id,omitempty"`
+	Quantity  int `json:"quantity,omitempty"`
+}
+
+// ReceiveRequest records a delivery, into WarehouseID when stock is kept
+// per warehouse. Without items every copy still outstanding is received.
+type ReceiveRequest struct {
+	WarehouseID uint                 `json:"warehouse_id,omitempty"`
+	Items       []ReceiveItemRequest `json:"items,omitempty"`
+}
+
+type ReceiveItemRequest struct {
+	ItemID   uint `json:"item_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// Stocktake statuses
+const (
+	StocktakeOpen      = "open"
+	StocktakeApplied   = "applied"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake reason codes
+const (
+	CountMiscount = "miscount"
+	CountDamaged  = "damaged"
+	CountLost     = "lost"
+	CountTheft    = "theft"
+	CountFound    = "found"
+)
+
+type Stocktake struct {
+	ID          uint            `json:"ID"`
+	CreatedAt   time.Time       `json:"CreatedAt"`
+	UpdatedAt   time.Time       `json:"UpdatedAt"`
+	WarehouseID *uint           `json:"warehouse_id,omitempty"`
+	Status      string          `json:"status"`
+	Note        string          `json:"note,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	CreatedBy   uint            `json:"created_by"`
+	AppliedBy   *uint           `json:"applied_by,omitempty"`
+	AppliedAt   *time.Time      `json:"applied_at,omitempty"`
+	Lines       []StocktakeLine `json:"lines"`
+}
+
+// StocktakeLine is the count of a book, or of one of its editions, and the
+// stock it had when it was counted. Variance is only filled in by
+// StocktakeVariances.
+type StocktakeLine struct {
+	ID          uint       `json:"ID"`
+	StocktakeID uint       `json:"stocktake_id"`
+	BookID      uint       `json:"book_id"`
+	EditionID   *uint      `json:"edition_id"`
+	Counted     *int       `json:"counted"`
+	Expected    *int       `json:"expected"`
+	CountedBy   *uint      `json:"counted_by,omitempty"`
+	CountedAt   *time.Time `json:"counted_at,omitempty"`
+	Adjustment  int        `json:"adjustment"`
+	Variance    *int       `json:"variance"`
+}
+
+// StocktakeRequest starts counting the books of BookIDs, in WarehouseID
+// when stock is kept per warehouse.
+type StocktakeRequest struct {
+	WarehouseID uint   `json:"warehouse_id,omitempty"`
+	BookIDs     []uint `json:"book_ids"`
+	Note        string `json:"note,omitempty"`
+}
+
+// StocktakeCount is the counted copies of a book, or of one of its
+// editions when EditionID is set.
+type StocktakeCount struct {
+	BookID    int `json:"book_id,omitempty"`
+	EditionID int `json:"edition_id,omitempty"`
+	Counted   int `json:"counted"`
+}
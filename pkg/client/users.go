@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+func (c *Client) GetUser(ctx context.Context, id uint) (*User, error) {
+	user := User{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%d", id), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser patches only the fields set in update.
+func (c *Client) UpdateUser(ctx context.Context, id uint, update UserUpdate) (*User, error) {
+	user := User{}
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/users/%d", id), update, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ReplaceUser overwrites every editable profile field.
+func (c *Client) ReplaceUser(ctx context.Context, id uint, fields UserFields) (*User, error) {
+	user := User{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/users/%d", id), fields, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) DeleteUser(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/users/%d", id), nil, nil)
+}
+
+// ListUsers requires an admin token.
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	users := []User{}
+	if err := c.do(ctx, http.MethodGet, "/users/", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
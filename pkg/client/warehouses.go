@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ListWarehouses lists the warehouses in priority order. Requires an admin
+// token.
+func (c *Client) ListWarehouses(ctx context.Context) ([]Warehouse, error) {
+	warehouses := []Warehouse{}
+	if err := c.do(ctx, http.MethodGet, "/admin/warehouses", nil, &warehouses); err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+// CreateWarehouse fails with CodeWarehouseExists when the code is taken.
+// Requires an admin token.
+func (c *Client) CreateWarehouse(ctx context.Context, warehouse Warehouse) (*Warehouse, error) {
+	created := Warehouse{}
+	if err := c.do(ctx, http.MethodPost, "/admin/warehouses", warehouse, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ReplaceWarehouse overwrites every editable field of a warehouse.
+// Requires an admin token.
+func (c *Client) ReplaceWarehouse(ctx context.Context, id uint, warehouse Warehouse) (*Warehouse, error) {
+	updated := Warehouse{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/admin/warehouses/%d", id), warehouse, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// WarehouseStock lists the stock levels of a warehouse. Requires an admin
+// token.
+func (c *Client) WarehouseStock(ctx context.Context, id uint) ([]StockLevel, error) {
+	levels := []StockLevel{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/warehouses/%d/stock", id), nil, &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// SetWarehouseStock sets the stock of a book or edition in a warehouse.
+// The first time a book or edition is stocked in a warehouse its copies
+// are taken to be kept there. Requires an admin token.
+func (c *Client) SetWarehouseStock(ctx context.Context, id uint, req StockLevelRequest) (*StockLevel, error) {
+	level := StockLevel{}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/admin/warehouses/%d/stock", id), req, &level); err != nil {
+		return nil, err
+	}
+	return &level, nil
+}
+
+// TransferStock moves copies between warehouses. It fails with
+// CodeInsufficientStock when the source holds fewer copies. Requires an
+// admin token.
+func (c *Client) TransferStock(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	transfer := Transfer{}
+	if err := c.do(ctx, http.MethodPost, "/admin/warehouses/transfers", req, &transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// ListTransfers lists the transfers between warehouses, newest first.
+// Requires an admin token.
+func (c *Client) ListTransfers(ctx context.Context) ([]Transfer, error) {
+	transfers := []Transfer{}
+	if err := c.do(ctx, http.MethodGet, "/admin/warehouses/transfers", nil, &transfers); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
@@ -0,0 +1,24 @@
+// Package storage keeps binary objects such as cover images outside the
+// database.
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when a key does not exist in a store.
+var ErrNotFound = errors.New("blob not found")
+
+// CacheControl is sent with every stored object. Keys embed a content hash
+// so an object never changes once written.
+const CacheControl = "public, max-age=31536000, immutable"
+
+// BlobStore stores objects under slash separated keys and tells where
+// clients can fetch them.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL is the public address of key, it does not check that key exists
+	URL(key string) string
+}
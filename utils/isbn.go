@@ -0,0 +1,102 @@
+package utils
+
+import "strings"
+
+// StripISBN removes the hyphens and spaces commonly used to group ISBN digits.
+func StripISBN(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+}
+
+// ValidISBN reports whether isbn is a valid ISBN-10 or ISBN-13, checksum included.
+func ValidISBN(isbn string) bool {
+	isbn = StripISBN(isbn)
+
+	switch len(isbn) {
+	case 10:
+		return validISBN10(isbn)
+	case 13:
+		return validISBN13(isbn)
+	}
+	return false
+}
+
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i, c := range isbn {
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c == 'X' && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += digit * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+func validISBN13(isbn string) bool {
+	sum := 0
+	for i, c := range isbn {
+		if c < '0' || c > '9' {
+			return false
+		}
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// NormalizeISBN returns isbn as a bare ISBN-13, converting ISBN-10 input.
+// It returns an empty string when isbn is not a valid ISBN.
+func NormalizeISBN(isbn string) string {
+	isbn = StripISBN(isbn)
+	if !ValidISBN(isbn) {
+		return ""
+	}
+
+	if len(isbn) == 13 {
+		return isbn
+	}
+
+	isbn13 := "978" + isbn[:9]
+	return isbn13 + isbn13CheckDigit(isbn13)
+}
+
+// ISBN10 converts a normalized ISBN-13 back to its ISBN-10 form. Only the
+// 978 prefix has an ISBN-10 equivalent, other prefixes return "".
+func ISBN10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+
+	body := isbn13[3:12]
+
+	sum := 0
+	for i, c := range body {
+		sum += int(c-'0') * (10 - i)
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+	return body + string(rune('0'+check))
+}
+
+func isbn13CheckDigit(first12 string) string {
+	sum := 0
+	for i, c := range first12 {
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return string(rune('0' + (10-sum%10)%10))
+}
@@ -40,12 +40,12 @@ func VerifyJWTToken(tokenStr string) (uint, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
 		return []byte(config.GetConfig().JWTSecretKey), nil
 	})
-	if err != nil || !token.Valid {
-		return 0, nil
+	if err != nil {
+		return 0, err
 	}
 
 	if !token.Valid {
-		return 0, err
+		return 0, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Slugify turns a display name into a url friendly slug, "Science Fiction &
+// Fantasy" becomes "science-fiction-fantasy". Accents are dropped.
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			hyphen = false
+		default:
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+// ValidSlug reports whether slug only has lower case words joined by hyphens.
+func ValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}